@@ -0,0 +1,128 @@
+package masker
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Encoder returns the encoded forms of a sequence that should be masked in addition to the sequence itself.
+type Encoder func(sequence []byte) [][]byte
+
+// Encoders contains all supported encoders by name.
+var Encoders = map[string]Encoder{
+	"base64": Base64Encoder,
+	"url":    URLEncoder,
+	"json":   JSONEncoder,
+	"hex":    HexEncoder,
+}
+
+// EncoderNames returns the names of all supported encoders in alphabetical order.
+func EncoderNames() []string {
+	names := make([]string, 0, len(Encoders))
+	for name := range Encoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Base64Encoder returns the standard and the URL-safe base64 encoding of the sequence.
+//
+// When a secret is part of a larger value that is base64 encoded, its encoding depends on its offset in that
+// value, as every 3 bytes are encoded into 4 characters. Therefore, the encoding is returned for all 3 possible
+// alignments, each consisting only of the characters that are fully determined by the sequence itself.
+func Base64Encoder(sequence []byte) [][]byte {
+	var res [][]byte
+	for offset := 0; offset < 3; offset++ {
+		aligned := make([]byte, offset+len(sequence))
+		copy(aligned[offset:], sequence)
+
+		// Characters encode 6 bits. Skip the characters containing bits of the offset
+		// and cut off the last character if it also encodes bits after the sequence.
+		start := (8*offset + 5) / 6
+		end := 8 * len(aligned) / 6
+		if end <= start {
+			continue
+		}
+
+		for _, encoding := range []*base64.Encoding{base64.RawStdEncoding, base64.RawURLEncoding} {
+			encoded := encoding.EncodeToString(aligned)[start:end]
+			res = append(res, []byte(encoded))
+		}
+	}
+	return res
+}
+
+// URLEncoder returns the sequence encoded as URL query component and as URL path segment.
+func URLEncoder(sequence []byte) [][]byte {
+	return [][]byte{
+		[]byte(url.QueryEscape(string(sequence))),
+		[]byte(url.PathEscape(string(sequence))),
+	}
+}
+
+// JSONEncoder returns the sequence as it is escaped within a JSON string, both with and without
+// the escaping of HTML characters (<, > and &).
+func JSONEncoder(sequence []byte) [][]byte {
+	var res [][]byte
+	for _, escapeHTML := range []bool{true, false} {
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(escapeHTML)
+		// Encoding a string never fails.
+		_ = encoder.Encode(string(sequence))
+
+		quoted := strings.TrimSuffix(buf.String(), "\n")
+		res = append(res, []byte(quoted[1:len(quoted)-1]))
+	}
+	return res
+}
+
+// HexEncoder returns the lowercase and uppercase hexadecimal encoding of the sequence.
+func HexEncoder(sequence []byte) [][]byte {
+	encoded := hex.EncodeToString(sequence)
+	return [][]byte{
+		[]byte(encoded),
+		[]byte(strings.ToUpper(encoded)),
+	}
+}
+
+// encodedSequences returns the given sequences together with their encoded forms produced by the given encoders.
+// The encoded forms have the same name as the sequence they are derived from. Duplicates and encoded forms that
+// are shorter than the sequence they are derived from are left out.
+func encodedSequences(sequences []Sequence, encoders []Encoder) []Sequence {
+	if len(encoders) == 0 {
+		return sequences
+	}
+
+	res := make([]Sequence, 0, len(sequences)*(len(encoders)+1))
+	seen := make(map[string]struct{}, cap(res))
+	for _, sequence := range sequences {
+		res = append(res, sequence)
+		seen[string(sequence.Value)] = struct{}{}
+	}
+
+	for _, sequence := range sequences {
+		for _, encoder := range encoders {
+			for _, encoded := range encoder(sequence.Value) {
+				if len(encoded) < len(sequence.Value) {
+					continue
+				}
+				if _, ok := seen[string(encoded)]; ok {
+					continue
+				}
+				seen[string(encoded)] = struct{}{}
+				res = append(res, Sequence{
+					Name:  sequence.Name,
+					Value: encoded,
+				})
+			}
+		}
+	}
+	return res
+}
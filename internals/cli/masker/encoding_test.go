@@ -0,0 +1,98 @@
+package masker
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/secrethub/secrethub-go/internals/assert"
+)
+
+func TestEncoders(t *testing.T) {
+	cases := map[string]struct {
+		encoder  Encoder
+		sequence string
+		expected []string
+	}{
+		"url": {
+			encoder:  URLEncoder,
+			sequence: "p@ss w/rd",
+			expected: []string{"p%40ss+w%2Frd", "p@ss%20w%2Frd"},
+		},
+		"json": {
+			encoder:  JSONEncoder,
+			sequence: "<p\"ss\nword>",
+			expected: []string{`\u003cp\"ss\nword\u003e`, `<p\"ss\nword>`},
+		},
+		"hex": {
+			encoder:  HexEncoder,
+			sequence: "\xab\xcd",
+			expected: []string{"abcd", "ABCD"},
+		},
+		"base64": {
+			encoder:  Base64Encoder,
+			sequence: "secret",
+			expected: []string{"c2VjcmV0", "c2VjcmV0", "NlY3Jld", "NlY3Jld", "zZWNyZX", "zZWNyZX"},
+		},
+		"base64 with URL-safe characters": {
+			encoder:  Base64Encoder,
+			sequence: "\xfb\xff\xfe",
+			expected: []string{"+//+", "-__-", "v//", "v__", "7//", "7__"},
+		},
+		"base64 too short for an alignment": {
+			encoder:  Base64Encoder,
+			sequence: "a",
+			expected: []string{"Y", "Y", "h", "h"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			var actual []string
+			for _, encoded := range tc.encoder([]byte(tc.sequence)) {
+				actual = append(actual, string(encoded))
+			}
+			assert.Equal(t, actual, tc.expected)
+		})
+	}
+}
+
+func TestBase64Encoder_Alignments(t *testing.T) {
+	secret := []byte("my secret value")
+	variants := Base64Encoder(secret)
+
+	for offset := 0; offset < 6; offset++ {
+		for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding} {
+			value := append(bytes.Repeat([]byte("x"), offset), secret...)
+			value = append(value, "suffix"...)
+			encoded := []byte(encoding.EncodeToString(value))
+
+			found := false
+			for _, variant := range variants {
+				if bytes.Contains(encoded, variant) {
+					found = true
+				}
+			}
+			assert.Equal(t, found, true)
+		}
+	}
+}
+
+func TestEncodedSequences(t *testing.T) {
+	sequences := []Sequence{
+		{Name: "FOO", Value: []byte("abc")},
+		{Name: "BAR", Value: []byte("a+b")},
+	}
+	actual := encodedSequences(sequences, []Encoder{URLEncoder, HexEncoder})
+
+	expected := []Sequence{
+		{Name: "FOO", Value: []byte("abc")},
+		{Name: "BAR", Value: []byte("a+b")},
+		// The uppercase hex encoding of abc is the same as the lowercase one.
+		{Name: "FOO", Value: []byte("616263")},
+		{Name: "BAR", Value: []byte("a%2Bb")},
+		{Name: "BAR", Value: []byte("612b62")},
+		{Name: "BAR", Value: []byte("612B62")},
+	}
+	assert.Equal(t, actual, expected)
+}
@@ -0,0 +1,56 @@
+package masker
+
+import (
+	"bytes"
+	"text/template"
+)
+
+// defaultRedaction is the text with which masked secrets are replaced when no format is configured.
+const defaultRedaction = "<redacted by SecretHub>"
+
+// Format determines the text with which a masked secret is replaced.
+type Format struct {
+	template *template.Template
+}
+
+// formatData is the data that is available in a format.
+type formatData struct {
+	// Name is the name of the masked sequence or pattern, e.g. the name of the environment variable
+	// containing the secret.
+	Name string
+}
+
+// ParseFormat parses a format for the text with which masked secrets are replaced.
+// The format is a Go template in which {{.Name}} is replaced with the name of the masked secret.
+func ParseFormat(format string) (*Format, error) {
+	tpl, err := template.New("mask-format").Parse(format)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Format{
+		template: tpl,
+	}
+
+	// Execute the format once, so that references to unknown fields are detected before any output is masked.
+	_, err = f.redaction("")
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// redaction returns the text with which a match of the sequence or pattern with the given name is replaced.
+// When the format is nil, the default redaction text is returned.
+func (f *Format) redaction(name string) ([]byte, error) {
+	if f == nil {
+		return []byte(defaultRedaction), nil
+	}
+
+	var buf bytes.Buffer
+	err := f.template.Execute(&buf, formatData{Name: name})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,67 @@
+package masker
+
+import (
+	"testing"
+
+	"github.com/secrethub/secrethub-go/internals/assert"
+)
+
+func mustParseFormat(t *testing.T, format string) *Format {
+	f, err := ParseFormat(format)
+	assert.OK(t, err)
+	return f
+}
+
+func TestFormat(t *testing.T) {
+	cases := map[string]struct {
+		format   string
+		name     string
+		expected string
+		err      bool
+	}{
+		"name": {
+			format:   "<redacted:{{.Name}}>",
+			name:     "DB_PASSWORD",
+			expected: "<redacted:DB_PASSWORD>",
+		},
+		"static": {
+			format:   "***",
+			name:     "DB_PASSWORD",
+			expected: "***",
+		},
+		"function": {
+			format:   "[{{printf \"%q\" .Name}}]",
+			name:     "path/to/secret",
+			expected: "[\"path/to/secret\"]",
+		},
+		"syntax error": {
+			format: "<redacted:{{.Name>",
+			err:    true,
+		},
+		"unknown field": {
+			format: "<redacted:{{.Value}}>",
+			err:    true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			f, err := ParseFormat(tc.format)
+			assert.Equal(t, err != nil, tc.err)
+			if err != nil {
+				return
+			}
+
+			actual, err := f.redaction(tc.name)
+			assert.OK(t, err)
+			assert.Equal(t, string(actual), tc.expected)
+		})
+	}
+}
+
+func TestFormat_Nil(t *testing.T) {
+	var f *Format
+	actual, err := f.redaction("DB_PASSWORD")
+	assert.OK(t, err)
+	assert.Equal(t, string(actual), defaultRedaction)
+}
@@ -0,0 +1,74 @@
+package masker
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+)
+
+// Leak is a secret that has been found in the output.
+type Leak struct {
+	// Name is the name of the sequence or pattern that matched.
+	Name string
+	// Count is the number of times the secret occurred in the output.
+	Count int
+}
+
+// leakCounter counts the matches per name. It is safe for concurrent use,
+// so that it can be shared by all streams of a masker.
+type leakCounter struct {
+	mutex  sync.Mutex
+	counts map[string]int
+}
+
+func newLeakCounter() *leakCounter {
+	return &leakCounter{
+		counts: make(map[string]int),
+	}
+}
+
+func (c *leakCounter) add(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.counts[name]++
+}
+
+// list returns the counted leaks ordered by name.
+func (c *leakCounter) list() []Leak {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	res := make([]Leak, 0, len(c.counts))
+	for name, count := range c.counts {
+		res = append(res, Leak{
+			Name:  name,
+			Count: count,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// writeReport writes a summary of the leaks to the given writer, if there are any.
+// Only the names of the secrets are written, never their values.
+func (c *leakCounter) writeReport(w io.Writer) error {
+	leaks := c.list()
+	if len(leaks) == 0 {
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 2, 2, ' ', 0)
+	fmt.Fprintln(tw, "The following secrets were masked in the output:")
+	for _, leak := range leaks {
+		times := "times"
+		if leak.Count == 1 {
+			times = "time"
+		}
+		fmt.Fprintf(tw, "  %s\t%d %s\n", leak.Name, leak.Count, times)
+	}
+	return tw.Flush()
+}
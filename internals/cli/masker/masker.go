@@ -17,12 +17,24 @@ import (
 // 4. After everything has been written to the io.Writers, flush all buffers using Stop()
 type Masker struct {
 	bufferDelay time.Duration
-	sequences   [][]byte
+	names       []string
+	automaton   *automaton
+	patterns    []Pattern
+	format      *Format
+	leaks       *leakCounter
+	report      io.Writer
 	frames      chan frame
 	stopChan    chan struct{}
 	err         error
 }
 
+// Sequence is a secret value to mask. The name, e.g. the name of the environment
+// variable that contains the secret, identifies the sequence without revealing its value.
+type Sequence struct {
+	Name  string
+	Value []byte
+}
+
 // Options for configuring masking behavior.
 type Options struct {
 	// DisableBuffer completely disables the buffering of the masker. This increases output responsiveness
@@ -38,17 +50,37 @@ type Options struct {
 	// FrameBufferLength is the number of frames that can be in the buffer simultaneously.
 	// If the frame buffer is full, writing to a stream blocks until there is space.
 	FrameBufferLength int
+
+	// Encoders derive encoded forms of the sequences, e.g. base64, that are masked as well.
+	Encoders []Encoder
+
+	// Patterns are masked in addition to the sequences, to mask credentials of which the value is not known in advance.
+	Patterns []Pattern
+
+	// Report is the writer to which a summary of the masked secrets is written by Stop().
+	// No summary is written when it is nil or when nothing has been masked.
+	Report io.Writer
+
+	// Format determines the text with which masked secrets are replaced.
+	// When it is nil, secrets are replaced with "<redacted by SecretHub>".
+	Format *Format
 }
 
 // New creates a new Masker that scans all streams for the given sequences and masks them.
-func New(sequences [][]byte, opts *Options) *Masker {
+// The forms of the sequences produced by the encoders in the options are masked as well.
+func New(sequences []Sequence, opts *Options) *Masker {
 	masker := &Masker{
 		bufferDelay: time.Millisecond * 50,
-		sequences:   sequences,
+		leaks:       newLeakCounter(),
 		stopChan:    make(chan struct{}),
 	}
 	frameChanlength := 1024
 	if opts != nil {
+		sequences = encodedSequences(sequences, opts.Encoders)
+		masker.patterns = opts.Patterns
+		masker.report = opts.Report
+		masker.format = opts.Format
+
 		if opts.DisableBuffer {
 			masker.bufferDelay = 0
 			frameChanlength = 0
@@ -63,6 +95,13 @@ func New(sequences [][]byte, opts *Options) *Masker {
 
 	}
 	masker.frames = make(chan frame, frameChanlength)
+	masker.names = make([]string, len(sequences))
+	values := make([][]byte, len(sequences))
+	for i, sequence := range sequences {
+		masker.names[i] = sequence.Name
+		values[i] = sequence.Value
+	}
+	masker.automaton = newAutomaton(values)
 
 	return masker
 }
@@ -72,8 +111,14 @@ func (m *Masker) AddStream(w io.Writer) io.Writer {
 	s := stream{
 		dest:          w,
 		registerFrame: m.registerFrame,
+		format:        m.format,
+		leaks:         m.leaks,
 		matches:       matches{},
-		matcher:       newMatcher(m.sequences),
+		matcher:       m.automaton.newMatcher(),
+	}
+	s.matcher.names = m.names
+	if len(m.patterns) > 0 {
+		s.patternMatcher = newPatternMatcher(m.patterns)
 	}
 	return &s
 }
@@ -111,9 +156,20 @@ func (m *Masker) Stop() error {
 	close(m.frames)
 	<-m.stopChan
 
+	if m.report != nil {
+		err := m.leaks.writeReport(m.report)
+		m.handleErr(err)
+	}
+
 	return m.err
 }
 
+// Leaks returns the names of the secrets that have been found in the output
+// and the number of times they occurred, ordered by name.
+func (m *Masker) Leaks() []Leak {
+	return m.leaks.list()
+}
+
 // registerFrame adds a new frame to the frames channel with a timeout of bufferDelay plus the given offset.
 // After this timer has passed, the frame will be flushed to the output.
 func (m *Masker) registerFrame(s *stream, offset time.Duration, l int) {
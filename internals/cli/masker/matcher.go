@@ -1,138 +1,294 @@
 package masker
 
-import (
-	"bytes"
-	"crypto/subtle"
-)
+// matches represents a set of sequence matches. The key is the index at which the match is found.
+// The index corresponds to the index of the byte in the BufferedIndex of the stream.
+type matches map[int64]match
 
-// matches represents a set of sequence matches. The key is the index at which the match is found and the value is the
-// length of the match. The index corresponds to the index of the byte in the BufferedIndex of the stream.
-type matches map[int64]int
+// match is a single occurrence of a sequence or pattern.
+type match struct {
+	length int
+	// name is the name of the matched sequence or pattern.
+	name string
+}
 
 // add a new match to the map if it does not yet exist or the existing match has a shorter length.
-func (m matches) add(index int64, length int) matches {
+func (m matches) add(index int64, length int, name string) matches {
 	existing, exists := m[index]
-	if !exists || existing < length {
-		m[index] = length
+	if !exists || existing.length < length {
+		m[index] = match{
+			length: length,
+			name:   name,
+		}
 	}
 	return m
 }
 
-// matcher combines multiple sequenceMatchers to check for matches of secrets against any of them.
+// automaton finds all given sequences in a stream of bytes in a single pass. The sequences are split over one or
+// more deterministic finite automata, so that the transition table of each of them stays small. An automaton is not
+// modified after it is created, so it can be shared by multiple matchers.
+//
+// Every input byte is processed by all parts, each with a single lookup in its transition table. As the number of
+// parts only depends on the sequences, processing a byte always takes the same time, regardless of the input.
+// This limits the information that can be derived from measuring the execution time of the masking functionality.
+type automaton struct {
+	parts []*denseAutomaton
+}
+
+// denseAutomaton is a deterministic finite automaton that finds sequences based on the Aho-Corasick algorithm.
+// Every state corresponds to a prefix of one or more of the sequences. It is fully expanded: for every state and
+// every input byte the next state is stored in a table.
+type denseAutomaton struct {
+	// classes maps every byte to its equivalence class. Bytes that do not occur in any sequence share class 0.
+	// Using classes instead of bytes keeps the transition table small.
+	classes    [256]int32
+	classCount int32
+	// transitions contains the next state for every state and class, at index state*classCount+class.
+	transitions []int32
+	// matchLength contains for every state the length of the longest sequence that ends in that state,
+	// or 0 if no sequence ends in that state.
+	matchLength []int32
+	// matchID contains for every state the index of the longest sequence that ends in that state,
+	// or -1 if no sequence ends in that state.
+	matchID []int32
+	// depth contains for every state the length of the prefix it corresponds to.
+	depth []int32
+}
+
+// maxDenseTransitions is the maximum number of entries of the transition table of a part of an automaton,
+// which takes 4 bytes per entry. Only a part with a single sequence can be larger.
+const maxDenseTransitions = 1 << 20
+
+// trieEdge is an edge of the trie from which an automaton is built.
+type trieEdge struct {
+	class int32
+	next  int32
+}
+
+// newAutomaton builds an automaton that matches all given sequences. Empty sequences are ignored.
+func newAutomaton(sequences [][]byte) *automaton {
+	return buildAutomaton(sequences, maxDenseTransitions)
+}
+
+// buildAutomaton builds an automaton that matches all given sequences, of which the parts have transition tables
+// of at most maxTransitions entries. The number of states of a part is estimated by the total length of its
+// sequences, which is the number of states of its trie when the sequences do not share any prefix.
+func buildAutomaton(sequences [][]byte, maxTransitions int) *automaton {
+	a := &automaton{}
+
+	var ids []int
+	var used [256]bool
+	classCount, stateCount := 1, 1
+	for id, sequence := range sequences {
+		if len(sequence) == 0 {
+			continue
+		}
+
+		withSequence, count := addClasses(used, classCount, sequence)
+		if len(ids) > 0 && (stateCount+len(sequence))*count > maxTransitions {
+			a.parts = append(a.parts, newDenseAutomaton(sequences, ids))
+			ids = nil
+			stateCount = 1
+			withSequence, count = addClasses([256]bool{}, 1, sequence)
+		}
+
+		ids = append(ids, id)
+		used, classCount = withSequence, count
+		stateCount += len(sequence)
+	}
+	if len(ids) > 0 {
+		a.parts = append(a.parts, newDenseAutomaton(sequences, ids))
+	}
+	return a
+}
+
+// addClasses marks the bytes of the sequence as used and returns the resulting number of classes.
+func addClasses(used [256]bool, classCount int, sequence []byte) ([256]bool, int) {
+	for _, b := range sequence {
+		if !used[b] {
+			used[b] = true
+			classCount++
+		}
+	}
+	return used, classCount
+}
+
+// newDenseAutomaton builds an automaton that matches the sequences with the given indices.
+func newDenseAutomaton(sequences [][]byte, ids []int) *denseAutomaton {
+	a := &denseAutomaton{
+		classCount: 1,
+	}
+	for _, id := range ids {
+		for _, b := range sequences[id] {
+			if a.classes[b] == 0 {
+				a.classes[b] = a.classCount
+				a.classCount++
+			}
+		}
+	}
+
+	// Build a trie of all sequences.
+	var children [][]trieEdge
+	addState := func(depth int32) int32 {
+		children = append(children, nil)
+		a.matchLength = append(a.matchLength, 0)
+		a.matchID = append(a.matchID, -1)
+		a.depth = append(a.depth, depth)
+		return int32(len(a.depth) - 1)
+	}
+	child := func(state, class int32) (int32, bool) {
+		for _, edge := range children[state] {
+			if edge.class == class {
+				return edge.next, true
+			}
+		}
+		return 0, false
+	}
+
+	addState(0)
+	for _, id := range ids {
+		sequence := sequences[id]
+		state := int32(0)
+		for i, b := range sequence {
+			class := a.classes[b]
+			next, ok := child(state, class)
+			if !ok {
+				next = addState(int32(i + 1))
+				children[state] = append(children[state], trieEdge{class: class, next: next})
+			}
+			state = next
+		}
+		a.matchLength[state] = int32(len(sequence))
+		a.matchID[state] = int32(id)
+	}
+
+	// Traverse the trie breadth-first to compute the failure links. The failure state of a state always has been
+	// processed already because it has a lower depth.
+	stateCount := len(a.depth)
+	fail := make([]int32, stateCount)
+	order := make([]int32, 0, stateCount)
+	order = append(order, 0)
+	for _, edge := range children[0] {
+		order = append(order, edge.next)
+	}
+	for i := 1; i < len(order); i++ {
+		state := order[i]
+
+		// The longest sequence ending in this state is either the prefix itself or a sequence ending in the failure state.
+		if a.matchLength[state] == 0 {
+			a.matchLength[state] = a.matchLength[fail[state]]
+			a.matchID[state] = a.matchID[fail[state]]
+		}
+
+		for _, edge := range children[state] {
+			f := fail[state]
+			for {
+				next, ok := child(f, edge.class)
+				if ok {
+					fail[edge.next] = next
+					break
+				}
+				if f == 0 {
+					break
+				}
+				f = fail[f]
+			}
+			order = append(order, edge.next)
+		}
+	}
+
+	// Missing edges are replaced by the corresponding edge of the failure state.
+	classCount := int(a.classCount)
+	a.transitions = make([]int32, stateCount*classCount)
+	for _, state := range order {
+		row := a.transitions[int(state)*classCount : int(state+1)*classCount]
+		if state != 0 {
+			copy(row, a.transitions[int(fail[state])*classCount:int(fail[state]+1)*classCount])
+		}
+		for _, edge := range children[state] {
+			row[edge.class] = edge.next
+		}
+	}
+	return a
+}
+
+// next returns the state the automaton moves to from the given state on the given input byte.
+func (a *denseAutomaton) next(state int32, in byte) int32 {
+	return a.transitions[state*a.classCount+a.classes[in]]
+}
+
+// size returns the number of bytes taken by the tables of the automaton.
+func (a *automaton) size() int {
+	size := 0
+	for _, part := range a.parts {
+		entries := len(part.transitions) + len(part.matchLength) + len(part.matchID) + len(part.depth)
+		size += 4*entries + 4*len(part.classes)
+	}
+	return size
+}
+
+// matcher checks for matches of secrets against any of the sequences of its automaton.
+// It keeps track of the current states, so matches can span multiple writes.
 type matcher struct {
-	detectors    []*sequenceDetector
+	automaton *automaton
+	// states contains the current state of every part of the automaton.
+	states       []int32
 	currentIndex int64
+	// names contains the names of the sequences of the automaton, by index. It can be nil.
+	names []string
 }
 
-// newMatcher returns a new matcher that contains a sequenceDetector for all given sequences.
+// newMatcher returns a new matcher that matches all given sequences.
 func newMatcher(sequences [][]byte) *matcher {
-	res := &matcher{
-		detectors: make([]*sequenceDetector, 0, len(sequences)),
-	}
-
-	for _, sequence := range sequences {
-		res.detectors = append(res.detectors, &sequenceDetector{
-			sequence: sequence,
-			offset:   0,
-		})
-		// Add detectors for any repetitions in the sequence.
-		// For example, if the sequence is "aaab", we also require a detector for "aaaab" and "aaaaab".
-		for length, count := range sequenceRepetitions(sequence) {
-			for i := 1; i <= count; i++ {
-				prefixedSequence := make([]byte, len(sequence)+length*i)
-				copy(prefixedSequence, sequence[:length*i])
-				copy(prefixedSequence[length*i:], sequence)
-				res.detectors = append(res.detectors, &sequenceDetector{
-					sequence: prefixedSequence,
-					offset:   length * i,
-				})
-			}
-		}
+	return newAutomaton(sequences).newMatcher()
+}
+
+// newMatcher returns a new matcher that uses the automaton.
+func (a *automaton) newMatcher() *matcher {
+	return &matcher{
+		automaton: a,
+		states:    make([]int32, len(a.parts)),
 	}
-	return res
 }
 
-// write takes in a slice of bytes and returns all matches found by any of its detectors.
+// write takes in a slice of bytes and returns all matches found in it.
+// When multiple sequences end at the same byte, only the longest one is returned,
+// as the shorter sequences are completely covered by it.
 func (m *matcher) write(in []byte) matches {
 	res := matches{}
 	for i, b := range in {
-		for _, detector := range m.detectors {
-			match := detector.writeByte(b)
-			if match {
-				res = res.add(m.currentIndex+int64(i-detector.length()+1), detector.length())
+		length, id := int32(0), int32(-1)
+		for j, part := range m.automaton.parts {
+			state := part.next(m.states[j], b)
+			m.states[j] = state
+			if part.matchLength[state] > length {
+				length = part.matchLength[state]
+				id = part.matchID[state]
 			}
 		}
+		if length > 0 {
+			res = res.add(m.currentIndex+int64(i)-int64(length)+1, int(length), m.name(int(id)))
+		}
 	}
 	m.currentIndex += int64(len(in))
 	return res
 }
 
-// sequenceDetector detects if a sequence is present in the bytes it receives.
-type sequenceDetector struct {
-	sequence []byte
-	offset   int
-	index    int
-}
-
-// length returns the length of the sequence corrected for its offset.
-func (d *sequenceDetector) length() int {
-	return len(d.sequence) - d.offset
-}
-
-// writeByte takes in a new byte to match against.
-// Returns true if the given byte results in a match with sequence
-// The implementation tries to reduce the effect of the input on the execution duration as much as possible
-// to limit the information that can be derived from measuring the execution time of the masking functionality.
-func (d *sequenceDetector) writeByte(in byte) bool {
-	// Implementation of the following code that limits data-dependency as much as possible.
-	//
-	// 	if d.sequence[d.index] == in {
-	// 		d.index++
-	//
-	//		if d.index == len(d.sequence) {
-	//			d.index = 0
-	//			return true
-	//		}
-	//		return false
-	//	} else if d.sequence[0] == in{
-	//		d.index = d.offset + 1
-	//	} else {
-	//      d.index = 0
-	//  }
-	//	return false
-
-	newIndex := d.index
-
-	correctInput := subtle.ConstantTimeByteEq(d.sequence[newIndex], in)
-	newIndex = subtle.ConstantTimeSelect(correctInput, newIndex+1, newIndex)
-	sequenceComplete := subtle.ConstantTimeEq(int32(len(d.sequence)), int32(newIndex))
-
-	newIndexIfNotCorrectInput := subtle.ConstantTimeSelect(subtle.ConstantTimeByteEq(d.sequence[0], in), d.offset+1, 0)
-	newIndex = subtle.ConstantTimeSelect(correctInput, newIndex, newIndexIfNotCorrectInput)
-
-	d.index = subtle.ConstantTimeSelect(sequenceComplete, 0, newIndex)
-
-	return sequenceComplete == 1
-}
-
-// sequenceRepetitions finds all repetitions of bytes in the start of the sequence and returns a map of the length of
-// repeated sequences as the key and the number of repetitions as the value.
-// Example, if the input is aabaabaab, the "a" is repeated 1 time and "aab" is repeated 2 times,
-// so the result is: {1:1, 3:2}
-func sequenceRepetitions(seq []byte) map[int]int {
-	res := map[int]int{}
-	for i := 1; i < len(seq); i++ {
-		count := 0
-		for j := 1; i*(j+1) <= len(seq); j++ {
-			if bytes.Equal(seq[0:i], seq[i*j:i*(j+1)]) {
-				count++
-			} else {
-				break
-			}
-		}
-		if count > 0 {
-			res[i] = count
+// name returns the name of the sequence with the given index.
+func (m *matcher) name(id int) string {
+	if id < len(m.names) {
+		return m.names[id]
+	}
+	return ""
+}
+
+// partialMatchLength returns the length of the longest suffix of the input written so far that is
+// the start of one of the sequences.
+func (m *matcher) partialMatchLength() int {
+	length := int32(0)
+	for j, part := range m.automaton.parts {
+		if part.depth[m.states[j]] > length {
+			length = part.depth[m.states[j]]
 		}
 	}
-	return res
+	return int(length)
 }
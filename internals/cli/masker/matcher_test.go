@@ -3,6 +3,7 @@ package masker
 import (
 	"fmt"
 	"math/rand"
+	"runtime"
 	"strconv"
 	"strings"
 	"testing"
@@ -43,8 +44,8 @@ func TestMatcher(t *testing.T) {
 				[]byte("123test"),
 			},
 			wantMatches: []matches{
-				map[int64]int{
-					3: 4,
+				matches{
+					3: {length: 4},
 				},
 			},
 		},
@@ -54,9 +55,9 @@ func TestMatcher(t *testing.T) {
 				[]byte("123test89test"),
 			},
 			wantMatches: []matches{
-				map[int64]int{
-					3: 4,
-					9: 4,
+				matches{
+					3: {length: 4},
+					9: {length: 4},
 				},
 			},
 		},
@@ -66,8 +67,8 @@ func TestMatcher(t *testing.T) {
 				[]byte("12test but longer"),
 			},
 			wantMatches: []matches{
-				map[int64]int{
-					2: 15,
+				matches{
+					2: {length: 15},
 				},
 			},
 		},
@@ -77,9 +78,9 @@ func TestMatcher(t *testing.T) {
 				[]byte("12another first sequence"),
 			},
 			wantMatches: []matches{
-				map[int64]int{
-					2:  13,
-					10: 14,
+				matches{
+					2:  {length: 13},
+					10: {length: 14},
 				},
 			},
 		},
@@ -91,8 +92,8 @@ func TestMatcher(t *testing.T) {
 			},
 			wantMatches: []matches{
 				nil,
-				map[int64]int{
-					4: 4,
+				matches{
+					4: {length: 4},
 				},
 			},
 		},
@@ -104,8 +105,8 @@ func TestMatcher(t *testing.T) {
 			},
 			wantMatches: []matches{
 				nil,
-				map[int64]int{
-					3: 4,
+				matches{
+					3: {length: 4},
 				},
 			},
 		},
@@ -115,8 +116,8 @@ func TestMatcher(t *testing.T) {
 				[]byte("1112"),
 			},
 			wantMatches: []matches{
-				map[int64]int{
-					1: 3,
+				matches{
+					1: {length: 3},
 				},
 			},
 		},
@@ -126,9 +127,9 @@ func TestMatcher(t *testing.T) {
 				[]byte("1112112"),
 			},
 			wantMatches: []matches{
-				map[int64]int{
-					1: 3,
-					4: 3,
+				matches{
+					1: {length: 3},
+					4: {length: 3},
 				},
 			},
 		},
@@ -138,9 +139,9 @@ func TestMatcher(t *testing.T) {
 				[]byte("334334334334334"),
 			},
 			wantMatches: []matches{
-				map[int64]int{
-					0: 12,
-					3: 12,
+				matches{
+					0: {length: 12},
+					3: {length: 12},
 				},
 			},
 		},
@@ -181,20 +182,20 @@ func TestMatcher_Repeats(t *testing.T) {
 				input := append(prefix, input...)
 				matcher := newMatcher(sequences)
 
-				matches := matcher.write(input)
+				gotMatches := matcher.write(input)
 
-				expected := map[int64]int{}
+				expected := matches{}
 				if i >= repeats {
-					expected[int64((i-repeats)*repeatLen+len(prefix))] = repeatLen*repeats + 1
+					expected[int64((i-repeats)*repeatLen+len(prefix))] = match{length: repeatLen*repeats + 1}
 				}
-				assert.Equal(t, matches, expected)
+				assert.Equal(t, gotMatches, expected)
 			})
 		}
 	}
 
 }
 
-func TestSequenceDetector(t *testing.T) {
+func TestMatcher_SingleSequence(t *testing.T) {
 	tests := []struct {
 		matchString     string
 		input           string
@@ -223,7 +224,7 @@ func TestSequenceDetector(t *testing.T) {
 		{
 			matchString:     "foofoobar",
 			input:           "foofoofoobar",
-			expectedMatches: []int{}, // This case is handled by adding multiple detectors with newMatcher
+			expectedMatches: []int{3},
 		},
 		{
 			matchString:     "test",
@@ -253,7 +254,7 @@ func TestSequenceDetector(t *testing.T) {
 		{
 			matchString:     "tt",
 			input:           "ttattt",
-			expectedMatches: []int{0, 3},
+			expectedMatches: []int{0, 3, 4},
 		},
 	}
 
@@ -261,12 +262,13 @@ func TestSequenceDetector(t *testing.T) {
 		name := fmt.Sprintf("%s in %s", tc.matchString, tc.input)
 
 		t.Run(name, func(t *testing.T) {
-			matcher := sequenceDetector{sequence: []byte(tc.matchString)}
+			matcher := newMatcher([][]byte{[]byte(tc.matchString)})
 			var matches []int
 			for i, b := range []byte(tc.input) {
-				match := matcher.writeByte(b)
-				if match {
-					matches = append(matches, i-len(tc.matchString)+1)
+				for index, match := range matcher.write([]byte{b}) {
+					assert.Equal(t, index, int64(i-len(tc.matchString)+1))
+					assert.Equal(t, match.length, len(tc.matchString))
+					matches = append(matches, int(index))
 				}
 			}
 			assert.Equal(t, matches, tc.expectedMatches)
@@ -275,60 +277,154 @@ func TestSequenceDetector(t *testing.T) {
 
 }
 
-func TestSequenceRepetitions(t *testing.T) {
+func TestAutomaton(t *testing.T) {
 	cases := map[string]struct {
-		sequence string
-		want     map[int]int
+		sequences   []string
+		input       string
+		wantMatches matches
 	}{
-		"no repetition": {
-			sequence: "abcd",
-			want:     map[int]int{},
+		"shorter sequence ending at the same byte": {
+			sequences: []string{"foo", "barfoo"},
+			input:     "xbarfoo",
+			wantMatches: matches{
+				1: {length: 6},
+			},
+		},
+		"shorter sequence at the same index": {
+			sequences: []string{"foobar", "foo"},
+			input:     "foobar",
+			wantMatches: matches{
+				0: {length: 6},
+			},
 		},
-		"single sequence": {
-			sequence: "aabcd",
-			want: map[int]int{
-				1: 1,
+		"match through failure link": {
+			sequences: []string{"abcd", "bce"},
+			input:     "abce",
+			wantMatches: matches{
+				1: {length: 3},
 			},
 		},
-		"single sequence multiple times": {
-			sequence: "aaaabcd",
-			want: map[int]int{
-				1: 3,
-				2: 1,
+		"sequence in the middle of a longer partial match": {
+			sequences: []string{"abcdef", "cd"},
+			input:     "abcdx",
+			wantMatches: matches{
+				2: {length: 2},
 			},
 		},
-		"double repetition": {
-			sequence: "aabcaabc",
-			want: map[int]int{
-				1: 1,
-				4: 1,
+		"duplicate sequences": {
+			sequences: []string{"foo", "foo"},
+			input:     "foo",
+			wantMatches: matches{
+				0: {length: 3},
 			},
 		},
-		"repetition with divider": {
-			sequence: "abcdabc",
-			want:     map[int]int{},
+		"empty sequence is ignored": {
+			sequences:   []string{""},
+			input:       "foo",
+			wantMatches: matches{},
+		},
+		"no sequences": {
+			input:       "foo",
+			wantMatches: matches{},
+		},
+		"non-ASCII bytes": {
+			sequences: []string{"\xff\x00\xfe"},
+			input:     "\x00\xff\xff\x00\xfe",
+			wantMatches: matches{
+				2: {length: 3},
+			},
 		},
 	}
 
 	for name, tc := range cases {
 		t.Run(name, func(t *testing.T) {
-			got := sequenceRepetitions([]byte(tc.sequence))
+			sequences := make([][]byte, len(tc.sequences))
+			for i, sequence := range tc.sequences {
+				sequences[i] = []byte(sequence)
+			}
 
-			assert.Equal(t, got, tc.want)
+			// Every sequence is put in a separate part when the transition tables can have no entries.
+			for _, maxTransitions := range []int{maxDenseTransitions, 0} {
+				gotMatches := buildAutomaton(sequences, maxTransitions).newMatcher().write([]byte(tc.input))
+				assert.Equal(t, gotMatches, tc.wantMatches)
+			}
 		})
 	}
 }
 
+func TestAutomaton_Parts(t *testing.T) {
+	// A small alphabet results in many partial matches, which span multiple parts.
+	random := rand.New(rand.NewSource(1))
+	randomBytes := func(n int) []byte {
+		res := make([]byte, n)
+		for i := range res {
+			res[i] = "abc"[random.Intn(3)]
+		}
+		return res
+	}
+
+	sequences := make([][]byte, 50)
+	for i := range sequences {
+		sequences[i] = randomBytes(2 + random.Intn(8))
+	}
+	input := randomBytes(10000)
+
+	single := buildAutomaton(sequences, maxDenseTransitions)
+	split := buildAutomaton(sequences, 64)
+	assert.Equal(t, len(single.parts), 1)
+	assert.Equal(t, len(split.parts) > 1, true)
+	for _, part := range split.parts {
+		assert.Equal(t, len(part.transitions) <= 64, true)
+	}
+
+	singleMatcher := single.newMatcher()
+	splitMatcher := split.newMatcher()
+	for i := 0; i < len(input); i += 7 {
+		end := i + 7
+		if end > len(input) {
+			end = len(input)
+		}
+		assert.Equal(t, splitMatcher.write(input[i:end]), singleMatcher.write(input[i:end]))
+		assert.Equal(t, splitMatcher.partialMatchLength(), singleMatcher.partialMatchLength())
+	}
+}
+
+func TestAutomaton_Size(t *testing.T) {
+	sequences, _ := encodedBenchmarkInput(t, 500)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	a := newAutomaton(sequences)
+	runtime.ReadMemStats(&after)
+
+	// The transition tables of the automaton for these sequences take about 34MB in total.
+	allocated := after.TotalAlloc - before.TotalAlloc
+	if allocated > 64<<20 {
+		t.Errorf("building the automaton allocated %dMB, expected at most 64MB", allocated>>20)
+	}
+	if a.size() > 40<<20 {
+		t.Errorf("the automaton takes %dMB, expected at most 40MB", a.size()>>20)
+	}
+	for _, part := range a.parts {
+		assert.Equal(t, len(part.transitions) <= maxDenseTransitions, true)
+	}
+}
+
+func TestAutomaton_SharedByMatchers(t *testing.T) {
+	a := newAutomaton([][]byte{[]byte("secret")})
+	m1 := a.newMatcher()
+	m2 := a.newMatcher()
+
+	assert.Equal(t, m1.write([]byte("sec")), matches{})
+	assert.Equal(t, m2.write([]byte("a secret")), matches{2: {length: 6}})
+	assert.Equal(t, m1.write([]byte("ret")), matches{0: {length: 6}})
+}
+
 func doBench(sequences [][]byte, input []byte) int {
 	m := newMatcher(sequences)
 	_ = m.write(input)
-	maxIndex := 0
-	for _, d := range m.detectors {
-		if d.index > maxIndex {
-			maxIndex = d.index
-		}
-	}
-	return maxIndex
+	return m.partialMatchLength()
 }
 
 func BenchmarkMatcher(b *testing.B) {
@@ -373,3 +469,112 @@ func BenchmarkMatcher(b *testing.B) {
 	})
 
 }
+
+// benchmarkInput returns the given number of random secrets and 64KiB of random log output
+// in which some of the secrets occur.
+func benchmarkInput(tb testing.TB, secretCount int) ([][]byte, []byte) {
+	sequences := make([][]byte, secretCount)
+	for i := range sequences {
+		seq, err := randchar.Generate(32)
+		assert.OK(tb, err)
+		sequences[i] = seq
+	}
+
+	var input []byte
+	for len(input) < 64*1024 {
+		line, err := randchar.Generate(80)
+		assert.OK(tb, err)
+		input = append(input, line...)
+		input = append(input, sequences[rand.Intn(secretCount)]...)
+		input = append(input, '\n')
+	}
+	return sequences, input[:64*1024]
+}
+
+// encodedBenchmarkInput returns the given number of random secrets together with all their encoded forms
+// and 64KiB of random log output in which some of the secrets occur.
+func encodedBenchmarkInput(tb testing.TB, secretCount int) ([][]byte, []byte) {
+	secrets, input := benchmarkInput(tb, secretCount)
+
+	sequences := make([]Sequence, len(secrets))
+	for i, secret := range secrets {
+		sequences[i] = Sequence{Value: secret}
+	}
+
+	var encoders []Encoder
+	for _, name := range EncoderNames() {
+		encoders = append(encoders, Encoders[name])
+	}
+
+	encoded := encodedSequences(sequences, encoders)
+	values := make([][]byte, len(encoded))
+	for i, sequence := range encoded {
+		values[i] = sequence.Value
+	}
+	return values, input
+}
+
+func BenchmarkMatcher_Write(b *testing.B) {
+	for _, secretCount := range []int{10, 100, 500} {
+		b.Run(fmt.Sprintf("%d secrets", secretCount), func(b *testing.B) {
+			sequences, input := benchmarkInput(b, secretCount)
+			m := newMatcher(sequences)
+
+			b.SetBytes(int64(len(input)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				m.write(input)
+			}
+		})
+	}
+}
+
+func BenchmarkPatternMatcher_Write(b *testing.B) {
+	_, input := benchmarkInput(b, 10)
+	m := newPatternMatcher(DefaultPatterns)
+
+	// Write the input in chunks of the size of a line, as a process usually does.
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < len(input); j += 128 {
+			end := j + 128
+			if end > len(input) {
+				end = len(input)
+			}
+			m.write(input[j:end])
+		}
+	}
+}
+
+func BenchmarkNewMatcher(b *testing.B) {
+	for _, secretCount := range []int{10, 100, 500} {
+		b.Run(fmt.Sprintf("%d secrets", secretCount), func(b *testing.B) {
+			sequences, _ := benchmarkInput(b, secretCount)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				newMatcher(sequences)
+			}
+		})
+	}
+}
+
+func BenchmarkNewMatcher_Encoded(b *testing.B) {
+	for _, secretCount := range []int{10, 100, 500} {
+		b.Run(fmt.Sprintf("%d secrets", secretCount), func(b *testing.B) {
+			sequences, input := encodedBenchmarkInput(b, secretCount)
+
+			var a *automaton
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				a = newAutomaton(sequences)
+			}
+			b.StopTimer()
+
+			b.ReportMetric(float64(a.size()), "automaton-bytes")
+			a.newMatcher().write(input)
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package masker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+// patternWindowSize is the maximum number of bytes that are kept to match patterns across multiple writes.
+// Matches of patterns that are longer than this are not detected.
+const patternWindowSize = 8 * 1024
+
+// Pattern is a regular expression that matches credentials of a well-known format,
+// for masking credentials of which the value is not known in advance.
+type Pattern struct {
+	Name   string
+	Regexp *regexp.Regexp
+}
+
+// DefaultPatterns contains patterns for commonly used credential formats.
+var DefaultPatterns = []Pattern{
+	{
+		Name:   "aws-access-key-id",
+		Regexp: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),
+	},
+	{
+		Name:   "private-key",
+		Regexp: regexp.MustCompile(`-----BEGIN[A-Z0-9 ]* PRIVATE KEY-----[\s\S]*?-----END[A-Z0-9 ]* PRIVATE KEY-----`),
+	},
+	{
+		Name:   "jwt",
+		Regexp: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+	},
+	{
+		Name:   "github-token",
+		Regexp: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36}|github_pat_[A-Za-z0-9_]{82})\b`),
+	},
+	{
+		Name:   "slack-token",
+		Regexp: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`),
+	},
+	{
+		Name:   "slack-webhook",
+		Regexp: regexp.MustCompile(`https://hooks\.slack\.com/services/T[A-Z0-9]+/B[A-Z0-9]+/[A-Za-z0-9]+`),
+	},
+}
+
+// ParsePatterns reads patterns from the given reader. Every line contains a single pattern
+// of the form `<name>: <regular expression>`. Empty lines and lines starting with # are ignored.
+func ParsePatterns(r io.Reader) ([]Pattern, error) {
+	var res []Pattern
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, expr, found := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		expr = strings.TrimSpace(expr)
+		if !found || name == "" || expr == "" {
+			return nil, fmt.Errorf("line %d: pattern is not of the form <name>: <regular expression>", lineNo)
+		}
+
+		regex, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid regular expression for pattern %s: %s", lineNo, name, err)
+		}
+
+		res = append(res, Pattern{
+			Name:   name,
+			Regexp: regex,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// patternMatcher finds matches of patterns in the bytes it receives.
+// The last written bytes are kept in a window, so that matches can span multiple writes.
+type patternMatcher struct {
+	patterns []Pattern
+	// prefixes contains for every pattern an expression that matches the end of the window from the first index
+	// at which a match of the pattern could still be completed by later writes, or nil if this cannot be determined.
+	prefixes []*regexp.Regexp
+	window   []byte
+	// windowIndex is the index of the first byte in the window.
+	windowIndex int64
+	// scanIndex is the index from which the window is scanned on the next write.
+	// All matches that start before it have already been returned.
+	scanIndex int64
+}
+
+func newPatternMatcher(patterns []Pattern) *patternMatcher {
+	prefixes := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		prefixes[i] = prefixRegexp(pattern.Regexp)
+	}
+	return &patternMatcher{
+		patterns: patterns,
+		prefixes: prefixes,
+	}
+}
+
+// write takes in a slice of bytes and returns all matches of the patterns that have been completed by it.
+// Matches that end at the end of the written bytes can still grow or disappear with the next write,
+// e.g. when the pattern ends with \b, so these are returned separately as pending matches.
+func (m *patternMatcher) write(in []byte) (found matches, pending matches) {
+	found, pending = matches{}, matches{}
+	m.window = append(m.window, in...)
+
+	start := int(m.scanIndex - m.windowIndex)
+	// The byte before the start is scanned as well, so that assertions like \b can see it.
+	context := 0
+	if start > 0 {
+		context = 1
+	}
+	scan := m.window[start-context:]
+
+	for _, pattern := range m.patterns {
+		for _, loc := range pattern.Regexp.FindAllIndex(scan, -1) {
+			if loc[0] < context || loc[1] == loc[0] {
+				continue
+			}
+			index := m.scanIndex + int64(loc[0]-context)
+			if loc[1] == len(scan) {
+				pending = pending.add(index, loc[1]-loc[0], pattern.Name)
+				continue
+			}
+			found = found.add(index, loc[1]-loc[0], pattern.Name)
+		}
+	}
+
+	// Bytes before the first index at which a match could still be completed never have to be scanned again.
+	next := len(m.window)
+	for _, prefix := range m.prefixes {
+		if prefix == nil {
+			next = start
+			break
+		}
+		loc := prefix.FindIndex(m.window[start:])
+		if loc != nil && start+loc[0] < next {
+			next = start + loc[0]
+		}
+	}
+	m.scanIndex = m.windowIndex + int64(next)
+
+	if len(m.window) > patternWindowSize {
+		drop := len(m.window) - patternWindowSize
+		m.window = append(m.window[:0], m.window[drop:]...)
+		m.windowIndex += int64(drop)
+		if m.scanIndex < m.windowIndex {
+			m.scanIndex = m.windowIndex
+		}
+	}
+	return found, pending
+}
+
+// prefixRegexp returns an expression that matches the end of the input from the first index at which
+// the input could be the start of a match of the given expression. It returns nil if no such
+// expression can be constructed.
+func prefixRegexp(re *regexp.Regexp) *regexp.Regexp {
+	parsed, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	prefix := concatRegexp(prefixOf(parsed), &syntax.Regexp{Op: syntax.OpEndText})
+	res, err := regexp.Compile(prefix.String())
+	if err != nil {
+		return nil
+	}
+	return res
+}
+
+// prefixOf returns an expression that matches every prefix of the strings matched by the given expression.
+// Empty-width assertions are treated as if they always match, as they can depend on bytes that have not been
+// written yet. So the returned expression can match more than just these prefixes, but never less.
+func prefixOf(re *syntax.Regexp) *syntax.Regexp {
+	switch re.Op {
+	case syntax.OpNoMatch:
+		return re
+	case syntax.OpLiteral:
+		// The prefixes of abc are matched by (?:a(?:bc?)?)?.
+		res := &syntax.Regexp{Op: syntax.OpEmptyMatch}
+		for i := len(re.Rune) - 1; i >= 0; i-- {
+			r := &syntax.Regexp{Op: syntax.OpLiteral, Flags: re.Flags, Rune: re.Rune[i : i+1]}
+			if i < len(re.Rune)-1 {
+				r = concatRegexp(r, res)
+			}
+			res = &syntax.Regexp{Op: syntax.OpQuest, Sub: []*syntax.Regexp{r}}
+		}
+		return res
+	case syntax.OpCharClass, syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return &syntax.Regexp{Op: syntax.OpQuest, Sub: []*syntax.Regexp{re}}
+	case syntax.OpCapture, syntax.OpQuest:
+		return prefixOf(re.Sub[0])
+	case syntax.OpStar, syntax.OpPlus:
+		return concatRegexp(&syntax.Regexp{Op: syntax.OpStar, Sub: re.Sub}, prefixOf(re.Sub[0]))
+	case syntax.OpRepeat:
+		if re.Max == -1 {
+			return concatRegexp(&syntax.Regexp{Op: syntax.OpStar, Sub: re.Sub}, prefixOf(re.Sub[0]))
+		}
+		if re.Max == 0 {
+			return &syntax.Regexp{Op: syntax.OpEmptyMatch}
+		}
+		return concatRegexp(&syntax.Regexp{Op: syntax.OpRepeat, Min: 0, Max: re.Max - 1, Sub: re.Sub}, prefixOf(re.Sub[0]))
+	case syntax.OpConcat:
+		// A prefix of xyz is a prefix of x, or x followed by a prefix of yz.
+		res := prefixOf(re.Sub[len(re.Sub)-1])
+		for i := len(re.Sub) - 2; i >= 0; i-- {
+			res = &syntax.Regexp{
+				Op:  syntax.OpAlternate,
+				Sub: []*syntax.Regexp{prefixOf(re.Sub[i]), concatRegexp(re.Sub[i], res)},
+			}
+		}
+		return res
+	case syntax.OpAlternate:
+		subs := make([]*syntax.Regexp, len(re.Sub))
+		for i, sub := range re.Sub {
+			subs[i] = prefixOf(sub)
+		}
+		return &syntax.Regexp{Op: syntax.OpAlternate, Sub: subs}
+	default:
+		return &syntax.Regexp{Op: syntax.OpEmptyMatch}
+	}
+}
+
+func concatRegexp(subs ...*syntax.Regexp) *syntax.Regexp {
+	return &syntax.Regexp{Op: syntax.OpConcat, Sub: subs}
+}
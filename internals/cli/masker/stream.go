@@ -12,10 +12,17 @@ type stream struct {
 	dest          io.Writer
 	buf           indexedBuffer
 	registerFrame func(*stream, time.Duration, int)
-
-	matcher     *matcher
-	matches     matches
-	matchesLock sync.Mutex
+	format        *Format
+	// leaks counts the secrets that are redacted. It can be nil.
+	leaks *leakCounter
+
+	matcher        *matcher
+	patternMatcher *patternMatcher
+	matches        matches
+	// pendingMatches are the matches of patterns that end at the end of the written bytes. These can still grow or
+	// disappear with the next write, so they are only masked if their bytes are flushed before that write.
+	pendingMatches matches
+	matchesLock    sync.Mutex
 }
 
 // Write implements the io.Writer interface for the stream.
@@ -28,8 +35,17 @@ func (s *stream) Write(p []byte) (int, error) {
 
 	n, err := s.buf.write(p)
 
-	for index, length := range s.matcher.write(p[:n]) {
-		s.addMatch(index, length)
+	for index, match := range s.matcher.write(p[:n]) {
+		s.addMatch(index, match)
+	}
+	if s.patternMatcher != nil {
+		found, pending := s.patternMatcher.write(p[:n])
+		for index, match := range found {
+			s.addMatch(index, match)
+		}
+		s.matchesLock.Lock()
+		s.pendingMatches = pending
+		s.matchesLock.Unlock()
 	}
 
 	if n > 0 {
@@ -39,15 +55,15 @@ func (s *stream) Write(p []byte) (int, error) {
 	return n, err
 }
 
-// addMatch adds the match of a secret at the given index and with the given length to the map of matches.
+// addMatch adds the match of a secret at the given index to the map of matches.
 // If the associated bytes have already been written to the destination, the match is ignored to avoid storing matches
 // that are never being processed by flush().
-func (s *stream) addMatch(index int64, length int) {
+func (s *stream) addMatch(index int64, m match) {
 	s.matchesLock.Lock()
 	defer s.matchesLock.Unlock()
 
 	if index >= s.buf.currentIndex {
-		s.matches = s.matches.add(index, length)
+		s.matches = s.matches.add(index, m.length, m.name)
 	}
 }
 
@@ -56,10 +72,18 @@ func (s *stream) flush(n int) error {
 	startIndex := s.buf.currentIndex
 	endIndex := startIndex + int64(n)
 
+	s.matchesLock.Lock()
+	for index, match := range s.pendingMatches {
+		if index >= startIndex && index < endIndex {
+			s.matches = s.matches.add(index, match.length, match.name)
+		}
+	}
+	s.matchesLock.Unlock()
+
 	// Increment the frameIndex before processing matches to avoid adding new matches in the processed frame.
 	for i := startIndex; i < endIndex; i++ {
 		s.matchesLock.Lock()
-		length, exists := s.matches[i]
+		match, exists := s.matches[i]
 		s.matchesLock.Unlock()
 
 		if exists {
@@ -72,14 +96,21 @@ func (s *stream) flush(n int) error {
 			// Only write the redaction text if there were bytes between this match and the previous match
 			// or this is the first flush for the buffer.
 			if bytesBeforeMatch > 0 || s.buf.currentIndex == 0 {
-				_, err = s.dest.Write([]byte("<redacted by SecretHub>"))
+				redaction, err := s.format.redaction(match.name)
+				if err != nil {
+					return err
+				}
+				_, err = s.dest.Write(redaction)
 				if err != nil {
 					return err
 				}
+				if s.leaks != nil {
+					s.leaks.add(match.name)
+				}
 			}
 
 			// Drop all bytes until the end of the mask.
-			_, err = s.buf.writeUpToIndex(io.Discard, i+int64(length))
+			_, err = s.buf.writeUpToIndex(io.Discard, i+int64(match.length))
 			if err != nil {
 				return err
 			}
@@ -149,6 +149,7 @@ func (app *App) registerCommands() {
 	NewCredentialCommand(app.io, app.clientFactory, app.credentialStore).Register(app.cli)
 	NewConfigCommand(app.io, app.credentialStore).Register(app.cli)
 	NewEnvCommand(app.io, app.clientFactory.NewClient).Register(app.cli)
+	NewTemplateCommand(app.io, app.clientFactory.NewClient).Register(app.cli)
 
 	// Commands
 	NewMigrateCommand(app.io, app.clientFactory.NewClient).Register(app.cli)
@@ -163,8 +164,8 @@ func (app *App) registerCommands() {
 	NewTreeCommand(app.io, app.clientFactory.NewClient).Register(app.cli)
 	NewInspectCommand(app.io, app.clientFactory.NewClient).Register(app.cli)
 	NewAuditCommand(app.io, app.clientFactory.NewClient).Register(app.cli)
-	NewInjectCommand(app.io, app.clientFactory.NewClient).Register(app.cli)
-	NewRunCommand(app.io, app.clientFactory.NewClient).Register(app.cli)
+	NewInjectCommand(app.io, app.clientFactory.NewClient, app.credentialStore).Register(app.cli)
+	NewRunCommand(app.io, app.clientFactory.NewClient, app.credentialStore).Register(app.cli)
 	NewPrintEnvCommand(app.cli, app.io).Register(app.cli)
 
 	// Hidden commands
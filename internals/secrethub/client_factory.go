@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 
 	"github.com/secrethub/secrethub-cli/internals/cli"
 
@@ -34,6 +35,7 @@ func NewClientFactory(store CredentialConfig) ClientFactory {
 
 type clientFactory struct {
 	client           *secrethub.Client
+	clientMutex      sync.Mutex
 	ServerURL        urlValue
 	identityProvider string
 	proxyAddress     urlValue
@@ -49,8 +51,11 @@ func (f *clientFactory) Register(app *cli.App) {
 }
 
 // NewClient returns a new client that is configured to use the remote that
-// is set with the flag.
+// is set with the flag. It is safe to call NewClient from multiple goroutines.
 func (f *clientFactory) NewClient() (secrethub.ClientInterface, error) {
+	f.clientMutex.Lock()
+	defer f.clientMutex.Unlock()
+
 	if f.client == nil {
 		var credentialProvider credentials.Provider
 		switch strings.ToLower(f.identityProvider) {
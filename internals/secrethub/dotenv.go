@@ -0,0 +1,314 @@
+package secrethub
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl"
+)
+
+// Errors
+var (
+	ErrDuplicateEnvVar         = errRun.Code("duplicate_env_var").ErrorPref("%s is defined on both line %d and line %d")
+	ErrDuplicateIncludedEnvVar = errRun.Code("duplicate_env_var").ErrorPref("%s is defined on both %s and %s")
+
+	errNotKeyValue           = errors.New("template is not formatted as key=value pairs")
+	errUnterminatedQuote     = errors.New("quoted value is not closed")
+	errCharsAfterQuotedValue = errors.New("unexpected characters after the closing quote, only a comment is allowed")
+)
+
+const (
+	doubleQuoteChar = '\u0022' // "
+	singleQuoteChar = '\u0027' // '
+	commentChar     = '#'
+	exportKeyword   = "export"
+)
+
+// doubleQuoteEscapes maps the characters that can be escaped with a backslash in a
+// double quoted value to the character they represent.
+var doubleQuoteEscapes = map[rune]rune{
+	'n':             '\n',
+	'r':             '\r',
+	't':             '\t',
+	doubleQuoteChar: doubleQuoteChar,
+}
+
+// templateEscapes contains the characters that are escaped with a backslash in a template.
+// These escapes are left as is in double quoted values, so that they are handled by the template parser.
+var templateEscapes = map[rune]bool{
+	'\\': true,
+	'$':  true,
+	'{':  true,
+	'}':  true,
+}
+
+// parseDotEnv parses key-value pairs in the .env syntax (key=value).
+// The variables are returned in the order in which they are declared.
+//
+// Rules:
+//   - Empty lines and lines starting with a # are ignored.
+//   - Keys may be prefixed with `export `, which is ignored.
+//   - Whitespace around keys and unquoted values is trimmed.
+//   - Unquoted values end at the end of the line or at a # preceded by whitespace, which starts a comment.
+//   - Single quoted values are taken literally and can span multiple lines.
+//   - Double quoted values can span multiple lines and support the escape sequences \n, \r, \t and \".
+//     The template escapes \\, \$, \{ and \} are kept as is for the template parser, as are other backslashes.
+//   - A quoted value can only be followed by whitespace and a comment.
+//   - Declaring a key more than once is an error.
+func parseDotEnv(r io.Reader) ([]envvar, error) {
+	return parseDotEnvLines(r, nil)
+}
+
+// parseDotEnvLines parses key-value pairs in the .env syntax like parseDotEnv does. The lines of the input
+// are the given lines, which can be lines of included templates, so that errors are located in the file
+// that contains them. The line numbers of the returned variables are the line numbers in the input.
+func parseDotEnvLines(r io.Reader, lines []tpl.Line) ([]envvar, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	l := dotEnvLexer{
+		input:  []rune(string(raw)),
+		lines:  lines,
+		lineNo: 1,
+		colNo:  1,
+	}
+
+	var res []envvar
+	declaredOn := map[string]int{}
+	for {
+		envvar, ok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return res, nil
+		}
+
+		if prevLine, found := declaredOn[envvar.key]; found {
+			return nil, l.duplicateError(envvar.key, prevLine, envvar.lineNumber)
+		}
+		declaredOn[envvar.key] = envvar.lineNumber
+
+		res = append(res, envvar)
+	}
+}
+
+// dotEnvLexer reads the variables from a .env file one by one,
+// while keeping track of the current line and column.
+type dotEnvLexer struct {
+	input []rune
+	// lines contains the source of every line of the input. It is nil when the input is a single file.
+	lines  []tpl.Line
+	pos    int
+	lineNo int
+	colNo  int
+}
+
+// next returns the next variable declared in the input.
+// The returned bool is false when the end of the input has been reached.
+func (l *dotEnvLexer) next() (envvar, bool, error) {
+	// Skip empty lines and comments.
+	for {
+		l.skipWhitespace()
+		if l.eof() {
+			return envvar{}, false, nil
+		}
+		switch l.peek() {
+		case '\n':
+			l.read()
+		case commentChar:
+			l.skipLine()
+		default:
+			return l.readVar()
+		}
+	}
+}
+
+// readVar reads a single key=value declaration, starting at the first character of the key.
+func (l *dotEnvLexer) readVar() (envvar, bool, error) {
+	lineNo := l.lineNo
+
+	if l.hasExportPrefix() {
+		for range exportKeyword {
+			l.read()
+		}
+		l.skipWhitespace()
+	}
+
+	columnNumberKey := l.colNo
+	var key strings.Builder
+	for !l.eof() && l.peek() != '=' && l.peek() != '\n' {
+		key.WriteRune(l.read())
+	}
+	if l.eof() || l.peek() != '=' {
+		return envvar{}, false, l.lineError(lineNo, errNotKeyValue)
+	}
+	l.read() // =
+
+	l.skipWhitespace()
+	columnNumberValue := l.colNo
+
+	var value string
+	var err error
+	if !l.eof() && (l.peek() == doubleQuoteChar || l.peek() == singleQuoteChar) {
+		columnNumberValue++
+		value, err = l.readQuoted()
+		if err != nil {
+			return envvar{}, false, l.lineError(lineNo, err)
+		}
+	} else {
+		value = l.readUnquoted()
+	}
+
+	return envvar{
+		key:               strings.TrimSpace(key.String()),
+		value:             value,
+		lineNumber:        lineNo,
+		columnNumberKey:   columnNumberKey,
+		columnNumberValue: columnNumberValue,
+	}, true, nil
+}
+
+// readUnquoted reads a value up to the end of the line or the start of a comment, with trailing whitespace trimmed.
+func (l *dotEnvLexer) readUnquoted() string {
+	var value strings.Builder
+	prevIsSpace := l.pos > 0 && isInlineSpace(l.input[l.pos-1])
+	for !l.eof() && l.peek() != '\n' {
+		if l.peek() == commentChar && prevIsSpace {
+			l.skipLine()
+			break
+		}
+		r := l.read()
+		prevIsSpace = isInlineSpace(r)
+		value.WriteRune(r)
+	}
+	return strings.TrimRight(value.String(), " \t\r")
+}
+
+// readQuoted reads a value wrapped in single or double quotes, starting at the opening quote.
+// After the closing quote, only whitespace and a comment are allowed on the same line.
+func (l *dotEnvLexer) readQuoted() (string, error) {
+	quote := l.read()
+
+	var value strings.Builder
+	for {
+		if l.eof() {
+			return "", errUnterminatedQuote
+		}
+
+		r := l.read()
+		if r == quote {
+			break
+		}
+
+		if r == '\\' && quote == doubleQuoteChar && !l.eof() {
+			if escaped, ok := doubleQuoteEscapes[l.peek()]; ok {
+				l.read()
+				r = escaped
+			} else if templateEscapes[l.peek()] {
+				value.WriteRune(r)
+				r = l.read()
+			}
+		}
+		value.WriteRune(r)
+	}
+
+	l.skipWhitespace()
+	if !l.eof() && l.peek() != '\n' {
+		if l.peek() != commentChar {
+			return "", errCharsAfterQuotedValue
+		}
+		l.skipLine()
+	}
+
+	return value.String(), nil
+}
+
+// hasExportPrefix returns whether the declaration starts with `export` followed by whitespace.
+func (l *dotEnvLexer) hasExportPrefix() bool {
+	n := len(exportKeyword)
+	if len(l.input)-l.pos <= n || string(l.input[l.pos:l.pos+n]) != exportKeyword {
+		return false
+	}
+	return isInlineSpace(l.input[l.pos+n])
+}
+
+// skipWhitespace skips whitespace up to the end of the line.
+func (l *dotEnvLexer) skipWhitespace() {
+	for !l.eof() && isInlineSpace(l.peek()) {
+		l.read()
+	}
+}
+
+// skipLine skips everything up to the end of the line.
+func (l *dotEnvLexer) skipLine() {
+	for !l.eof() && l.peek() != '\n' {
+		l.read()
+	}
+}
+
+// source returns the file and the number of the given line of the input within that file.
+// The file is empty for lines of the input itself.
+func (l *dotEnvLexer) source(lineNo int) (string, int) {
+	if lineNo < 1 || lineNo > len(l.lines) {
+		return "", lineNo
+	}
+	line := l.lines[lineNo-1]
+	return line.File, line.LineNo
+}
+
+// lineError returns the error for a variable declared on the given line of the input.
+func (l *dotEnvLexer) lineError(lineNo int, err error) error {
+	file, lineNo := l.source(lineNo)
+	if file != "" {
+		return ErrIncludedTemplate(file, lineNo, err)
+	}
+	return ErrTemplate(lineNo, err)
+}
+
+// duplicateError returns the error for a variable that is declared on both given lines of the input.
+func (l *dotEnvLexer) duplicateError(key string, prevLineNo, lineNo int) error {
+	prevFile, prevLineNo := l.source(prevLineNo)
+	file, lineNo := l.source(lineNo)
+	if prevFile == "" && file == "" {
+		return ErrDuplicateEnvVar(key, prevLineNo, lineNo)
+	}
+
+	describe := func(file string, lineNo int) string {
+		if file == "" {
+			return fmt.Sprintf("line %d", lineNo)
+		}
+		return fmt.Sprintf("line %d of %s", lineNo, file)
+	}
+	return ErrDuplicateIncludedEnvVar(key, describe(prevFile, prevLineNo), describe(file, lineNo))
+}
+
+func (l *dotEnvLexer) eof() bool {
+	return l.pos >= len(l.input)
+}
+
+func (l *dotEnvLexer) peek() rune {
+	return l.input[l.pos]
+}
+
+// read returns the current rune and advances to the next one.
+func (l *dotEnvLexer) read() rune {
+	r := l.input[l.pos]
+	l.pos++
+	if r == '\n' {
+		l.lineNo++
+		l.colNo = 1
+	} else {
+		l.colNo++
+	}
+	return r
+}
+
+// isInlineSpace returns whether the rune is whitespace that does not end a line.
+func isInlineSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\r' || r == '\v' || r == '\f'
+}
@@ -25,4 +25,6 @@ func (cmd *EnvCommand) Register(r cli.Registerer) {
 	clause.HelpLong("This command is hidden because it is still in beta. Future versions may break.")
 	NewEnvReadCommand(cmd.io, cmd.newClient).Register(clause)
 	NewEnvListCommand(cmd.io, cmd.newClient).Register(clause)
+	NewEnvExplainCommand(cmd.io, cmd.newClient).Register(clause)
+	NewEnvExportCommand(cmd.io, cmd.newClient).Register(clause)
 }
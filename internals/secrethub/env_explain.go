@@ -0,0 +1,153 @@
+package secrethub
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/secrethub/secrethub-cli/internals/cli"
+	"github.com/secrethub/secrethub-cli/internals/cli/ui"
+)
+
+// EnvExplainCommand is a command to show where the environment variables set in the process of `secrethub run` are sourced from.
+type EnvExplainCommand struct {
+	io          ui.IO
+	environment *environment
+}
+
+// NewEnvExplainCommand creates a new EnvExplainCommand.
+func NewEnvExplainCommand(io ui.IO, newClient newClientFunc) *EnvExplainCommand {
+	return &EnvExplainCommand{
+		io:          io,
+		environment: newEnvironment(io, newClient),
+	}
+}
+
+// Register adds a CommandClause and it's args and flags to a Registerer.
+func (cmd *EnvExplainCommand) Register(r cli.Registerer) {
+	clause := r.Command("explain", "[BETA] Show for every environment variable where it is sourced from.")
+	clause.HelpLong("For every environment variable, the source, file and line it is defined on are listed, together with the definitions in sources with a lower precedence that it overrides. " +
+		"The values of the environment variables are never printed.\n\n" +
+		"This command is hidden because it is still in beta. Future versions may break.")
+
+	cmd.environment.register(clause)
+
+	clause.BindAction(cmd.Run)
+	clause.BindArguments(nil)
+}
+
+// Run executes the command.
+func (cmd *EnvExplainCommand) Run() error {
+	layers, err := cmd.environment.layers()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(cmd.io.Output(), 0, 2, 2, ' ', 0)
+
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", "NAME", "SOURCE", "LOCATION", "SECRET", "OVERRIDES")
+	for _, explanation := range explainEnv(layers) {
+		overrides := make([]string, len(explanation.overrides))
+		for i, definition := range explanation.overrides {
+			overrides[i] = definition.String()
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			explanation.name,
+			explanation.definition.source,
+			orDash(explanation.definition.location()),
+			yesNo(explanation.definition.value.containsSecret()),
+			orDash(strings.Join(overrides, ", ")),
+		)
+	}
+
+	return w.Flush()
+}
+
+// envVarDefinition is the definition of an environment variable in a source.
+type envVarDefinition struct {
+	source string
+	value  value
+}
+
+// location returns the file and line the environment variable is defined on
+// or an empty string if the source is not a file.
+func (d envVarDefinition) location() string {
+	located, ok := d.value.(locatedValue)
+	if !ok {
+		return ""
+	}
+
+	file, line := located.location()
+	if line > 0 {
+		return fmt.Sprintf("%s:%d", file, line)
+	}
+	return file
+}
+
+// String returns the source and location of the definition.
+func (d envVarDefinition) String() string {
+	location := d.location()
+	if location == "" {
+		return d.source
+	}
+	return fmt.Sprintf("%s (%s)", d.source, location)
+}
+
+// envVarExplanation describes where an environment variable is sourced from.
+type envVarExplanation struct {
+	name       string
+	definition envVarDefinition
+	// overrides contains the definitions in sources with a lower precedence,
+	// ordered from highest to lowest precedence.
+	overrides []envVarDefinition
+}
+
+// explainEnv returns for every environment variable defined in the given layers,
+// ordered from lowest to highest precedence, the definition that is used and the
+// definitions it overrides. The result is sorted by the name of the variable.
+func explainEnv(layers []envLayer) []envVarExplanation {
+	definitions := make(map[string][]envVarDefinition)
+	for _, layer := range layers {
+		for name, value := range layer.vars {
+			definitions[name] = append(definitions[name], envVarDefinition{
+				source: layer.source,
+				value:  value,
+			})
+		}
+	}
+
+	result := make([]envVarExplanation, 0, len(definitions))
+	for name, defs := range definitions {
+		overrides := make([]envVarDefinition, len(defs)-1)
+		for i := range overrides {
+			overrides[i] = defs[len(defs)-2-i]
+		}
+
+		result = append(result, envVarExplanation{
+			name:       name,
+			definition: defs[len(defs)-1],
+			overrides:  overrides,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].name < result[j].name
+	})
+	return result
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
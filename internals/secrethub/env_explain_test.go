@@ -0,0 +1,68 @@
+package secrethub
+
+import (
+	"os"
+	"testing"
+
+	"github.com/secrethub/secrethub-cli/internals/cli/ui/fakeui"
+
+	"github.com/secrethub/secrethub-go/internals/assert"
+)
+
+func TestEnvExplainCommand_Run(t *testing.T) {
+	cases := map[string]struct {
+		environment *environment
+		out         string
+		err         error
+	}{
+		"layered env files": {
+			environment: &environment{
+				osEnv:           []string{"TEST=os", "DB_PASSWORD=secrethub://namespace/repo/db_password"},
+				envFiles:        []string{"secrethub.env", "secrethub.prod.env"},
+				templateVersion: "2",
+				readFile: func(filename string) ([]byte, error) {
+					switch filename {
+					case "secrethub.env":
+						return []byte("TEST=base\nAPI_KEY={{ namespace/repo/api_key }}"), nil
+					case "secrethub.prod.env":
+						return []byte("# production\nTEST=prod"), nil
+					}
+					return nil, os.ErrNotExist
+				},
+				envar: map[string]string{
+					"API_KEY": "namespace/repo/prod/api_key",
+				},
+			},
+			out: "NAME         SOURCE            LOCATION              SECRET  OVERRIDES\n" +
+				"API_KEY      --envar           -                     yes     env file (secrethub.env:2)\n" +
+				"DB_PASSWORD  secret reference  -                     yes     os environment\n" +
+				"TEST         env file          secrethub.prod.env:2  no      env file (secrethub.env:1), os environment\n",
+		},
+		"env file not found": {
+			environment: &environment{
+				envFiles: []string{"foo.env"},
+				readFile: func(filename string) ([]byte, error) {
+					return nil, os.ErrNotExist
+				},
+			},
+			err: ErrCannotReadFile("foo.env", os.ErrNotExist),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			io := fakeui.NewIO(t)
+			tc.environment.osStat = osStatFunc("secrethub.env", os.ErrNotExist)
+			tc.environment.dontPromptMissingTemplateVar = true
+
+			cmd := EnvExplainCommand{
+				io:          io,
+				environment: tc.environment,
+			}
+
+			err := cmd.Run()
+			assert.Equal(t, err, tc.err)
+			assert.Equal(t, io.Out.String(), tc.out)
+		})
+	}
+}
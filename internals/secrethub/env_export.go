@@ -0,0 +1,276 @@
+package secrethub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/secrethub/secrethub-cli/internals/cli"
+	"github.com/secrethub/secrethub-cli/internals/cli/filemode"
+	"github.com/secrethub/secrethub-cli/internals/cli/ui"
+
+	"github.com/spf13/cobra"
+)
+
+// Errors
+var (
+	ErrUnknownExportFormat   = errMain.Code("unknown_export_format").ErrorPref("unknown export format: '%s' supported formats are bash, fish, powershell, dotenv, json, docker and systemd")
+	ErrCannotExportMultiline = errMain.Code("cannot_export_multiline").ErrorPref("the value of %s spans multiple lines, which cannot be represented in the %s format")
+)
+
+// envExportFormats are the formats in which an environment can be exported.
+var envExportFormats = []string{"bash", "fish", "powershell", "dotenv", "json", "docker", "systemd"}
+
+// EnvExportCommand is a command to export the environment variables set in the process of `secrethub run`.
+type EnvExportCommand struct {
+	io          ui.IO
+	newClient   newClientFunc
+	environment *environment
+	format      string
+	outFile     string
+	fileMode    filemode.FileMode
+	force       bool
+}
+
+// NewEnvExportCommand creates a new EnvExportCommand.
+func NewEnvExportCommand(io ui.IO, newClient newClientFunc) *EnvExportCommand {
+	return &EnvExportCommand{
+		io:          io,
+		newClient:   newClient,
+		environment: newEnvironment(io, newClient),
+		fileMode:    filemode.New(0600),
+	}
+}
+
+// Register adds a CommandClause and it's args and flags to a Registerer.
+func (cmd *EnvExportCommand) Register(r cli.Registerer) {
+	clause := r.Command("export", "[BETA] Export the environment variables with their values in a format that can be read by other tools.")
+	clause.HelpLong("Variables that are only set in the environment of this command are not exported. " +
+		"Values are quoted and escaped for the chosen format. The docker format does not support quoting, so values that span multiple lines cannot be exported in that format.\n\n" +
+		"This command is hidden because it is still in beta. Future versions may break.")
+
+	cmd.environment.register(clause)
+	clause.Flags().StringVar(&cmd.format, "format", "dotenv", "The format in which the environment is exported. The options are bash, fish, powershell, dotenv, json, docker and systemd.")
+	_ = clause.Cmd.RegisterFlagCompletionFunc("format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return envExportFormats, cobra.ShellCompDirectiveDefault
+	})
+	clause.Flags().StringVarP(&cmd.outFile, "out-file", "o", "", "Write the exported environment to a file instead of stdout.")
+	clause.Flags().Var(&cmd.fileMode, "file-mode", "Set filemode for the output file. An existing file gets this filemode as well. It is ignored without the --out-file flag.")
+	clause.Flags().BoolVarP(&cmd.force, "force", "f", false, "Overwrite the output file if it already exists, without prompting for confirmation. This flag is ignored if no --out-file is supplied.")
+
+	clause.BindAction(cmd.Run)
+	clause.BindArguments(nil)
+}
+
+// Run executes the command.
+func (cmd *EnvExportCommand) Run() error {
+	layers, err := cmd.environment.layers()
+	if err != nil {
+		return err
+	}
+
+	// Only variables that are defined by SecretHub are exported,
+	// the environment of this command is only used to resolve them.
+	var envs []map[string]value
+	for _, layer := range layers {
+		if layer.source != sourceOSEnv {
+			envs = append(envs, layer.vars)
+		}
+	}
+	env := mergeEnvs(envs...)
+
+	var paths []string
+	for _, value := range env {
+		valuePaths, err := value.secretPaths()
+		if err != nil {
+			return err
+		}
+		paths = append(paths, valuePaths...)
+	}
+
+	secretReader := newPrefetchSecretReader(newSecretReader(cmd.newClient), secretReadConcurrency)
+	secretReader.Prefetch(paths)
+
+	values := make(map[string]string, len(env))
+	for name, value := range env {
+		values[name], err = value.resolve(secretReader)
+		if err != nil {
+			return err
+		}
+	}
+
+	out, err := formatEnv(cmd.format, values)
+	if err != nil {
+		return err
+	}
+
+	if cmd.outFile == "" {
+		_, err = cmd.io.Output().Write(out)
+		return err
+	}
+
+	_, err = os.Stat(cmd.outFile)
+	if err == nil && !cmd.force {
+		if cmd.io.IsOutputPiped() {
+			return ErrFileAlreadyExists
+		}
+
+		confirmed, err := ui.AskYesNo(
+			cmd.io,
+			fmt.Sprintf(
+				"File %s already exists, overwrite it?",
+				cmd.outFile,
+			),
+			ui.DefaultNo,
+		)
+		if err != nil {
+			return err
+		}
+
+		if !confirmed {
+			fmt.Fprintln(cmd.io.Output(), "Aborting.")
+			return nil
+		}
+	}
+
+	err = replaceFile(cmd.outFile, out, cmd.fileMode.FileMode())
+	if err != nil {
+		return ErrCannotWrite(cmd.outFile, err)
+	}
+
+	absPath, err := filepath.Abs(cmd.outFile)
+	if err != nil {
+		return ErrCannotWrite(err)
+	}
+
+	fmt.Fprintf(cmd.io.Output(), "%s\n", absPath)
+	return nil
+}
+
+// replaceFile writes the data to a temporary file with the given mode and moves it over the file with the given name.
+// Unlike os.WriteFile, an existing file does not keep its own mode, so secrets are never written to a file that is more
+// widely readable than intended.
+func replaceFile(filename string, data []byte, mode os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = tmp.Chmod(mode)
+	if err == nil {
+		_, err = tmp.Write(data)
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
+
+// formatEnv returns the given environment variables in the given format, sorted by name.
+func formatEnv(format string, values map[string]string) ([]byte, error) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if format == "json" {
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "    ")
+		err := encoder.Encode(values)
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	var formatLine func(name, value string) (string, error)
+	switch format {
+	case "bash":
+		formatLine = func(name, value string) (string, error) {
+			return "export " + name + "=" + quotePOSIX(value), nil
+		}
+	case "fish":
+		formatLine = func(name, value string) (string, error) {
+			return "set -gx " + name + " " + quoteFish(value), nil
+		}
+	case "powershell":
+		formatLine = func(name, value string) (string, error) {
+			return "$env:" + name + " = " + quotePowerShell(value), nil
+		}
+	case "dotenv":
+		formatLine = func(name, value string) (string, error) {
+			return name + "=" + quoteDotEnv(value), nil
+		}
+	case "docker":
+		formatLine = func(name, value string) (string, error) {
+			if strings.ContainsAny(value, "\r\n") {
+				return "", ErrCannotExportMultiline(name, format)
+			}
+			return name + "=" + value, nil
+		}
+	case "systemd":
+		formatLine = func(name, value string) (string, error) {
+			return name + "=" + quoteSystemd(value), nil
+		}
+	default:
+		return nil, ErrUnknownExportFormat(format)
+	}
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		line, err := formatLine(name, values[name])
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// quotePOSIX wraps the value in single quotes, in which every character is taken literally.
+// Single quotes in the value are ended, escaped and reopened.
+func quotePOSIX(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// quoteFish wraps the value in single quotes. Inside single quotes,
+// fish only treats backslashes and single quotes specially.
+func quoteFish(value string) string {
+	r := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	return "'" + r.Replace(value) + "'"
+}
+
+// quotePowerShell wraps the value in single quotes, in which single quotes are escaped by doubling them.
+func quotePowerShell(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// quoteDotEnv wraps the value in double quotes and escapes backslashes, double quotes and line breaks.
+// Dollar signs and opening braces are escaped as well, so that tools that expand variables in .env files
+// keep them as is and an exported file read with --env-file results in the same values instead of templates.
+func quoteDotEnv(value string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, `$`, `\$`, `{`, `\{`)
+	return `"` + r.Replace(value) + `"`
+}
+
+// quoteSystemd wraps the value in double quotes as read by the EnvironmentFile directive.
+// Inside double quotes, line breaks are preserved and backslashes, double quotes,
+// backticks and dollar signs have to be escaped.
+func quoteSystemd(value string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`", `$`, `\$`)
+	return `"` + r.Replace(value) + `"`
+}
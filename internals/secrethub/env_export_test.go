@@ -0,0 +1,183 @@
+package secrethub
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/secrethub/secrethub-cli/internals/cli/filemode"
+	"github.com/secrethub/secrethub-cli/internals/cli/ui/fakeui"
+	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl/fakes"
+
+	"github.com/secrethub/secrethub-go/internals/api"
+	"github.com/secrethub/secrethub-go/internals/assert"
+	"github.com/secrethub/secrethub-go/pkg/secrethub"
+	"github.com/secrethub/secrethub-go/pkg/secrethub/fakeclient"
+)
+
+func TestFormatEnv(t *testing.T) {
+	values := map[string]string{
+		"SIMPLE":    "foo",
+		"MULTILINE": "-----BEGIN KEY-----\nabc\n-----END KEY-----",
+		"QUOTES":    `it's "quoted" $HOME \n`,
+	}
+
+	cases := map[string]struct {
+		format   string
+		values   map[string]string
+		expected string
+		err      error
+	}{
+		"bash": {
+			format: "bash",
+			values: values,
+			expected: "export MULTILINE='-----BEGIN KEY-----\nabc\n-----END KEY-----'\n" +
+				`export QUOTES='it'\''s "quoted" $HOME \n'` + "\n" +
+				"export SIMPLE='foo'\n",
+		},
+		"fish": {
+			format: "fish",
+			values: values,
+			expected: "set -gx MULTILINE '-----BEGIN KEY-----\nabc\n-----END KEY-----'\n" +
+				`set -gx QUOTES 'it\'s "quoted" $HOME \\n'` + "\n" +
+				"set -gx SIMPLE 'foo'\n",
+		},
+		"powershell": {
+			format: "powershell",
+			values: values,
+			expected: "$env:MULTILINE = '-----BEGIN KEY-----\nabc\n-----END KEY-----'\n" +
+				`$env:QUOTES = 'it''s "quoted" $HOME \n'` + "\n" +
+				"$env:SIMPLE = 'foo'\n",
+		},
+		"dotenv": {
+			format: "dotenv",
+			values: values,
+			expected: `MULTILINE="-----BEGIN KEY-----\nabc\n-----END KEY-----"` + "\n" +
+				`QUOTES="it's \"quoted\" \$HOME \\n"` + "\n" +
+				`SIMPLE="foo"` + "\n",
+		},
+		"json": {
+			format: "json",
+			values: values,
+			expected: "{\n" +
+				`    "MULTILINE": "-----BEGIN KEY-----\nabc\n-----END KEY-----",` + "\n" +
+				`    "QUOTES": "it's \"quoted\" $HOME \\n",` + "\n" +
+				`    "SIMPLE": "foo"` + "\n" +
+				"}\n",
+		},
+		"docker": {
+			format: "docker",
+			values: map[string]string{
+				"QUOTES": `it's "quoted"`,
+				"SIMPLE": "foo",
+			},
+			expected: `QUOTES=it's "quoted"` + "\n" +
+				"SIMPLE=foo\n",
+		},
+		"docker multiline": {
+			format: "docker",
+			values: values,
+			err:    ErrCannotExportMultiline("MULTILINE", "docker"),
+		},
+		"systemd": {
+			format: "systemd",
+			values: values,
+			expected: "MULTILINE=\"-----BEGIN KEY-----\nabc\n-----END KEY-----\"\n" +
+				`QUOTES="it's \"quoted\" \$HOME \\n"` + "\n" +
+				`SIMPLE="foo"` + "\n",
+		},
+		"unknown format": {
+			format: "yaml",
+			values: values,
+			err:    ErrUnknownExportFormat("yaml"),
+		},
+		"empty": {
+			format:   "bash",
+			expected: "",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			actual, err := formatEnv(tc.format, tc.values)
+			assert.Equal(t, err, tc.err)
+			assert.Equal(t, string(actual), tc.expected)
+		})
+	}
+}
+
+func TestFormatEnv_DotEnvRoundTrip(t *testing.T) {
+	values := map[string]string{
+		"DOLLAR":    "pa$$word${HOME}$",
+		"QUOTES":    `it's "quoted" \n \$ \\`,
+		"MULTILINE": "foo\r\nbar",
+		"TEMPLATE":  "{{ path/to/secret }} ${namespace/repo/secret} {}",
+	}
+
+	out, err := formatEnv("dotenv", values)
+	assert.OK(t, err)
+
+	// The exported file is read like an env file given with --env-file.
+	parser, err := getTemplateParser(out, "auto", "secrethub.env", os.ReadFile)
+	assert.OK(t, err)
+	env, err := NewEnv("secrethub.env", bytes.NewReader(out), fakes.FakeVariableReader{}, parser)
+	assert.OK(t, err)
+	envValues, err := env.env()
+	assert.OK(t, err)
+
+	actual := make(map[string]string, len(envValues))
+	for name, value := range envValues {
+		assert.Equal(t, value.containsSecret(), false)
+		actual[name], err = value.resolve(fakes.FakeSecretReader{})
+		assert.OK(t, err)
+	}
+	assert.Equal(t, actual, values)
+}
+
+func TestEnvExportCommand_Run(t *testing.T) {
+	outFile := filepath.Join(t.TempDir(), "secrethub.env")
+	// An existing file that is readable by everyone does not keep its mode.
+	err := os.WriteFile(outFile, []byte("OLD=old\n"), 0644)
+	assert.OK(t, err)
+	err = os.Chmod(outFile, 0644)
+	assert.OK(t, err)
+
+	io := fakeui.NewIO(t)
+	cmd := EnvExportCommand{
+		io:       io,
+		format:   "dotenv",
+		outFile:  outFile,
+		fileMode: filemode.New(0600),
+		force:    true,
+		environment: &environment{
+			osEnv:  []string{"HOME=/home/user"},
+			osStat: osStatFunc("secrethub.env", os.ErrNotExist),
+			envar: map[string]string{
+				"FOO": "namespace/repo/foo",
+			},
+		},
+		newClient: func() (secrethub.ClientInterface, error) {
+			return fakeclient.Client{
+				SecretService: &fakeclient.SecretService{
+					VersionService: &fakeclient.SecretVersionService{
+						GetWithDataFunc: func(path string) (*api.SecretVersion, error) {
+							return &api.SecretVersion{Data: []byte("foo\nbar")}, nil
+						},
+					},
+				},
+			}, nil
+		},
+	}
+
+	err = cmd.Run()
+	assert.OK(t, err)
+
+	out, err := os.ReadFile(outFile)
+	assert.OK(t, err)
+	assert.Equal(t, string(out), `FOO="foo\nbar"`+"\n")
+
+	info, err := os.Stat(outFile)
+	assert.OK(t, err)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0600))
+}
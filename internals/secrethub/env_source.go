@@ -1,15 +1,14 @@
 package secrethub
 
 import (
-	"bufio"
 	"bytes"
-	"errors"
 	"fmt"
 	"io"
 	"os"
+	gopath "path"
 	"path/filepath"
+	"regexp"
 	"strings"
-	"unicode"
 
 	"github.com/secrethub/secrethub-cli/internals/cli"
 	"github.com/secrethub/secrethub-cli/internals/secretspec"
@@ -23,6 +22,13 @@ import (
 	"gopkg.in/yaml.v2"
 )
 
+// Errors
+var (
+	ErrInvalidSecretsDir        = errRun.Code("invalid_secrets_dir").ErrorPref("invalid --secrets-dir value %s: %s")
+	ErrInvalidSecretsDirPattern = errRun.Code("invalid_secrets_dir_pattern").ErrorPref("invalid secrets dir pattern %s: %s")
+	ErrInvalidSecretsDirDepth   = errRun.Code("invalid_secrets_dir_depth").ErrorPref("invalid --secrets-dir-depth %d: the depth must be -1 to include all secrets or at least 1")
+)
+
 type errNameCollision struct {
 	name  string
 	paths [2]string
@@ -39,12 +45,17 @@ type environment struct {
 	readFile                     func(filename string) ([]byte, error)
 	osStat                       func(filename string) (os.FileInfo, error)
 	envar                        map[string]string
-	envFile                      string
+	envFiles                     []string
 	templateVars                 map[string]string
 	templateVersion              string
 	dontPromptMissingTemplateVar bool
-	secretsDir                   string
+	secretsDirs                  []string
+	secretsDirDepth              int
+	secretsDirInclude            []string
+	secretsDirExclude            []string
 	secretsEnvDir                string
+	// includedFiles contains the files of the templates included by the env files when they were last read.
+	includedFiles []string
 }
 
 func newEnvironment(io ui.IO, newClient newClientFunc) *environment {
@@ -61,8 +72,8 @@ func newEnvironment(io ui.IO, newClient newClientFunc) *environment {
 
 func (env *environment) register(clause *cli.CommandClause) {
 	clause.Flags().StringToStringVarP(&env.envar, "envar", "e", nil, "Source an environment variable from a secret at a given path with `NAME=<path>`")
-	clause.Flags().StringVar(&env.envFile, "env-file", "", "The path to a file with environment variable mappings of the form `NAME=value`. Template syntax can be used to inject secrets.")
-	clause.Flags().StringVar(&env.envFile, "template", "", "")
+	clause.Flags().StringArrayVar(&env.envFiles, "env-file", nil, "The path to a file with environment variable mappings of the form `NAME=value`. Template syntax can be used to inject secrets. Can be repeated to layer env files, in which case variables in later files override those in earlier files.")
+	clause.Flags().StringArrayVar(&env.envFiles, "template", nil, "")
 	clause.Cmd.Flag("template").Hidden = true
 	clause.Flags().StringToStringVarP(&env.templateVars, "var", "v", nil, "Define the value for a template variable with `VAR=VALUE`, e.g. --var env=prod")
 	clause.Flags().StringVar(&env.templateVersion, "template-version", "auto", "The template syntax version to be used. The options are v1, v2, latest or auto to automatically detect the version.")
@@ -70,18 +81,57 @@ func (env *environment) register(clause *cli.CommandClause) {
 		return []string{"v1", "v2", "latest", "auto"}, cobra.ShellCompDirectiveDefault
 	})
 	clause.Flags().BoolVar(&env.dontPromptMissingTemplateVar, "no-prompt", false, "Do not prompt when a template variable is missing and return an error instead.")
-	clause.Flags().StringVar(&env.secretsDir, "secrets-dir", "", "Recursively include all secrets from a directory. Environment variable names are derived from the path of the secret: `/` are replaced with `_` and the name is uppercased.")
+	clause.Flags().StringArrayVar(&env.secretsDirs, "secrets-dir", nil, "Recursively include all secrets from a directory. Environment variable names are derived from the path of the secret: `/` are replaced with `_` and the name is uppercased. "+
+		"Naming rules can be appended as `<path>:prefix=<prefix>:rename=s/<regex>/<replacement>/`, in which the prefix is prepended to the names and the rename rule is applied to the paths relative to the directory before they are converted to names. "+
+		"The rename rule has to be the last option. Can be repeated to include multiple directories.")
+	clause.Flags().IntVar(&env.secretsDirDepth, "secrets-dir-depth", -1, "The maximum depth of the secrets included from a directory with --secrets-dir, where 1 only includes the secrets directly in the directory. When set to -1, all secrets are included. Other values below 1 are not allowed.")
+	clause.Flags().StringArrayVar(&env.secretsDirInclude, "secrets-dir-include", nil, "Only include secrets from a directory with --secrets-dir of which the path relative to the directory matches the given glob pattern, e.g. `db/*`. Can be repeated to include secrets matching any of the patterns.")
+	clause.Flags().StringArrayVar(&env.secretsDirExclude, "secrets-dir-exclude", nil, "Do not include secrets from a directory with --secrets-dir of which the path relative to the directory matches the given glob pattern. Can be repeated.")
 	clause.Flags().StringVar(&env.secretsEnvDir, "env", "default", "The name of the environment prepared by the set command.")
 	clause.Cmd.Flag("env").Hidden = true
 }
 
 func (env *environment) env() (map[string]value, error) {
+	layers, err := env.layers()
+	if err != nil {
+		return nil, err
+	}
+
+	envs := make([]map[string]value, len(layers))
+	for i, layer := range layers {
+		envs[i] = layer.vars
+	}
+
+	return mergeEnvs(envs...), nil
+}
+
+// Names of the sources of environment variables.
+const (
+	sourceOSEnv         = "os environment"
+	sourceSecretsEnvDir = ".secretsenv directory"
+	sourceSecretsDir    = "--secrets-dir"
+	sourceEnvFile       = "env file"
+	sourceReference     = "secret reference"
+	sourceEnvarFlag     = "--envar"
+)
+
+// envLayer contains the environment variables defined by a single source.
+type envLayer struct {
+	source string
+	vars   map[string]value
+}
+
+// layers returns the environment variables defined by every source, ordered
+// from lowest to highest precedence.
+func (env *environment) layers() ([]envLayer, error) {
 	osEnvMap, _ := parseKeyValueStringsToMap(env.osEnv)
 	var sources []EnvSource
+	var sourceNames []string
 
 	sources = append(sources, &osEnv{
 		osEnv: osEnvMap,
 	})
+	sourceNames = append(sourceNames, sourceOSEnv)
 
 	// .secretsenv dir (for backwards compatibility)
 	envDir := filepath.Join(secretspec.SecretEnvPath, env.secretsEnvDir)
@@ -92,25 +142,41 @@ func (env *environment) env() (map[string]value, error) {
 			return nil, err
 		}
 		sources = append(sources, dirSource)
+		sourceNames = append(sourceNames, sourceSecretsEnvDir)
 	}
 
 	// --secrets-dir flag
-	if env.secretsDir != "" {
-		secretsDirEnv := newSecretsDirEnv(env.newClient, env.secretsDir)
-		sources = append(sources, secretsDirEnv)
+	if len(env.secretsDirs) > 0 {
+		// A depth of 0 would include all secrets, so only -1 is accepted for that.
+		if env.secretsDirDepth == 0 || env.secretsDirDepth < -1 {
+			return nil, ErrInvalidSecretsDirDepth(env.secretsDirDepth)
+		}
+
+		secretsDirs := make(secretsDirsEnv, len(env.secretsDirs))
+		for i, value := range env.secretsDirs {
+			secretsDirs[i], err = parseSecretsDirEnv(env.newClient, value)
+			if err != nil {
+				return nil, err
+			}
+			secretsDirs[i].depth = env.secretsDirDepth
+			secretsDirs[i].include = env.secretsDirInclude
+			secretsDirs[i].exclude = env.secretsDirExclude
+		}
+		sources = append(sources, secretsDirs)
+		sourceNames = append(sourceNames, sourceSecretsDir)
 	}
 
 	//secrethub.env file
-	if env.envFile == "" {
+	if len(env.envFiles) == 0 {
 		_, err := env.osStat(defaultEnvFile)
 		if err == nil {
-			env.envFile = defaultEnvFile
+			env.envFiles = []string{defaultEnvFile}
 		} else if !os.IsNotExist(err) {
 			return nil, ErrReadDefaultEnvFile(defaultEnvFile, err)
 		}
 	}
 
-	if env.envFile != "" {
+	if len(env.envFiles) > 0 {
 		templateVariableReader, err := newVariableReader(osEnvMap, env.templateVars)
 		if err != nil {
 			return nil, err
@@ -120,26 +186,34 @@ func (env *environment) env() (map[string]value, error) {
 			templateVariableReader = newPromptMissingVariableReader(templateVariableReader, env.io)
 		}
 
-		raw, err := env.readFile(env.envFile)
-		if err != nil {
-			return nil, ErrCannotReadFile(env.envFile, err)
-		}
+		env.includedFiles = nil
 
-		parser, err := getTemplateParser(raw, env.templateVersion)
-		if err != nil {
-			return nil, err
-		}
+		// Env files are layered in the order in which they are given,
+		// so variables in later files override those in earlier files.
+		for _, envFilePath := range env.envFiles {
+			raw, err := env.readFile(envFilePath)
+			if err != nil {
+				return nil, ErrCannotReadFile(envFilePath, err)
+			}
 
-		envFile, err := ReadEnvFile(env.envFile, bytes.NewReader(raw), templateVariableReader, parser)
-		if err != nil {
-			return nil, err
+			parser, err := getTemplateParser(raw, env.templateVersion, envFilePath, env.readIncludedFile)
+			if err != nil {
+				return nil, err
+			}
+
+			envFile, err := ReadEnvFile(envFilePath, bytes.NewReader(raw), templateVariableReader, parser)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, envFile)
+			sourceNames = append(sourceNames, sourceEnvFile)
 		}
-		sources = append(sources, envFile)
 	}
 
 	// secret references (secrethub://)
 	referenceEnv := newReferenceEnv(osEnvMap)
 	sources = append(sources, referenceEnv)
+	sourceNames = append(sourceNames, sourceReference)
 
 	// --envar flag
 	// TODO: Validate the flags when parsing by implementing the Flag interface for EnvFlags.
@@ -148,17 +222,28 @@ func (env *environment) env() (map[string]value, error) {
 		return nil, err
 	}
 	sources = append(sources, flagEnv)
+	sourceNames = append(sourceNames, sourceEnvarFlag)
 
-	envs := make([]map[string]value, len(sources))
-	for _, source := range sources {
-		env, err := source.env()
+	layers := make([]envLayer, len(sources))
+	for i, source := range sources {
+		vars, err := source.env()
 		if err != nil {
 			return nil, err
 		}
-		envs = append(envs, env)
+		layers[i] = envLayer{
+			source: sourceNames[i],
+			vars:   vars,
+		}
 	}
 
-	return mergeEnvs(envs...), nil
+	return layers, nil
+}
+
+// readIncludedFile reads the file of a template included by an env file and keeps track of it,
+// so that it can be watched for changes.
+func (env *environment) readIncludedFile(filename string) ([]byte, error) {
+	env.includedFiles = append(env.includedFiles, filename)
+	return env.readFile(filename)
 }
 
 func mergeEnvs(envs ...map[string]value) map[string]value {
@@ -180,6 +265,15 @@ type EnvSource interface {
 type value interface {
 	resolve(tpl.SecretReader) (string, error)
 	containsSecret() bool
+	// secretPaths returns the paths of the secrets that are read when the value is resolved.
+	secretPaths() ([]string, error)
+}
+
+// locatedValue is implemented by values that are read from a file.
+type locatedValue interface {
+	// location returns the file the value is read from and the line on which it is defined.
+	// The line is 0 when it is unknown.
+	location() (string, int)
 }
 
 type secretValue struct {
@@ -194,26 +288,54 @@ func (s *secretValue) containsSecret() bool {
 	return true
 }
 
+func (s *secretValue) secretPaths() ([]string, error) {
+	return []string{s.path}, nil
+}
+
 func newSecretValue(path string) value {
 	return &secretValue{path: path}
 }
 
-// secretsDirEnv sources environment variables from the directory specified with the --secrets-dir flag.
+// secretsDirEnv sources environment variables from a directory specified with the --secrets-dir flag.
 type secretsDirEnv struct {
 	newClient newClientFunc
 	dirPath   string
+	// prefix is prepended to the names of the environment variables.
+	prefix string
+	// rename is applied to the relative paths of the secrets before they are converted to environment variable names.
+	rename *renameRule
+	// depth is the maximum depth of the secrets that are included, -1 to include all secrets.
+	depth int
+	// include contains the glob patterns of which the relative path of a secret should match at least one to be included.
+	include []string
+	// exclude contains the glob patterns of which the relative path of a secret should match none to be included.
+	exclude []string
 }
 
 // env returns a map of environment variables containing all secrets from the specified path.
 // The variable names are the relative paths of their corresponding secrets in uppercase snake case.
 // An error is returned if two secret paths map to the same variable name.
 func (s *secretsDirEnv) env() (map[string]value, error) {
+	paths, err := s.secretPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]value, len(paths))
+	for name, path := range paths {
+		result[name] = newSecretValue(path)
+	}
+	return result, nil
+}
+
+// secretPaths returns a map of environment variable names to the paths of the secrets they are sourced from.
+func (s *secretsDirEnv) secretPaths() (map[string]string, error) {
 	client, err := s.newClient()
 	if err != nil {
 		return nil, err
 	}
 
-	tree, err := client.Dirs().GetTree(s.dirPath, -1, false)
+	tree, err := client.Dirs().GetTree(s.dirPath, s.depth, false)
 	if err != nil {
 		return nil, err
 	}
@@ -226,7 +348,20 @@ func (s *secretsDirEnv) env() (map[string]value, error) {
 		}
 		path := secretPath.String()
 
+		included, err := s.includes(path)
+		if err != nil {
+			return nil, err
+		}
+		if !included {
+			continue
+		}
+
 		envVarName := s.envVarName(path)
+		err = validation.ValidateEnvarName(envVarName)
+		if err != nil {
+			return nil, err
+		}
+
 		if prevPath, found := paths[envVarName]; found {
 			return nil, errNameCollision{
 				name: envVarName,
@@ -238,31 +373,194 @@ func (s *secretsDirEnv) env() (map[string]value, error) {
 		}
 		paths[envVarName] = path
 	}
+	return paths, nil
+}
+
+// includes returns whether the secret on the specified path matches the include and exclude patterns.
+func (s *secretsDirEnv) includes(path string) (bool, error) {
+	relPath := s.relPath(path)
+
+	for _, pattern := range s.exclude {
+		match, err := gopath.Match(pattern, relPath)
+		if err != nil {
+			return false, ErrInvalidSecretsDirPattern(pattern, err)
+		}
+		if match {
+			return false, nil
+		}
+	}
 
-	result := make(map[string]value, len(paths))
-	for name, path := range paths {
-		result[name] = newSecretValue(path)
+	if len(s.include) == 0 {
+		return true, nil
 	}
-	return result, nil
+	for _, pattern := range s.include {
+		match, err := gopath.Match(pattern, relPath)
+		if err != nil {
+			return false, ErrInvalidSecretsDirPattern(pattern, err)
+		}
+		if match {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// relPath returns the path of the secret relative to the directory.
+func (s *secretsDirEnv) relPath(path string) string {
+	relPath := strings.TrimPrefix(path, strings.TrimSuffix(s.dirPath, "/"))
+	return strings.TrimPrefix(relPath, "/")
 }
 
 // envVarName returns the environment variable name corresponding to the secret on the specified path
-// by converting the relative path to uppercase snake case.
+// by applying the rename rule to the relative path, converting it to uppercase snake case and adding the prefix.
 func (s *secretsDirEnv) envVarName(path string) string {
-	envVarName := strings.TrimPrefix(path, s.dirPath)
-	envVarName = strings.TrimPrefix(envVarName, "/")
+	envVarName := s.relPath(path)
+	if s.rename != nil {
+		envVarName = s.rename.apply(envVarName)
+	}
 	envVarName = strings.ReplaceAll(envVarName, "/", "_")
 	envVarName = strings.ReplaceAll(envVarName, "-", "_")
 	envVarName = strings.ReplaceAll(envVarName, ".", "_")
 	envVarName = strings.ToUpper(envVarName)
-	return envVarName
+	return s.prefix + envVarName
 }
 
 func newSecretsDirEnv(newClient newClientFunc, dirPath string) *secretsDirEnv {
 	return &secretsDirEnv{
 		newClient: newClient,
 		dirPath:   dirPath,
+		depth:     -1,
+	}
+}
+
+// parseSecretsDirEnv parses the value of a --secrets-dir flag, which has the form
+// `<path>[:prefix=<prefix>][:rename=s/<regex>/<replacement>/]`. Because a regular
+// expression can contain colons, the rename option has to be the last option.
+func parseSecretsDirEnv(newClient newClientFunc, value string) (*secretsDirEnv, error) {
+	dirPath, options, hasOptions := strings.Cut(value, ":")
+	source := newSecretsDirEnv(newClient, dirPath)
+
+	for hasOptions {
+		var option string
+		if strings.HasPrefix(options, "rename=") {
+			option, options, hasOptions = options, "", false
+		} else {
+			option, options, hasOptions = strings.Cut(options, ":")
+		}
+
+		key, optionValue, found := strings.Cut(option, "=")
+		if !found {
+			return nil, ErrInvalidSecretsDir(value, fmt.Sprintf("option %s is not of the form key=value", option))
+		}
+
+		switch key {
+		case "prefix":
+			source.prefix = optionValue
+		case "rename":
+			rule, err := parseRenameRule(optionValue)
+			if err != nil {
+				return nil, ErrInvalidSecretsDir(value, err)
+			}
+			source.rename = rule
+		default:
+			return nil, ErrInvalidSecretsDir(value, fmt.Sprintf("unknown option %s, supported options are prefix and rename", key))
+		}
+	}
+
+	return source, nil
+}
+
+// renameRule replaces the matches of a regular expression with a replacement.
+type renameRule struct {
+	regex       *regexp.Regexp
+	replacement string
+}
+
+// parseRenameRule parses a rename rule of the form s/<regex>/<replacement>/.
+// Any character can be used as delimiter instead of a slash, e.g. s#<regex>#<replacement>#.
+// The delimiter can be escaped with a backslash to use it in the regex or replacement.
+// In the replacement, ${1} refers to the first submatch.
+func parseRenameRule(rule string) (*renameRule, error) {
+	if len(rule) < 4 || rule[0] != 's' {
+		return nil, fmt.Errorf("rename rule %s is not of the form s/<regex>/<replacement>/", rule)
+	}
+
+	delimiter := rule[1:2]
+	parts := splitUnescaped(rule[2:], delimiter)
+	if len(parts) != 3 || parts[2] != "" {
+		return nil, fmt.Errorf("rename rule %s is not of the form s%s<regex>%s<replacement>%s", rule, delimiter, delimiter, delimiter)
+	}
+
+	regex, err := regexp.Compile(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression in rename rule: %s", err)
+	}
+
+	return &renameRule{
+		regex:       regex,
+		replacement: parts[1],
+	}, nil
+}
+
+// splitUnescaped splits s around each occurrence of sep that is not preceded by a backslash.
+// The backslashes escaping sep are removed.
+func splitUnescaped(s string, sep string) []string {
+	var parts []string
+	var part strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && strings.HasPrefix(s[i+1:], sep) {
+			part.WriteString(sep)
+			i += len(sep)
+			continue
+		}
+		if strings.HasPrefix(s[i:], sep) {
+			parts = append(parts, part.String())
+			part.Reset()
+			i += len(sep) - 1
+			continue
+		}
+		part.WriteByte(s[i])
+	}
+	return append(parts, part.String())
+}
+
+// apply returns the name with all matches of the regular expression replaced.
+func (r *renameRule) apply(name string) string {
+	return r.regex.ReplaceAllString(name, r.replacement)
+}
+
+// secretsDirsEnv sources environment variables from all directories specified with the --secrets-dir flag.
+type secretsDirsEnv []*secretsDirEnv
+
+// env returns a map of environment variables containing the secrets of all directories.
+// An error is returned if two secret paths, in the same or in different directories, map to the same variable name.
+func (dirs secretsDirsEnv) env() (map[string]value, error) {
+	paths := make(map[string]string)
+	for _, dir := range dirs {
+		dirPaths, err := dir.secretPaths()
+		if err != nil {
+			return nil, err
+		}
+
+		for name, path := range dirPaths {
+			if prevPath, found := paths[name]; found {
+				return nil, errNameCollision{
+					name: name,
+					paths: [2]string{
+						prevPath,
+						path,
+					},
+				}
+			}
+			paths[name] = path
+		}
+	}
+
+	result := make(map[string]value, len(paths))
+	for name, path := range paths {
+		result[name] = newSecretValue(path)
 	}
+	return result, nil
 }
 
 // EnvFlags defines environment variables sourced from command-line flags.
@@ -326,7 +624,8 @@ func (env *referenceEnv) env() (map[string]value, error) {
 }
 
 type envDirSecretValue struct {
-	value string
+	value    string
+	filepath string
 }
 
 func (s *envDirSecretValue) resolve(_ tpl.SecretReader) (string, error) {
@@ -337,8 +636,19 @@ func (s *envDirSecretValue) containsSecret() bool {
 	return true
 }
 
-func newEnvDirSecretValue(value string) value {
-	return &envDirSecretValue{value: value}
+func (s *envDirSecretValue) secretPaths() ([]string, error) {
+	return nil, nil
+}
+
+func (s *envDirSecretValue) location() (string, int) {
+	return s.filepath, 0
+}
+
+func newEnvDirSecretValue(filepath string, value string) value {
+	return &envDirSecretValue{
+		value:    value,
+		filepath: filepath,
+	}
 }
 
 // EnvDir defines environment variables sourced from files in a directory.
@@ -361,7 +671,7 @@ func NewEnvDir(path string) (EnvDir, error) {
 				return nil, ErrReadEnvFile(f.Name(), err)
 			}
 
-			env[f.Name()] = newEnvDirSecretValue(string(fileContent))
+			env[f.Name()] = newEnvDirSecretValue(filePath, string(fileContent))
 		}
 	}
 
@@ -374,7 +684,10 @@ func (dir EnvDir) env() (map[string]value, error) {
 }
 
 type templateValue struct {
-	filepath  string
+	filepath string
+	// raw is the contents of the file, used to show the line of the template on which an error occurred.
+	raw       []byte
+	lineNo    int
 	template  tpl.Template
 	varReader tpl.VariableReader
 }
@@ -382,7 +695,7 @@ type templateValue struct {
 func (v *templateValue) resolve(sr tpl.SecretReader) (string, error) {
 	value, err := v.template.Evaluate(v.varReader, sr)
 	if err != nil {
-		return "", ErrParsingTemplate(v.filepath, err)
+		return "", parsingTemplateError(v.filepath, v.raw, err)
 	}
 	return value, nil
 }
@@ -391,9 +704,26 @@ func (v *templateValue) containsSecret() bool {
 	return v.template.ContainsSecrets()
 }
 
-func newTemplateValue(filepath string, template tpl.Template, varReader tpl.VariableReader) value {
+func (v *templateValue) secretPaths() ([]string, error) {
+	paths, err := v.template.SecretPaths(v.varReader)
+	if err != nil {
+		return nil, parsingTemplateError(v.filepath, v.raw, err)
+	}
+	return paths, nil
+}
+
+func (v *templateValue) location() (string, int) {
+	if v.lineNo < 0 {
+		return v.filepath, 0
+	}
+	return v.filepath, v.lineNo
+}
+
+func newTemplateValue(filepath string, raw []byte, lineNo int, template tpl.Template, varReader tpl.VariableReader) value {
 	return &templateValue{
 		filepath:  filepath,
+		raw:       raw,
+		lineNo:    lineNo,
 		template:  template,
 		varReader: varReader,
 	}
@@ -401,13 +731,16 @@ func newTemplateValue(filepath string, template tpl.Template, varReader tpl.Vari
 
 type envTemplate struct {
 	filepath          string
+	raw               []byte
 	envVars           []envvarTpls
 	templateVarReader tpl.VariableReader
 }
 
 type envvarTpls struct {
-	key    tpl.Template
-	value  tpl.Template
+	key   tpl.Template
+	value tpl.Template
+	// file is the included template in which the variable is declared. It is empty for the env file itself.
+	file   string
 	lineNo int
 }
 
@@ -416,9 +749,15 @@ type envvarTpls struct {
 func (t envTemplate) env() (map[string]value, error) {
 	result := make(map[string]value)
 	for _, tpls := range t.envVars {
+		// The contents of included templates are read again when an error in them is shown.
+		file, raw := t.filepath, t.raw
+		if tpls.file != "" {
+			file, raw = tpls.file, nil
+		}
+
 		key, err := tpls.key.Evaluate(t.templateVarReader, secretReaderNotAllowed{})
 		if err != nil {
-			return nil, err
+			return nil, annotateTemplateError(file, raw, err)
 		}
 
 		err = validation.ValidateEnvarName(key)
@@ -426,7 +765,7 @@ func (t envTemplate) env() (map[string]value, error) {
 			return nil, templateError(tpls.lineNo, err)
 		}
 
-		value := newTemplateValue(t.filepath, tpls.value, t.templateVarReader)
+		value := newTemplateValue(file, raw, tpls.lineNo, tpls.value, t.templateVarReader)
 
 		result[key] = value
 	}
@@ -440,14 +779,30 @@ func templateError(lineNo int, err error) error {
 	return err
 }
 
+// parsingTemplateError returns an error that occurred in the template in the given file, of which the contents
+// are raw. It is shown with the line of the template on which it occurred when its position is known.
+func parsingTemplateError(filepath string, raw []byte, err error) error {
+	annotated := annotateTemplateError(filepath, raw, err)
+	if _, ok := annotated.(templateSourceError); ok {
+		return annotated
+	}
+	return ErrParsingTemplate(filepath, err)
+}
+
 // ReadEnvFile reads and parses a .env file.
 func ReadEnvFile(filepath string, reader io.Reader, varReader tpl.VariableReader, parser tpl.Parser) (EnvFile, error) {
-	env, err := NewEnv(filepath, reader, varReader, parser)
+	raw, err := io.ReadAll(reader)
 	if err != nil {
 		return EnvFile{}, ErrParsingTemplate(filepath, err)
 	}
+
+	env, err := NewEnv(filepath, bytes.NewReader(raw), varReader, parser)
+	if err != nil {
+		return EnvFile{}, parsingTemplateError(filepath, raw, err)
+	}
 	return EnvFile{
 		path:      filepath,
+		raw:       raw,
 		envSource: env,
 	}, nil
 }
@@ -455,6 +810,7 @@ func ReadEnvFile(filepath string, reader io.Reader, varReader tpl.VariableReader
 // EnvFile contains an environment that is read from a file.
 type EnvFile struct {
 	path      string
+	raw       []byte
 	envSource EnvSource
 }
 
@@ -462,7 +818,7 @@ type EnvFile struct {
 func (e EnvFile) env() (map[string]value, error) {
 	env, err := e.envSource.env()
 	if err != nil {
-		return nil, ErrParsingTemplate(e.path, err)
+		return nil, parsingTemplateError(e.path, e.raw, err)
 	}
 	return env, nil
 }
@@ -470,14 +826,19 @@ func (e EnvFile) env() (map[string]value, error) {
 // NewEnv loads an environment of key-value pairs from a string.
 // The format of the string can be `key: value` or `key=value` pairs.
 func NewEnv(filepath string, r io.Reader, varReader tpl.VariableReader, parser tpl.Parser) (EnvSource, error) {
-	env, err := parseEnvironment(r)
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	env, err := parseEnvFile(raw, parser)
 	if err != nil {
 		return nil, err
 	}
 
 	secretTemplates := make([]envvarTpls, len(env))
 	for i, envvar := range env {
-		keyTpl, err := parser.Parse(envvar.key, envvar.lineNumber, envvar.columnNumberKey)
+		keyTpl, err := envvar.parser.Parse(envvar.key, envvar.lineNumber, envvar.columnNumberKey)
 		if err != nil {
 			return nil, err
 		}
@@ -487,7 +848,7 @@ func NewEnv(filepath string, r io.Reader, varReader tpl.VariableReader, parser t
 			return nil, err
 		}
 
-		valTpl, err := parser.Parse(envvar.value, envvar.lineNumber, envvar.columnNumberValue)
+		valTpl, err := envvar.parser.Parse(envvar.value, envvar.lineNumber, envvar.columnNumberValue)
 		if err != nil {
 			return nil, err
 		}
@@ -495,12 +856,14 @@ func NewEnv(filepath string, r io.Reader, varReader tpl.VariableReader, parser t
 		secretTemplates[i] = envvarTpls{
 			key:    keyTpl,
 			value:  valTpl,
+			file:   envvar.file,
 			lineNo: envvar.lineNumber,
 		}
 	}
 
 	return envTemplate{
 		filepath:          filepath,
+		raw:               raw,
 		envVars:           secretTemplates,
 		templateVarReader: varReader,
 	}, nil
@@ -512,15 +875,53 @@ type envvar struct {
 	lineNumber        int
 	columnNumberKey   int
 	columnNumberValue int
+	// file is the included template in which the variable is declared. It is empty for variables
+	// declared in the env file itself. It is only set by parseEnvFile.
+	file string
+	// parser parses the key and the value of the variable. It is only set by parseEnvFile.
+	parser tpl.Parser
+}
+
+// parseEnvFile parses the envvars in an env file that is parsed with the given parser. Include tags on a line
+// of their own are replaced by the lines of the included templates first, so that included templates can
+// declare variables. The line numbers of the returned envvars are the line numbers in the file that declares them.
+func parseEnvFile(raw []byte, parser tpl.Parser) ([]envvar, error) {
+	lines, err := tpl.IncludeLines(parser, string(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	texts := make([]string, len(lines))
+	for i, line := range lines {
+		texts[i] = line.Text
+	}
+
+	env, err := parseEnvironment(strings.NewReader(strings.Join(texts, "\n")), lines)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range env {
+		env[i].parser = parser
+		// Variables parsed from yml have no line number.
+		if env[i].lineNumber > 0 {
+			line := lines[env[i].lineNumber-1]
+			env[i].file = line.File
+			env[i].lineNumber = line.LineNo
+			env[i].parser = line.Parser
+		}
+	}
+	return env, nil
 }
 
 // parseEnvironment parses envvars from a string.
 // It first tries the key=value format. When that returns an error,
 // the yml format is tried.
-// The default parser to be used with the format is also returned.
-func parseEnvironment(r io.Reader) ([]envvar, error) {
+// The lines contain the source of every line of the input, for the errors of the key=value format.
+// They can be nil when the input is a single file.
+func parseEnvironment(r io.Reader, lines []tpl.Line) ([]envvar, error) {
 	var ymlReader bytes.Buffer
-	env, err := parseDotEnv(io.TeeReader(r, &ymlReader))
+	env, err := parseDotEnvLines(io.TeeReader(r, &ymlReader), lines)
 	if err != nil {
 		var ymlErr error
 		env, ymlErr = parseYML(&ymlReader)
@@ -531,94 +932,6 @@ func parseEnvironment(r io.Reader) ([]envvar, error) {
 	return env, nil
 }
 
-// parseDotEnv parses key-value pairs in the .env syntax (key=value).
-func parseDotEnv(r io.Reader) ([]envvar, error) {
-	vars := map[string]envvar{}
-	scanner := bufio.NewScanner(r)
-
-	i := 0
-	for scanner.Scan() {
-		i++
-		line := scanner.Text()
-
-		trimmed := strings.TrimSpace(line)
-		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
-			continue
-		}
-
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
-			return nil, ErrTemplate(i, errors.New("template is not formatted as key=value pairs"))
-		}
-
-		columnNumberValue := len(parts[0]) + 2 // the length of the key (including spaces and quotes) + one for the = sign and one for the current column.
-		for _, r := range parts[1] {
-			if !unicode.IsSpace(r) {
-				break
-			}
-			columnNumberValue++
-		}
-
-		columnNumberKey := 1 // one for the current column.
-		for _, r := range parts[0] {
-			if !unicode.IsSpace(r) {
-				break
-			}
-			columnNumberKey++
-		}
-
-		key := strings.TrimSpace(parts[0])
-
-		value, isTrimmed := trimQuotes(strings.TrimSpace(parts[1]))
-		if isTrimmed {
-			columnNumberValue++
-		}
-
-		vars[key] = envvar{
-			key:               key,
-			value:             value,
-			lineNumber:        i,
-			columnNumberValue: columnNumberValue,
-			columnNumberKey:   columnNumberKey,
-		}
-	}
-
-	i = 0
-	res := make([]envvar, len(vars))
-	for _, envvar := range vars {
-		res[i] = envvar
-		i++
-	}
-
-	return res, nil
-}
-
-const (
-	doubleQuoteChar = '\u0022' // "
-	singleQuoteChar = '\u0027' // '
-)
-
-// trimQuotes removes a leading and trailing quote from the given string value if
-// it is wrapped in either single or double quotes.
-//
-// Rules:
-//   - Empty values become empty values (e.g. `”`and `""` both evaluate to the empty string “).
-//   - Inner quotes are maintained (e.g. `{"foo":"bar"}` remains unchanged).
-//   - Single and double quoted values are escaped (e.g. `'foo'` and `"foo"` both evaluate to `foo`).
-//   - Single and double qouted values maintain whitespace from both ends (e.g. `" foo "` becomes ` foo `)
-//   - Inputs with either leading or trailing whitespace are considered unquoted,
-//     so make sure you sanitize your inputs before calling this function.
-func trimQuotes(s string) (string, bool) {
-	n := len(s)
-	if n > 1 &&
-		(s[0] == singleQuoteChar && s[n-1] == singleQuoteChar ||
-			s[0] == doubleQuoteChar && s[n-1] == doubleQuoteChar) {
-		return s[1 : n-1], true
-	}
-
-	return s, false
-}
-
 func parseYML(r io.Reader) ([]envvar, error) {
 	contents, err := io.ReadAll(r)
 	if err != nil {
@@ -660,6 +973,10 @@ func (v *plaintextValue) containsSecret() bool {
 	return false
 }
 
+func (v *plaintextValue) secretPaths() ([]string, error) {
+	return nil, nil
+}
+
 type osEnv struct {
 	osEnv map[string]string
 }
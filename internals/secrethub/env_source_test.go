@@ -1,9 +1,14 @@
 package secrethub
 
 import (
+	gopath "path"
+	"regexp"
 	"sort"
+	"strings"
 	"testing"
 
+	"github.com/secrethub/secrethub-cli/internals/cli/validation"
+
 	"github.com/secrethub/secrethub-go/internals/api"
 	"github.com/secrethub/secrethub-go/internals/api/uuid"
 	"github.com/secrethub/secrethub-go/internals/assert"
@@ -152,3 +157,261 @@ func TestSecretsDirEnv(t *testing.T) {
 		})
 	}
 }
+
+// newFakeTreeClient returns a client of which the tree of the directory namespace/<repo>
+// contains secrets on the given paths relative to the directory.
+func newFakeTreeClient(repo string, relPaths ...string) newClientFunc {
+	rootDirUUID := uuid.New()
+	tree := &api.Tree{
+		ParentPath: "namespace",
+		RootDir: &api.Dir{
+			DirID: rootDirUUID,
+			Name:  repo,
+		},
+		Dirs:    map[uuid.UUID]*api.Dir{},
+		Secrets: map[uuid.UUID]*api.Secret{},
+	}
+
+	dirs := map[string]uuid.UUID{"": rootDirUUID}
+	for _, relPath := range relPaths {
+		elems := strings.Split(relPath, "/")
+		parentPath := ""
+		for _, elem := range elems[:len(elems)-1] {
+			dirPath := strings.TrimPrefix(parentPath+"/"+elem, "/")
+			if _, found := dirs[dirPath]; !found {
+				parentID := dirs[parentPath]
+				dirID := uuid.New()
+				tree.Dirs[dirID] = &api.Dir{
+					DirID:    dirID,
+					ParentID: &parentID,
+					Name:     elem,
+				}
+				dirs[dirPath] = dirID
+			}
+			parentPath = dirPath
+		}
+
+		secretID := uuid.New()
+		tree.Secrets[secretID] = &api.Secret{
+			SecretID: secretID,
+			DirID:    dirs[parentPath],
+			Name:     elems[len(elems)-1],
+		}
+	}
+
+	return func() (secrethub.ClientInterface, error) {
+		return fakeclient.Client{
+			DirService: &fakeclient.DirService{
+				GetTreeFunc: func(path string, depth int, ancestors bool) (*api.Tree, error) {
+					return tree, nil
+				},
+			},
+		}, nil
+	}
+}
+
+func TestParseSecretsDirEnv(t *testing.T) {
+	cases := map[string]struct {
+		value        string
+		expectedPath string
+		expectedName string
+		expectedErr  error
+	}{
+		"path only": {
+			value:        "namespace/repo",
+			expectedPath: "namespace/repo",
+			expectedName: "DB_PASSWORD",
+		},
+		"prefix": {
+			value:        "namespace/repo:prefix=APP_",
+			expectedPath: "namespace/repo",
+			expectedName: "APP_DB_PASSWORD",
+		},
+		"rename": {
+			value:        "namespace/repo:rename=s/^db\\//database-/",
+			expectedPath: "namespace/repo",
+			expectedName: "DATABASE_PASSWORD",
+		},
+		"rename with submatch and other delimiter": {
+			value:        "namespace/repo:rename=s#^(\\w+)/(\\w+)$#${2}_${1}#",
+			expectedPath: "namespace/repo",
+			expectedName: "PASSWORD_DB",
+		},
+		"prefix and rename containing colons": {
+			value:        "namespace/repo:prefix=APP_:rename=s/^db:?\\//database_/",
+			expectedPath: "namespace/repo",
+			expectedName: "APP_DATABASE_PASSWORD",
+		},
+		"unknown option": {
+			value:       "namespace/repo:suffix=_APP",
+			expectedErr: ErrInvalidSecretsDir("namespace/repo:suffix=_APP", "unknown option suffix, supported options are prefix and rename"),
+		},
+		"option without value": {
+			value:       "namespace/repo:prefix",
+			expectedErr: ErrInvalidSecretsDir("namespace/repo:prefix", "option prefix is not of the form key=value"),
+		},
+		"rename without replacement": {
+			value:       "namespace/repo:rename=s/db/",
+			expectedErr: ErrInvalidSecretsDir("namespace/repo:rename=s/db/", "rename rule s/db/ is not of the form s/<regex>/<replacement>/"),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			source, err := parseSecretsDirEnv(nil, tc.value)
+			assert.Equal(t, err, tc.expectedErr)
+			if err == nil {
+				assert.Equal(t, source.dirPath, tc.expectedPath)
+				assert.Equal(t, source.envVarName("namespace/repo/db/password"), tc.expectedName)
+			}
+		})
+	}
+}
+
+func TestSecretsDirsEnv(t *testing.T) {
+	cases := map[string]struct {
+		dirs               secretsDirsEnv
+		expectedValues     []string
+		expectedCollission *errNameCollision
+		expectedErr        error
+	}{
+		"multiple directories": {
+			dirs: secretsDirsEnv{
+				newSecretsDirEnv(newFakeTreeClient("repo1", "foo"), "namespace/repo1"),
+				newSecretsDirEnv(newFakeTreeClient("repo2", "bar", "baz/qux"), "namespace/repo2"),
+			},
+			expectedValues: []string{"FOO", "BAR", "BAZ_QUX"},
+		},
+		"collision between directories": {
+			dirs: secretsDirsEnv{
+				newSecretsDirEnv(newFakeTreeClient("repo1", "foo"), "namespace/repo1"),
+				newSecretsDirEnv(newFakeTreeClient("repo2", "foo"), "namespace/repo2"),
+			},
+			expectedCollission: &errNameCollision{
+				name: "FOO",
+				paths: [2]string{
+					"namespace/repo1/foo",
+					"namespace/repo2/foo",
+				},
+			},
+		},
+		"collision resolved with prefix": {
+			dirs: secretsDirsEnv{
+				newSecretsDirEnv(newFakeTreeClient("repo1", "foo"), "namespace/repo1"),
+				&secretsDirEnv{
+					newClient: newFakeTreeClient("repo2", "foo"),
+					dirPath:   "namespace/repo2",
+					prefix:    "REPO2_",
+					depth:     -1,
+				},
+			},
+			expectedValues: []string{"FOO", "REPO2_FOO"},
+		},
+		"include": {
+			dirs: secretsDirsEnv{
+				&secretsDirEnv{
+					newClient: newFakeTreeClient("repo", "db/user", "db/password", "api/key"),
+					dirPath:   "namespace/repo",
+					include:   []string{"db/*"},
+					depth:     -1,
+				},
+			},
+			expectedValues: []string{"DB_USER", "DB_PASSWORD"},
+		},
+		"exclude": {
+			dirs: secretsDirsEnv{
+				&secretsDirEnv{
+					newClient: newFakeTreeClient("repo", "db/user", "db/password", "api/key"),
+					dirPath:   "namespace/repo",
+					include:   []string{"db/*", "api/*"},
+					exclude:   []string{"*/password"},
+					depth:     -1,
+				},
+			},
+			expectedValues: []string{"DB_USER", "API_KEY"},
+		},
+		"invalid name after rename": {
+			dirs: secretsDirsEnv{
+				&secretsDirEnv{
+					newClient: newFakeTreeClient("repo", "foo"),
+					dirPath:   "namespace/repo",
+					rename:    &renameRule{regex: regexp.MustCompile("^foo$"), replacement: "foo=bar"},
+					depth:     -1,
+				},
+			},
+			expectedErr: validation.ErrInvalidEnvarName("FOO=BAR"),
+		},
+		"invalid pattern": {
+			dirs: secretsDirsEnv{
+				&secretsDirEnv{
+					newClient: newFakeTreeClient("repo", "foo"),
+					dirPath:   "namespace/repo",
+					include:   []string{"[foo"},
+					depth:     -1,
+				},
+			},
+			expectedErr: ErrInvalidSecretsDirPattern("[foo", gopath.ErrBadPattern),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			secrets, err := tc.dirs.env()
+			if tc.expectedCollission != nil {
+				collisionErr, ok := err.(errNameCollision)
+				assert.Equal(t, ok, true)
+				assert.Equal(t, collisionErr, *tc.expectedCollission)
+			} else if tc.expectedErr != nil {
+				assert.Equal(t, err, tc.expectedErr)
+			} else {
+				assert.OK(t, err)
+				assert.Equal(t, len(secrets), len(tc.expectedValues))
+				for _, name := range tc.expectedValues {
+					if _, ok := secrets[name]; !ok {
+						t.Errorf("expected but not found env var with name: %s", name)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEnvironment_SecretsDirDepth(t *testing.T) {
+	for _, depth := range []int{0, -2} {
+		env := &environment{
+			secretsDirs:     []string{"namespace/repo"},
+			secretsDirDepth: depth,
+		}
+
+		_, err := env.layers()
+		assert.Equal(t, err, ErrInvalidSecretsDirDepth(depth))
+	}
+}
+
+func TestSecretsDirEnv_Depth(t *testing.T) {
+	var actualDepth int
+	source := &secretsDirEnv{
+		newClient: func() (secrethub.ClientInterface, error) {
+			return fakeclient.Client{
+				DirService: &fakeclient.DirService{
+					GetTreeFunc: func(path string, depth int, ancestors bool) (*api.Tree, error) {
+						actualDepth = depth
+						return &api.Tree{
+							ParentPath: "namespace",
+							RootDir: &api.Dir{
+								DirID: uuid.New(),
+								Name:  "repo",
+							},
+						}, nil
+					},
+				},
+			}, nil
+		},
+		dirPath: "namespace/repo",
+		depth:   1,
+	}
+
+	_, err := source.env()
+	assert.OK(t, err)
+	assert.Equal(t, actualDepth, 1)
+}
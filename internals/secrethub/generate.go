@@ -9,6 +9,7 @@ import (
 	"github.com/secrethub/secrethub-cli/internals/cli"
 	"github.com/secrethub/secrethub-cli/internals/cli/clip"
 	"github.com/secrethub/secrethub-cli/internals/cli/ui"
+	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl"
 
 	"github.com/secrethub/secrethub-go/internals/api"
 	"github.com/secrethub/secrethub-go/internals/errio"
@@ -103,6 +104,26 @@ func (cmd *GenerateSecretCommand) before() error {
 	return nil
 }
 
+// newTemplateGenerator returns a generator for the secrets of a generate filter in a template.
+// The character sets and min rules of the filter are parsed like the --charset and --min flags.
+func newTemplateGenerator(options tpl.GenerateOptions) (randchar.Generator, error) {
+	var charset charsetValue
+	err := charset.Set(options.Charset)
+	if err != nil {
+		return nil, err
+	}
+
+	var mins minRuleValue
+	for _, rule := range options.Min {
+		err = mins.Set(rule)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return randchar.NewRand(charset.v, mins.v...)
+}
+
 // Run generates a new secret and writes to the output path.
 func (cmd *GenerateSecretCommand) Run() error {
 	err := cmd.before()
@@ -4,7 +4,11 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/secrethub/secrethub-cli/internals/cli"
 	"github.com/secrethub/secrethub-cli/internals/cli/clip"
@@ -14,6 +18,7 @@ import (
 	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl"
 
 	"github.com/docker/go-units"
+	"github.com/spf13/cobra"
 )
 
 // Errors
@@ -27,6 +32,8 @@ type InjectCommand struct {
 	outFile                       string
 	inFile                        string
 	fileMode                      filemode.FileMode
+	include                       []string
+	exclude                       []string
 	force                         bool
 	io                            ui.IO
 	useClipboard                  bool
@@ -36,10 +43,19 @@ type InjectCommand struct {
 	templateVars                  map[string]string
 	templateVersion               string
 	dontPromptMissingTemplateVars bool
+	cacheOptions                  secretCacheOptions
+	watch                         bool
+	watchInterval                 time.Duration
+	execOnChange                  string
+	execTimeout                   time.Duration
+	escaping                      string
+	generateMissing               bool
+	// includedFiles contains the files of the templates included by the last injected templates.
+	includedFiles []string
 }
 
 // NewInjectCommand creates a new InjectCommand.
-func NewInjectCommand(io ui.IO, newClient newClientFunc) *InjectCommand {
+func NewInjectCommand(io ui.IO, newClient newClientFunc, credentialStore CredentialConfig) *InjectCommand {
 	return &InjectCommand{
 		clipWriter: &ClipboardWriterAutoClear{
 			clipper: clip.NewClipboard(),
@@ -49,6 +65,9 @@ func NewInjectCommand(io ui.IO, newClient newClientFunc) *InjectCommand {
 		newClient:    newClient,
 		templateVars: make(map[string]string),
 		fileMode:     filemode.New(0600),
+		cacheOptions: secretCacheOptions{
+			credentialStore: credentialStore,
+		},
 	}
 }
 
@@ -62,15 +81,31 @@ func (cmd *InjectCommand) Register(r cli.Registerer) {
 			"Copy the injected template to the clipboard instead of stdout. The clipboard is automatically cleared after %s.",
 			units.HumanDuration(clearClipboardAfter),
 		))
-	clause.Flags().StringVarP(&cmd.inFile, "in-file", "i", "", "The filename of a template file to inject.")
+	clause.Flags().StringVarP(&cmd.inFile, "in-file", "i", "", "The filename of a template file to inject. When this is a directory, all templates in it are injected and --out-file is the directory to write them to.")
 	clause.Flags().StringVarP(&cmd.outFile, "out-file", "o", "", "Write the injected template to a file instead of stdout.")
+	clause.Flags().StringArrayVar(&cmd.include, "include", nil, "Only inject templates from a directory given with --in-file that match the given glob pattern, e.g. `*.yml`. Patterns without a slash are matched against the file name and patterns with a slash against the path relative to the directory. Can be repeated to include templates matching any of the patterns.")
+	clause.Flags().StringArrayVar(&cmd.exclude, "exclude", nil, "Do not inject templates from a directory given with --in-file that match the given glob pattern. A matching directory is skipped completely. Can be repeated.")
 	clause.Flags().StringVar(&cmd.outFile, "file", "", "") // Alias of --out-file (for backwards compatibility)
 	clause.Cmd.Flag("file").Hidden = true
-	clause.Flags().Var(&cmd.fileMode, "file-mode", "Set filemode for the output file if it does not yet exist. It is ignored without the --out-file flag.")
+	clause.Flags().Var(&cmd.fileMode, "file-mode", "Set filemode for the output file if it does not yet exist. It is ignored without the --out-file flag. When injecting a directory, it is the mode of all rendered files.")
 	clause.Flags().StringToStringVarP(&cmd.templateVars, "var", "v", nil, "Define the value for a template variable with `VAR=VALUE`, e.g. --var env=prod")
 	clause.Flags().StringVar(&cmd.templateVersion, "template-version", "auto", "Do not prompt when a template variable is missing and return an error instead.")
 	clause.Flags().BoolVar(&cmd.dontPromptMissingTemplateVars, "no-prompt", false, "Do not prompt when a template variable is missing and return an error instead.")
 	clause.Flags().BoolVarP(&cmd.force, "force", "f", false, "Overwrite the output file if it already exists, without prompting for confirmation. This flag is ignored if no --out-file is supplied.")
+	clause.Flags().StringVar(&cmd.escaping, "escape", "none", "Escape the values of secrets for the format of the output, so that they can be used between double quotes in json, yaml, shell and toml files or in text and attributes of xml files. "+
+		"The options are json, yaml, shell, xml, toml, none or auto to choose the format by the extension of the output file. "+
+		"Secret tags that are not between quotes, like unquoted yaml values, must not be escaped, so use the escape filter for them. "+
+		"A secret tag can override this with the escape filter, e.g. `{{ path/to/secret | escape \"none\" }}`. Escaping is not supported by v1 templates.")
+	_ = clause.Cmd.RegisterFlagCompletionFunc("escape", func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+		return []string{"auto", "json", "yaml", "shell", "xml", "toml", "none"}, cobra.ShellCompDirectiveNoFileComp
+	})
+	clause.Flags().BoolVar(&cmd.watch, "watch", false, "Keep running and inject the template again when a new version of one of the secrets in it is written or when the template changes. Requires --in-file and --out-file. Output files are replaced atomically and only when their contents change.")
+	clause.Flags().DurationVar(&cmd.watchInterval, "watch-interval", time.Second*30, "The interval at which the secrets and the template are checked for changes when --watch is set. When checking fails, the interval is doubled on every failure, up to 5 minutes.")
+	clause.Flags().StringVar(&cmd.execOnChange, "exec-on-change", "", "A command to run every time an output file is written when --watch is set, e.g. `nginx -s reload`. The command is run with /bin/sh, or with cmd.exe on Windows.")
+	clause.Flags().DurationVar(&cmd.execTimeout, "exec-timeout", time.Second*30, "The maximum time the command given with --exec-on-change can run before it is killed.")
+	clause.Flags().BoolVar(&cmd.generateMissing, "generate-missing", false, "Generate and write secrets that do not exist yet when they are used in a secret tag with the generate filter, e.g. `{{ path/to/secret | generate 32 \"alphanumeric\" }}`. "+
+		"The filter takes the length of the secret, optionally followed by the character sets and the min rules like those of the --charset and --min flags of the generate command. The paths of the generated secrets are printed.")
+	cmd.cacheOptions.register(clause)
 
 	clause.BindAction(cmd.Run)
 	clause.BindArguments(nil)
@@ -81,11 +116,46 @@ func (cmd *InjectCommand) Run() error {
 	if cmd.useClipboard && cmd.outFile != "" {
 		return ErrFlagsConflict("--clip and --file")
 	}
+	if cmd.escaping != "" && cmd.escaping != "auto" {
+		_, err := tpl.ParseEscaping(cmd.escaping)
+		if err != nil {
+			return err
+		}
+	}
+	if cmd.execOnChange != "" && !cmd.watch {
+		return ErrExecOnChangeWithoutWatch
+	}
+	if cmd.generateMissing && cmd.cacheOptions.offline {
+		return ErrFlagsConflict("--generate-missing and --offline")
+	}
+	err := cmd.cacheOptions.validate()
+	if err != nil {
+		return err
+	}
+
+	if cmd.watch {
+		if cmd.inFile == "" || cmd.outFile == "" {
+			return ErrInjectWatchNoFiles
+		}
+		if strings.TrimSpace(cmd.execOnChange) == "" {
+			cmd.execOnChange = ""
+		}
+
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(stop)
+
+		return cmd.watchTemplates(stop)
+	}
 
-	var err error
 	var raw []byte
 
 	if cmd.inFile != "" {
+		info, statErr := os.Stat(cmd.inFile)
+		if statErr == nil && info.IsDir() {
+			return cmd.injectDir()
+		}
+
 		raw, err = os.ReadFile(cmd.inFile)
 		if err != nil {
 			return ErrReadFile(cmd.inFile, err)
@@ -101,31 +171,46 @@ func (cmd *InjectCommand) Run() error {
 		}
 	}
 
-	osEnv, _ := parseKeyValueStringsToMap(cmd.osEnv)
+	templateVariableReader, err := cmd.templateVariableReader()
+	if err != nil {
+		return err
+	}
 
-	var templateVariableReader tpl.VariableReader
-	templateVariableReader, err = newVariableReader(osEnv, cmd.templateVars)
+	parser, err := getEscapingTemplateParser(raw, cmd.templateVersion, cmd.escapingFor(cmd.outFile), cmd.inFile, cmd.readIncludedFile)
 	if err != nil {
 		return err
 	}
 
-	if !cmd.dontPromptMissingTemplateVars {
-		templateVariableReader = newPromptMissingVariableReader(templateVariableReader, cmd.io)
+	template, err := parser.Parse(string(raw), 1, 1)
+	if err != nil {
+		return annotateTemplateError(cmd.inFile, raw, err)
 	}
 
-	parser, err := getTemplateParser(raw, cmd.templateVersion)
+	paths, err := template.SecretPaths(templateVariableReader)
 	if err != nil {
-		return err
+		return annotateTemplateError(cmd.inFile, raw, err)
 	}
 
-	template, err := parser.Parse(string(raw), 1, 1)
+	secretReader, cachedReader, err := cmd.secretReader()
 	if err != nil {
 		return err
 	}
+	secretReader.Prefetch(paths)
+
+	var templateSecretReader tpl.SecretReader = secretReader
+	if cmd.generateMissing {
+		generatingReader := newGeneratingSecretReader(secretReader, cmd.newClient)
+		defer generatingReader.printGenerated(os.Stderr)
+		templateSecretReader = generatingReader
+	}
 
-	injected, err := template.Evaluate(templateVariableReader, newSecretReader(cmd.newClient))
+	injected, err := template.Evaluate(templateVariableReader, templateSecretReader)
 	if err != nil {
-		return err
+		return annotateTemplateError(cmd.inFile, raw, err)
+	}
+
+	if cachedReader != nil {
+		cachedReader.warnStale(os.Stderr)
 	}
 
 	out := []byte(injected)
@@ -181,3 +266,53 @@ func (cmd *InjectCommand) Run() error {
 
 	return nil
 }
+
+// templateVariableReader returns a reader for the template variables set with flags and environment variables,
+// that prompts for missing variables unless --no-prompt is set.
+func (cmd *InjectCommand) templateVariableReader() (tpl.VariableReader, error) {
+	osEnv, _ := parseKeyValueStringsToMap(cmd.osEnv)
+
+	templateVariableReader, err := newVariableReader(osEnv, cmd.templateVars)
+	if err != nil {
+		return nil, err
+	}
+
+	if !cmd.dontPromptMissingTemplateVars {
+		return newPromptMissingVariableReader(templateVariableReader, cmd.io), nil
+	}
+	return templateVariableReader, nil
+}
+
+// secretReader returns a reader that reads every secret once, using the secret cache when it is enabled.
+// The cached reader is returned as well, so that stale secrets can be reported. It is nil when the cache is disabled.
+func (cmd *InjectCommand) secretReader() (*prefetchSecretReader, *cachedSecretReader, error) {
+	var sr tpl.SecretReader = newSecretReader(cmd.newClient)
+	var cachedReader *cachedSecretReader
+	if cmd.cacheOptions.enabled() {
+		var err error
+		cachedReader, err = cmd.cacheOptions.wrap(sr)
+		if err != nil {
+			return nil, nil, err
+		}
+		sr = cachedReader
+	}
+
+	return newPrefetchSecretReader(sr, secretReadConcurrency), cachedReader, nil
+}
+
+// escapingFor returns the escaping for secrets injected into the output file with the given path.
+// The path is empty when the output is not written to a file.
+func (cmd *InjectCommand) escapingFor(path string) tpl.Escaping {
+	if cmd.escaping == "auto" {
+		if path == "" {
+			return tpl.EscapingNone
+		}
+		return tpl.EscapingForFile(path)
+	}
+	if cmd.escaping == "" {
+		return tpl.EscapingNone
+	}
+	// The escaping has been validated when the command started.
+	escaping, _ := tpl.ParseEscaping(cmd.escaping)
+	return escaping
+}
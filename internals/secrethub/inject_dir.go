@@ -0,0 +1,540 @@
+package secrethub
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	gopath "path"
+	"path/filepath"
+	"strings"
+
+	"github.com/secrethub/secrethub-cli/internals/cli/posix"
+	"github.com/secrethub/secrethub-cli/internals/cli/ui"
+	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl"
+)
+
+// Errors
+var (
+	ErrInjectDirNoOutDir    = errMain.Code("inject_dir_no_out_dir").Error("the directory to write the injected templates to must be given with --out-file when --in-file is a directory")
+	ErrInjectOutDirNotDir   = errMain.Code("inject_out_dir_not_dir").ErrorPref("cannot write the injected templates to %s: it is not a directory")
+	ErrNoTemplatesInDir     = errMain.Code("no_templates_in_dir").Error("no templates to inject found in the input directory")
+	ErrInvalidInjectPattern = errMain.Code("invalid_inject_pattern").ErrorPref("invalid pattern %s: %s")
+	ErrInjectTemplate       = errMain.Code("inject_template_failed").ErrorPref("error while injecting template file '%s': %s")
+)
+
+// injectFile is a template file that is injected into an output file.
+type injectFile struct {
+	// name identifies the template in messages. It is the path relative to the input directory
+	// when injecting a directory and the path of the input file otherwise.
+	name string
+	path string
+	// relPath is the path of the output file relative to the output directory.
+	relPath  string
+	mode     os.FileMode
+	raw      []byte
+	template tpl.Template
+	injected []byte
+}
+
+// templateError returns the error that occurred while injecting the template. It is shown with the line of the
+// template on which it occurred when its position is known and is prefixed with the name of the template otherwise.
+func (file *injectFile) templateError(err error) error {
+	annotated := annotateTemplateError(file.name, file.raw, err)
+	if _, ok := annotated.(templateSourceError); ok {
+		return annotated
+	}
+	return ErrInjectTemplate(file.name, err)
+}
+
+// injectDir injects all templates in the input directory and writes them to the output directory,
+// preserving the relative paths of the templates. All templates are injected before anything is
+// written, so a template that cannot be injected never results in a partially written output directory.
+func (cmd *InjectCommand) injectDir() error {
+	if cmd.outFile == "" {
+		return ErrInjectDirNoOutDir
+	}
+
+	files, err := cmd.templateFiles()
+	if err != nil {
+		return err
+	}
+
+	templateVariableReader, err := cmd.templateVariableReader()
+	if err != nil {
+		return err
+	}
+
+	err = cmd.injectFiles(files, templateVariableReader)
+	if err != nil {
+		return err
+	}
+
+	confirmed, err := cmd.confirmOverwrite(files)
+	if err != nil {
+		return err
+	}
+	if !confirmed {
+		fmt.Fprintln(cmd.io.Output(), "Aborting.")
+		return nil
+	}
+
+	err = cmd.writeFiles(files)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		absPath, err := filepath.Abs(filepath.Join(cmd.outFile, file.relPath))
+		if err != nil {
+			return ErrCannotWrite(err)
+		}
+		fmt.Fprintf(cmd.io.Output(), "%s\n", absPath)
+	}
+
+	return nil
+}
+
+// injectFiles parses and injects the given template files.
+func (cmd *InjectCommand) injectFiles(files []*injectFile, templateVariableReader tpl.VariableReader) error {
+	paths, err := cmd.parseFiles(files, templateVariableReader)
+	if err != nil {
+		return err
+	}
+	return cmd.evaluateFiles(files, paths, templateVariableReader)
+}
+
+// parseFiles reads and parses the given template files and returns the paths of the secrets used in them.
+func (cmd *InjectCommand) parseFiles(files []*injectFile, templateVariableReader tpl.VariableReader) ([]string, error) {
+	cmd.includedFiles = nil
+
+	var paths []string
+	for _, file := range files {
+		raw, err := os.ReadFile(file.path)
+		if err != nil {
+			return nil, ErrReadFile(file.name, err)
+		}
+		file.raw = raw
+
+		parser, err := getEscapingTemplateParser(raw, cmd.templateVersion, cmd.escapingFor(cmd.outPath(file)), file.path, cmd.readIncludedFile)
+		if err != nil {
+			return nil, err
+		}
+
+		file.template, err = parser.Parse(string(raw), 1, 1)
+		if err != nil {
+			return nil, file.templateError(err)
+		}
+
+		templatePaths, err := file.template.SecretPaths(templateVariableReader)
+		if err != nil {
+			return nil, file.templateError(err)
+		}
+		paths = append(paths, templatePaths...)
+	}
+	return paths, nil
+}
+
+// evaluateFiles injects the secrets on the given paths into the parsed template files.
+// All secrets are read once through a single reader, also when they are used in multiple templates.
+func (cmd *InjectCommand) evaluateFiles(files []*injectFile, paths []string, templateVariableReader tpl.VariableReader) error {
+	secretReader, cachedReader, err := cmd.secretReader()
+	if err != nil {
+		return err
+	}
+	secretReader.Prefetch(paths)
+
+	var templateSecretReader tpl.SecretReader = secretReader
+	if cmd.generateMissing {
+		generatingReader := newGeneratingSecretReader(secretReader, cmd.newClient)
+		defer generatingReader.printGenerated(os.Stderr)
+		templateSecretReader = generatingReader
+	}
+
+	for _, file := range files {
+		injected, err := file.template.Evaluate(templateVariableReader, templateSecretReader)
+		if err != nil {
+			return file.templateError(err)
+		}
+		file.injected = posix.AddNewLine([]byte(injected))
+	}
+
+	if cachedReader != nil {
+		cachedReader.warnStale(os.Stderr)
+	}
+
+	return nil
+}
+
+// readIncludedFile reads the file of an included template and keeps track of it, so that it can be watched for changes.
+func (cmd *InjectCommand) readIncludedFile(filename string) ([]byte, error) {
+	cmd.includedFiles = append(cmd.includedFiles, filename)
+	return os.ReadFile(filename)
+}
+
+// confirmOverwrite asks for confirmation when any of the output files already exists,
+// unless --force is set. When the output is piped, an error is returned instead.
+func (cmd *InjectCommand) confirmOverwrite(files []*injectFile) (bool, error) {
+	var existing int
+	for _, file := range files {
+		_, err := os.Stat(cmd.outPath(file))
+		if err == nil {
+			existing++
+		}
+	}
+	if existing == 0 || cmd.force {
+		return true, nil
+	}
+
+	if cmd.io.IsOutputPiped() {
+		return false, ErrFileAlreadyExists
+	}
+
+	return ui.AskYesNo(
+		cmd.io,
+		fmt.Sprintf(
+			"%d file(s) in %s already exist, overwrite them?",
+			existing,
+			cmd.outDir(),
+		),
+		ui.DefaultNo,
+	)
+}
+
+// writeFiles writes the injected templates to their output files. The files are only moved into place
+// once all of them have been written successfully.
+func (cmd *InjectCommand) writeFiles(files []*injectFile) error {
+	stage := &injectStage{
+		inDir:  cmd.inFile,
+		outDir: cmd.outDir(),
+	}
+	if !cmd.isDir() {
+		stage.inDir = filepath.Dir(cmd.inFile)
+	}
+
+	for _, file := range files {
+		err := stage.add(file.relPath, file.injected, file.mode)
+		if err != nil {
+			stage.abort()
+			return err
+		}
+	}
+	return stage.commit()
+}
+
+// changedFiles returns the files of which the injected contents differ from the current contents of their output file.
+func (cmd *InjectCommand) changedFiles(files []*injectFile) []*injectFile {
+	var changed []*injectFile
+	for _, file := range files {
+		current, err := os.ReadFile(cmd.outPath(file))
+		if err != nil || !bytes.Equal(current, file.injected) {
+			changed = append(changed, file)
+		}
+	}
+	return changed
+}
+
+// isDir returns whether the input is a directory of templates.
+func (cmd *InjectCommand) isDir() bool {
+	if cmd.inFile == "" {
+		return false
+	}
+	info, err := os.Stat(cmd.inFile)
+	return err == nil && info.IsDir()
+}
+
+// outDir returns the directory the injected templates are written to.
+func (cmd *InjectCommand) outDir() string {
+	if cmd.isDir() {
+		return cmd.outFile
+	}
+	return filepath.Dir(cmd.outFile)
+}
+
+// outPath returns the path of the output file of the given template.
+func (cmd *InjectCommand) outPath(file *injectFile) string {
+	return filepath.Join(cmd.outDir(), file.relPath)
+}
+
+// templateFiles returns the templates to inject. When the input is a directory, these are all regular files in it
+// that are included by the --include and --exclude patterns, skipping the output directory when it is inside the input directory.
+func (cmd *InjectCommand) templateFiles() ([]*injectFile, error) {
+	if !cmd.isDir() {
+		return []*injectFile{{
+			name:    cmd.inFile,
+			path:    cmd.inFile,
+			relPath: filepath.Base(cmd.outFile),
+			mode:    cmd.fileMode.FileMode(),
+		}}, nil
+	}
+
+	info, err := os.Stat(cmd.outFile)
+	if err == nil && !info.IsDir() {
+		return nil, ErrInjectOutDirNotDir(cmd.outFile)
+	}
+
+	outDir, err := filepath.Abs(cmd.outFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*injectFile
+	err = filepath.WalkDir(cmd.inFile, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return ErrReadFile(path, err)
+		}
+
+		relPath, err := filepath.Rel(cmd.inFile, path)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return nil
+		}
+		relPath = filepath.ToSlash(relPath)
+
+		if d.IsDir() {
+			absPath, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+			if absPath == outDir {
+				return filepath.SkipDir
+			}
+
+			excluded, err := matchInjectPatterns(cmd.exclude, relPath)
+			if err != nil {
+				return err
+			}
+			if excluded {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		included, err := cmd.includes(relPath)
+		if err != nil {
+			return err
+		}
+		if !included {
+			return nil
+		}
+
+		// Symlinks are followed, other files that are not regular files are skipped.
+		info, err := os.Stat(path)
+		if err != nil {
+			return ErrReadFile(path, err)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		files = append(files, &injectFile{
+			name:    relPath,
+			path:    path,
+			relPath: filepath.FromSlash(relPath),
+			mode:    cmd.fileMode.FileMode(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, ErrNoTemplatesInDir
+	}
+	return files, nil
+}
+
+// includes returns whether the file with the given path, relative to the input directory,
+// is included by the --include and --exclude patterns.
+func (cmd *InjectCommand) includes(relPath string) (bool, error) {
+	excluded, err := matchInjectPatterns(cmd.exclude, relPath)
+	if err != nil || excluded {
+		return false, err
+	}
+
+	if len(cmd.include) == 0 {
+		return true, nil
+	}
+	return matchInjectPatterns(cmd.include, relPath)
+}
+
+// matchInjectPatterns returns whether the given slash separated relative path matches any of the patterns.
+// Patterns without a slash are matched against the last element of the path only.
+func matchInjectPatterns(patterns []string, relPath string) (bool, error) {
+	for _, pattern := range patterns {
+		name := relPath
+		if !strings.Contains(pattern, "/") {
+			name = gopath.Base(relPath)
+		}
+
+		match, err := gopath.Match(pattern, name)
+		if err != nil {
+			return false, ErrInvalidInjectPattern(pattern, err)
+		}
+		if match {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// injectStage writes injected templates to temporary files next to their destination,
+// so that they can all be moved into place once every file has been written successfully.
+type injectStage struct {
+	inDir  string
+	outDir string
+	// createdDirs contains the directories created in the output directory, parents first.
+	createdDirs []string
+	staged      []stagedFile
+}
+
+// stagedFile is a temporary file that is renamed to its destination on commit.
+type stagedFile struct {
+	tmpPath string
+	path    string
+	// backupPath is the path the existing file at the destination is moved to while committing.
+	backupPath string
+	committed  bool
+}
+
+// add writes the content to a temporary file for the given path relative to the output directory.
+func (s *injectStage) add(relPath string, content []byte, mode os.FileMode) error {
+	path := filepath.Join(s.outDir, relPath)
+
+	err := s.createDir(filepath.Dir(relPath))
+	if err != nil {
+		return ErrCannotWrite(path, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return ErrCannotWrite(path, err)
+	}
+	s.staged = append(s.staged, stagedFile{tmpPath: tmp.Name(), path: path})
+
+	_, err = tmp.Write(content)
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return ErrCannotWrite(path, err)
+	}
+	return nil
+}
+
+// createDir creates the directory with the given path relative to the output directory, including any missing parents.
+// Directories are created with the mode of the corresponding directory in the input directory,
+// made writable for the owner so that the injected templates can be written to them.
+// Missing parents of the output directory itself get the mode of the input directory.
+func (s *injectStage) createDir(relPath string) error {
+	path := filepath.Join(s.outDir, relPath)
+	_, err := os.Stat(path)
+	if err == nil {
+		return nil
+	}
+
+	if relPath != "." {
+		err = s.createDir(filepath.Dir(relPath))
+		if err != nil {
+			return err
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(s.inDir, relPath))
+	if err != nil {
+		return err
+	}
+
+	if relPath == "." {
+		return s.createDirAll(path, info.Mode().Perm()|0700)
+	}
+
+	err = os.Mkdir(path, info.Mode().Perm()|0700)
+	if err != nil {
+		return err
+	}
+	s.createdDirs = append(s.createdDirs, path)
+	return nil
+}
+
+// createDirAll creates the directory with the given path and any missing parents with os.MkdirAll,
+// keeping track of the directories it creates.
+func (s *injectStage) createDirAll(path string, mode os.FileMode) error {
+	var missing []string
+	for dir := path; ; dir = filepath.Dir(dir) {
+		_, err := os.Stat(dir)
+		if err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		missing = append(missing, dir)
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		s.createdDirs = append(s.createdDirs, missing[i])
+	}
+	return os.MkdirAll(path, mode)
+}
+
+// commit moves all staged files to their destination. Existing files are moved aside first,
+// so that they can be put back when a file cannot be moved into place. This way, a failed commit
+// leaves the output directory as it was, apart from files that cannot be restored either.
+func (s *injectStage) commit() error {
+	for i := range s.staged {
+		file := &s.staged[i]
+
+		_, err := os.Lstat(file.path)
+		if err == nil {
+			backupPath := file.tmpPath + ".old"
+			err = os.Rename(file.path, backupPath)
+			if err != nil {
+				s.rollback(i)
+				return ErrCannotWrite(file.path, err)
+			}
+			file.backupPath = backupPath
+		}
+
+		err = os.Rename(file.tmpPath, file.path)
+		if err != nil {
+			s.rollback(i + 1)
+			return ErrCannotWrite(file.path, err)
+		}
+		file.committed = true
+	}
+
+	for _, file := range s.staged {
+		if file.backupPath != "" {
+			_ = os.Remove(file.backupPath)
+		}
+	}
+	return nil
+}
+
+// rollback restores the destinations of the first n staged files and removes all staged files
+// and the directories created for them.
+func (s *injectStage) rollback(n int) {
+	for i := n - 1; i >= 0; i-- {
+		file := s.staged[i]
+		if file.committed {
+			_ = os.Remove(file.path)
+		}
+		if file.backupPath != "" {
+			_ = os.Rename(file.backupPath, file.path)
+		}
+	}
+	s.abort()
+}
+
+// abort removes all staged files and the directories created for them.
+func (s *injectStage) abort() {
+	for _, file := range s.staged {
+		_ = os.Remove(file.tmpPath)
+	}
+	for i := len(s.createdDirs) - 1; i >= 0; i-- {
+		_ = os.Remove(s.createdDirs[i])
+	}
+}
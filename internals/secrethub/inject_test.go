@@ -0,0 +1,737 @@
+package secrethub
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/secrethub/secrethub-cli/internals/cli/filemode"
+	"github.com/secrethub/secrethub-cli/internals/cli/ui/fakeui"
+	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl"
+
+	"github.com/secrethub/secrethub-go/internals/api"
+	"github.com/secrethub/secrethub-go/internals/assert"
+	"github.com/secrethub/secrethub-go/pkg/secrethub"
+	"github.com/secrethub/secrethub-go/pkg/secrethub/fakeclient"
+)
+
+func TestInjectCommand_Run_Dir(t *testing.T) {
+	templates := map[string]string{
+		"app.yml":          "user: {{ company/app/db/user }}\npass: {{ company/app/db/pass }}",
+		"nested/db.conf":   "password={{ company/app/db/pass }}",
+		"nested/README.md": "no secrets here",
+		"skipped/key.pem":  "{{ company/app/key }}",
+	}
+
+	cases := map[string]struct {
+		include  []string
+		exclude  []string
+		outDir   string
+		existing map[string]string
+		force    bool
+		missing  bool
+		// fileMode is the value of --file-mode, which is 0600 when not set. The templates have mode 0640.
+		fileMode os.FileMode
+		expected map[string]string
+		err      error
+	}{
+		"all templates": {
+			exclude: []string{"skipped"},
+			expected: map[string]string{
+				"app.yml":          "user: root\npass: secret\n",
+				"nested/db.conf":   "password=secret\n",
+				"nested/README.md": "no secrets here\n",
+			},
+		},
+		"file mode": {
+			exclude:  []string{"skipped"},
+			fileMode: 0400,
+			expected: map[string]string{
+				"app.yml":          "user: root\npass: secret\n",
+				"nested/db.conf":   "password=secret\n",
+				"nested/README.md": "no secrets here\n",
+			},
+		},
+		"nested output directory": {
+			exclude: []string{"skipped"},
+			outDir:  "a/b/out",
+			expected: map[string]string{
+				"app.yml":          "user: root\npass: secret\n",
+				"nested/db.conf":   "password=secret\n",
+				"nested/README.md": "no secrets here\n",
+			},
+		},
+		"include and exclude": {
+			include: []string{"*.conf", "*.yml"},
+			exclude: []string{"app.*"},
+			expected: map[string]string{
+				"nested/db.conf": "password=secret\n",
+			},
+		},
+		"include relative path": {
+			include: []string{"nested/*"},
+			exclude: []string{"*.md"},
+			expected: map[string]string{
+				"nested/db.conf": "password=secret\n",
+			},
+		},
+		"invalid pattern": {
+			include: []string{"[foo"},
+			err:     ErrInvalidInjectPattern("[foo", filepath.ErrBadPattern),
+		},
+		"nothing included": {
+			include: []string{"*.json"},
+			err:     ErrNoTemplatesInDir,
+		},
+		"existing file": {
+			exclude: []string{"skipped"},
+			existing: map[string]string{
+				"app.yml": "old",
+			},
+			expected: map[string]string{
+				"app.yml": "old",
+			},
+			err: ErrFileAlreadyExists,
+		},
+		"overwrite existing file": {
+			exclude: []string{"skipped"},
+			existing: map[string]string{
+				"app.yml": "old",
+				"other":   "untouched",
+			},
+			force: true,
+			expected: map[string]string{
+				"app.yml":          "user: root\npass: secret\n",
+				"nested/db.conf":   "password=secret\n",
+				"nested/README.md": "no secrets here\n",
+				"other":            "untouched",
+			},
+		},
+		"secret not found": {
+			missing: true,
+			err: templateSourceError{
+				file:    "skipped/key.pem",
+				lineNo:  1,
+				colNo:   1,
+				message: "Secret not found (server.secret_not_found)",
+				source:  "{{ company/app/key }}",
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			inDir := filepath.Join(dir, "in")
+			if tc.fileMode == 0 {
+				tc.fileMode = 0600
+			}
+			if tc.outDir == "" {
+				tc.outDir = "out"
+			}
+			outDir := filepath.Join(dir, filepath.FromSlash(tc.outDir))
+			writeFiles(t, inDir, templates, 0640)
+			if tc.existing != nil {
+				writeFiles(t, outDir, tc.existing, 0600)
+			}
+
+			var mutex sync.Mutex
+			reads := map[string]int{}
+			secrets := map[string]string{
+				"company/app/db/user": "root",
+				"company/app/db/pass": "secret",
+			}
+			if !tc.missing {
+				secrets["company/app/key"] = "key"
+			}
+
+			io := fakeui.NewIO(t)
+			io.Out.Piped = true
+			cmd := InjectCommand{
+				io:              io,
+				inFile:          inDir,
+				outFile:         outDir,
+				include:         tc.include,
+				exclude:         tc.exclude,
+				force:           tc.force,
+				fileMode:        filemode.New(tc.fileMode),
+				templateVersion: "auto",
+
+				dontPromptMissingTemplateVars: true,
+				newClient: func() (secrethub.ClientInterface, error) {
+					return fakeclient.Client{
+						SecretService: &fakeclient.SecretService{
+							VersionService: &fakeclient.SecretVersionService{
+								GetWithDataFunc: func(path string) (*api.SecretVersion, error) {
+									mutex.Lock()
+									defer mutex.Unlock()
+									reads[path]++
+									secret, ok := secrets[path]
+									if !ok {
+										return nil, api.ErrSecretNotFound
+									}
+									return &api.SecretVersion{Data: []byte(secret)}, nil
+								},
+							},
+						},
+					}, nil
+				},
+			}
+
+			err := cmd.Run()
+			assert.Equal(t, err, tc.err)
+
+			for path, count := range reads {
+				if count != 1 {
+					t.Errorf("secret %s read %d times, expected once", path, count)
+				}
+			}
+
+			actual := readFiles(t, outDir)
+			if tc.expected == nil {
+				tc.expected = map[string]string{}
+			}
+			assert.Equal(t, actual, tc.expected)
+
+			if err == nil && runtime.GOOS != "windows" {
+				info, err := os.Stat(filepath.Join(outDir, "nested", "db.conf"))
+				assert.OK(t, err)
+				assert.Equal(t, info.Mode().Perm(), tc.fileMode)
+			}
+		})
+	}
+}
+
+func TestInjectStage_Commit_Rollback(t *testing.T) {
+	dir := t.TempDir()
+	inDir := filepath.Join(dir, "in")
+	outDir := filepath.Join(dir, "out")
+	writeFiles(t, inDir, map[string]string{"a": "", "b": ""}, 0600)
+	writeFiles(t, outDir, map[string]string{"a": "old"}, 0600)
+
+	stage := &injectStage{
+		inDir:  inDir,
+		outDir: outDir,
+	}
+	err := stage.add("a", []byte("new"), 0600)
+	assert.OK(t, err)
+	err = stage.add("b", []byte("new"), 0600)
+	assert.OK(t, err)
+
+	// Moving the second file into place fails after the first one has been moved into place.
+	err = os.Remove(stage.staged[1].tmpPath)
+	assert.OK(t, err)
+
+	err = stage.commit()
+	if err == nil {
+		t.Fatal("expected an error when a staged file cannot be moved into place")
+	}
+
+	assert.Equal(t, readFiles(t, outDir), map[string]string{"a": "old"})
+}
+
+// writeFiles writes the given files, with paths relative to the given directory.
+func writeFiles(t *testing.T, dir string, files map[string]string, mode os.FileMode) {
+	for path, content := range files {
+		path = filepath.Join(dir, filepath.FromSlash(path))
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		assert.OK(t, err)
+		err = os.WriteFile(path, []byte(content), mode)
+		assert.OK(t, err)
+	}
+}
+
+// readFiles returns the contents of all files in the given directory by their slash separated relative path.
+func readFiles(t *testing.T, dir string) map[string]string {
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(relPath)] = string(content)
+		return nil
+	})
+	assert.OK(t, err)
+	return files
+}
+
+func TestInjectCommand_Run_Watch(t *testing.T) {
+	cases := map[string]struct {
+		cmd InjectCommand
+		err error
+	}{
+		"exec on change without watch": {
+			cmd: InjectCommand{
+				inFile:       "secrethub.tpl",
+				outFile:      "out",
+				execOnChange: "nginx -s reload",
+			},
+			err: ErrExecOnChangeWithoutWatch,
+		},
+		"watch without out file": {
+			cmd: InjectCommand{
+				inFile: "secrethub.tpl",
+				watch:  true,
+			},
+			err: ErrInjectWatchNoFiles,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			tc.cmd.io = fakeui.NewIO(t)
+
+			err := tc.cmd.Run()
+			assert.Equal(t, err, tc.err)
+		})
+	}
+}
+
+func TestInjectCommand_watchTemplates(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the --exec-on-change command of this test uses the syntax of sh")
+	}
+
+	dir := t.TempDir()
+	inFile := filepath.Join(dir, "config.tpl")
+	outFile := filepath.Join(dir, "config")
+	markerFile := filepath.Join(dir, "reloads")
+	writeFiles(t, dir, map[string]string{
+		"config.tpl": "pass: {{ company/app/db/pass }}",
+	}, 0600)
+
+	var version int32 = 1
+	var failures int32 = 1
+	data := map[int32]string{1: "first", 2: "second"}
+
+	io := fakeui.NewIO(t)
+	cmd := InjectCommand{
+		io:              io,
+		inFile:          inFile,
+		outFile:         outFile,
+		fileMode:        filemode.New(0600),
+		templateVersion: "auto",
+		watch:           true,
+		watchInterval:   time.Millisecond * 10,
+		execOnChange:    "echo 'reloaded' >> '" + markerFile + "'",
+		execTimeout:     time.Second * 5,
+
+		dontPromptMissingTemplateVars: true,
+		newClient: func() (secrethub.ClientInterface, error) {
+			return fakeclient.Client{
+				SecretService: &fakeclient.SecretService{
+					VersionService: &fakeclient.SecretVersionService{
+						GetWithDataFunc: func(path string) (*api.SecretVersion, error) {
+							v := atomic.LoadInt32(&version)
+							return &api.SecretVersion{Version: int(v), Data: []byte(data[v])}, nil
+						},
+						GetWithoutDataFunc: func(path string) (*api.SecretVersion, error) {
+							v := atomic.LoadInt32(&version)
+							// The first check after a new version is written fails, to test that the check is retried.
+							if v == 2 && atomic.AddInt32(&failures, -1) >= 0 {
+								return nil, api.ErrSecretNotFound
+							}
+							return &api.SecretVersion{Version: int(v)}, nil
+						},
+					},
+				},
+			}, nil
+		},
+	}
+
+	stop := make(chan os.Signal, 1)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- cmd.watchTemplates(stop)
+	}()
+
+	waitForFile := func(expected string) {
+		deadline := time.Now().Add(time.Second * 5)
+		for time.Now().Before(deadline) {
+			content, err := os.ReadFile(outFile)
+			if err == nil && string(content) == expected {
+				return
+			}
+			time.Sleep(time.Millisecond * 5)
+		}
+		t.Fatalf("%s was not injected", expected)
+	}
+
+	waitForFile("pass: first\n")
+
+	atomic.StoreInt32(&version, 2)
+	waitForFile("pass: second\n")
+
+	err := os.WriteFile(inFile, []byte("password: {{ company/app/db/pass }}"), 0600)
+	assert.OK(t, err)
+	waitForFile("password: second\n")
+
+	stop <- os.Interrupt
+	assert.OK(t, <-errs)
+
+	reloads, err := os.ReadFile(markerFile)
+	assert.OK(t, err)
+	assert.Equal(t, string(reloads), "reloaded\nreloaded\nreloaded\n")
+
+	info, err := os.Stat(outFile)
+	assert.OK(t, err)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0600))
+}
+
+func TestInjectCommand_watchTemplates_ChangeWhileInjecting(t *testing.T) {
+	dir := t.TempDir()
+	inFile := filepath.Join(dir, "config.tpl")
+	outFile := filepath.Join(dir, "config")
+	writeFiles(t, dir, map[string]string{
+		"config.tpl": "pass: {{ company/app/db/pass }}",
+	}, 0600)
+
+	var latest int32 = 1
+	data := map[int32]string{1: "first", 2: "second"}
+
+	cmd := InjectCommand{
+		io:              fakeui.NewIO(t),
+		inFile:          inFile,
+		outFile:         outFile,
+		fileMode:        filemode.New(0600),
+		templateVersion: "auto",
+		watch:           true,
+		watchInterval:   time.Millisecond * 10,
+
+		dontPromptMissingTemplateVars: true,
+		newClient: func() (secrethub.ClientInterface, error) {
+			return fakeclient.Client{
+				SecretService: &fakeclient.SecretService{
+					VersionService: &fakeclient.SecretVersionService{
+						GetWithDataFunc: func(path string) (*api.SecretVersion, error) {
+							v := atomic.LoadInt32(&latest)
+							// A new version is written right after the secret is read for the first time.
+							atomic.CompareAndSwapInt32(&latest, 1, 2)
+							return &api.SecretVersion{Version: int(v), Data: []byte(data[v])}, nil
+						},
+						GetWithoutDataFunc: func(path string) (*api.SecretVersion, error) {
+							return &api.SecretVersion{Version: int(atomic.LoadInt32(&latest))}, nil
+						},
+					},
+				},
+			}, nil
+		},
+	}
+
+	stop := make(chan os.Signal, 1)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- cmd.watchTemplates(stop)
+	}()
+
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		content, err := os.ReadFile(outFile)
+		if err == nil && string(content) == "pass: second\n" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the new version of the secret was not injected, got %q", content)
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+
+	stop <- os.Interrupt
+	assert.OK(t, <-errs)
+}
+
+func TestInjectCommand_Run_Escape(t *testing.T) {
+	cases := map[string]struct {
+		outFile  string
+		escaping string
+		template string
+		expected string
+		err      error
+	}{
+		"auto json": {
+			outFile:  "config.json",
+			escaping: "auto",
+			template: `{"password": "{{ company/app/db/pass }}"}`,
+			expected: `{"password": "p\"a\\ss\nword"}` + "\n",
+		},
+		"default": {
+			outFile:  "config.json",
+			template: `{"password": "{{ company/app/db/pass }}"}`,
+			expected: "{\"password\": \"p\"a\\ss\nword\"}\n",
+		},
+		"unquoted yaml value": {
+			outFile:  "config.yml",
+			escaping: "none",
+			template: "database:\n  password: {{ company/app/db/pass }}",
+			expected: "database:\n  password: p\"a\\ss\nword\n",
+		},
+		"auto unknown extension": {
+			outFile:  "config.conf",
+			escaping: "auto",
+			template: `password={{ company/app/db/pass }}`,
+			expected: "password=p\"a\\ss\nword\n",
+		},
+		"explicit escaping": {
+			outFile:  "config.conf",
+			escaping: "shell",
+			template: `PASSWORD="{{ company/app/db/pass }}"`,
+			expected: "PASSWORD=\"p\\\"a\\\\ss\nword\"\n",
+		},
+		"override for tag": {
+			outFile:  "config.json",
+			escaping: "auto",
+			template: `{{ company/app/db/pass | escape "none" }}`,
+			expected: "p\"a\\ss\nword\n",
+		},
+		"v1 template": {
+			outFile:  "config.json",
+			escaping: "json",
+			template: `${ company/app/db/pass }`,
+			expected: "p\"a\\ss\nword\n",
+		},
+		"unknown escaping": {
+			outFile:  "config.json",
+			escaping: "csv",
+			err:      tpl.ErrUnknownEscaping("csv"),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"config.tpl": tc.template}, 0600)
+
+			cmd := InjectCommand{
+				io:              fakeui.NewIO(t),
+				inFile:          filepath.Join(dir, "config.tpl"),
+				outFile:         filepath.Join(dir, tc.outFile),
+				fileMode:        filemode.New(0600),
+				templateVersion: "auto",
+				escaping:        tc.escaping,
+				newClient: func() (secrethub.ClientInterface, error) {
+					return fakeclient.Client{
+						SecretService: &fakeclient.SecretService{
+							VersionService: &fakeclient.SecretVersionService{
+								GetWithDataFunc: func(path string) (*api.SecretVersion, error) {
+									return &api.SecretVersion{Data: []byte("p\"a\\ss\nword")}, nil
+								},
+							},
+						},
+					}, nil
+				},
+			}
+
+			err := cmd.Run()
+			assert.Equal(t, err, tc.err)
+
+			if tc.err == nil {
+				actual, err := os.ReadFile(cmd.outFile)
+				assert.OK(t, err)
+				assert.Equal(t, string(actual), tc.expected)
+			}
+		})
+	}
+}
+
+func TestInjectCommand_Run_Include(t *testing.T) {
+	cases := map[string]struct {
+		templates map[string]string
+		expected  string
+		included  []string
+		cycle     bool
+	}{
+		"include": {
+			templates: map[string]string{
+				"app.tpl":           "[db]\n{{ include \"partials/db.tpl\" }}",
+				"partials/db.tpl":   "user={{ company/app/db/user }}\n{{ include \"pass.tpl\" }}",
+				"partials/pass.tpl": "pass={{ company/${app}/db/pass }}",
+			},
+			expected: "[db]\nuser=root\npass=secret\n",
+			included: []string{"partials/db.tpl", "partials/pass.tpl"},
+		},
+		"include cycle": {
+			templates: map[string]string{
+				"app.tpl": "{{ include \"app.tpl\" }}",
+			},
+			cycle: true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tc.templates, 0600)
+
+			cmd := InjectCommand{
+				io:              fakeui.NewIO(t),
+				inFile:          filepath.Join(dir, "app.tpl"),
+				outFile:         filepath.Join(dir, "app.conf"),
+				fileMode:        filemode.New(0600),
+				templateVersion: "auto",
+				templateVars:    map[string]string{"app": "app"},
+				newClient: func() (secrethub.ClientInterface, error) {
+					return fakeclient.Client{
+						SecretService: &fakeclient.SecretService{
+							VersionService: &fakeclient.SecretVersionService{
+								GetWithDataFunc: func(path string) (*api.SecretVersion, error) {
+									if path == "company/app/db/user" {
+										return &api.SecretVersion{Data: []byte("root")}, nil
+									}
+									return &api.SecretVersion{Data: []byte("secret")}, nil
+								},
+							},
+						},
+					}, nil
+				},
+			}
+
+			err := cmd.Run()
+			if tc.cycle {
+				path := filepath.Join(dir, "app.tpl")
+				assert.Equal(t, err, templateSourceError{
+					file:    path,
+					lineNo:  1,
+					colNo:   1,
+					message: tpl.ErrIncludeCycle(1, 1, []string{path, path}).(tpl.PositionedError).Message(),
+					source:  `{{ include "app.tpl" }}`,
+				})
+				return
+			}
+			assert.OK(t, err)
+
+			actual, err := os.ReadFile(cmd.outFile)
+			assert.OK(t, err)
+			assert.Equal(t, string(actual), tc.expected)
+
+			included := make([]string, len(tc.included))
+			for i, file := range tc.included {
+				included[i] = filepath.Join(dir, filepath.FromSlash(file))
+			}
+			assert.Equal(t, cmd.includedFiles, included)
+		})
+	}
+}
+
+func TestInjectCommand_Run_GenerateMissing(t *testing.T) {
+	cases := map[string]struct {
+		template        string
+		generateMissing bool
+		offline         bool
+		exists          bool
+		expectedWrites  int
+		// expected is the injected output, in which <generated> is replaced by the written secret.
+		expected string
+		err      error
+	}{
+		"generate missing": {
+			template:        `key={{ company/app/session-key | generate 16 "numeric" }}`,
+			generateMissing: true,
+			expectedWrites:  1,
+			expected:        "key=<generated>\n",
+		},
+		"generate once": {
+			template:        `{{ company/app/session-key | generate 16 "numeric" }}/{{ company/app/session-key | generate 16 "numeric" }}`,
+			generateMissing: true,
+			expectedWrites:  1,
+			expected:        "<generated>/<generated>\n",
+		},
+		"existing secret": {
+			template:        `key={{ company/app/session-key | generate 16 "numeric" }}`,
+			generateMissing: true,
+			exists:          true,
+			expected:        "key=existing\n",
+		},
+		"without generate missing": {
+			template: `key={{ company/app/session-key | generate 16 "numeric" }}`,
+			err: templateSourceError{
+				lineNo:  1,
+				colNo:   5,
+				message: "Secret not found (server.secret_not_found)",
+				source:  `key={{ company/app/session-key | generate 16 "numeric" }}`,
+			},
+		},
+		"offline": {
+			template:        `key={{ company/app/session-key | generate 16 "numeric" }}`,
+			generateMissing: true,
+			offline:         true,
+			err:             ErrFlagsConflict("--generate-missing and --offline"),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"app.tpl": tc.template}, 0600)
+
+			var writes []string
+			cmd := InjectCommand{
+				io:              fakeui.NewIO(t),
+				inFile:          filepath.Join(dir, "app.tpl"),
+				outFile:         filepath.Join(dir, "app.conf"),
+				fileMode:        filemode.New(0600),
+				templateVersion: "auto",
+				generateMissing: tc.generateMissing,
+				cacheOptions:    secretCacheOptions{offline: tc.offline},
+				newClient: func() (secrethub.ClientInterface, error) {
+					return fakeclient.Client{
+						SecretService: &fakeclient.SecretService{
+							VersionService: &fakeclient.SecretVersionService{
+								GetWithDataFunc: func(path string) (*api.SecretVersion, error) {
+									if tc.exists {
+										return &api.SecretVersion{Data: []byte("existing")}, nil
+									}
+									return nil, api.ErrSecretNotFound
+								},
+							},
+							WriteFunc: func(path string, data []byte) (*api.SecretVersion, error) {
+								assert.Equal(t, path, "company/app/session-key")
+								writes = append(writes, string(data))
+								return &api.SecretVersion{Version: 1}, nil
+							},
+						},
+					}, nil
+				},
+			}
+
+			// Template errors are reported in the template file, which is only known here.
+			if templateErr, ok := tc.err.(templateSourceError); ok {
+				templateErr.file = cmd.inFile
+				tc.err = templateErr
+			}
+
+			err := cmd.Run()
+			assert.Equal(t, err, tc.err)
+			assert.Equal(t, len(writes), tc.expectedWrites)
+			if err != nil {
+				return
+			}
+
+			actual, err := os.ReadFile(cmd.outFile)
+			assert.OK(t, err)
+
+			expected := tc.expected
+			if len(writes) > 0 {
+				assert.Equal(t, len(writes[0]), 16)
+				expected = strings.ReplaceAll(expected, "<generated>", writes[0])
+			}
+			assert.Equal(t, string(actual), expected)
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package secrethub
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl"
+)
+
+// Errors
+var (
+	ErrInjectWatchNoFiles       = errMain.Code("inject_watch_no_files").Error("--watch requires a template file or directory given with --in-file and an output file or directory given with --out-file")
+	ErrExecOnChangeWithoutWatch = errMain.Code("exec_on_change_without_watch").Error("--exec-on-change can only be used together with --watch")
+	ErrInjectWatchFailed        = errMain.Code("inject_watch_failed").ErrorPref("could not check the templates and secrets for changes, retrying in %s: %s")
+	ErrInjectRetryFailed        = errMain.Code("inject_retry_failed").ErrorPref("could not inject the changed templates, retrying in %s: %s")
+	ErrExecOnChangeFailed       = errMain.Code("exec_on_change_failed").ErrorPref("the command given with --exec-on-change failed: %s")
+	ErrExecOnChangeTimeout      = errMain.Code("exec_on_change_timeout").ErrorPref("the command given with --exec-on-change did not finish within %s and has been killed")
+)
+
+// maxWatchBackoff is the longest time to wait before checking for changes again
+// after checking or injecting the templates failed, unless the watch interval itself is longer.
+const maxWatchBackoff = 5 * time.Minute
+
+// watchTemplates injects the templates and writes them to the output files. After that, the templates are
+// injected again whenever one of the templates or a secret used in them changes, until a signal is received on stop.
+// The command given with --exec-on-change is run every time an output file changes.
+//
+// When checking for changes or injecting the templates fails, the output files are left untouched and the
+// interval before the next attempt is doubled, up to maxWatchBackoff.
+func (cmd *InjectCommand) watchTemplates(stop <-chan os.Signal) error {
+	templateVariableReader, err := cmd.templateVariableReader()
+	if err != nil {
+		return err
+	}
+
+	files, err := cmd.templateFiles()
+	if err != nil {
+		return err
+	}
+
+	watcher, err := cmd.injectWatchedFiles(files, templateVariableReader)
+	if err != nil {
+		return err
+	}
+
+	confirmed, err := cmd.confirmOverwrite(files)
+	if err != nil {
+		return err
+	}
+	if !confirmed {
+		fmt.Fprintln(cmd.io.Output(), "Aborting.")
+		return nil
+	}
+
+	err = cmd.writeChanges(files)
+	if err != nil {
+		return err
+	}
+
+	interval := cmd.watchInterval
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	// retry is set when injecting the changed templates failed, so that it is retried without waiting for another change.
+	retry := false
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-timer.C:
+		}
+
+		if !retry {
+			changed, err := watcher.changed()
+			if err != nil {
+				interval = cmd.backoff(interval)
+				fmt.Fprintln(os.Stderr, ErrInjectWatchFailed(interval, err))
+				timer.Reset(interval)
+				continue
+			}
+			if !changed {
+				interval = cmd.watchInterval
+				timer.Reset(interval)
+				continue
+			}
+		}
+
+		newWatcher, err := cmd.reinject(templateVariableReader)
+		if err != nil {
+			retry = true
+			interval = cmd.backoff(interval)
+			fmt.Fprintln(os.Stderr, ErrInjectRetryFailed(interval, err))
+			timer.Reset(interval)
+			continue
+		}
+
+		watcher = newWatcher
+		retry = false
+		interval = cmd.watchInterval
+		timer.Reset(interval)
+	}
+}
+
+// reinject injects the current templates again with newly read secrets and writes the output files that changed.
+func (cmd *InjectCommand) reinject(templateVariableReader tpl.VariableReader) (*sourceWatcher, error) {
+	files, err := cmd.templateFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	watcher, err := cmd.injectWatchedFiles(files, templateVariableReader)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cmd.writeChanges(files)
+	if err != nil {
+		return nil, err
+	}
+	return watcher, nil
+}
+
+// injectWatchedFiles parses and injects the given template files and returns a watcher for the templates and
+// the secrets used in them. The watcher is created before the secrets are read, so that a secret that changes
+// while the templates are injected is detected as a change.
+func (cmd *InjectCommand) injectWatchedFiles(files []*injectFile, templateVariableReader tpl.VariableReader) (*sourceWatcher, error) {
+	paths, err := cmd.parseFiles(files, templateVariableReader)
+	if err != nil {
+		return nil, err
+	}
+
+	watcher, err := newSourceWatcher(cmd.newClient, os.ReadFile, cmd.templatePaths, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cmd.evaluateFiles(files, paths, templateVariableReader)
+	if err != nil {
+		return nil, err
+	}
+	return watcher, nil
+}
+
+// writeChanges writes the injected templates of which the output files changed and runs the command
+// given with --exec-on-change when any output file changed.
+func (cmd *InjectCommand) writeChanges(files []*injectFile) error {
+	changed := cmd.changedFiles(files)
+	if len(changed) == 0 {
+		return nil
+	}
+
+	err := cmd.writeFiles(changed)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range changed {
+		absPath, err := filepath.Abs(cmd.outPath(file))
+		if err != nil {
+			return ErrCannotWrite(err)
+		}
+		fmt.Fprintf(cmd.io.Output(), "%s\n", absPath)
+	}
+
+	if cmd.execOnChange != "" {
+		// The output files have been written, so a failing command should not cause them to be written again.
+		err = cmd.runExecOnChange()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+
+	return nil
+}
+
+// templatePaths returns the paths of the template files to watch, including the files of the
+// templates they included when they were last injected. Included files that no longer exist are
+// left out, so that removing them is detected as a change.
+func (cmd *InjectCommand) templatePaths() ([]string, error) {
+	files, err := cmd.templateFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(files)+len(cmd.includedFiles))
+	seen := make(map[string]bool, len(files)+len(cmd.includedFiles))
+	for _, file := range files {
+		paths = append(paths, file.path)
+		seen[file.path] = true
+	}
+	for _, path := range cmd.includedFiles {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		_, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// runExecOnChange runs the command given with --exec-on-change in a shell and kills it when it does not finish
+// within the --exec-timeout.
+func (cmd *InjectCommand) runExecOnChange() error {
+	ctx, cancel := context.WithTimeout(context.Background(), cmd.execTimeout)
+	defer cancel()
+
+	command := shellCommand(ctx, cmd.execOnChange)
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+
+	err := command.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return ErrExecOnChangeTimeout(cmd.execTimeout)
+	}
+	if err != nil {
+		return ErrExecOnChangeFailed(err)
+	}
+	return nil
+}
+
+// backoff returns the interval to wait after a failed attempt that followed the given interval.
+func (cmd *InjectCommand) backoff(interval time.Duration) time.Duration {
+	max := maxWatchBackoff
+	if cmd.watchInterval > max {
+		max = cmd.watchInterval
+	}
+
+	interval *= 2
+	if interval > max {
+		return max
+	}
+	return interval
+}
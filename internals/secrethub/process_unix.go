@@ -0,0 +1,81 @@
+//go:build !windows
+
+package secrethub
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setProcessGroup makes the command run in a new process group, of which it is the leader.
+// When the current process runs in the foreground of the terminal on stdin, the new process group
+// becomes the foreground process group of the terminal, so that the command is not stopped when it
+// reads from the terminal. The returned function gives the terminal back to the current process group
+// and should be called when the command has exited.
+func setProcessGroup(command *exec.Cmd) func() {
+	if command.SysProcAttr == nil {
+		command.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	command.SysProcAttr.Setpgid = true
+
+	fd := int(os.Stdin.Fd())
+	foreground, err := unix.IoctlGetInt(fd, unix.TIOCGPGRP)
+	if err != nil || foreground != unix.Getpgrp() {
+		return func() {}
+	}
+
+	command.SysProcAttr.Foreground = true
+	command.SysProcAttr.Ctty = fd
+	return func() {
+		// The current process group is in the background now, so changing the foreground process group
+		// sends it a SIGTTOU, which would stop it, unless the signal is ignored.
+		signal.Ignore(syscall.SIGTTOU)
+		defer signal.Reset(syscall.SIGTTOU)
+		_ = unix.IoctlSetPointerInt(fd, unix.TIOCSPGRP, foreground)
+	}
+}
+
+// shellCommand returns a command that runs the given command line with sh.
+func shellCommand(ctx context.Context, commandLine string) *exec.Cmd {
+	return exec.CommandContext(ctx, "/bin/sh", "-c", commandLine)
+}
+
+// signalProcess sends the signal to the process or, if group is true,
+// to every process in the process group that the process leads.
+func signalProcess(process *os.Process, s os.Signal, group bool) error {
+	if !group {
+		return process.Signal(s)
+	}
+
+	sig, ok := s.(syscall.Signal)
+	if !ok {
+		return process.Signal(s)
+	}
+
+	err := syscall.Kill(-process.Pid, sig)
+	if err == syscall.ESRCH {
+		return os.ErrProcessDone
+	}
+	return err
+}
+
+// isForwardable returns whether a signal received by the current process should be passed on to the child process.
+// Signals that concern the current process only, like the notification that a child process has stopped
+// and the signal the Go runtime uses for preemption, are not forwarded.
+func isForwardable(s os.Signal) bool {
+	switch s {
+	case syscall.SIGCHLD, syscall.SIGURG, syscall.SIGPIPE, syscall.SIGTTIN, syscall.SIGTTOU:
+		return false
+	}
+	return true
+}
+
+// isChildExit returns whether the signal notifies that a child process has exited.
+func isChildExit(s os.Signal) bool {
+	return s == syscall.SIGCHLD
+}
@@ -0,0 +1,40 @@
+package secrethub
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup is a no-op on Windows, which does not have process groups.
+func setProcessGroup(command *exec.Cmd) func() {
+	return func() {}
+}
+
+// shellCommand returns a command that runs the given command line with cmd.exe.
+// The command line is passed as is, as cmd.exe does not follow the quoting rules that are used to escape arguments.
+func shellCommand(ctx context.Context, commandLine string) *exec.Cmd {
+	command := exec.CommandContext(ctx, "cmd.exe")
+	command.SysProcAttr = &syscall.SysProcAttr{
+		CmdLine: "cmd.exe /C " + commandLine,
+	}
+	return command
+}
+
+// signalProcess sends the signal to the process.
+// Windows does not have process groups, so group is ignored.
+func signalProcess(process *os.Process, s os.Signal, group bool) error {
+	return process.Signal(s)
+}
+
+// isForwardable returns whether a signal received by the current process should be passed on to the child process.
+func isForwardable(s os.Signal) bool {
+	return true
+}
+
+// isChildExit returns whether the signal notifies that a child process has exited.
+// Windows does not notify processes of exited child processes with a signal.
+func isChildExit(s os.Signal) bool {
+	return false
+}
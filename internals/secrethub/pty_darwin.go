@@ -0,0 +1,53 @@
+package secrethub
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPTY opens a new pseudo-terminal and returns both of its sides.
+func openPTY() (pty *os.File, tty *os.File, err error) {
+	pty, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = pty.Close()
+		}
+	}()
+
+	// The name of the terminal is written to a buffer of 128 bytes.
+	name := make([]byte, 128)
+	err = controlFd(pty, func(fd int) error {
+		err := unix.IoctlSetInt(fd, unix.TIOCPTYGRANT, 0)
+		if err != nil {
+			return err
+		}
+		err = unix.IoctlSetInt(fd, unix.TIOCPTYUNLK, 0)
+		if err != nil {
+			return err
+		}
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), uintptr(unix.TIOCPTYGNAME), uintptr(unsafe.Pointer(&name[0])))
+		if errno != 0 {
+			return errno
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+
+	tty, err = os.OpenFile(string(name), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pty, tty, nil
+}
@@ -0,0 +1,41 @@
+package secrethub
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPTY opens a new pseudo-terminal and returns both of its sides.
+func openPTY() (pty *os.File, tty *os.File, err error) {
+	pty, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = pty.Close()
+		}
+	}()
+
+	var n uint32
+	err = controlFd(pty, func(fd int) error {
+		var err error
+		n, err = unix.IoctlGetUint32(fd, unix.TIOCGPTN)
+		if err != nil {
+			return err
+		}
+		return unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tty, err = os.OpenFile("/dev/pts/"+strconv.FormatUint(uint64(n), 10), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pty, tty, nil
+}
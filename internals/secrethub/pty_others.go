@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !windows
+
+package secrethub
+
+import "os"
+
+// openPTY is only implemented on Linux and macOS.
+func openPTY() (pty *os.File, tty *os.File, err error) {
+	return nil, nil, ErrTTYNotSupported
+}
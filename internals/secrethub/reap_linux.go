@@ -0,0 +1,29 @@
+package secrethub
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// reapZombies waits for all exited child processes. When running as PID 1, for example in a container,
+// orphaned processes are reparented to the current process. They become zombies when they exit, until
+// they are waited for.
+//
+// The command with the given pid can be reaped here before exec.Cmd waits for it. Its status is then
+// sent on the given channel, which should be buffered, so that it can be passed back to exec.Cmd.
+func reapZombies(pid int, status chan<- syscall.WaitStatus) {
+	for {
+		var ws unix.WaitStatus
+		reaped, err := unix.Wait4(-1, &ws, unix.WNOHANG, nil)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil || reaped <= 0 {
+			return
+		}
+		if reaped == pid {
+			status <- syscall.WaitStatus(ws)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package secrethub
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/secrethub/secrethub-go/internals/assert"
+)
+
+func TestReapZombies(t *testing.T) {
+	orphan := exec.Command("/bin/sh", "-c", "exit 0")
+	command := exec.Command("/bin/sh", "-c", "exit 3")
+	for _, c := range []*exec.Cmd{orphan, command} {
+		err := c.Start()
+		assert.OK(t, err)
+
+		// Wait until the process has exited, without reaping it.
+		var info unix.Siginfo
+		err = unix.Waitid(unix.P_PID, c.Process.Pid, &info, unix.WEXITED|unix.WNOWAIT, nil)
+		assert.OK(t, err)
+	}
+
+	status := make(chan syscall.WaitStatus, 1)
+	reapZombies(command.Process.Pid, status)
+
+	// Both processes have been reaped, so they can no longer be waited for.
+	err := orphan.Wait()
+	assert.Equal(t, errors.Is(err, syscall.ECHILD), true)
+	err = command.Wait()
+	assert.Equal(t, errors.Is(err, syscall.ECHILD), true)
+
+	select {
+	case s := <-status:
+		assert.Equal(t, exitCode(s), 3)
+	default:
+		t.Fatal("the status of the command has not been passed on")
+	}
+}
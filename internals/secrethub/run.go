@@ -1,10 +1,14 @@
 package secrethub
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -16,6 +20,7 @@ import (
 	"github.com/secrethub/secrethub-cli/internals/cli/ui"
 
 	"github.com/secrethub/secrethub-go/internals/errio"
+	"github.com/spf13/cobra"
 
 	"github.com/secrethub/secrethub-cli/internals/cli/validation"
 )
@@ -29,9 +34,19 @@ var (
 	ErrReadEnvFile            = errRun.Code("env_file_read_error").ErrorPref("could not read the environment file %s: %s")
 	ErrReadDefaultEnvFile     = errRun.Code("default_env_file_read_error").ErrorPref("could not read default run env-file %s: %s")
 	ErrTemplate               = errRun.Code("invalid_template").ErrorPref("could not parse template at line %d: %s")
+	ErrIncludedTemplate       = errRun.Code("invalid_template").ErrorPref("could not parse included template %s at line %d: %s")
 	ErrParsingTemplate        = errRun.Code("template_parsing_failed").ErrorPref("error while processing template file '%s': %s")
 	ErrInvalidTemplateVar     = errRun.Code("invalid_template_var").ErrorPref("template variable '%s' is invalid: template variables may only contain uppercase letters, digits, and the '_' (underscore) and are not allowed to start with a number")
 	ErrSecretsNotAllowedInKey = errRun.Code("secret_in_key").Error("secrets are not allowed in run template keys")
+	ErrWatchFailed            = errRun.Code("watch_failed").ErrorPref("could not check the sourced secrets and env files for changes: %s")
+	ErrReloadFailed           = errRun.Code("reload_failed").ErrorPref("could not reload the environment, the command keeps running with the previous environment: %s")
+	ErrUnknownMaskEncoding    = errRun.Code("unknown_mask_encoding").ErrorPref("unknown mask encoding '%s': supported encodings are %s")
+	ErrReadMaskPatterns       = errRun.Code("mask_patterns_read_error").ErrorPref("could not read the mask patterns file %s: %s")
+	ErrInvalidMaskFormat      = errRun.Code("invalid_mask_format").ErrorPref("invalid mask format: %s")
+	ErrTTYNotSupported        = errRun.Code("tty_not_supported").Error("running the command in a terminal with --tty is only supported on Linux and macOS")
+	ErrTTYFailed              = errRun.Code("tty_failed").ErrorPref("could not run the command in a terminal: %s")
+	ErrSecretsLeaked          = errRun.Code("secrets_leaked").ErrorPref("secrets were written to the output of the command: %s")
+	ErrFailOnLeakNoMasking    = errRun.Code("fail_on_leak_no_masking").Error("--fail-on-leak cannot be used together with --no-masking")
 )
 
 const (
@@ -45,6 +60,10 @@ const (
 	secretReferencePrefix = "secrethub://"
 )
 
+// defaultMaskEncodings are the encodings of secrets that are masked by default,
+// in addition to the secrets themselves.
+var defaultMaskEncodings = []string{"base64", "url", "json"}
+
 // RunCommand runs a program and passes environment variables to it that are
 // defined with --envar or --env-file flags and secrets.yml files.
 // The yml files write to .secretsenv/<env-name> when running the set command.
@@ -55,24 +74,44 @@ type RunCommand struct {
 	environment          *environment
 	noMasking            bool
 	maskerOptions        masker.Options
+	maskEncodings        []string
+	maskPatterns         bool
+	maskPatternsFiles    []string
+	maskFormat           string
+	failOnLeak           bool
+	tty                  bool
 	newClient            newClientFunc
 	ignoreMissingSecrets bool
+	watch                bool
+	watchInterval        time.Duration
+	reloadSignal         signalValue
+	reloadGracePeriod    time.Duration
+	killTimeout          time.Duration
+	signalProcessGroup   bool
+	secretsAsFiles       string
+	cacheOptions         secretCacheOptions
 }
 
 // NewRunCommand creates a new RunCommand.
-func NewRunCommand(io ui.IO, newClient newClientFunc) *RunCommand {
+func NewRunCommand(io ui.IO, newClient newClientFunc, credentialStore CredentialConfig) *RunCommand {
 	return &RunCommand{
-		io:          io,
-		osEnv:       os.Environ(),
-		environment: newEnvironment(io, newClient),
-		newClient:   newClient,
+		io:           io,
+		osEnv:        os.Environ(),
+		environment:  newEnvironment(io, newClient),
+		newClient:    newClient,
+		reloadSignal: newSignalValue(syscall.SIGTERM),
+		cacheOptions: secretCacheOptions{
+			credentialStore: credentialStore,
+		},
 	}
 }
 
 // Register registers the command, arguments and flags on the provided Registerer.
 func (cmd *RunCommand) Register(r cli.Registerer) {
 	const helpShort = "Pass secrets as environment variables to a process."
-	const helpLong = "To protect against secrets leaking via stdout and stderr, those output streams are monitored for secrets. Detected secrets are automatically masked by replacing them with \"" + maskString + "\". " +
+	const helpLong = "To protect against secrets leaking via stdout and stderr, those output streams are monitored for secrets. Detected secrets are automatically masked by replacing them with \"" + maskString + "\", which can be changed using the mask-format flag. " +
+		"Encoded forms of secrets are masked as well, which can be configured using the mask-encodings flag. " +
+		"When the command exits, the names of the variables of which the secrets have been masked are written to stderr, together with the number of times they were masked. " +
 		"The output is buffered to scan for secrets and can be adjusted using the masking-buffer-period flag. " +
 		"You should regard the masking as a best effort attempt and should always prevent secrets ending up on stdout and stderr in the first place."
 
@@ -82,7 +121,24 @@ func (cmd *RunCommand) Register(r cli.Registerer) {
 	clause.Flags().BoolVar(&cmd.noMasking, "no-masking", false, "Disable masking of secrets on stdout and stderr")
 	clause.Flags().BoolVar(&cmd.maskerOptions.DisableBuffer, "no-output-buffering", false, "Disable output buffering. This increases output responsiveness, but decreases the probability that secrets get masked.")
 	clause.Flags().DurationVar(&cmd.maskerOptions.BufferDelay, "masking-buffer-period", time.Millisecond*50, "The time period for which output is buffered. A higher value increases the probability that secrets get masked but decreases output responsiveness.")
+	clause.Flags().StringSliceVar(&cmd.maskEncodings, "mask-encodings", defaultMaskEncodings, "The encodings in which secrets are masked in addition to their literal value, as a comma-separated list. Supported encodings are "+strings.Join(masker.EncoderNames(), ", ")+". Set to an empty value to only mask literal secrets.")
+	_ = clause.Cmd.RegisterFlagCompletionFunc("mask-encodings", func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+		return masker.EncoderNames(), cobra.ShellCompDirectiveNoFileComp
+	})
+	clause.Flags().BoolVar(&cmd.maskPatterns, "mask-patterns", false, "Also mask credentials of well-known formats that are not sourced by SecretHub, such as AWS access key IDs, private keys, JWTs and GitHub and Slack tokens.")
+	clause.Flags().StringArrayVar(&cmd.maskPatternsFiles, "mask-patterns-file", nil, "The path to a file with additional patterns to mask, one per line, of the form `<name>: <regular expression>`. Empty lines and lines starting with # are ignored. Can be repeated.")
+	clause.Flags().StringVar(&cmd.maskFormat, "mask-format", maskString, "The text with which masked secrets are replaced. This is a Go template in which {{.Name}} is replaced with the name of the environment variable or the path of the secret that is masked, e.g. '<redacted:{{.Name}}>'.")
+	clause.Flags().BoolVar(&cmd.failOnLeak, "fail-on-leak", false, "Exit with a non-zero exit code when a secret has been masked in the output of the command, e.g. to catch leaking secrets in CI.")
+	clause.Flags().BoolVar(&cmd.tty, "tty", false, "Run the command in a pseudo-terminal, so that interactive programs keep their colors, line editing and progress bars while their output is masked. The output of the command is written to stdout. Only supported on Linux and macOS.")
 	clause.Flags().BoolVar(&cmd.ignoreMissingSecrets, "ignore-missing-secrets", false, "Do not return an error when a secret does not exist and use an empty value instead.")
+	clause.Flags().BoolVar(&cmd.watch, "watch", false, "Restart the command when a new version of one of the sourced secrets is written or when one of the env files changes.")
+	clause.Flags().DurationVar(&cmd.watchInterval, "watch-interval", time.Second*30, "The interval at which the sourced secrets and the env files are checked for changes when --watch is set.")
+	clause.Flags().Var(&cmd.reloadSignal, "reload-signal", "The signal that is sent to the command to stop it when it is restarted by --watch.")
+	clause.Flags().DurationVar(&cmd.reloadGracePeriod, "reload-grace-period", time.Second*10, "The time the command is given to exit after the reload signal is sent, before it is killed.")
+	clause.Flags().DurationVar(&cmd.killTimeout, "kill-timeout", 0, "Kill the command when it has not exited within the given duration after a SIGTERM is passed on to it. When set to 0, the command is never killed.")
+	clause.Flags().BoolVar(&cmd.signalProcessGroup, "signal-process-group", false, "Run the command in its own process group and pass signals on to all processes in that group instead of only to the command itself.")
+	clause.Flags().StringVar(&cmd.secretsAsFiles, "secrets-as-files", "", "Instead of passing environment variables that contain secrets to the command directly, write their values to read-only files in a private directory created in the given directory, which must be on a tmpfs file system on Linux. For every such variable `NAME`, the variable `NAME_FILE` is set to the path of the file, which fails when `NAME_FILE` is set already. The files are removed when the command exits.")
+	cmd.cacheOptions.register(clause)
 	cmd.environment.register(clause)
 	clause.BindAction(cmd.Run)
 	clause.BindArgumentsArr(cli.Argument{Value: &cmd.command, Name: "command", Required: true, Description: "The command to execute"})
@@ -91,7 +147,36 @@ func (cmd *RunCommand) Register(r cli.Registerer) {
 // Run reads files from the .secretsenv/<env-name> directory, sets them as environment variables and runs the given command.
 // Note that the environment variables are only passed to the child process and not exported globally, which is nice.
 func (cmd *RunCommand) Run() error {
-	environment, secrets, err := cmd.sourceEnvironment()
+	if cmd.failOnLeak && cmd.noMasking {
+		return ErrFailOnLeakNoMasking
+	}
+	err := cmd.cacheOptions.validate()
+	if err != nil {
+		return err
+	}
+
+	encoders, err := maskEncoders(cmd.maskEncodings)
+	if err != nil {
+		return err
+	}
+	cmd.maskerOptions.Encoders = encoders
+
+	patterns, err := cmd.loadMaskPatterns()
+	if err != nil {
+		return err
+	}
+	cmd.maskerOptions.Patterns = patterns
+
+	if cmd.maskFormat != "" && cmd.maskFormat != maskString {
+		format, err := masker.ParseFormat(cmd.maskFormat)
+		if err != nil {
+			return ErrInvalidMaskFormat(err)
+		}
+		cmd.maskerOptions.Format = format
+	}
+	cmd.maskerOptions.Report = os.Stderr
+
+	environment, err := cmd.sourceEnvironment()
 	if err != nil {
 		return err
 	}
@@ -101,107 +186,524 @@ func (cmd *RunCommand) Run() error {
 		cmd.command = strings.Split(cmd.command[0], " ")
 	}
 
-	sequences := make([][]byte, 0, len(secrets))
-	for _, val := range secrets {
-		if val != "" {
-			sequences = append(sequences, []byte(val))
+	proc, err := cmd.start(environment)
+	if err != nil {
+		return err
+	}
+
+	if cmd.watch {
+		proc, err = cmd.watchSources(proc, environment.watcher)
+		if err != nil {
+			return err
+		}
+	}
+
+	commandErr := proc.wait()
+	leakErr := cmd.checkLeaks(proc)
+	if commandErr != nil {
+		// Check if the program exited with an error
+		status, ok := waitStatus(commandErr)
+		if ok {
+			// Report the leaked secrets before exiting, as the exit code of the process takes precedence.
+			if leakErr != nil {
+				fmt.Fprintf(os.Stderr, "Encountered an error: %s\n", leakErr)
+			}
+			// Return the status code returned by the process
+			os.Exit(exitCode(status))
+			return nil
+		}
+		return commandErr
+	}
+
+	return leakErr
+}
+
+// checkLeaks returns an error listing the secrets that have been masked in the output
+// of the command, if --fail-on-leak is set.
+func (cmd *RunCommand) checkLeaks(proc *process) error {
+	if !cmd.failOnLeak || proc.masker == nil {
+		return nil
+	}
+	leaks := proc.masker.Leaks()
+	if len(leaks) == 0 {
+		return nil
+	}
+	names := make([]string, len(leaks))
+	for i, leak := range leaks {
+		names[i] = leak.Name
+	}
+	return ErrSecretsLeaked(strings.Join(names, ", "))
+}
+
+// maskEncoders returns the masker encoders for the encodings with the given names.
+func maskEncoders(names []string) ([]masker.Encoder, error) {
+	encoders := make([]masker.Encoder, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		encoder, ok := masker.Encoders[strings.ToLower(name)]
+		if !ok {
+			return nil, ErrUnknownMaskEncoding(name, strings.Join(masker.EncoderNames(), ", "))
+		}
+		encoders = append(encoders, encoder)
+	}
+	return encoders, nil
+}
+
+// loadMaskPatterns returns the built-in patterns when --mask-patterns is set,
+// together with the patterns read from the files given with --mask-patterns-file.
+func (cmd *RunCommand) loadMaskPatterns() ([]masker.Pattern, error) {
+	var patterns []masker.Pattern
+	if cmd.maskPatterns {
+		patterns = append(patterns, masker.DefaultPatterns...)
+	}
+
+	for _, path := range cmd.maskPatternsFiles {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, ErrReadMaskPatterns(path, err)
+		}
+
+		filePatterns, err := masker.ParsePatterns(file)
+		_ = file.Close()
+		if err != nil {
+			return nil, ErrReadMaskPatterns(path, err)
+		}
+		patterns = append(patterns, filePatterns...)
+	}
+	return patterns, nil
+}
+
+// start starts the command with the given environment and masks the secrets in the environment in its output.
+// The secret files of the environment are removed when the process exits.
+func (cmd *RunCommand) start(environment *sourcedEnvironment) (*process, error) {
+	sequences := make([]masker.Sequence, 0, len(environment.secrets))
+	for _, secret := range environment.secrets {
+		if len(secret.Value) > 0 {
+			sequences = append(sequences, secret)
 		}
 	}
-	m := masker.New(sequences, &cmd.maskerOptions)
 
 	command := exec.Command(cmd.command[0], cmd.command[1:]...)
-	command.Env = environment
+	command.Env = environment.vars
 	command.Stdin = os.Stdin
+	// A command that runs in a terminal is the leader of a new session and process group already.
+	restoreTerminal := func() {}
+	if cmd.signalProcessGroup && !cmd.tty {
+		restoreTerminal = setProcessGroup(command)
+	}
+
+	var t *tty
+	if cmd.tty {
+		var err error
+		t, err = newTTY(os.Stdin)
+		if err != nil {
+			environment.cleanup()
+			return nil, err
+		}
+	}
+
+	var m *masker.Masker
 	if cmd.noMasking {
 		command.Stdout = cmd.io.Stdout()
 		command.Stderr = os.Stderr
 	} else {
+		m = masker.New(sequences, &cmd.maskerOptions)
 		command.Stdout = m.AddStream(cmd.io.Stdout())
 		command.Stderr = m.AddStream(os.Stderr)
 
 		go m.Start()
 	}
 
-	err = command.Start()
+	// In a terminal, the output of the command is read from the terminal and then written to the (masked) output.
+	var output io.Writer
+	if t != nil {
+		output = command.Stdout
+		t.attach(command)
+	}
+
+	// Signals are caught before the command is started, so that no signal is missed.
+	signals := make(chan os.Signal, 16)
+	signal.Notify(signals)
+
+	err := command.Start()
 	if err != nil {
-		return ErrStartFailed(err)
+		restoreTerminal()
+		signal.Stop(signals)
+		if t != nil {
+			t.close()
+		}
+		if m != nil {
+			_ = m.Stop()
+		}
+		environment.cleanup()
+		return nil, ErrStartFailed(err)
 	}
 
-	done := make(chan bool, 1)
+	if t != nil {
+		t.start(output)
+	}
 
-	// Pass all signals to child process
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals)
+	proc := &process{
+		command:     command,
+		masker:      m,
+		signalGroup: cmd.signalProcessGroup,
+		exited:      make(chan struct{}),
+		reaped:      make(chan syscall.WaitStatus, 1),
+	}
+
+	// When running as PID 1, orphaned processes have to be reaped by the current process.
+	reap := os.Getpid() == 1
 
+	go proc.forwardSignals(signals, cmd.killTimeout, reap)
 	go func() {
+		err := command.Wait()
+		if reap && errors.Is(err, syscall.ECHILD) {
+			// The command has been reaped together with the orphaned processes, before exec.Cmd could wait for it.
+			err = reapedError(<-proc.reaped)
+		}
+		proc.err = err
+		restoreTerminal()
+		if t != nil {
+			t.wait()
+		}
+		environment.cleanup()
+		close(proc.exited)
+	}()
+
+	return proc, nil
+}
+
+// process is a started instance of the command of which the output is optionally masked.
+type process struct {
+	command *exec.Cmd
+	masker  *masker.Masker
+	// signalGroup indicates that signals are sent to the process group the process leads.
+	signalGroup bool
+	// exited is closed when the process has exited. After that, err contains the result of waiting for the process.
+	exited chan struct{}
+	err    error
+	// reaped receives the status of the process when it is reaped while reaping zombies.
+	reaped chan syscall.WaitStatus
+}
+
+// forwardSignals passes the signals received on the given channel to the child process until it exits.
+// When killTimeout is larger than 0, the process is killed if it has not exited within killTimeout after
+// a SIGTERM is passed on. When reap is true, exited child processes other than the process are waited for,
+// so that orphaned processes do not remain as zombies.
+func (p *process) forwardSignals(signals chan os.Signal, killTimeout time.Duration, reap bool) {
+	defer signal.Stop(signals)
+
+	var kill <-chan time.Time
+	for {
 		select {
 		case s := <-signals:
-			err := command.Process.Signal(s)
-			if err != nil && !strings.Contains(err.Error(), "process already finished") {
+			if isChildExit(s) && reap {
+				reapZombies(p.command.Process.Pid, p.reaped)
+			}
+			if !isForwardable(s) {
+				continue
+			}
+
+			err := p.signal(s)
+			if err != nil && !isProcessDone(err) {
+				fmt.Fprintln(os.Stderr, ErrSignalFailed(err))
+			}
+
+			if s == syscall.SIGTERM && killTimeout > 0 && kill == nil {
+				timer := time.NewTimer(killTimeout)
+				defer timer.Stop()
+				kill = timer.C
+			}
+		case <-kill:
+			err := p.signal(os.Kill)
+			if err != nil && !isProcessDone(err) {
 				fmt.Fprintln(os.Stderr, ErrSignalFailed(err))
 			}
-		case <-done:
-			signal.Stop(signals)
+		case <-p.exited:
 			return
 		}
-	}()
+	}
+}
+
+// signal sends the signal to the process or, if signalGroup is set, to its process group.
+func (p *process) signal(s os.Signal) error {
+	return signalProcess(p.command.Process, s, p.signalGroup)
+}
 
-	commandErr := command.Wait()
-	done <- true
+// wait waits for the process to exit and flushes the masked output.
+// The error returned by the command is returned if the output is flushed successfully.
+func (p *process) wait() error {
+	<-p.exited
 
-	if !cmd.noMasking {
-		err := m.Stop()
+	if p.masker != nil {
+		err := p.masker.Stop()
 		if err != nil {
 			return err
 		}
 	}
 
-	if commandErr != nil {
-		// Check if the program exited with an error
-		exitErr, ok := commandErr.(*exec.ExitError)
-		if ok {
-			waitStatus, ok := exitErr.Sys().(syscall.WaitStatus)
-			if ok {
-				// Return the status code returned by the process
-				os.Exit(waitStatus.ExitStatus())
-				return nil
-			}
+	return p.err
+}
 
+// stop sends the given signal to the process and waits for it to exit.
+// If the process has not exited after the grace period, it is killed.
+func (p *process) stop(s os.Signal, gracePeriod time.Duration) error {
+	err := p.signal(s)
+	if err != nil && !isProcessDone(err) {
+		return ErrSignalFailed(err)
+	}
+
+	timer := time.NewTimer(gracePeriod)
+	defer timer.Stop()
+
+	select {
+	case <-p.exited:
+	case <-timer.C:
+		err = p.signal(os.Kill)
+		if err != nil && !isProcessDone(err) {
+			return ErrSignalFailed(err)
 		}
-		return commandErr
 	}
 
-	return nil
+	err = p.wait()
+	if _, ok := waitStatus(err); ok {
+		// The process is expected to exit because of the signal.
+		return nil
+	}
+	return err
+}
+
+// exitStatusError is returned when the command exited unsuccessfully and has been reaped
+// while reaping zombies, so that exec.Cmd could not wait for it.
+type exitStatusError syscall.WaitStatus
+
+func (err exitStatusError) Error() string {
+	status := syscall.WaitStatus(err)
+	if status.Signaled() {
+		return "signal: " + status.Signal().String()
+	}
+	return "exit status " + strconv.Itoa(status.ExitStatus())
+}
+
+// reapedError returns the error for a command that has been reaped with the given status
+// while reaping zombies, which is nil when the command exited successfully.
+func reapedError(status syscall.WaitStatus) error {
+	if status.Exited() && status.ExitStatus() == 0 {
+		return nil
+	}
+	return exitStatusError(status)
+}
+
+// waitStatus returns the status of the command from the error returned when waiting for it,
+// if the error is returned because the command exited unsuccessfully.
+func waitStatus(err error) (syscall.WaitStatus, bool) {
+	switch err := err.(type) {
+	case *exec.ExitError:
+		status, ok := err.Sys().(syscall.WaitStatus)
+		return status, ok
+	case exitStatusError:
+		return syscall.WaitStatus(err), true
+	}
+	var status syscall.WaitStatus
+	return status, false
+}
+
+// exitCode returns the exit code of a process with the given status.
+// Following the shell convention, the exit code of a process that is terminated by a signal is 128 + the signal number.
+func exitCode(status syscall.WaitStatus) int {
+	if status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return status.ExitStatus()
+}
+
+// isProcessDone returns whether the given error is returned because a signal is sent to
+// a process that has already finished.
+func isProcessDone(err error) bool {
+	return strings.Contains(err.Error(), "process already finished")
+}
+
+// sourcedEnvironment is the environment of the subcommand, with all the secrets sourced.
+type sourcedEnvironment struct {
+	// vars contains the environment variables as key=value pairs.
+	vars []string
+	// secrets contains the secret values that need to be masked, named after the variables they are used in.
+	secrets []masker.Sequence
+	// secretFiles contains the files the secrets are written to when secrets are passed as files.
+	secretFiles *secretFiles
+	// watcher detects changes in the sources of the environment. It is only set when --watch is set.
+	watcher *sourceWatcher
+}
+
+// cleanup removes the secret files of the environment, if any.
+func (e *sourcedEnvironment) cleanup() {
+	if e.secretFiles == nil {
+		return
+	}
+	err := e.secretFiles.remove()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
 }
 
-// sourceEnvironment returns the environment of the subcommand, with all the secrets sourced
-// and the secret values that need to be masked.
-func (cmd *RunCommand) sourceEnvironment() ([]string, []string, error) {
+// sourceEnvironment returns the environment of the subcommand, with all the secrets sourced.
+func (cmd *RunCommand) sourceEnvironment() (*sourcedEnvironment, error) {
 	_, passthroughEnv := parseKeyValueStringsToMap(cmd.osEnv)
 	newEnv := map[string]string{}
 
 	envValues, err := cmd.environment.env()
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	var sr tpl.SecretReader = newSecretReader(cmd.newClient)
+	var cachedReader *cachedSecretReader
+	if cmd.cacheOptions.enabled() {
+		cachedReader, err = cmd.cacheOptions.wrap(sr)
+		if err != nil {
+			return nil, err
+		}
+		sr = cachedReader
+	}
 	if cmd.ignoreMissingSecrets {
 		sr = newIgnoreMissingSecretReader(sr)
 	}
-	secretReader := newBufferedSecretReader(sr)
+	prefetchReader := newPrefetchSecretReader(sr, secretReadConcurrency)
+	secretReader := newBufferedSecretReader(prefetchReader)
+
+	// Read all secrets up front, so that they can be read concurrently and
+	// a secret that is used multiple times is only read once.
+	// Every secret is named after the first variable that uses it, so that
+	// it can be referred to without revealing its value.
+	names := make([]string, 0, len(envValues))
+	for name := range envValues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
+	var paths []string
+	secretNames := make(map[string]string)
+	for _, name := range names {
+		valuePaths, err := envValues[name].secretPaths()
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range valuePaths {
+			if _, ok := secretNames[path]; !ok {
+				secretNames[path] = name
+			}
+		}
+		paths = append(paths, valuePaths...)
+	}
+
+	// The versions of the secrets are recorded before the secrets are read,
+	// so that a version written while they are read is detected as a change.
+	var watcher *sourceWatcher
+	if cmd.watch {
+		watcher, err = cmd.newSourceWatcher(paths)
+		if err != nil {
+			return nil, err
+		}
+	}
+	prefetchReader.Prefetch(paths)
+
+	resolved := make(map[string]string, len(envValues))
 	for name, value := range envValues {
-		newEnv[name], err = value.resolve(secretReader)
+		resolved[name], err = value.resolve(secretReader)
 		if err != nil {
-			return nil, nil, err
+			return nil, err
+		}
+		newEnv[name] = resolved[name]
+	}
+
+	if cachedReader != nil {
+		cachedReader.warnStale(os.Stderr)
+	}
+
+	var files *secretFiles
+	if cmd.secretsAsFiles != "" {
+		// The variables with the paths of the files should not replace variables that are set already.
+		for name, value := range envValues {
+			if !value.containsSecret() {
+				continue
+			}
+			if _, ok := envValues[name+secretFileEnvSuffix]; ok {
+				return nil, ErrSecretFileEnvExists(name, name+secretFileEnvSuffix)
+			}
+		}
+
+		files, err = newSecretFiles(cmd.secretsAsFiles)
+		if err != nil {
+			return nil, err
+		}
+
+		for name, value := range envValues {
+			if !value.containsSecret() {
+				continue
+			}
+
+			path, err := files.write(name, newEnv[name])
+			if err != nil {
+				_ = files.remove()
+				return nil, err
+			}
+			delete(newEnv, name)
+			newEnv[name+secretFileEnvSuffix] = path
 		}
 	}
 
 	// Finally add the unparsed variables
 	processedOsEnv := append(passthroughEnv, mapToKeyValueStrings(newEnv)...)
 
-	return processedOsEnv, secretReader.Values(), nil
+	var secrets []masker.Sequence
+	read := make(map[string]struct{})
+	values := secretReader.Values()
+	for i, path := range secretReader.Paths() {
+		if _, ok := read[path]; ok {
+			continue
+		}
+		read[path] = struct{}{}
+
+		name, ok := secretNames[path]
+		if !ok {
+			name = path
+		}
+		secrets = append(secrets, masker.Sequence{
+			Name:  name,
+			Value: []byte(values[i]),
+		})
+	}
+
+	// Filters can derive values from secrets that differ from the secrets themselves, e.g. a field extracted
+	// from a JSON secret, so the resolved values of variables that contain secrets are masked as well.
+	seen := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		seen[value] = struct{}{}
+	}
+	for _, name := range names {
+		if !envValues[name].containsSecret() {
+			continue
+		}
+		value := resolved[name]
+		if _, ok := seen[value]; ok {
+			continue
+		}
+		seen[value] = struct{}{}
+		secrets = append(secrets, masker.Sequence{
+			Name:  name,
+			Value: []byte(value),
+		})
+	}
+
+	return &sourcedEnvironment{
+		vars:        processedOsEnv,
+		secrets:     secrets,
+		secretFiles: files,
+		watcher:     watcher,
+	}, nil
 }
 
 // mapToKeyValueStrings converts a map to a slice of key=value pairs.
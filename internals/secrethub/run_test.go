@@ -10,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/secrethub/secrethub-cli/internals/cli"
+	"github.com/secrethub/secrethub-cli/internals/cli/masker"
 	"github.com/secrethub/secrethub-cli/internals/cli/ui/fakeui"
 	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl"
 	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl/fakes"
@@ -296,10 +297,194 @@ func TestParseDotEnv(t *testing.T) {
 				},
 			},
 		},
+		"export prefix": {
+			raw: "export foo=bar",
+			expected: []envvar{
+				{
+					key:               "foo",
+					value:             "bar",
+					lineNumber:        1,
+					columnNumberKey:   8,
+					columnNumberValue: 12,
+				},
+			},
+		},
+		"key named export": {
+			raw: "export=bar",
+			expected: []envvar{
+				{
+					key:               "export",
+					value:             "bar",
+					lineNumber:        1,
+					columnNumberKey:   1,
+					columnNumberValue: 8,
+				},
+			},
+		},
+		"inline comment": {
+			raw: "foo=bar # comment",
+			expected: []envvar{
+				{
+					key:               "foo",
+					value:             "bar",
+					lineNumber:        1,
+					columnNumberKey:   1,
+					columnNumberValue: 5,
+				},
+			},
+		},
+		"hash in unquoted value": {
+			raw: "foo=http://example.com/#anchor",
+			expected: []envvar{
+				{
+					key:               "foo",
+					value:             "http://example.com/#anchor",
+					lineNumber:        1,
+					columnNumberKey:   1,
+					columnNumberValue: 5,
+				},
+			},
+		},
+		"inline comment after quoted value": {
+			raw: `foo="bar # baz" # comment`,
+			expected: []envvar{
+				{
+					key:               "foo",
+					value:             "bar # baz",
+					lineNumber:        1,
+					columnNumberKey:   1,
+					columnNumberValue: 6,
+				},
+			},
+		},
+		"maintain quotes inside unquoted value": {
+			raw: `foo={"foo":"bar"}`,
+			expected: []envvar{
+				{
+					key:               "foo",
+					value:             `{"foo":"bar"}`,
+					lineNumber:        1,
+					columnNumberKey:   1,
+					columnNumberValue: 5,
+				},
+			},
+		},
+		"empty quoted values": {
+			raw: "foo=''\nbar=\"\"",
+			expected: []envvar{
+				{
+					key:               "foo",
+					value:             "",
+					lineNumber:        1,
+					columnNumberKey:   1,
+					columnNumberValue: 6,
+				},
+				{
+					key:               "bar",
+					value:             "",
+					lineNumber:        2,
+					columnNumberKey:   1,
+					columnNumberValue: 6,
+				},
+			},
+		},
+		"quotes wrapped in other quotes": {
+			raw: `foo="'bar'"` + "\n" + `bar='"baz"'`,
+			expected: []envvar{
+				{
+					key:               "foo",
+					value:             "'bar'",
+					lineNumber:        1,
+					columnNumberKey:   1,
+					columnNumberValue: 6,
+				},
+				{
+					key:               "bar",
+					value:             `"baz"`,
+					lineNumber:        2,
+					columnNumberKey:   1,
+					columnNumberValue: 6,
+				},
+			},
+		},
+		"escapes in double quotes": {
+			raw: `foo="a\nb\t\"c\" \\ \$HOME \d"`,
+			expected: []envvar{
+				{
+					key:               "foo",
+					value:             "a\nb\t\"c\" \\\\ \\$HOME \\d",
+					lineNumber:        1,
+					columnNumberKey:   1,
+					columnNumberValue: 6,
+				},
+			},
+		},
+		"no escapes in single quotes": {
+			raw: `foo='a\nb'`,
+			expected: []envvar{
+				{
+					key:               "foo",
+					value:             `a\nb`,
+					lineNumber:        1,
+					columnNumberKey:   1,
+					columnNumberValue: 6,
+				},
+			},
+		},
+		"multi-line quoted value": {
+			raw: "key=\"-----BEGIN KEY-----\nabc\n-----END KEY-----\"\nfoo=bar",
+			expected: []envvar{
+				{
+					key:               "key",
+					value:             "-----BEGIN KEY-----\nabc\n-----END KEY-----",
+					lineNumber:        1,
+					columnNumberKey:   1,
+					columnNumberValue: 6,
+				},
+				{
+					key:               "foo",
+					value:             "bar",
+					lineNumber:        4,
+					columnNumberKey:   1,
+					columnNumberValue: 5,
+				},
+			},
+		},
+		"windows line endings": {
+			raw: "foo=bar\r\nbar='baz'\r\n",
+			expected: []envvar{
+				{
+					key:               "foo",
+					value:             "bar",
+					lineNumber:        1,
+					columnNumberKey:   1,
+					columnNumberValue: 5,
+				},
+				{
+					key:               "bar",
+					value:             "baz",
+					lineNumber:        2,
+					columnNumberKey:   1,
+					columnNumberValue: 6,
+				},
+			},
+		},
 		"invalid": {
 			raw: "foobar",
 			err: ErrTemplate(1, errors.New("template is not formatted as key=value pairs")),
 		},
+		"quote not closed": {
+			raw: "foo=bar\nkey=\"value\nbar=baz",
+			err: ErrTemplate(2, errors.New("quoted value is not closed")),
+		},
+		"characters after quoted value": {
+			raw: `foo="bar" baz`,
+			err: ErrTemplate(1, errors.New("unexpected characters after the closing quote, only a comment is allowed")),
+		},
+		"duplicate key": {
+			raw: "foo=bar\n\nfoo=baz",
+			err: ErrDuplicateEnvVar("foo", 1, 3),
+		},
 	}
 
 	for name, tc := range cases {
@@ -312,6 +497,17 @@ func TestParseDotEnv(t *testing.T) {
 	}
 }
 
+func TestParseDotEnv_PreservesOrder(t *testing.T) {
+	actual, err := parseDotEnv(strings.NewReader("c=1\na=2\nexport b=3\nd='4'"))
+	assert.OK(t, err)
+
+	keys := make([]string, len(actual))
+	for i, envvar := range actual {
+		keys[i] = envvar.key
+	}
+	assert.Equal(t, keys, []string{"c", "a", "b", "d"})
+}
+
 func TestParseYML(t *testing.T) {
 	cases := map[string]struct {
 		raw      string
@@ -397,6 +593,32 @@ func TestNewEnv(t *testing.T) {
 				"baz": "secret",
 			},
 		},
+		"escaped variable in double quotes": {
+			raw: `foo="\${var}"` + "\n" + `bar="\\"`,
+			templateVarReader: fakes.FakeVariableReader{
+				Variables: map[string]string{
+					"var": "value",
+				},
+			},
+			expected: map[string]string{
+				"foo": "${var}",
+				"bar": "\\",
+			},
+		},
+		"success with filters": {
+			raw: "DB_PASSWORD={{ app/db | json \".password\" }}\nDB_PORT={{ app/db | json \".port\" | default \"5432\" }}",
+			replacements: map[string]string{
+				"app/db": `{"password": "secret"}`,
+			},
+			expected: map[string]string{
+				"DB_PASSWORD": "secret",
+				"DB_PORT":     "5432",
+			},
+		},
+		"unknown filter": {
+			raw: "foo=bar\nDB_PASSWORD = {{ app/db | upper }}",
+			err: tpl.ErrUnknownFilter(2, 27, "upper"),
+		},
 		"success with var in key": {
 			raw: "${var}=value",
 			templateVarReader: fakes.FakeVariableReader{
@@ -420,7 +642,13 @@ func TestNewEnv(t *testing.T) {
 		},
 		"secret not allowed in key": {
 			raw: "{{ path/to/secret }}key=value",
-			err: ErrSecretsNotAllowedInKey,
+			err: templateSourceError{
+				file:    "secrethub.env",
+				lineNo:  1,
+				colNo:   1,
+				message: "secrets are not allowed in run template keys (run.secret_in_key)",
+				source:  "{{ path/to/secret }}key=value",
+			},
 		},
 		"yml template error": {
 			raw: "foo: bar: baz",
@@ -442,7 +670,7 @@ func TestNewEnv(t *testing.T) {
 
 	for name, tc := range cases {
 		t.Run(name, func(t *testing.T) {
-			parser, err := getTemplateParser([]byte(tc.raw), "auto")
+			parser, err := getTemplateParser([]byte(tc.raw), "auto", "secrethub.env", os.ReadFile)
 			assert.OK(t, err)
 
 			env, err := NewEnv("secrethub.env", strings.NewReader(tc.raw), tc.templateVarReader, parser)
@@ -467,6 +695,9 @@ func TestNewEnv(t *testing.T) {
 }
 
 func TestRunCommand_Run(t *testing.T) {
+	_, invalidMaskFormatErr := masker.ParseFormat("<redacted:{{.Value}}>")
+	assert.Equal(t, invalidMaskFormatErr != nil, true)
+
 	osStatNotExist := func(_ string) (info os.FileInfo, err error) {
 		return nil, os.ErrNotExist
 	}
@@ -561,8 +792,8 @@ func TestRunCommand_Run(t *testing.T) {
 		"invalid template var: start with a number": {
 			command: RunCommand{
 				environment: &environment{
-					osStat:  osStatNotExist,
-					envFile: "secrethub.env",
+					osStat:   osStatNotExist,
+					envFiles: []string{"secrethub.env"},
 					templateVars: map[string]string{
 						"0foo": "value",
 					},
@@ -574,8 +805,8 @@ func TestRunCommand_Run(t *testing.T) {
 		"invalid template var: illegal character": {
 			command: RunCommand{
 				environment: &environment{
-					osStat:  osStatNotExist,
-					envFile: "secrethub.env",
+					osStat:   osStatNotExist,
+					envFiles: []string{"secrethub.env"},
 					templateVars: map[string]string{
 						"foo@bar": "value",
 					},
@@ -628,6 +859,46 @@ func TestRunCommand_Run(t *testing.T) {
 				},
 			},
 		},
+		"fail on leak": {
+			command: RunCommand{
+				command: cli.StringListValue{"echo", "the secret is bbb"},
+				environment: &environment{
+					envar: map[string]string{
+						"SECRET": "path/to/secret",
+					},
+					osStat: osStatNotExist,
+				},
+				io: fakeui.NewIO(t),
+				newClient: func() (secrethub.ClientInterface, error) {
+					return fakeclient.Client{
+						SecretService: &fakeclient.SecretService{
+							VersionService: &fakeclient.SecretVersionService{
+								GetWithDataFunc: func(path string) (*api.SecretVersion, error) {
+									return &api.SecretVersion{Data: []byte("bbb")}, nil
+								},
+							},
+						},
+					}, nil
+				},
+				failOnLeak: true,
+			},
+			err: ErrSecretsLeaked("SECRET"),
+		},
+		"invalid mask format": {
+			command: RunCommand{
+				command:    cli.StringListValue{"echo", "test"},
+				maskFormat: "<redacted:{{.Value}}>",
+			},
+			err: ErrInvalidMaskFormat(invalidMaskFormatErr),
+		},
+		"fail on leak without masking": {
+			command: RunCommand{
+				command:    cli.StringListValue{"echo", "test"},
+				noMasking:  true,
+				failOnLeak: true,
+			},
+			err: ErrFailOnLeakNoMasking,
+		},
 	}
 
 	for name, tc := range cases {
@@ -674,11 +945,17 @@ func TestRunCommand_environment(t *testing.T) {
 				environment: &environment{
 					osStat:          osStatFunc("secrethub.env", nil),
 					readFile:        readFileFunc("secrethub.env", "TEST={{path/to/secret}"),
-					envFile:         "secrethub.env",
+					envFiles:        []string{"secrethub.env"},
 					templateVersion: "2",
 				},
 			},
-			err: ErrParsingTemplate("secrethub.env", "template syntax error at 1:23: expected the closing of a secret tag `}}`, but reached the end of the template. (template.secret_tag_not_closed) "),
+			err: templateSourceError{
+				file:    "secrethub.env",
+				lineNo:  1,
+				colNo:   23,
+				message: "expected the closing of a secret tag `}}`, but reached the end of the template.",
+				source:  "TEST={{path/to/secret}",
+			},
 		},
 		"default env file does not exist": {
 			command: RunCommand{
@@ -698,7 +975,7 @@ func TestRunCommand_environment(t *testing.T) {
 		"custom env file does not exist": {
 			command: RunCommand{
 				environment: &environment{
-					envFile: "foo.env",
+					envFiles: []string{"foo.env"},
 					readFile: func(filename string) ([]byte, error) {
 						if filename == "foo.env" {
 							return nil, &os.PathError{Op: "open", Path: "foo.env", Err: os.ErrNotExist}
@@ -713,20 +990,159 @@ func TestRunCommand_environment(t *testing.T) {
 			command: RunCommand{
 				environment: &environment{
 					osStat:          osStatFunc("foo.env", nil),
-					envFile:         "foo.env",
+					envFiles:        []string{"foo.env"},
 					templateVersion: "2",
 					readFile:        readFileFunc("foo.env", "TEST=test"),
 				},
 			},
 			expectedEnv: []string{"TEST=test"},
 		},
+		"later env file overrides earlier env file": {
+			command: RunCommand{
+				environment: &environment{
+					envFiles:        []string{"secrethub.env", "secrethub.prod.env"},
+					templateVersion: "2",
+					readFile: func(filename string) ([]byte, error) {
+						switch filename {
+						case "secrethub.env":
+							return []byte("TEST=base\nBASE=base"), nil
+						case "secrethub.prod.env":
+							return []byte("TEST=prod"), nil
+						}
+						return nil, os.ErrNotExist
+					},
+				},
+			},
+			expectedEnv: []string{"BASE=base", "TEST=prod"},
+		},
+		"env file with include": {
+			command: RunCommand{
+				environment: &environment{
+					envFiles:        []string{"config/secrethub.env"},
+					templateVersion: "2",
+					readFile: func(filename string) ([]byte, error) {
+						switch filename {
+						case "config/secrethub.env":
+							return []byte("DB_URL=postgres://{{ include \"partials/db.tpl\" }}/app"), nil
+						case filepath.Join("config", "partials", "db.tpl"):
+							return []byte("db.example.com:5432"), nil
+						}
+						return nil, os.ErrNotExist
+					},
+				},
+			},
+			expectedEnv: []string{"DB_URL=postgres://db.example.com:5432/app"},
+		},
+		"env file with error in included template": {
+			command: RunCommand{
+				environment: &environment{
+					envFiles:        []string{"secrethub.env"},
+					templateVersion: "2",
+					readFile: func(filename string) ([]byte, error) {
+						switch filename {
+						case "secrethub.env":
+							return []byte("DB_URL={{ include \"db.tpl\" }}"), nil
+						case "db.tpl":
+							return []byte("{{ path/to/secret }"), nil
+						}
+						return nil, os.ErrNotExist
+					},
+				},
+			},
+			// The included template is read with a fake, so its source line cannot be shown.
+			err: templateSourceError{
+				file:    "db.tpl",
+				lineNo:  1,
+				colNo:   20,
+				message: "expected the closing of a secret tag `}}`, but reached the end of the template.",
+			},
+		},
+		"env file with multi-line include": {
+			command: RunCommand{
+				environment: &environment{
+					envFiles:        []string{"config/secrethub.env"},
+					templateVersion: "2",
+					readFile: func(filename string) ([]byte, error) {
+						switch filename {
+						case "config/secrethub.env":
+							return []byte("APP=app\n  {{ include \"partials/db.env\" }}\nDEBUG=false\n"), nil
+						case filepath.Join("config", "partials", "db.env"):
+							return []byte("DB_HOST=db.example.com\nDB_URL=postgres://{{ include \"port.tpl\" }}/app\n"), nil
+						case filepath.Join("config", "partials", "port.tpl"):
+							return []byte("5432"), nil
+						}
+						return nil, os.ErrNotExist
+					},
+				},
+			},
+			expectedEnv: []string{"APP=app", "DB_HOST=db.example.com", "DB_URL=postgres://5432/app", "DEBUG=false"},
+		},
+		"env file with error in included lines": {
+			command: RunCommand{
+				environment: &environment{
+					envFiles:        []string{"secrethub.env"},
+					templateVersion: "2",
+					readFile: func(filename string) ([]byte, error) {
+						switch filename {
+						case "secrethub.env":
+							return []byte("APP=app\n{{ include \"db.env\" }}"), nil
+						case "db.env":
+							return []byte("DB_HOST=db.example.com\nDB_PASS={{ path/to/secret }"), nil
+						}
+						return nil, os.ErrNotExist
+					},
+				},
+			},
+			err: templateSourceError{
+				file:    "db.env",
+				lineNo:  2,
+				colNo:   28,
+				message: "expected the closing of a secret tag `}}`, but reached the end of the template.",
+			},
+		},
+		"env file with invalid included lines": {
+			command: RunCommand{
+				environment: &environment{
+					envFiles:        []string{"secrethub.env"},
+					templateVersion: "2",
+					readFile: func(filename string) ([]byte, error) {
+						switch filename {
+						case "secrethub.env":
+							return []byte("APP=app\n{{ include \"db.env\" }}"), nil
+						case "db.env":
+							return []byte("DB_HOST=db.example.com\nnot a variable"), nil
+						}
+						return nil, os.ErrNotExist
+					},
+				},
+			},
+			err: ErrParsingTemplate("secrethub.env", ErrIncludedTemplate("db.env", 2, errNotKeyValue)),
+		},
+		"env file with variable declared in included lines": {
+			command: RunCommand{
+				environment: &environment{
+					envFiles:        []string{"secrethub.env"},
+					templateVersion: "2",
+					readFile: func(filename string) ([]byte, error) {
+						switch filename {
+						case "secrethub.env":
+							return []byte("DB_HOST=localhost\n{{ include \"db.env\" }}"), nil
+						case "db.env":
+							return []byte("DB_HOST=db.example.com"), nil
+						}
+						return nil, os.ErrNotExist
+					},
+				},
+			},
+			err: ErrParsingTemplate("secrethub.env", ErrDuplicateIncludedEnvVar("DB_HOST", "line 1", "line 1 of db.env")),
+		},
 		"env file secret does not exist": {
 			command: RunCommand{
 				command: cli.StringListValue{"echo", "test"},
 				environment: &environment{
 					osStat:          osStatFunc("secrethub.env", nil),
 					readFile:        readFileFunc("secrethub.env", "TEST= {{ unexistent/secret/path }}"),
-					envFile:         "secrethub.env",
+					envFiles:        []string{"secrethub.env"},
 					templateVersion: "2",
 				},
 				newClient: func() (secrethub.ClientInterface, error) {
@@ -741,14 +1157,20 @@ func TestRunCommand_environment(t *testing.T) {
 					}, nil
 				},
 			},
-			err: ErrParsingTemplate("secrethub.env", api.ErrSecretNotFound),
+			err: templateSourceError{
+				file:    "secrethub.env",
+				lineNo:  1,
+				colNo:   7,
+				message: "Secret not found (server.secret_not_found)",
+				source:  "TEST= {{ unexistent/secret/path }}",
+			},
 		},
 		"envar flag has precedence over env file": {
 			command: RunCommand{
 				environment: &environment{
 					osStat:   osStatFunc("secrethub.env", nil),
 					readFile: readFileFunc("secrethub.env", "TEST=aaa"),
-					envFile:  "secrethub.env",
+					envFiles: []string{"secrethub.env"},
 					envar: map[string]string{
 						"TEST": "test/test/test",
 					},
@@ -794,7 +1216,8 @@ func TestRunCommand_environment(t *testing.T) {
 							},
 						}, nil
 					},
-					secretsDir:                   "namespace/repo",
+					secretsDirs:                  []string{"namespace/repo"},
+					secretsDirDepth:              -1,
 					dontPromptMissingTemplateVar: true,
 					templateVersion:              "2",
 					osEnv:                        []string{"FOO=bbb"},
@@ -846,7 +1269,8 @@ func TestRunCommand_environment(t *testing.T) {
 							},
 						}, nil
 					},
-					secretsDir:                   "namespace/repo",
+					secretsDirs:                  []string{"namespace/repo"},
+					secretsDirDepth:              -1,
 					dontPromptMissingTemplateVar: true,
 					templateVersion:              "2",
 					osEnv:                        []string{"FOO=bbb"},
@@ -896,7 +1320,8 @@ func TestRunCommand_environment(t *testing.T) {
 							},
 						}, nil
 					},
-					secretsDir:                   "namespace/repo",
+					secretsDirs:                  []string{"namespace/repo"},
+					secretsDirDepth:              -1,
 					dontPromptMissingTemplateVar: true,
 					templateVersion:              "2",
 					osEnv:                        []string{"FOO=secrethub://test/test/test"},
@@ -988,7 +1413,7 @@ func TestRunCommand_environment(t *testing.T) {
 				ignoreMissingSecrets: true,
 				environment: &environment{
 					osStat:   osStatFunc("secrethub.env", nil),
-					envFile:  "secrethub.env",
+					envFiles: []string{"secrethub.env"},
 					readFile: readFileFunc("secrethub.env", ""),
 					envar: map[string]string{
 						"TEST": "test/test/test",
@@ -1017,7 +1442,7 @@ func TestRunCommand_environment(t *testing.T) {
 					osStat:                       osStatFunc("secrethub.env", nil),
 					readFile:                     readFileFunc("secrethub.env", "TEST = {{ test/$variable/test }}"),
 					dontPromptMissingTemplateVar: true,
-					envFile:                      "secrethub.env",
+					envFiles:                     []string{"secrethub.env"},
 					templateVersion:              "2",
 				},
 				newClient: func() (secrethub.ClientInterface, error) {
@@ -1032,7 +1457,13 @@ func TestRunCommand_environment(t *testing.T) {
 					}, nil
 				},
 			},
-			err: ErrParsingTemplate("secrethub.env", tpl.ErrTemplateVarNotFound("variable")),
+			err: templateSourceError{
+				file:    "secrethub.env",
+				lineNo:  1,
+				colNo:   16,
+				message: "no value was supplied for template variable 'variable' (template.template_var_not_found)",
+				source:  "TEST = {{ test/$variable/test }}",
+			},
 		},
 		"template var set in os environment": {
 			command: RunCommand{
@@ -1056,7 +1487,13 @@ func TestRunCommand_environment(t *testing.T) {
 					}, nil
 				},
 			},
-			err: ErrParsingTemplate("secrethub.env", api.ErrSecretNotFound),
+			err: templateSourceError{
+				file:    "secrethub.env",
+				lineNo:  1,
+				colNo:   8,
+				message: "Secret not found (server.secret_not_found)",
+				source:  "TEST = {{ test/$variable/test }}",
+			},
 		},
 		"template var set by flag": {
 			command: RunCommand{
@@ -1080,7 +1517,13 @@ func TestRunCommand_environment(t *testing.T) {
 					}, nil
 				},
 			},
-			err: ErrParsingTemplate("secrethub.env", api.ErrSecretNotFound),
+			err: templateSourceError{
+				file:    "secrethub.env",
+				lineNo:  1,
+				colNo:   8,
+				message: "Secret not found (server.secret_not_found)",
+				source:  "TEST = {{ test/$variable/test }}",
+			},
 		},
 		"template var set by flag has precedence over var set by environment": {
 			command: RunCommand{
@@ -1135,7 +1578,14 @@ func TestRunCommand_environment(t *testing.T) {
 
 	for name, tc := range cases {
 		t.Run(name, func(t *testing.T) {
-			env, secrets, err := tc.command.sourceEnvironment()
+			var env, secrets []string
+			environment, err := tc.command.sourceEnvironment()
+			if err == nil {
+				env = environment.vars
+				for _, secret := range environment.secrets {
+					secrets = append(secrets, string(secret.Value))
+				}
+			}
 
 			sort.Strings(env)
 			sort.Strings(tc.expectedEnv)
@@ -1177,7 +1627,7 @@ func TestRunCommand_RunWithFile(t *testing.T) {
 				environment: &environment{
 					osStat:   osStatOnlySecretHubEnv,
 					readFile: readFileWithContent(""),
-					envFile:  "secrethub.env",
+					envFiles: []string{"secrethub.env"},
 					envar: map[string]string{
 						"TEST": "test/test/test",
 					},
@@ -1203,7 +1653,7 @@ func TestRunCommand_RunWithFile(t *testing.T) {
 				command: cli.StringListValue{"/bin/sh", "./test.sh"},
 				environment: &environment{
 					osStat:   osStatOnlySecretHubEnv,
-					envFile:  "secrethub.env",
+					envFiles: []string{"secrethub.env"},
 					readFile: readFileWithContent(""),
 					envar: map[string]string{
 						"TEST": "test/test/test",
@@ -1224,6 +1674,30 @@ func TestRunCommand_RunWithFile(t *testing.T) {
 			},
 			expectedStdOut: maskString + "\n",
 		},
+		"masking of a value derived with a filter": {
+			script: "echo $TEST",
+			command: RunCommand{
+				command: cli.StringListValue{"/bin/sh", "./test.sh"},
+				environment: &environment{
+					osStat:          osStatOnlySecretHubEnv,
+					envFiles:        []string{"secrethub.env"},
+					readFile:        readFileWithContent(`TEST={{ test/test/test | json ".password" }}`),
+					templateVersion: "2",
+				},
+				newClient: func() (secrethub.ClientInterface, error) {
+					return fakeclient.Client{
+						SecretService: &fakeclient.SecretService{
+							VersionService: &fakeclient.SecretVersionService{
+								GetWithDataFunc: func(path string) (*api.SecretVersion, error) {
+									return &api.SecretVersion{Data: []byte(`{"password": "bbb"}`)}, nil
+								},
+							},
+						},
+					}, nil
+				},
+			},
+			expectedStdOut: maskString + "\n",
+		},
 	}
 
 	for name, tc := range cases {
@@ -1251,130 +1725,114 @@ func TestRunCommand_RunWithFile(t *testing.T) {
 	}
 }
 
-func TestTrimQuotes(t *testing.T) {
+func Test_parseKeyValueStringsToMap(t *testing.T) {
+	input := []string{
+		"A=B",
+		"B",
+		"=::=::\\",
+	}
+
+	parsableValues, unparsableValues := parseKeyValueStringsToMap(input)
+
+	assert.Equal(t, parsableValues, map[string]string{
+		"A": "B",
+		"B": "",
+	})
+	assert.Equal(t, unparsableValues, []string{
+		"=::=::\\",
+	})
+}
+
+func TestMaskEncoders(t *testing.T) {
 	cases := map[string]struct {
-		in       string
-		expected string
+		names         []string
+		expectedCount int
+		err           error
 	}{
-		"unquoted": {
-			in:       `foo`,
-			expected: `foo`,
-		},
-		"single quoted": {
-			in:       `'foo'`,
-			expected: `foo`,
-		},
-		"double quoted": {
-			in:       `"foo"`,
-			expected: `foo`,
-		},
-		"maintain quotes inside unquoted value": {
-			in:       `{"foo":"bar"}`,
-			expected: `{"foo":"bar"}`,
-		},
-		"empty string": {
-			in:       "",
-			expected: "",
-		},
-		"single quoted empty string": {
-			in:       `''`,
-			expected: ``,
-		},
-		"double qouted empty string": {
-			in:       `""`,
-			expected: ``,
-		},
-		"single quote wrapped in single quote": {
-			in:       `''foo''`,
-			expected: `'foo'`,
+		"default": {
+			names:         defaultMaskEncodings,
+			expectedCount: 3,
 		},
-		"single quote wrapped in double quote": {
-			in:       `"'foo'"`,
-			expected: `'foo'`,
+		"all": {
+			names:         []string{"base64", "url", "json", "hex"},
+			expectedCount: 4,
 		},
-		"double quote wrapped in double quote": {
-			in:       `""foo""`,
-			expected: `"foo"`,
+		"case insensitive with spaces": {
+			names:         []string{" Base64", "HEX "},
+			expectedCount: 2,
 		},
-		"double quote wrapped in single quote": {
-			in:       `'"foo"'`,
-			expected: `"foo"`,
+		"none": {
+			names:         []string{},
+			expectedCount: 0,
 		},
-		"single quote opened but not closed": {
-			in:       `'foo`,
-			expected: `'foo`,
+		"empty value": {
+			names:         []string{""},
+			expectedCount: 0,
 		},
-		"double quote opened but not closed": {
-			in:       `"foo`,
-			expected: `"foo`,
-		},
-		"single quote closed but not opened": {
-			in:       `foo'`,
-			expected: `foo'`,
-		},
-		"double quote closed but not opened": {
-			in:       `foo"`,
-			expected: `foo"`,
-		},
-		"single quoted with inner leading whitespace": {
-			in:       `' foo'`,
-			expected: ` foo`,
-		},
-		"double quoted with inner leading whitespace": {
-			in:       `" foo"`,
-			expected: ` foo`,
-		},
-		"single quoted with inner trailing whitespace": {
-			in:       `'foo '`,
-			expected: `foo `,
-		},
-		"double quoted with inner trailing whitespace": {
-			in:       `"foo "`,
-			expected: `foo `,
-		},
-
-		// Trimming OUTER whitespace is explicitly not the responsibility of this function.
-		"single quoted with outer leading whitespace": {
-			in:       ` 'foo'`,
-			expected: ` 'foo'`,
-		},
-		"double quoted with outer leading whitespace": {
-			in:       ` "foo"`,
-			expected: ` "foo"`,
-		},
-		"single quoted with outer trailing whitespace": {
-			in:       `'foo' `,
-			expected: `'foo' `,
-		},
-		"double quoted with outer trailing whitespace": {
-			in:       `"foo" `,
-			expected: `"foo" `,
+		"unknown": {
+			names: []string{"base64", "rot13"},
+			err:   ErrUnknownMaskEncoding("rot13", "base64, hex, json, url"),
 		},
 	}
 
 	for name, tc := range cases {
 		t.Run(name, func(t *testing.T) {
-			actual, _ := trimQuotes(tc.in)
-
-			assert.Equal(t, actual, tc.expected)
+			encoders, err := maskEncoders(tc.names)
+			assert.Equal(t, err, tc.err)
+			assert.Equal(t, len(encoders), tc.expectedCount)
 		})
 	}
 }
 
-func Test_parseKeyValueStringsToMap(t *testing.T) {
-	input := []string{
-		"A=B",
-		"B",
-		"=::=::\\",
+func TestRunCommand_loadMaskPatterns(t *testing.T) {
+	dir := t.TempDir()
+	validFile := filepath.Join(dir, "patterns.txt")
+	err := os.WriteFile(validFile, []byte("# internal\ninternal-token: itk_[a-z0-9]{8}\n"), 0600)
+	assert.OK(t, err)
+	invalidFile := filepath.Join(dir, "invalid.txt")
+	err = os.WriteFile(invalidFile, []byte("itk_[a-z0-9]{8}\n"), 0600)
+	assert.OK(t, err)
+
+	cases := map[string]struct {
+		cmd           RunCommand
+		expectedNames []string
+		err           error
+	}{
+		"disabled": {},
+		"default patterns": {
+			cmd: RunCommand{
+				maskPatterns: true,
+			},
+			expectedNames: []string{"aws-access-key-id", "private-key", "jwt", "github-token", "slack-token", "slack-webhook"},
+		},
+		"patterns file": {
+			cmd: RunCommand{
+				maskPatternsFiles: []string{validFile},
+			},
+			expectedNames: []string{"internal-token"},
+		},
+		"invalid patterns file": {
+			cmd: RunCommand{
+				maskPatternsFiles: []string{invalidFile},
+			},
+			err: ErrReadMaskPatterns(invalidFile, errors.New("line 1: pattern is not of the form <name>: <regular expression>")),
+		},
 	}
 
-	parsableValues, unparsableValues := parseKeyValueStringsToMap(input)
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			patterns, err := tc.cmd.loadMaskPatterns()
+			if tc.err != nil {
+				assert.Equal(t, err.Error(), tc.err.Error())
+				return
+			}
+			assert.OK(t, err)
 
-	assert.Equal(t, parsableValues, map[string]string{
-		"A": "B",
-		"B": "",
-	})
-	assert.Equal(t, unparsableValues, []string{
-		"=::=::\\",
-	})
+			var names []string
+			for _, pattern := range patterns {
+				names = append(names, pattern.Name)
+			}
+			assert.Equal(t, names, tc.expectedNames)
+		})
+	}
 }
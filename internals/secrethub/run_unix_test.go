@@ -0,0 +1,173 @@
+//go:build !windows
+
+package secrethub
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/secrethub/secrethub-cli/internals/cli"
+	"github.com/secrethub/secrethub-cli/internals/cli/masker"
+	"github.com/secrethub/secrethub-cli/internals/cli/ui/fakeui"
+
+	"github.com/secrethub/secrethub-go/internals/assert"
+)
+
+func TestExitCode(t *testing.T) {
+	cases := map[string]struct {
+		status   syscall.WaitStatus
+		expected int
+	}{
+		"exited": {
+			status:   syscall.WaitStatus(3 << 8),
+			expected: 3,
+		},
+		"terminated by SIGTERM": {
+			status:   syscall.WaitStatus(syscall.SIGTERM),
+			expected: 143,
+		},
+		"killed": {
+			status:   syscall.WaitStatus(syscall.SIGKILL),
+			expected: 137,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, exitCode(tc.status), tc.expected)
+		})
+	}
+}
+
+func TestExitStatusError(t *testing.T) {
+	err := exitStatusError(syscall.WaitStatus(3 << 8))
+	assert.Equal(t, err.Error(), "exit status 3")
+
+	status, ok := waitStatus(err)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, exitCode(status), 3)
+
+	err = exitStatusError(syscall.WaitStatus(syscall.SIGTERM))
+	assert.Equal(t, err.Error(), "signal: terminated")
+}
+
+func TestReapedError(t *testing.T) {
+	assert.OK(t, reapedError(syscall.WaitStatus(0)))
+	assert.Equal(t, reapedError(syscall.WaitStatus(3<<8)), exitStatusError(3<<8))
+	assert.Equal(t, reapedError(syscall.WaitStatus(syscall.SIGKILL)), exitStatusError(syscall.SIGKILL))
+}
+
+// waitForOutput waits until the output of the given fake IO ends with the given suffix.
+func waitForOutput(t *testing.T, io *fakeui.FakeIO, suffix string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		out, err := io.ReadStdout()
+		assert.OK(t, err)
+		if strings.HasSuffix(string(out), suffix) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for output %q", suffix)
+}
+
+func TestProcess_forwardSignals(t *testing.T) {
+	cases := map[string]struct {
+		script             string
+		signals            []syscall.Signal
+		outputs            []string
+		killTimeout        time.Duration
+		signalProcessGroup bool
+		expectedOut        string
+		expectedExitCode   int
+	}{
+		"every signal is forwarded": {
+			script:           "trap 'echo hup' HUP; trap 'echo term; exit 0' TERM; echo ready; while true; do sleep 0.01; done",
+			signals:          []syscall.Signal{syscall.SIGHUP, syscall.SIGHUP, syscall.SIGTERM},
+			outputs:          []string{"hup\n", "hup\nhup\n", ""},
+			expectedOut:      "ready\nhup\nhup\nterm\n",
+			expectedExitCode: 0,
+		},
+		"killed after timeout": {
+			script:           "trap '' TERM; echo ready; while true; do sleep 0.01; done",
+			signals:          []syscall.Signal{syscall.SIGTERM},
+			outputs:          []string{""},
+			killTimeout:      100 * time.Millisecond,
+			expectedOut:      "ready\n",
+			expectedExitCode: 137,
+		},
+		"forwarded to process group": {
+			script:             "trap 'echo term; exit 0' TERM; echo ready; while true; do sleep 0.01; done",
+			signals:            []syscall.Signal{syscall.SIGTERM},
+			outputs:            []string{""},
+			signalProcessGroup: true,
+			expectedOut:        "ready\nterm\n",
+			expectedExitCode:   0,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			io := fakeui.NewIO(t)
+			cmd := RunCommand{
+				io:                 io,
+				command:            cli.StringListValue{"/bin/sh", "-c", tc.script},
+				noMasking:          true,
+				killTimeout:        tc.killTimeout,
+				signalProcessGroup: tc.signalProcessGroup,
+			}
+
+			proc, err := cmd.start(&sourcedEnvironment{})
+			assert.OK(t, err)
+
+			waitForOutput(t, io, "ready\n")
+			for i, s := range tc.signals {
+				err = syscall.Kill(os.Getpid(), s)
+				assert.OK(t, err)
+				if tc.outputs[i] != "" {
+					waitForOutput(t, io, tc.outputs[i])
+				}
+			}
+
+			err = proc.wait()
+			actualExitCode := 0
+			if status, ok := waitStatus(err); ok {
+				actualExitCode = exitCode(status)
+			} else {
+				assert.OK(t, err)
+			}
+
+			out, err := io.ReadStdout()
+			assert.OK(t, err)
+			assert.Equal(t, string(out), tc.expectedOut)
+			assert.Equal(t, actualExitCode, tc.expectedExitCode)
+		})
+	}
+}
+
+func TestRunCommand_start_TTY(t *testing.T) {
+	io := fakeui.NewIO(t)
+	cmd := RunCommand{
+		io:      io,
+		command: cli.StringListValue{"/bin/sh", "-c", "test -t 0 && test -t 1 && test -t 2 && echo 'in a terminal: secret'"},
+		tty:     true,
+	}
+
+	proc, err := cmd.start(&sourcedEnvironment{
+		secrets: []masker.Sequence{{Name: "SECRET", Value: []byte("secret")}},
+	})
+	if err == ErrTTYNotSupported {
+		t.Skip(err)
+	}
+	assert.OK(t, err)
+
+	err = proc.wait()
+	assert.OK(t, err)
+
+	out, err := io.ReadStdout()
+	assert.OK(t, err)
+	assert.Equal(t, string(out), "in a terminal: "+maskString+"\r\n")
+}
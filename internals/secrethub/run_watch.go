@@ -0,0 +1,247 @@
+package secrethub
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	gopath "path"
+	"strings"
+	"time"
+
+	"github.com/secrethub/secrethub-go/internals/api"
+	"github.com/secrethub/secrethub-go/pkg/secrethub"
+)
+
+// sourceWatcher detects changes in secrets and files, such as the sources of the environment of the run command.
+// It keeps track of the versions of the secrets and the contents of the files.
+type sourceWatcher struct {
+	newClient newClientFunc
+	readFile  func(filename string) ([]byte, error)
+	listFiles func() ([]string, error)
+
+	versions     map[string]int
+	fileContents map[string][]byte
+}
+
+// newSourceWatcher returns a watcher for the given secret paths and the env files of the run command,
+// including the files of the templates they included. The current state of the sources is used to detect changes.
+func (cmd *RunCommand) newSourceWatcher(paths []string) (*sourceWatcher, error) {
+	return newSourceWatcher(cmd.newClient, cmd.environment.readFile, cmd.environment.watchedFiles, paths)
+}
+
+// watchedFiles returns the env files and the files of the templates they included when they were last read.
+// Included files that no longer exist are left out, so that removing them is detected as a change.
+func (env *environment) watchedFiles() ([]string, error) {
+	files := make([]string, 0, len(env.envFiles)+len(env.includedFiles))
+	seen := make(map[string]bool, len(env.envFiles)+len(env.includedFiles))
+	for _, file := range env.envFiles {
+		files = append(files, file)
+		seen[file] = true
+	}
+	for _, file := range env.includedFiles {
+		if seen[file] {
+			continue
+		}
+		seen[file] = true
+
+		_, err := env.osStat(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// newSourceWatcher returns a watcher for the given secret paths and the files returned by listFiles.
+// The current state of the secrets and files is used to detect changes.
+func newSourceWatcher(newClient newClientFunc, readFile func(filename string) ([]byte, error), listFiles func() ([]string, error), paths []string) (*sourceWatcher, error) {
+	watcher := &sourceWatcher{
+		newClient: newClient,
+		readFile:  readFile,
+		listFiles: listFiles,
+		versions:  make(map[string]int, len(paths)),
+	}
+
+	for _, path := range paths {
+		watcher.versions[path] = 0
+	}
+
+	versions, fileContents, err := watcher.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	watcher.versions = versions
+	watcher.fileContents = fileContents
+
+	return watcher, nil
+}
+
+// snapshot returns the current versions of the watched secrets and the current contents of the files.
+func (w *sourceWatcher) snapshot() (map[string]int, map[string][]byte, error) {
+	versions := make(map[string]int, len(w.versions))
+	if len(w.versions) > 0 {
+		client, err := w.newClient()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		paths := make([]string, 0, len(w.versions))
+		for path := range w.versions {
+			paths = append(paths, path)
+		}
+		versions, err = secretVersions(client, paths)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	files, err := w.listFiles()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fileContents := make(map[string][]byte, len(files))
+	for _, file := range files {
+		contents, err := w.readFile(file)
+		if err != nil {
+			return nil, nil, ErrCannotReadFile(file, err)
+		}
+		fileContents[file] = contents
+	}
+
+	return versions, fileContents, nil
+}
+
+// secretVersions returns the latest versions of the secrets on the given paths. Secrets that do not exist
+// have version 0, so that creating a secret that was missing, e.g. with --ignore-missing-secrets, is a change.
+// The versions of multiple secrets in the same directory are retrieved with a single request for the directory.
+func secretVersions(client secrethub.ClientInterface, paths []string) (map[string]int, error) {
+	dirs := make(map[string][]string)
+	for _, path := range paths {
+		dir := gopath.Dir(path)
+		dirs[dir] = append(dirs[dir], path)
+	}
+
+	versions := make(map[string]int, len(paths))
+	for dir, dirPaths := range dirs {
+		if len(dirPaths) == 1 || hasVersionedPath(dirPaths) {
+			for _, path := range dirPaths {
+				version, err := client.Secrets().Versions().GetWithoutData(path)
+				if api.IsErrNotFound(err) {
+					versions[path] = 0
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+				versions[path] = version.Version
+			}
+			continue
+		}
+
+		tree, err := client.Dirs().GetTree(dir, 1, false)
+		if api.IsErrNotFound(err) {
+			for _, path := range dirPaths {
+				versions[path] = 0
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Secret names are case insensitive.
+		latest := make(map[string]int, len(tree.Secrets))
+		for _, secret := range tree.Secrets {
+			if secret.DirID == tree.RootDir.DirID {
+				latest[strings.ToLower(secret.Name)] = secret.LatestVersion
+			}
+		}
+		for _, path := range dirPaths {
+			versions[path] = latest[strings.ToLower(gopath.Base(path))]
+		}
+	}
+	return versions, nil
+}
+
+// hasVersionedPath returns whether any of the paths refers to a specific version of a secret, like path/to/secret:1.
+func hasVersionedPath(paths []string) bool {
+	for _, path := range paths {
+		if api.SecretPath(path).HasVersion() {
+			return true
+		}
+	}
+	return false
+}
+
+// changed returns whether any of the watched secrets or files has changed since the watcher was created.
+// Files that are added or removed are changes as well.
+func (w *sourceWatcher) changed() (bool, error) {
+	versions, fileContents, err := w.snapshot()
+	if err != nil {
+		return false, err
+	}
+
+	if len(fileContents) != len(w.fileContents) {
+		return true, nil
+	}
+	for file, contents := range fileContents {
+		previous, ok := w.fileContents[file]
+		if !ok || !bytes.Equal(contents, previous) {
+			return true, nil
+		}
+	}
+
+	for path, version := range versions {
+		if w.versions[path] != version {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// watchSources periodically checks the sources of the environment for changes and restarts the
+// process with the reloaded environment when a change is detected. It returns the last started
+// process as soon as it exits by itself.
+//
+// When the environment cannot be reloaded, the running process is left untouched and the reload
+// is retried on the next check.
+func (cmd *RunCommand) watchSources(proc *process, watcher *sourceWatcher) (*process, error) {
+	ticker := time.NewTicker(cmd.watchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-proc.exited:
+			return proc, nil
+		case <-ticker.C:
+			changed, err := watcher.changed()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, ErrWatchFailed(err))
+				continue
+			}
+			if !changed {
+				continue
+			}
+
+			environment, err := cmd.sourceEnvironment()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, ErrReloadFailed(err))
+				continue
+			}
+
+			err = proc.stop(cmd.reloadSignal.signal, cmd.reloadGracePeriod)
+			if err != nil {
+				environment.cleanup()
+				return nil, err
+			}
+
+			proc, err = cmd.start(environment)
+			if err != nil {
+				return nil, err
+			}
+			watcher = environment.watcher
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package secrethub
+
+import (
+	"os"
+	gopath "path"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/secrethub/secrethub-cli/internals/cli"
+	"github.com/secrethub/secrethub-cli/internals/cli/ui/fakeui"
+
+	"github.com/secrethub/secrethub-go/internals/api"
+	"github.com/secrethub/secrethub-go/internals/api/uuid"
+	"github.com/secrethub/secrethub-go/internals/assert"
+	"github.com/secrethub/secrethub-go/pkg/secrethub"
+	"github.com/secrethub/secrethub-go/pkg/secrethub/fakeclient"
+)
+
+func TestSourceWatcher_changed(t *testing.T) {
+	cases := map[string]struct {
+		paths           []string
+		envFiles        []string
+		versions        map[string]int
+		envFileContents string
+		newVersions     map[string]int
+		newContents     string
+		getErr          error
+		expected        bool
+		err             error
+	}{
+		"no changes": {
+			paths:           []string{"namespace/repo/foo", "namespace/repo/bar"},
+			envFiles:        []string{"secrethub.env"},
+			versions:        map[string]int{"namespace/repo/foo": 1, "namespace/repo/bar": 3},
+			envFileContents: "FOO={{ namespace/repo/foo }}",
+			newVersions:     map[string]int{"namespace/repo/foo": 1, "namespace/repo/bar": 3},
+			newContents:     "FOO={{ namespace/repo/foo }}",
+			expected:        false,
+		},
+		"new secret version": {
+			paths:       []string{"namespace/repo/foo", "namespace/repo/bar"},
+			versions:    map[string]int{"namespace/repo/foo": 1, "namespace/repo/bar": 3},
+			newVersions: map[string]int{"namespace/repo/foo": 1, "namespace/repo/bar": 4},
+			expected:    true,
+		},
+		"env file changed": {
+			envFiles:        []string{"secrethub.env"},
+			envFileContents: "FOO={{ namespace/repo/foo }}",
+			newContents:     "FOO={{ namespace/repo/bar }}",
+			expected:        true,
+		},
+		"no sources": {
+			expected: false,
+		},
+		"missing secret": {
+			paths:       []string{"namespace/repo/foo"},
+			versions:    map[string]int{},
+			newVersions: map[string]int{},
+			expected:    false,
+		},
+		"missing secret created": {
+			paths:       []string{"namespace/repo/foo"},
+			versions:    map[string]int{},
+			newVersions: map[string]int{"namespace/repo/foo": 1},
+			expected:    true,
+		},
+		"missing secret in directory": {
+			paths:       []string{"namespace/repo/foo", "namespace/repo/bar"},
+			versions:    map[string]int{"namespace/repo/foo": 1},
+			newVersions: map[string]int{"namespace/repo/foo": 1},
+			expected:    false,
+		},
+		"missing secret in directory created": {
+			paths:       []string{"namespace/repo/foo", "namespace/repo/bar"},
+			versions:    map[string]int{"namespace/repo/foo": 1},
+			newVersions: map[string]int{"namespace/repo/foo": 1, "namespace/repo/bar": 1},
+			expected:    true,
+		},
+		"secret deleted": {
+			paths:       []string{"namespace/repo/foo"},
+			versions:    map[string]int{"namespace/repo/foo": 1},
+			newVersions: map[string]int{},
+			expected:    true,
+		},
+		"versioned path": {
+			paths:       []string{"namespace/repo/foo:1", "namespace/repo/bar"},
+			versions:    map[string]int{"namespace/repo/foo:1": 1, "namespace/repo/bar": 3},
+			newVersions: map[string]int{"namespace/repo/foo:1": 1, "namespace/repo/bar": 4},
+			expected:    true,
+		},
+		"get version error": {
+			paths:       []string{"namespace/repo/foo"},
+			versions:    map[string]int{"namespace/repo/foo": 1},
+			newVersions: map[string]int{"namespace/repo/foo": 1},
+			getErr:      api.ErrForbidden,
+			err:         api.ErrForbidden,
+		},
+		"get directory error": {
+			paths:       []string{"namespace/repo/foo", "namespace/repo/bar"},
+			versions:    map[string]int{"namespace/repo/foo": 1, "namespace/repo/bar": 3},
+			newVersions: map[string]int{"namespace/repo/foo": 1, "namespace/repo/bar": 3},
+			getErr:      api.ErrForbidden,
+			err:         api.ErrForbidden,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			versions := tc.versions
+			contents := tc.envFileContents
+			var getErr error
+
+			cmd := RunCommand{
+				newClient: func() (secrethub.ClientInterface, error) {
+					return fakeclient.Client{
+						DirService: &fakeclient.DirService{
+							GetTreeFunc: func(path string, depth int, ancestors bool) (*api.Tree, error) {
+								if getErr != nil {
+									return nil, getErr
+								}
+								return fakeSecretTree(path, versions), nil
+							},
+						},
+						SecretService: &fakeclient.SecretService{
+							VersionService: &fakeclient.SecretVersionService{
+								GetWithoutDataFunc: func(path string) (*api.SecretVersion, error) {
+									if getErr != nil {
+										return nil, getErr
+									}
+									version, ok := versions[path]
+									if !ok {
+										return nil, api.ErrSecretNotFound
+									}
+									return &api.SecretVersion{Version: version}, nil
+								},
+							},
+						},
+					}, nil
+				},
+				environment: &environment{
+					envFiles: tc.envFiles,
+					readFile: func(filename string) ([]byte, error) {
+						return []byte(contents), nil
+					},
+				},
+			}
+
+			watcher, err := cmd.newSourceWatcher(tc.paths)
+			assert.OK(t, err)
+
+			versions = tc.newVersions
+			contents = tc.newContents
+			getErr = tc.getErr
+
+			actual, err := watcher.changed()
+			assert.Equal(t, err, tc.err)
+			assert.Equal(t, actual, tc.expected)
+		})
+	}
+}
+
+func TestRunCommand_sourceEnvironment_WatchIncludedFile(t *testing.T) {
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, "secrethub.env")
+	partialFile := filepath.Join(dir, "partial.env")
+	err := os.WriteFile(envFile, []byte("{{ include \"partial.env\" }}\nFOO=foo"), 0600)
+	assert.OK(t, err)
+	err = os.WriteFile(partialFile, []byte("BAR=bar"), 0600)
+	assert.OK(t, err)
+
+	environment := newEnvironment(fakeui.NewIO(t), nil)
+	environment.osEnv = nil
+	environment.envFiles = []string{envFile}
+	environment.templateVersion = "auto"
+	environment.dontPromptMissingTemplateVar = true
+
+	cmd := RunCommand{
+		watch:       true,
+		environment: environment,
+	}
+
+	sourced, err := cmd.sourceEnvironment()
+	assert.OK(t, err)
+	defer sourced.cleanup()
+
+	changed, err := sourced.watcher.changed()
+	assert.OK(t, err)
+	assert.Equal(t, changed, false)
+
+	err = os.WriteFile(partialFile, []byte("BAR=baz"), 0600)
+	assert.OK(t, err)
+
+	changed, err = sourced.watcher.changed()
+	assert.OK(t, err)
+	assert.Equal(t, changed, true)
+}
+
+func TestSecretVersions(t *testing.T) {
+	var treeReads, versionReads []string
+	client := fakeclient.Client{
+		DirService: &fakeclient.DirService{
+			GetTreeFunc: func(path string, depth int, ancestors bool) (*api.Tree, error) {
+				treeReads = append(treeReads, path)
+				if path == "namespace/missing" {
+					return nil, api.ErrDirNotFound
+				}
+				return fakeSecretTree(path, map[string]int{"namespace/repo/foo": 2, "namespace/repo/Bar": 5}), nil
+			},
+		},
+		SecretService: &fakeclient.SecretService{
+			VersionService: &fakeclient.SecretVersionService{
+				GetWithoutDataFunc: func(path string) (*api.SecretVersion, error) {
+					versionReads = append(versionReads, path)
+					return nil, api.ErrSecretNotFound
+				},
+			},
+		},
+	}
+
+	versions, err := secretVersions(client, []string{
+		"namespace/repo/foo",
+		"namespace/repo/bar",
+		"namespace/repo/baz",
+		"namespace/missing/foo",
+		"namespace/missing/bar",
+		"namespace/other/foo",
+	})
+	assert.OK(t, err)
+
+	sort.Strings(treeReads)
+	assert.Equal(t, treeReads, []string{"namespace/missing", "namespace/repo"})
+	assert.Equal(t, versionReads, []string{"namespace/other/foo"})
+	assert.Equal(t, versions, map[string]int{
+		"namespace/repo/foo":    2,
+		"namespace/repo/bar":    5,
+		"namespace/repo/baz":    0,
+		"namespace/missing/foo": 0,
+		"namespace/missing/bar": 0,
+		"namespace/other/foo":   0,
+	})
+}
+
+// fakeSecretTree returns a tree of the directory on the given path with the secrets
+// in the directory of which the latest versions are given by path.
+func fakeSecretTree(dirPath string, versions map[string]int) *api.Tree {
+	rootDir := &api.Dir{DirID: uuid.New(), Name: gopath.Base(dirPath)}
+	tree := &api.Tree{
+		RootDir: rootDir,
+		Dirs:    map[uuid.UUID]*api.Dir{rootDir.DirID: rootDir},
+		Secrets: map[uuid.UUID]*api.Secret{},
+	}
+	for path, version := range versions {
+		if gopath.Dir(path) != dirPath {
+			continue
+		}
+		secret := &api.Secret{SecretID: uuid.New(), DirID: rootDir.DirID, Name: gopath.Base(path), LatestVersion: version}
+		tree.Secrets[secret.SecretID] = secret
+	}
+	return tree
+}
+
+func TestRunCommand_RunWatch(t *testing.T) {
+	scriptFile := filepath.Join(os.TempDir(), "watch.sh")
+	script := "echo $TEST\nif [ \"$TEST\" = first ]; then exec sleep 10; fi"
+	err := os.WriteFile(scriptFile, []byte(script), os.ModePerm)
+	assert.OK(t, err)
+	defer os.Remove(scriptFile)
+
+	// The second version of the secret is written right after the first version is read.
+	// This is detected as a change, so the command is restarted with the second version.
+	var latest int32 = 1
+
+	fakeIO := fakeui.NewIO(t)
+	cmd := RunCommand{
+		io:                fakeIO,
+		command:           cli.StringListValue{"/bin/sh", scriptFile},
+		noMasking:         true,
+		watch:             true,
+		watchInterval:     time.Millisecond * 10,
+		reloadSignal:      newSignalValue(syscall.SIGTERM),
+		reloadGracePeriod: time.Second,
+		environment: &environment{
+			osStat: osStatFunc("secrethub.env", os.ErrNotExist),
+			envar: map[string]string{
+				"TEST": "namespace/repo/test",
+			},
+		},
+		newClient: func() (secrethub.ClientInterface, error) {
+			return fakeclient.Client{
+				SecretService: &fakeclient.SecretService{
+					VersionService: &fakeclient.SecretVersionService{
+						GetWithDataFunc: func(path string) (*api.SecretVersion, error) {
+							if atomic.SwapInt32(&latest, 2) == 1 {
+								return &api.SecretVersion{Version: 1, Data: []byte("first")}, nil
+							}
+							return &api.SecretVersion{Version: 2, Data: []byte("second")}, nil
+						},
+						GetWithoutDataFunc: func(path string) (*api.SecretVersion, error) {
+							return &api.SecretVersion{Version: int(atomic.LoadInt32(&latest))}, nil
+						},
+					},
+				},
+			}, nil
+		},
+	}
+
+	err = cmd.Run()
+	assert.OK(t, err)
+
+	stdout, err := fakeIO.ReadStdout()
+	assert.OK(t, err)
+	assert.Equal(t, string(stdout), "first\nsecond\n")
+}
+
+func TestSignalValue_Set(t *testing.T) {
+	cases := map[string]struct {
+		in       string
+		expected syscall.Signal
+		err      error
+	}{
+		"full name": {
+			in:       "SIGTERM",
+			expected: syscall.SIGTERM,
+		},
+		"without prefix": {
+			in:       "HUP",
+			expected: syscall.SIGHUP,
+		},
+		"lowercase": {
+			in:       "sigint",
+			expected: syscall.SIGINT,
+		},
+		"unknown": {
+			in:  "SIGFOO",
+			err: ErrUnknownSignal("SIGFOO"),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			var v signalValue
+			err := v.Set(tc.in)
+			assert.Equal(t, err, tc.err)
+			if err == nil {
+				assert.Equal(t, v.signal, tc.expected)
+			}
+		})
+	}
+}
+
+func TestSignalValue_String(t *testing.T) {
+	v := newSignalValue(syscall.SIGTERM)
+	assert.Equal(t, v.String(), "SIGTERM")
+}
@@ -0,0 +1,401 @@
+package secrethub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/secrethub/secrethub-cli/internals/cli"
+	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl"
+
+	"github.com/secrethub/secrethub-go/internals/crypto"
+	"github.com/secrethub/secrethub-go/internals/errio"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Errors
+var (
+	errCache                 = errio.Namespace("cache")
+	ErrSecretNotCached       = errCache.Code("secret_not_cached").ErrorPref("secret %s is not available in the cache, it cannot be read with --offline")
+	ErrDirNotCached          = errCache.Code("dir_not_cached").ErrorPref("the secrets in directory %s are not cached, they cannot be listed with --offline")
+	ErrCacheKeyUnavailable   = errCache.Code("key_unavailable").ErrorPref("the secret cache can only be used with a credential key: %s")
+	ErrCannotWriteCacheEntry = errCache.Code("write_failed").ErrorPref("could not write secret %s to the cache: %s")
+	ErrCannotPruneCache      = errCache.Code("prune_failed").ErrorPref("could not remove expired secrets from the cache: %s")
+	ErrAllowStaleWithoutTTL  = errCache.Code("allow_stale_without_ttl").Error("--allow-stale can only be used when caching is enabled with --cache-ttl or --offline")
+)
+
+const (
+	// secretCacheDirName is the name of the directory in the configuration directory in which secrets are cached.
+	secretCacheDirName = "cache"
+	// secretCacheKeyInfo is used to derive the key with which the cache is encrypted from the credential.
+	secretCacheKeyInfo = "secrethub-cli secret cache v1"
+)
+
+// secretCacheOptions configures caching of secrets on disk.
+// The cache is disabled when neither a TTL nor offline mode is set.
+type secretCacheOptions struct {
+	ttl             time.Duration
+	offline         bool
+	allowStale      bool
+	credentialStore CredentialConfig
+}
+
+func (o *secretCacheOptions) register(clause *cli.CommandClause) {
+	clause.Flags().DurationVar(&o.ttl, "cache-ttl", 0, "Cache secrets on disk, encrypted with a key derived from your credential, and use cached secrets that are younger than the given duration instead of fetching them. Older secrets are removed from the cache, unless --allow-stale is set. Caching is disabled when set to 0.")
+	clause.Flags().BoolVar(&o.offline, "offline", false, "Do not connect to the API and read all secrets from the cache, regardless of their age.")
+	clause.Flags().BoolVar(&o.allowStale, "allow-stale", false, "Use cached secrets that are older than --cache-ttl when the API cannot be reached. Can only be used together with --cache-ttl or --offline.")
+}
+
+// enabled returns whether secrets should be cached.
+func (o secretCacheOptions) enabled() bool {
+	return o.ttl > 0 || o.offline
+}
+
+// validate returns an error when the flags are set in a combination that has no effect.
+func (o secretCacheOptions) validate() error {
+	if o.allowStale && !o.enabled() {
+		return ErrAllowStaleWithoutTTL
+	}
+	return nil
+}
+
+// wrap returns a secret reader that caches the secrets read by the given secret reader.
+func (o secretCacheOptions) wrap(sr tpl.SecretReader) (*cachedSecretReader, error) {
+	key, err := o.deriveKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return &cachedSecretReader{
+		secretReader: sr,
+		cache: &secretCache{
+			dir: filepath.Join(o.credentialStore.ConfigDir().Path(), secretCacheDirName),
+			key: key,
+		},
+		ttl:        o.ttl,
+		offline:    o.offline,
+		allowStale: o.allowStale,
+		now:        time.Now,
+		warnings:   os.Stderr,
+	}, nil
+}
+
+// deriveKey derives the key that is used to encrypt the cache from the account credential.
+func (o secretCacheOptions) deriveKey() (*crypto.SymmetricKey, error) {
+	credential, err := o.credentialStore.Import()
+	if err != nil {
+		return nil, ErrCacheKeyUnavailable(err)
+	}
+
+	exported, err := credential.Export()
+	if err != nil {
+		return nil, ErrCacheKeyUnavailable(err)
+	}
+
+	key := make([]byte, crypto.SymmetricKeyLength)
+	_, err = io.ReadFull(hkdf.New(sha256.New, exported, nil, []byte(secretCacheKeyInfo)), key)
+	if err != nil {
+		return nil, ErrCacheKeyUnavailable(err)
+	}
+
+	return crypto.NewSymmetricKey(key), nil
+}
+
+// cachedSecret is a secret stored in the cache.
+type cachedSecret struct {
+	Value     string    `json:"value"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// secretCache stores secrets in files in a directory. Both the secret
+// and the time it was fetched are encrypted with the key. File names are
+// derived from a HMAC of the secret path, so they do not reveal the path.
+type secretCache struct {
+	dir string
+	key *crypto.SymmetricKey
+}
+
+// get returns the cached secret for the given path or nil if the path is not cached.
+// Entries that cannot be decrypted, e.g. because they were written with another
+// credential, are treated as not cached.
+func (c *secretCache) get(path string) (*cachedSecret, error) {
+	filename, err := c.filename(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var ciphertext crypto.CiphertextAES
+	err = json.Unmarshal(raw, &ciphertext)
+	if err != nil {
+		return nil, nil
+	}
+
+	plaintext, err := c.key.Decrypt(ciphertext)
+	if err != nil {
+		return nil, nil
+	}
+
+	var secret cachedSecret
+	err = json.Unmarshal(plaintext, &secret)
+	if err != nil {
+		return nil, nil
+	}
+	return &secret, nil
+}
+
+// put stores the secret for the given path in the cache.
+// The file is written atomically, so a concurrent get never reads a partially written entry.
+func (c *secretCache) put(path string, secret cachedSecret) error {
+	plaintext, err := json.Marshal(secret)
+	if err != nil {
+		return err
+	}
+
+	ciphertext, err := c.key.Encrypt(plaintext)
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(ciphertext)
+	if err != nil {
+		return err
+	}
+
+	filename, err := c.filename(path)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(c.dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(c.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(raw)
+	if err != nil {
+		_ = tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
+
+// remove removes the cached secret for the given path, if it is cached.
+func (c *secretCache) remove(path string) error {
+	filename, err := c.filename(path)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// prune removes all cached secrets that were written before the given time.
+// As entries can be written with another credential, the modification time
+// of the files is used instead of the time stored in the entries.
+func (c *secretCache) prune(before time.Time) error {
+	files, err := os.ReadDir(c.dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if !file.Type().IsRegular() {
+			continue
+		}
+		info, err := file.Info()
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if !info.ModTime().Before(before) {
+			continue
+		}
+		err = os.Remove(filepath.Join(c.dir, file.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// filename returns the name of the file in which the secret at the given path is cached.
+func (c *secretCache) filename(path string) (string, error) {
+	mac, err := c.key.HMAC([]byte(path))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(c.dir, hex.EncodeToString(mac)), nil
+}
+
+// cachedSecretReader reads secrets from the cache when they are younger than the TTL
+// and reads them with the underlying secret reader otherwise, storing the result in the cache.
+// Unless stale secrets are allowed, secrets older than the TTL are removed from the cache.
+type cachedSecretReader struct {
+	secretReader tpl.SecretReader
+	cache        *secretCache
+	ttl          time.Duration
+	offline      bool
+	allowStale   bool
+	now          func() time.Time
+	// warnings receives the errors of writing to the cache, which do not stop the secrets from being read.
+	warnings io.Writer
+
+	mutex      sync.Mutex
+	stalePaths []string
+	pruneOnce  sync.Once
+}
+
+// ReadSecret reads the secret from the cache if it is fresh enough, or fetches it otherwise.
+// In offline mode, the secret is always read from the cache. When fetching the secret fails
+// because the API cannot be reached and stale secrets are allowed, the cached secret is returned.
+func (sr *cachedSecretReader) ReadSecret(path string) (string, error) {
+	cached, err := sr.cache.get(path)
+	if err != nil {
+		return "", err
+	}
+
+	if cached != nil {
+		fresh := sr.now().Sub(cached.FetchedAt) < sr.ttl
+		if fresh {
+			return cached.Value, nil
+		}
+		if sr.offline {
+			sr.addStalePath(path)
+			return cached.Value, nil
+		}
+		if !sr.allowStale {
+			err = sr.cache.remove(path)
+			if err != nil {
+				sr.warn(ErrCannotPruneCache(err))
+			}
+			cached = nil
+		}
+	}
+
+	if sr.offline {
+		return "", ErrSecretNotCached(path)
+	}
+
+	value, err := sr.secretReader.ReadSecret(path)
+	if err != nil {
+		if cached != nil && sr.allowStale && isAPIUnreachable(err) {
+			sr.addStalePath(path)
+			return cached.Value, nil
+		}
+		return "", err
+	}
+
+	// Writing to the cache is best-effort: the secret has been read, so it is returned regardless.
+	err = sr.cache.put(path, cachedSecret{
+		Value:     value,
+		FetchedAt: sr.now(),
+	})
+	if err != nil {
+		sr.warn(ErrCannotWriteCacheEntry(path, err))
+	}
+
+	if !sr.allowStale {
+		sr.pruneOnce.Do(func() {
+			err := sr.cache.prune(sr.now().Add(-sr.ttl))
+			if err != nil {
+				sr.warn(ErrCannotPruneCache(err))
+			}
+		})
+	}
+
+	return value, nil
+}
+
+// warn writes the given error to the warnings writer.
+func (sr *cachedSecretReader) warn(err error) {
+	if sr.warnings == nil {
+		return
+	}
+	fmt.Fprintf(sr.warnings, "WARN: %s\n", err)
+}
+
+// ListSecrets lists the secrets in the directory using the underlying secret reader.
+// Directory listings are not cached, so they are not available in offline mode.
+func (sr *cachedSecretReader) ListSecrets(dirPath string) ([]string, error) {
+	if sr.offline {
+		return nil, ErrDirNotCached(dirPath)
+	}
+	return listSecrets(sr.secretReader, dirPath)
+}
+
+func (sr *cachedSecretReader) addStalePath(path string) {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+	sr.stalePaths = append(sr.stalePaths, path)
+}
+
+// StalePaths returns the paths of the secrets that were read from the cache even though they are older than the TTL.
+func (sr *cachedSecretReader) StalePaths() []string {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	paths := make([]string, len(sr.stalePaths))
+	copy(paths, sr.stalePaths)
+	sort.Strings(paths)
+	return paths
+}
+
+// warnStale writes a warning to the given writer with the paths of the secrets that
+// were read from the cache even though they are older than the TTL.
+func (sr *cachedSecretReader) warnStale(w io.Writer) {
+	paths := sr.StalePaths()
+	if len(paths) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w, "WARN: The following secrets were read from the cache and may be outdated:")
+	for _, path := range paths {
+		fmt.Fprintf(w, "  - %s\n", path)
+	}
+}
+
+// isAPIUnreachable returns whether the given error is returned because the API
+// could not be reached or is temporarily unavailable.
+func isAPIUnreachable(err error) bool {
+	switch e := err.(type) {
+	case errio.PublicError:
+		return e.Namespace == "http" && (e.Code == "request_failed" || e.Code == "timeout")
+	case errio.PublicStatusError:
+		return e.StatusCode >= 500
+	}
+	return false
+}
@@ -0,0 +1,289 @@
+package secrethub
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl"
+
+	"github.com/secrethub/secrethub-go/internals/assert"
+	"github.com/secrethub/secrethub-go/internals/crypto"
+	"github.com/secrethub/secrethub-go/internals/errio"
+)
+
+type errSecretReader struct {
+	err error
+}
+
+func (sr errSecretReader) ReadSecret(path string) (string, error) {
+	return "", sr.err
+}
+
+func TestCachedSecretReader_ReadSecret(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	errRequestFailed := errio.Namespace("http").Code("request_failed").Error("request to API server failed")
+	errServer := errio.Namespace("server").Code("unavailable").StatusError("service unavailable", http.StatusServiceUnavailable)
+	errNotFound := errors.New("secret not found")
+
+	cases := map[string]struct {
+		cached             *cachedSecret
+		secretReader       tpl.SecretReader
+		ttl                time.Duration
+		offline            bool
+		allowStale         bool
+		expected           string
+		expectedStalePaths []string
+		expectedCached     string
+		err                error
+	}{
+		"not cached": {
+			secretReader:   &countingSecretReader{secrets: map[string]string{"namespace/repo/foo": "new"}},
+			ttl:            time.Hour,
+			expected:       "new",
+			expectedCached: "new",
+		},
+		"fresh": {
+			cached:         &cachedSecret{Value: "old", FetchedAt: now.Add(-time.Minute)},
+			secretReader:   errSecretReader{err: errNotFound},
+			ttl:            time.Hour,
+			expected:       "old",
+			expectedCached: "old",
+		},
+		"expired": {
+			cached:         &cachedSecret{Value: "old", FetchedAt: now.Add(-2 * time.Hour)},
+			secretReader:   &countingSecretReader{secrets: map[string]string{"namespace/repo/foo": "new"}},
+			ttl:            time.Hour,
+			expected:       "new",
+			expectedCached: "new",
+		},
+		"offline": {
+			cached:             &cachedSecret{Value: "old", FetchedAt: now.Add(-2 * time.Hour)},
+			secretReader:       errSecretReader{err: errNotFound},
+			offline:            true,
+			expected:           "old",
+			expectedStalePaths: []string{"namespace/repo/foo"},
+			expectedCached:     "old",
+		},
+		"offline not cached": {
+			secretReader: errSecretReader{err: errNotFound},
+			offline:      true,
+			err:          ErrSecretNotCached("namespace/repo/foo"),
+		},
+		"stale on request failure": {
+			cached:             &cachedSecret{Value: "old", FetchedAt: now.Add(-2 * time.Hour)},
+			secretReader:       errSecretReader{err: errRequestFailed},
+			ttl:                time.Hour,
+			allowStale:         true,
+			expected:           "old",
+			expectedStalePaths: []string{"namespace/repo/foo"},
+			expectedCached:     "old",
+		},
+		"stale on server error": {
+			cached:             &cachedSecret{Value: "old", FetchedAt: now.Add(-2 * time.Hour)},
+			secretReader:       errSecretReader{err: errServer},
+			ttl:                time.Hour,
+			allowStale:         true,
+			expected:           "old",
+			expectedStalePaths: []string{"namespace/repo/foo"},
+			expectedCached:     "old",
+		},
+		"stale not allowed": {
+			cached:       &cachedSecret{Value: "old", FetchedAt: now.Add(-2 * time.Hour)},
+			secretReader: errSecretReader{err: errRequestFailed},
+			ttl:          time.Hour,
+			err:          errRequestFailed,
+		},
+		"stale not used on other errors": {
+			cached:         &cachedSecret{Value: "old", FetchedAt: now.Add(-2 * time.Hour)},
+			secretReader:   errSecretReader{err: errNotFound},
+			ttl:            time.Hour,
+			allowStale:     true,
+			err:            errNotFound,
+			expectedCached: "old",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			cache := &secretCache{
+				dir: filepath.Join(t.TempDir(), "cache"),
+				key: crypto.NewSymmetricKey(bytes.Repeat([]byte{1}, crypto.SymmetricKeyLength)),
+			}
+			if tc.cached != nil {
+				err := cache.put("namespace/repo/foo", *tc.cached)
+				assert.OK(t, err)
+			}
+
+			sr := &cachedSecretReader{
+				secretReader: tc.secretReader,
+				cache:        cache,
+				ttl:          tc.ttl,
+				offline:      tc.offline,
+				allowStale:   tc.allowStale,
+				now: func() time.Time {
+					return now
+				},
+			}
+
+			actual, err := sr.ReadSecret("namespace/repo/foo")
+			assert.Equal(t, err, tc.err)
+			assert.Equal(t, actual, tc.expected)
+			assert.Equal(t, sr.StalePaths(), append([]string{}, tc.expectedStalePaths...))
+
+			cached, err := cache.get("namespace/repo/foo")
+			assert.OK(t, err)
+			if tc.expectedCached == "" {
+				assert.Equal(t, cached, (*cachedSecret)(nil))
+			} else {
+				assert.Equal(t, cached.Value, tc.expectedCached)
+			}
+		})
+	}
+}
+
+func TestSecretCache(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cache")
+	cache := &secretCache{
+		dir: dir,
+		key: crypto.NewSymmetricKey(bytes.Repeat([]byte{1}, crypto.SymmetricKeyLength)),
+	}
+
+	err := cache.put("namespace/repo/foo", cachedSecret{Value: "foo"})
+	assert.OK(t, err)
+
+	files, err := os.ReadDir(dir)
+	assert.OK(t, err)
+	assert.Equal(t, len(files), 1)
+
+	raw, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	assert.OK(t, err)
+	assert.Equal(t, bytes.Contains(raw, []byte("foo")), false)
+
+	// An entry written with another key is not readable.
+	otherCache := &secretCache{
+		dir: dir,
+		key: crypto.NewSymmetricKey(bytes.Repeat([]byte{2}, crypto.SymmetricKeyLength)),
+	}
+	actual, err := otherCache.get("namespace/repo/foo")
+	assert.OK(t, err)
+	assert.Equal(t, actual, (*cachedSecret)(nil))
+
+	actual, err = cache.get("namespace/repo/foo")
+	assert.OK(t, err)
+	assert.Equal(t, actual.Value, "foo")
+}
+
+func TestCachedSecretReader_ReadSecret_WriteFailure(t *testing.T) {
+	// The cache directory cannot be created, because a broken symlink is in its place.
+	dir := filepath.Join(t.TempDir(), "cache")
+	err := os.Symlink(filepath.Join(t.TempDir(), "missing", "cache"), dir)
+	assert.OK(t, err)
+
+	var warnings bytes.Buffer
+	sr := &cachedSecretReader{
+		secretReader: &countingSecretReader{secrets: map[string]string{"namespace/repo/foo": "new"}},
+		cache: &secretCache{
+			dir: dir,
+			key: crypto.NewSymmetricKey(bytes.Repeat([]byte{1}, crypto.SymmetricKeyLength)),
+		},
+		ttl:      time.Hour,
+		now:      time.Now,
+		warnings: &warnings,
+	}
+
+	actual, err := sr.ReadSecret("namespace/repo/foo")
+	assert.OK(t, err)
+	assert.Equal(t, actual, "new")
+	assert.Equal(t, strings.HasPrefix(warnings.String(), "WARN: could not write secret namespace/repo/foo to the cache: "), true)
+}
+
+func TestCachedSecretReader_Prune(t *testing.T) {
+	cases := map[string]struct {
+		allowStale bool
+		expected   []string
+	}{
+		"expired entries removed": {
+			expected: []string{"namespace/repo/fresh", "namespace/repo/new"},
+		},
+		"stale allowed": {
+			allowStale: true,
+			expected:   []string{"namespace/repo/expired", "namespace/repo/fresh", "namespace/repo/new"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			cache := &secretCache{
+				dir: filepath.Join(t.TempDir(), "cache"),
+				key: crypto.NewSymmetricKey(bytes.Repeat([]byte{1}, crypto.SymmetricKeyLength)),
+			}
+			now := time.Now()
+
+			for path, age := range map[string]time.Duration{
+				"namespace/repo/fresh":   time.Minute,
+				"namespace/repo/expired": 2 * time.Hour,
+			} {
+				err := cache.put(path, cachedSecret{Value: "old", FetchedAt: now.Add(-age)})
+				assert.OK(t, err)
+				filename, err := cache.filename(path)
+				assert.OK(t, err)
+				err = os.Chtimes(filename, now.Add(-age), now.Add(-age))
+				assert.OK(t, err)
+			}
+
+			sr := &cachedSecretReader{
+				secretReader: &countingSecretReader{secrets: map[string]string{"namespace/repo/new": "new"}},
+				cache:        cache,
+				ttl:          time.Hour,
+				allowStale:   tc.allowStale,
+				now: func() time.Time {
+					return now
+				},
+			}
+
+			_, err := sr.ReadSecret("namespace/repo/new")
+			assert.OK(t, err)
+
+			var actual []string
+			for _, path := range []string{"namespace/repo/expired", "namespace/repo/fresh", "namespace/repo/new"} {
+				cached, err := cache.get(path)
+				assert.OK(t, err)
+				if cached != nil {
+					actual = append(actual, path)
+				}
+			}
+			assert.Equal(t, actual, tc.expected)
+		})
+	}
+}
+
+func TestSecretCacheOptions_validate(t *testing.T) {
+	cases := map[string]struct {
+		options secretCacheOptions
+		err     error
+	}{
+		"allow stale with ttl": {
+			options: secretCacheOptions{ttl: time.Hour, allowStale: true},
+		},
+		"allow stale offline": {
+			options: secretCacheOptions{offline: true, allowStale: true},
+		},
+		"allow stale without ttl": {
+			options: secretCacheOptions{allowStale: true},
+			err:     ErrAllowStaleWithoutTTL,
+		},
+		"cache disabled": {},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.options.validate(), tc.err)
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package secrethub
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/secrethub/secrethub-cli/internals/cli/filemode"
+)
+
+// Errors
+var (
+	ErrSecretFilesNotInMemory = errRun.Code("secret_files_not_in_memory").ErrorPref("%s is not on a tmpfs or ramfs file system, so secrets written to it could end up on disk")
+	ErrCannotWriteSecretFile  = errRun.Code("cannot_write_secret_file").ErrorPref("could not write the secret file for %s: %s")
+	ErrCannotRemoveSecretDir  = errRun.Code("cannot_remove_secret_dir").ErrorPref("could not remove the directory with secret files %s: %s")
+	ErrSecretFileEnvExists    = errRun.Code("secret_file_env_exists").ErrorPref("cannot pass the secret in %s as a file: %s is already set")
+)
+
+const (
+	// secretFileEnvSuffix is appended to the name of an environment variable to get the
+	// name of the environment variable that contains the path of the file with its value.
+	secretFileEnvSuffix = "_FILE"
+)
+
+// secretFileMode is the file mode of the files that contain secrets, which can only be read by the owner.
+var secretFileMode = filemode.New(0400)
+
+// secretFiles is a private directory in which environment variables are written to files.
+type secretFiles struct {
+	dir string
+}
+
+// newSecretFiles creates a private directory for secret files in the given parent directory.
+func newSecretFiles(parent string) (*secretFiles, error) {
+	err := os.MkdirAll(parent, 0700)
+	if err != nil {
+		return nil, ErrCannotWrite(parent, err)
+	}
+
+	err = checkMemoryFS(parent)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp(parent, "secrethub-")
+	if err != nil {
+		return nil, ErrCannotWrite(parent, err)
+	}
+
+	return &secretFiles{
+		dir: dir,
+	}, nil
+}
+
+// write writes the value of the environment variable with the given name to a file
+// that can only be read by the owner and returns the path of the file.
+func (f *secretFiles) write(name string, value string) (string, error) {
+	path := filepath.Join(f.dir, name)
+	err := os.WriteFile(path, []byte(value), secretFileMode.FileMode())
+	if err != nil {
+		return "", ErrCannotWriteSecretFile(name, err)
+	}
+	return path, nil
+}
+
+// remove removes the directory and all secret files in it.
+func (f *secretFiles) remove() error {
+	err := os.RemoveAll(f.dir)
+	if err != nil {
+		return ErrCannotRemoveSecretDir(f.dir, err)
+	}
+	return nil
+}
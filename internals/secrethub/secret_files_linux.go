@@ -0,0 +1,21 @@
+package secrethub
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// checkMemoryFS returns an error when the given directory is not on a file system that is kept in memory.
+func checkMemoryFS(dir string) error {
+	var stat unix.Statfs_t
+	err := unix.Statfs(dir, &stat)
+	if err != nil {
+		return ErrCannotWrite(dir, err)
+	}
+
+	// The type is a signed 32 bit integer on some architectures, in which RAMFS_MAGIC does not fit.
+	switch uint32(stat.Type) {
+	case unix.TMPFS_MAGIC, unix.RAMFS_MAGIC:
+		return nil
+	}
+	return ErrSecretFilesNotInMemory(dir)
+}
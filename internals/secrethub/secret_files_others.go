@@ -0,0 +1,9 @@
+//go:build !linux
+
+package secrethub
+
+// checkMemoryFS cannot determine whether a directory is kept in memory on this platform,
+// so it is up to the user to choose a directory that is not backed by a disk.
+func checkMemoryFS(dir string) error {
+	return nil
+}
@@ -1,10 +1,22 @@
 package secrethub
 
 import (
+	"fmt"
+	"io"
+	"sync"
+
 	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl"
 	"github.com/secrethub/secrethub-go/internals/api"
 )
 
+// Errors
+var (
+	ErrCannotGenerateSecret = errMain.Code("cannot_generate_secret").ErrorPref("could not write generated secret %s: %s")
+)
+
+// secretReadConcurrency is the maximum number of secrets that are read simultaneously when prefetching secrets.
+const secretReadConcurrency = 10
+
 type secretReader struct {
 	newClient newClientFunc
 }
@@ -31,17 +43,47 @@ func (sr secretReader) ReadSecret(path string) (string, error) {
 	return string(secret.Data), nil
 }
 
+// ListSecrets returns the paths of the secrets directly in the directory, using the provided client.
+func (sr secretReader) ListSecrets(dirPath string) ([]string, error) {
+	client, err := sr.newClient()
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := client.Dirs().GetTree(dirPath, 1, false)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	for id, secret := range tree.Secrets {
+		if secret.DirID != tree.RootDir.DirID {
+			continue
+		}
+
+		path, err := tree.AbsSecretPath(id)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path.String())
+	}
+	return paths, nil
+}
+
 type bufferedSecretReader struct {
 	secretReader tpl.SecretReader
 	secretsRead  []string
+	pathsRead    []string
 }
 
 // newBufferedSecretReader wraps a secret reader and stores the retrieved
-// secret values for retrieval with the Values function.
+// secret values for retrieval with the Values function and the paths
+// of the read secrets for retrieval with the Paths function.
 func newBufferedSecretReader(sr tpl.SecretReader) *bufferedSecretReader {
 	return &bufferedSecretReader{
 		secretReader: sr,
 		secretsRead:  []string{},
+		pathsRead:    []string{},
 	}
 }
 
@@ -52,11 +94,17 @@ func (sr *bufferedSecretReader) ReadSecret(path string) (string, error) {
 
 	if err == nil {
 		sr.secretsRead = append(sr.secretsRead, secret)
+		sr.pathsRead = append(sr.pathsRead, path)
 	}
 
 	return secret, err
 }
 
+// ListSecrets lists the secrets in the directory using the underlying secret reader.
+func (sr *bufferedSecretReader) ListSecrets(dirPath string) ([]string, error) {
+	return listSecrets(sr.secretReader, dirPath)
+}
+
 type secretReaderNotAllowed struct{}
 
 func (sr secretReaderNotAllowed) ReadSecret(path string) (string, error) {
@@ -68,6 +116,11 @@ func (sr bufferedSecretReader) Values() []string {
 	return sr.secretsRead
 }
 
+// Paths returns a list of the paths of the secrets read with this secret reader.
+func (sr bufferedSecretReader) Paths() []string {
+	return sr.pathsRead
+}
+
 type ignoreMissingSecretReader struct {
 	secretReader tpl.SecretReader
 }
@@ -87,3 +140,210 @@ func (sr *ignoreMissingSecretReader) ReadSecret(path string) (string, error) {
 	}
 	return secret, err
 }
+
+// ListSecrets uses the underlying secret reader to list the secrets in the directory,
+// but ignores errors for non-existing directories. Instead, it returns no secrets.
+func (sr *ignoreMissingSecretReader) ListSecrets(dirPath string) ([]string, error) {
+	paths, err := listSecrets(sr.secretReader, dirPath)
+	if api.IsErrNotFound(err) {
+		return []string{}, nil
+	}
+	return paths, err
+}
+
+// readResult is the outcome of reading a single secret.
+type readResult struct {
+	value string
+	err   error
+}
+
+type prefetchSecretReader struct {
+	secretReader tpl.SecretReader
+	concurrency  int
+
+	mutex   sync.Mutex
+	results map[string]readResult
+}
+
+// newPrefetchSecretReader wraps a secret reader so that secrets can be read
+// ahead of time with Prefetch. Every secret path is read at most once.
+func newPrefetchSecretReader(sr tpl.SecretReader, concurrency int) *prefetchSecretReader {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &prefetchSecretReader{
+		secretReader: sr,
+		concurrency:  concurrency,
+		results:      make(map[string]readResult),
+	}
+}
+
+// Prefetch reads the secrets on the given paths concurrently, using at most the configured
+// number of simultaneous reads. Every unique path is only read once. Errors are not returned
+// by Prefetch, but by the first call to ReadSecret for the path that caused them.
+func (sr *prefetchSecretReader) Prefetch(paths []string) {
+	var todo []string
+	seen := make(map[string]struct{}, len(paths))
+	sr.mutex.Lock()
+	for _, path := range paths {
+		if _, ok := sr.results[path]; ok {
+			continue
+		}
+		if _, ok := seen[path]; ok {
+			continue
+		}
+		seen[path] = struct{}{}
+		todo = append(todo, path)
+	}
+	sr.mutex.Unlock()
+
+	workers := sr.concurrency
+	if len(todo) < workers {
+		workers = len(todo)
+	}
+
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for path := range jobs {
+				value, err := sr.secretReader.ReadSecret(path)
+				sr.store(path, value, err)
+			}
+		}()
+	}
+
+	for _, path := range todo {
+		jobs <- path
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// ReadSecret returns the prefetched result for the given path. If the secret has
+// not been prefetched, it is read using the underlying secret reader.
+func (sr *prefetchSecretReader) ReadSecret(path string) (string, error) {
+	sr.mutex.Lock()
+	result, ok := sr.results[path]
+	sr.mutex.Unlock()
+	if ok {
+		return result.value, result.err
+	}
+
+	value, err := sr.secretReader.ReadSecret(path)
+	sr.store(path, value, err)
+	return value, err
+}
+
+// ListSecrets lists the secrets in the directory using the underlying secret reader.
+func (sr *prefetchSecretReader) ListSecrets(dirPath string) ([]string, error) {
+	return listSecrets(sr.secretReader, dirPath)
+}
+
+func (sr *prefetchSecretReader) store(path string, value string, err error) {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+	sr.results[path] = readResult{
+		value: value,
+		err:   err,
+	}
+}
+
+// listSecrets lists the secrets in the directory with the given secret reader,
+// if it supports listing directories.
+func listSecrets(sr tpl.SecretReader, dirPath string) ([]string, error) {
+	dirReader, ok := sr.(tpl.DirReader)
+	if !ok {
+		return nil, tpl.ErrDirsNotSupported
+	}
+	return dirReader.ListSecrets(dirPath)
+}
+
+// generatedSecret is a secret that has been generated because it did not exist.
+type generatedSecret struct {
+	path    string
+	version int
+}
+
+type generatingSecretReader struct {
+	secretReader tpl.SecretReader
+	newClient    newClientFunc
+
+	values    map[string]string
+	generated []generatedSecret
+}
+
+// newGeneratingSecretReader wraps a secret reader so that secrets that do not exist are
+// generated and written when they are read by a secret tag with a generate filter.
+func newGeneratingSecretReader(sr tpl.SecretReader, newClient newClientFunc) *generatingSecretReader {
+	return &generatingSecretReader{
+		secretReader: sr,
+		newClient:    newClient,
+		values:       make(map[string]string),
+	}
+}
+
+// ReadSecret returns the value of the secret if it has been generated by this secret reader
+// and reads it using the underlying secret reader otherwise.
+func (sr *generatingSecretReader) ReadSecret(path string) (string, error) {
+	value, ok := sr.values[path]
+	if ok {
+		return value, nil
+	}
+	return sr.secretReader.ReadSecret(path)
+}
+
+// ListSecrets lists the secrets in the directory using the underlying secret reader.
+func (sr *generatingSecretReader) ListSecrets(dirPath string) ([]string, error) {
+	return listSecrets(sr.secretReader, dirPath)
+}
+
+// GenerateSecret generates a random value for the secret with the same generator as the generate command
+// uses and writes it. Every secret is generated once, after which ReadSecret returns its value.
+func (sr *generatingSecretReader) GenerateSecret(path string, options tpl.GenerateOptions) (string, error) {
+	value, ok := sr.values[path]
+	if ok {
+		return value, nil
+	}
+
+	generator, err := newTemplateGenerator(options)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := generator.Generate(options.Length)
+	if err != nil {
+		return "", err
+	}
+
+	client, err := sr.newClient()
+	if err != nil {
+		return "", err
+	}
+
+	version, err := client.Secrets().Write(path, data)
+	if err != nil {
+		return "", ErrCannotGenerateSecret(path, err)
+	}
+
+	sr.values[path] = string(data)
+	sr.generated = append(sr.generated, generatedSecret{
+		path:    path,
+		version: version.Version,
+	})
+	return string(data), nil
+}
+
+// printGenerated writes a summary of the secrets that have been generated to the given writer.
+func (sr *generatingSecretReader) printGenerated(w io.Writer) {
+	if len(sr.generated) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w, "The following missing secrets have been generated:")
+	for _, secret := range sr.generated {
+		fmt.Fprintf(w, "  - %s:%d\n", secret.path, secret.version)
+	}
+}
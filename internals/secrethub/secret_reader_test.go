@@ -0,0 +1,227 @@
+package secrethub
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl"
+
+	"github.com/secrethub/secrethub-go/internals/api"
+	"github.com/secrethub/secrethub-go/internals/api/uuid"
+	"github.com/secrethub/secrethub-go/internals/assert"
+	"github.com/secrethub/secrethub-go/pkg/secrethub"
+	"github.com/secrethub/secrethub-go/pkg/secrethub/fakeclient"
+)
+
+type countingSecretReader struct {
+	secrets map[string]string
+	mutex   sync.Mutex
+	reads   []string
+}
+
+func (sr *countingSecretReader) ReadSecret(path string) (string, error) {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	sr.reads = append(sr.reads, path)
+	secret, ok := sr.secrets[path]
+	if !ok {
+		return "", errors.New("secret not found: " + path)
+	}
+	return secret, nil
+}
+
+func TestPrefetchSecretReader(t *testing.T) {
+	cases := map[string]struct {
+		secrets       map[string]string
+		prefetch      []string
+		read          []string
+		expected      []string
+		expectedErr   error
+		expectedReads []string
+	}{
+		"read once per path": {
+			secrets: map[string]string{
+				"namespace/repo/foo": "foo",
+				"namespace/repo/bar": "bar",
+			},
+			prefetch:      []string{"namespace/repo/foo", "namespace/repo/bar", "namespace/repo/foo"},
+			read:          []string{"namespace/repo/foo", "namespace/repo/bar", "namespace/repo/foo"},
+			expected:      []string{"foo", "bar", "foo"},
+			expectedReads: []string{"namespace/repo/bar", "namespace/repo/foo"},
+		},
+		"read not prefetched": {
+			secrets: map[string]string{
+				"namespace/repo/foo": "foo",
+				"namespace/repo/bar": "bar",
+			},
+			prefetch:      []string{"namespace/repo/foo"},
+			read:          []string{"namespace/repo/foo", "namespace/repo/bar", "namespace/repo/bar"},
+			expected:      []string{"foo", "bar", "bar"},
+			expectedReads: []string{"namespace/repo/bar", "namespace/repo/foo"},
+		},
+		"error returned on read": {
+			secrets: map[string]string{
+				"namespace/repo/foo": "foo",
+			},
+			prefetch:      []string{"namespace/repo/foo", "namespace/repo/missing"},
+			read:          []string{"namespace/repo/foo", "namespace/repo/missing"},
+			expected:      []string{"foo"},
+			expectedErr:   errors.New("secret not found: namespace/repo/missing"),
+			expectedReads: []string{"namespace/repo/foo", "namespace/repo/missing"},
+		},
+		"nothing to prefetch": {
+			expectedReads: nil,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			underlying := &countingSecretReader{secrets: tc.secrets}
+			sr := newPrefetchSecretReader(underlying, 2)
+
+			sr.Prefetch(tc.prefetch)
+
+			var actual []string
+			var err error
+			for _, path := range tc.read {
+				var secret string
+				secret, err = sr.ReadSecret(path)
+				if err != nil {
+					break
+				}
+				actual = append(actual, secret)
+			}
+
+			sort.Strings(underlying.reads)
+
+			assert.Equal(t, actual, tc.expected)
+			assert.Equal(t, err, tc.expectedErr)
+			assert.Equal(t, underlying.reads, tc.expectedReads)
+		})
+	}
+}
+
+func TestSecretReader_ListSecrets(t *testing.T) {
+	rootDirUUID := uuid.New()
+	subDirUUID := uuid.New()
+	secretUUID1 := uuid.New()
+	secretUUID2 := uuid.New()
+	secretUUID3 := uuid.New()
+
+	cases := map[string]struct {
+		tree     *api.Tree
+		treeErr  error
+		expected []string
+		err      error
+	}{
+		"secrets in dir": {
+			tree: &api.Tree{
+				ParentPath: "namespace/repo",
+				RootDir: &api.Dir{
+					DirID: rootDirUUID,
+					Name:  "tenants",
+				},
+				Dirs: map[uuid.UUID]*api.Dir{
+					subDirUUID: {
+						DirID:    subDirUUID,
+						ParentID: &rootDirUUID,
+						Name:     "archived",
+					},
+				},
+				Secrets: map[uuid.UUID]*api.Secret{
+					secretUUID1: {
+						SecretID: secretUUID1,
+						DirID:    rootDirUUID,
+						Name:     "acme",
+					},
+					secretUUID2: {
+						SecretID: secretUUID2,
+						DirID:    rootDirUUID,
+						Name:     "initech",
+					},
+					secretUUID3: {
+						SecretID: secretUUID3,
+						DirID:    subDirUUID,
+						Name:     "hooli",
+					},
+				},
+			},
+			expected: []string{"namespace/repo/tenants/acme", "namespace/repo/tenants/initech"},
+		},
+		"empty dir": {
+			tree: &api.Tree{
+				ParentPath: "namespace/repo",
+				RootDir: &api.Dir{
+					DirID: rootDirUUID,
+					Name:  "tenants",
+				},
+			},
+			expected: []string{},
+		},
+		"dir not found": {
+			treeErr: api.ErrDirNotFound,
+			err:     api.ErrDirNotFound,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			sr := newSecretReader(func() (secrethub.ClientInterface, error) {
+				return fakeclient.Client{
+					DirService: &fakeclient.DirService{
+						GetTreeFunc: func(path string, depth int, ancestors bool) (*api.Tree, error) {
+							assert.Equal(t, path, "namespace/repo/tenants")
+							assert.Equal(t, depth, 1)
+							return tc.tree, tc.treeErr
+						},
+					},
+				}, nil
+			})
+
+			actual, err := sr.ListSecrets("namespace/repo/tenants")
+			sort.Strings(actual)
+
+			assert.Equal(t, err, tc.err)
+			assert.Equal(t, actual, tc.expected)
+		})
+	}
+}
+
+func TestGeneratingSecretReader(t *testing.T) {
+	var writes []string
+	sr := newGeneratingSecretReader(&countingSecretReader{}, func() (secrethub.ClientInterface, error) {
+		return fakeclient.Client{
+			SecretService: &fakeclient.SecretService{
+				WriteFunc: func(path string, data []byte) (*api.SecretVersion, error) {
+					writes = append(writes, path)
+					return &api.SecretVersion{Version: len(writes)}, nil
+				},
+			},
+		}, nil
+	})
+
+	options := tpl.GenerateOptions{Length: 12, Charset: "letters", Min: []string{"uppercase:3"}}
+	first, err := sr.GenerateSecret("namespace/repo/foo", options)
+	assert.OK(t, err)
+	assert.Equal(t, len(first), 12)
+
+	again, err := sr.GenerateSecret("namespace/repo/foo", options)
+	assert.OK(t, err)
+	assert.Equal(t, again, first)
+
+	read, err := sr.ReadSecret("namespace/repo/foo")
+	assert.OK(t, err)
+	assert.Equal(t, read, first)
+
+	_, err = sr.GenerateSecret("namespace/repo/bar", tpl.GenerateOptions{Length: 8, Charset: "numeric"})
+	assert.OK(t, err)
+	assert.Equal(t, writes, []string{"namespace/repo/foo", "namespace/repo/bar"})
+
+	var buf bytes.Buffer
+	sr.printGenerated(&buf)
+	assert.Equal(t, buf.String(), "The following missing secrets have been generated:\n  - namespace/repo/foo:1\n  - namespace/repo/bar:2\n")
+}
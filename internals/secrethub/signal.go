@@ -0,0 +1,43 @@
+package secrethub
+
+import (
+	"strings"
+	"syscall"
+)
+
+// Errors
+var (
+	ErrUnknownSignal = errRun.Code("unknown_signal").ErrorPref("unknown signal: %s")
+)
+
+// signalValue implements the flag.Value interface for a signal that is
+// given by its name, e.g. SIGTERM, TERM or HUP.
+type signalValue struct {
+	signal syscall.Signal
+}
+
+func newSignalValue(signal syscall.Signal) signalValue {
+	return signalValue{signal: signal}
+}
+
+func (v *signalValue) Type() string {
+	return "signal"
+}
+
+func (v *signalValue) String() string {
+	return signalName(v.signal)
+}
+
+func (v *signalValue) Set(name string) error {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+
+	signal, ok := signalByName(name)
+	if !ok {
+		return ErrUnknownSignal(name)
+	}
+	v.signal = signal
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+
+package secrethub
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// signalByName returns the signal with the given name, e.g. SIGTERM.
+func signalByName(name string) (syscall.Signal, bool) {
+	signal := unix.SignalNum(name)
+	return signal, signal != 0
+}
+
+// signalName returns the name of the given signal, e.g. SIGTERM.
+func signalName(signal syscall.Signal) string {
+	return unix.SignalName(signal)
+}
@@ -0,0 +1,30 @@
+package secrethub
+
+import (
+	"syscall"
+)
+
+// signals contains the signals that can be sent to a process on Windows.
+var signals = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+// signalByName returns the signal with the given name, e.g. SIGTERM.
+func signalByName(name string) (syscall.Signal, bool) {
+	signal, ok := signals[name]
+	return signal, ok
+}
+
+// signalName returns the name of the given signal, e.g. SIGTERM.
+func signalName(signal syscall.Signal) string {
+	for name, s := range signals {
+		if s == signal {
+			return name
+		}
+	}
+	return signal.String()
+}
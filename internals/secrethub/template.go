@@ -1,22 +1,52 @@
 package secrethub
 
 import (
+	"github.com/secrethub/secrethub-cli/internals/cli"
+	"github.com/secrethub/secrethub-cli/internals/cli/ui"
 	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl"
 )
 
-func getTemplateParser(raw []byte, version string) (tpl.Parser, error) {
+// TemplateCommand handles operations on templates and env files.
+type TemplateCommand struct {
+	io        ui.IO
+	newClient newClientFunc
+}
+
+// NewTemplateCommand creates a new TemplateCommand.
+func NewTemplateCommand(io ui.IO, newClient newClientFunc) *TemplateCommand {
+	return &TemplateCommand{
+		io:        io,
+		newClient: newClient,
+	}
+}
+
+// Register registers the command and its sub-commands on the provided Registerer.
+func (cmd *TemplateCommand) Register(r cli.Registerer) {
+	clause := r.Command("template", "Check and upgrade templates and env files.")
+	NewTemplateLintCommand(cmd.io, cmd.newClient).Register(clause)
+	NewTemplateUpgradeCommand(cmd.io).Register(clause)
+}
+
+// getTemplateParser returns the parser for the given template version for the template in the given file.
+// Templates included by it are read with readFile.
+func getTemplateParser(raw []byte, version string, file string, readFile func(filename string) ([]byte, error)) (tpl.Parser, error) {
+	return getEscapingTemplateParser(raw, version, tpl.EscapingNone, file, readFile)
+}
+
+// getEscapingTemplateParser returns the parser for the given template version, of which the templates
+// escape the values of secrets for the given escaping. Templates included by the template in the given
+// file are read with readFile. Escaping and includes are not supported by v1 templates.
+func getEscapingTemplateParser(raw []byte, version string, escaping tpl.Escaping, file string, readFile func(filename string) ([]byte, error)) (tpl.Parser, error) {
 	switch version {
 	case "auto":
 		if tpl.IsV1Template(raw) {
 			return tpl.NewV1Parser(), nil
 		}
-		return tpl.NewParser(), nil
+		return tpl.NewIncludingV2Parser(escaping, file, readFile), nil
 	case "1", "v1":
 		return tpl.NewV1Parser(), nil
-	case "2", "v2":
-		return tpl.NewV2Parser(), nil
-	case "latest":
-		return tpl.NewParser(), nil
+	case "2", "v2", "latest":
+		return tpl.NewIncludingV2Parser(escaping, file, readFile), nil
 	default:
 		return nil, ErrUnknownTemplateVersion(version)
 	}
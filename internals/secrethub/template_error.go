@@ -0,0 +1,122 @@
+package secrethub
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl"
+)
+
+// templateSourceError is an error at a known position in a template file. It is formatted like the errors
+// of a compiler: `file:line:column: message`, followed by the line of the template on which the error
+// occurred with a caret under the tag that caused it. The first line can be parsed by editors.
+type templateSourceError struct {
+	file    string
+	lineNo  int
+	colNo   int
+	message string
+	// source is the line of the template on which the error occurred. It is empty when the line cannot be read.
+	source string
+}
+
+func (e templateSourceError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s:%d:%d: %s", e.file, e.lineNo, e.colNo, e.message)
+	if e.source == "" {
+		return b.String()
+	}
+
+	// The source line is indented by the width of the line number, so that the caret
+	// line lines up with it. Tabs are kept in the caret line to keep the alignment.
+	gutter := strings.Repeat(" ", len(strconv.Itoa(e.lineNo)))
+	var indent strings.Builder
+	for i, r := range []rune(e.source) {
+		if i >= e.colNo-1 {
+			break
+		}
+		if r == '\t' {
+			indent.WriteRune('\t')
+		} else {
+			indent.WriteRune(' ')
+		}
+	}
+
+	fmt.Fprintf(&b, "\n%s |\n%d | %s\n%s | %s%s", gutter, e.lineNo, e.source, gutter, indent.String(), underline(e.source, e.colNo))
+	return b.String()
+}
+
+// Position returns the line and column in the template file at which the error occurred.
+func (e templateSourceError) Position() (int, int) {
+	return e.lineNo, e.colNo
+}
+
+// annotateTemplateError returns the error as a templateSourceError when it occurred at a known position in
+// the template in the given file, of which the contents are raw. Errors in included templates are located in
+// the files of those templates. Errors of which the position is not known are returned unchanged.
+func annotateTemplateError(file string, raw []byte, err error) error {
+	positioned, ok := err.(tpl.PositionedError)
+	if !ok {
+		return err
+	}
+
+	lineNo, colNo := positioned.Position()
+	if lineNo <= 0 || colNo <= 0 {
+		return err
+	}
+
+	if positioned.File() != "" {
+		file = positioned.File()
+		// The error is shown without the line of the template when the included template cannot be read.
+		included, readErr := os.ReadFile(file)
+		if readErr != nil {
+			included = nil
+		}
+		raw = included
+	}
+	if file == "" {
+		file = "<stdin>"
+	}
+
+	return templateSourceError{
+		file:    file,
+		lineNo:  lineNo,
+		colNo:   colNo,
+		message: positioned.Message(),
+		source:  sourceLine(raw, lineNo),
+	}
+}
+
+// sourceLine returns the line with the given number, starting at 1, without its line ending.
+// The empty string is returned when the line does not exist.
+func sourceLine(raw []byte, lineNo int) string {
+	lines := strings.Split(string(raw), "\n")
+	if lineNo > len(lines) {
+		return ""
+	}
+	return strings.TrimRight(lines[lineNo-1], "\r")
+}
+
+// underline returns a caret under the character at the given column of the line, followed
+// by tildes under the rest of the tag that starts at that column when it ends on the same line.
+func underline(line string, colNo int) string {
+	runes := []rune(line)
+	if colNo > len(runes) {
+		return "^"
+	}
+	rest := string(runes[colNo-1:])
+
+	var end int
+	switch {
+	case strings.HasPrefix(rest, "{{"):
+		end = strings.Index(rest, "}}") + len("}}")
+	case strings.HasPrefix(rest, "${"):
+		end = strings.Index(rest, "}") + len("}")
+	}
+	if end <= 1 {
+		return "^"
+	}
+	return "^" + strings.Repeat("~", utf8.RuneCountInString(rest[:end])-1)
+}
@@ -0,0 +1,187 @@
+package secrethub
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl"
+	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl/fakes"
+
+	"github.com/secrethub/secrethub-go/internals/assert"
+)
+
+func TestTemplateSourceError_Error(t *testing.T) {
+	cases := map[string]struct {
+		err      templateSourceError
+		expected string
+	}{
+		"secret tag": {
+			err: templateSourceError{
+				file:    "app.yml",
+				lineNo:  2,
+				colNo:   7,
+				message: "Secret not found (server.secret_not_found)",
+				source:  "pass: {{ company/app/db/pass }}",
+			},
+			expected: "app.yml:2:7: Secret not found (server.secret_not_found)\n" +
+				"  |\n" +
+				"2 | pass: {{ company/app/db/pass }}\n" +
+				"  |       ^~~~~~~~~~~~~~~~~~~~~~~~~",
+		},
+		"v1 secret tag": {
+			err: templateSourceError{
+				file:    "app.env",
+				lineNo:  1,
+				colNo:   3,
+				message: "Secret not found (server.secret_not_found)",
+				source:  "x=${ a/b/c } y",
+			},
+			expected: "app.env:1:3: Secret not found (server.secret_not_found)\n" +
+				"  |\n" +
+				"1 | x=${ a/b/c } y\n" +
+				"  |   ^~~~~~~~~~",
+		},
+		"variable": {
+			err: templateSourceError{
+				file:    "app.env",
+				lineNo:  12,
+				colNo:   16,
+				message: "no value was supplied for template variable 'env'",
+				source:  "DB_PASS={{ app/${env}/db/pass }}",
+			},
+			expected: "app.env:12:16: no value was supplied for template variable 'env'\n" +
+				"   |\n" +
+				"12 | DB_PASS={{ app/${env}/db/pass }}\n" +
+				"   |                ^~~~~~",
+		},
+		"tabs": {
+			err: templateSourceError{
+				file:    "app.conf",
+				lineNo:  1,
+				colNo:   6,
+				message: "Secret not found (server.secret_not_found)",
+				source:  "\tkey={{ a/b/c }}",
+			},
+			expected: "app.conf:1:6: Secret not found (server.secret_not_found)\n" +
+				"  |\n" +
+				"1 | \tkey={{ a/b/c }}\n" +
+				"  | \t    ^~~~~~~~~~~",
+		},
+		"end of line": {
+			err: templateSourceError{
+				file:    "secrethub.env",
+				lineNo:  1,
+				colNo:   23,
+				message: "expected the closing of a secret tag `}}`, but reached the end of the template.",
+				source:  "TEST={{path/to/secret}",
+			},
+			expected: "secrethub.env:1:23: expected the closing of a secret tag `}}`, but reached the end of the template.\n" +
+				"  |\n" +
+				"1 | TEST={{path/to/secret}\n" +
+				"  |                       ^",
+		},
+		"without source": {
+			err: templateSourceError{
+				file:    "db.tpl",
+				lineNo:  1,
+				colNo:   20,
+				message: "expected the closing of a secret tag `}}`, but reached the end of the template.",
+			},
+			expected: "db.tpl:1:20: expected the closing of a secret tag `}}`, but reached the end of the template.",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.err.Error(), tc.expected)
+		})
+	}
+}
+
+func TestAnnotateTemplateError(t *testing.T) {
+	dir := t.TempDir()
+	included := filepath.Join(dir, "db.tpl")
+	err := os.WriteFile(included, []byte("user=root\npass={{ company/app/db/pass }}\n"), 0600)
+	assert.OK(t, err)
+
+	cases := map[string]struct {
+		file     string
+		raw      string
+		err      error
+		expected error
+	}{
+		"syntax error": {
+			file: "secrethub.env",
+			raw:  "FOO=bar\nTEST={{path/to/secret}\n",
+			err:  tpl.ErrSecretTagNotClosed(2, 23),
+			expected: templateSourceError{
+				file:    "secrethub.env",
+				lineNo:  2,
+				colNo:   23,
+				message: "expected the closing of a secret tag `}}`, but reached the end of the template.",
+				source:  "TEST={{path/to/secret}",
+			},
+		},
+		"windows line endings": {
+			file: "secrethub.env",
+			raw:  "FOO=bar\r\nTEST={{path/to/secret}\r\n",
+			err:  tpl.ErrSecretTagNotClosed(2, 23),
+			expected: templateSourceError{
+				file:    "secrethub.env",
+				lineNo:  2,
+				colNo:   23,
+				message: "expected the closing of a secret tag `}}`, but reached the end of the template.",
+				source:  "TEST={{path/to/secret}",
+			},
+		},
+		"stdin": {
+			raw: "TEST={{path/to/secret}",
+			err: tpl.ErrSecretTagNotClosed(1, 23),
+			expected: templateSourceError{
+				file:    "<stdin>",
+				lineNo:  1,
+				colNo:   23,
+				message: "expected the closing of a secret tag `}}`, but reached the end of the template.",
+				source:  "TEST={{path/to/secret}",
+			},
+		},
+		"not positioned": {
+			file:     "secrethub.env",
+			raw:      "TEST={{ path/to/secret }}",
+			err:      errors.New("something went wrong"),
+			expected: errors.New("something went wrong"),
+		},
+		"unknown position": {
+			file:     "secrethub.env",
+			raw:      "TEST={{ path/to/secret }}",
+			err:      tpl.ErrSecretTagNotClosed(-1, -1),
+			expected: tpl.ErrSecretTagNotClosed(-1, -1),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			actual := annotateTemplateError(tc.file, []byte(tc.raw), tc.err)
+			assert.Equal(t, actual, tc.expected)
+		})
+	}
+
+	t.Run("included template", func(t *testing.T) {
+		raw := `{{ include "db.tpl" }}`
+		parsed, err := tpl.NewIncludingV2Parser(tpl.EscapingNone, filepath.Join(dir, "app.tpl"), os.ReadFile).Parse(raw, 1, 1)
+		assert.OK(t, err)
+
+		_, err = parsed.Evaluate(fakes.FakeVariableReader{}, fakes.FakeSecretReader{})
+
+		actual := annotateTemplateError("app.tpl", []byte(raw), err)
+		assert.Equal(t, actual, templateSourceError{
+			file:    included,
+			lineNo:  2,
+			colNo:   6,
+			message: "Secret not found (server.secret_not_found)",
+			source:  "pass={{ company/app/db/pass }}",
+		})
+	})
+}
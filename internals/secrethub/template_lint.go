@@ -0,0 +1,381 @@
+package secrethub
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/secrethub/secrethub-cli/internals/cli"
+	"github.com/secrethub/secrethub-cli/internals/cli/ui"
+	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl"
+
+	"github.com/secrethub/secrethub-go/internals/api"
+	"github.com/secrethub/secrethub-go/pkg/secrethub"
+
+	"github.com/spf13/cobra"
+)
+
+// Errors
+var (
+	ErrTemplateLintProblems    = errMain.Code("template_lint_problems").ErrorPref("found %d problem(s) in the templates")
+	ErrNoTemplatesToLint       = errMain.Code("no_templates_to_lint").Error("no templates to check: give the paths of templates as arguments or of env files with --env-file")
+	ErrInvalidLintOutputFormat = errMain.Code("invalid_lint_output_format").ErrorPref("invalid output format '%s': the options are text and json")
+)
+
+// TemplateLintCommand checks templates and env files for problems without reading any secrets.
+type TemplateLintCommand struct {
+	io              ui.IO
+	newClient       newClientFunc
+	osEnv           []string
+	readFile        func(filename string) ([]byte, error)
+	templates       cli.StringListValue
+	envFiles        []string
+	templateVars    map[string]string
+	templateVersion string
+	format          string
+}
+
+// NewTemplateLintCommand creates a new TemplateLintCommand.
+func NewTemplateLintCommand(io ui.IO, newClient newClientFunc) *TemplateLintCommand {
+	return &TemplateLintCommand{
+		io:           io,
+		newClient:    newClient,
+		osEnv:        os.Environ(),
+		readFile:     os.ReadFile,
+		templateVars: make(map[string]string),
+	}
+}
+
+// Register adds a CommandClause and it's args and flags to a Registerer.
+func (cmd *TemplateLintCommand) Register(r cli.Registerer) {
+	clause := r.Command("lint", "Check templates and env files for problems.")
+	clause.HelpLong("Parse templates and env files and list the secrets and template variables used in them. " +
+		"For every secret it is checked that it exists and can be read by the current account, using only the metadata of the secret: the values of secrets are never read. " +
+		"Variables without a value, given with --var or a SECRETHUB_VAR_ environment variable, are reported as well.\n\n" +
+		"Every problem is reported as `file:line:column: message` and the command exits with a non-zero exit code when any problems are found.")
+	clause.Flags().StringArrayVar(&cmd.envFiles, "env-file", nil, "The path to an env file, as used by `secrethub run`, to check. Can be repeated.")
+	clause.Flags().StringToStringVarP(&cmd.templateVars, "var", "v", nil, "Define the value for a template variable with `VAR=VALUE`, e.g. --var env=prod")
+	clause.Flags().StringVar(&cmd.templateVersion, "template-version", "auto", "The template syntax version to use. The options are v1, v2, latest or auto to automatically detect the version.")
+	clause.Flags().StringVar(&cmd.format, "output-format", "text", "Specify the format in which to output the results. Options are: text and json.")
+	_ = clause.Cmd.RegisterFlagCompletionFunc("output-format", func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+		return []string{"text", "json"}, cobra.ShellCompDirectiveNoFileComp
+	})
+
+	clause.BindAction(cmd.Run)
+	clause.BindArgumentsArr(cli.Argument{Value: &cmd.templates, Name: "template", Required: false, Description: "The paths of the templates to check, as used by `secrethub inject`."})
+}
+
+// Run checks the templates and env files and prints the results.
+func (cmd *TemplateLintCommand) Run() error {
+	if cmd.format != "text" && cmd.format != formatJSON {
+		return ErrInvalidLintOutputFormat(cmd.format)
+	}
+	if len(cmd.templates) == 0 && len(cmd.envFiles) == 0 {
+		return ErrNoTemplatesToLint
+	}
+
+	osEnv, _ := parseKeyValueStringsToMap(cmd.osEnv)
+	varReader, err := newVariableReader(osEnv, cmd.templateVars)
+	if err != nil {
+		return err
+	}
+
+	linter := &templateLinter{
+		varReader: varReader,
+		newClient: cmd.newClient,
+		checked:   make(map[string]error),
+		result: lintResult{
+			Secrets:   []lintSecret{},
+			Variables: []lintVariable{},
+			Problems:  []lintProblem{},
+		},
+	}
+
+	for _, path := range cmd.templates {
+		err = cmd.lintFile(linter, path, false)
+		if err != nil {
+			return err
+		}
+	}
+	for _, path := range cmd.envFiles {
+		err = cmd.lintFile(linter, path, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = linter.check()
+	if err != nil {
+		return err
+	}
+
+	if cmd.format == formatJSON {
+		encoder := json.NewEncoder(cmd.io.Output())
+		encoder.SetIndent("", "    ")
+		err = encoder.Encode(linter.result)
+	} else {
+		err = linter.result.write(cmd.io.Output())
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(linter.result.Problems) > 0 {
+		return ErrTemplateLintProblems(len(linter.result.Problems))
+	}
+	return nil
+}
+
+// lintFile parses the file with the given path and adds the secret and variable tags in it to the linter.
+// When the file cannot be read or parsed, this is reported as a problem.
+func (cmd *TemplateLintCommand) lintFile(linter *templateLinter, path string, isEnvFile bool) error {
+	raw, err := cmd.readFile(path)
+	if err != nil {
+		linter.addProblem(lintLocation{File: path}, "could not read the file: %s", err)
+		return nil
+	}
+
+	parser, err := getTemplateParser(raw, cmd.templateVersion, path, cmd.readFile)
+	if err != nil {
+		return err
+	}
+
+	if !isEnvFile {
+		template, err := parser.Parse(string(raw), 1, 1)
+		if err != nil {
+			linter.addSyntaxError(path, err)
+			return nil
+		}
+		linter.addReferences(path, template.References())
+		return nil
+	}
+
+	envVars, err := parseEnvFile(raw, parser)
+	if err != nil {
+		linter.addProblem(lintLocation{File: path}, "%s", err)
+		return nil
+	}
+
+	for _, envVar := range envVars {
+		keyTpl, err := envVar.parser.Parse(envVar.key, envVar.lineNumber, envVar.columnNumberKey)
+		if err != nil {
+			linter.addSyntaxError(path, err)
+			continue
+		}
+		for _, ref := range keyTpl.References() {
+			if ref.IsSecret() {
+				location := lintLocation{File: path, Line: ref.LineNo, Column: ref.ColNo}
+				if ref.File != "" {
+					location.File = ref.File
+				}
+				linter.addProblem(location, "secrets are not allowed in environment variable names")
+			}
+		}
+		linter.addReferences(path, keyTpl.References())
+
+		valueTpl, err := envVar.parser.Parse(envVar.value, envVar.lineNumber, envVar.columnNumberValue)
+		if err != nil {
+			linter.addSyntaxError(path, err)
+			continue
+		}
+		linter.addReferences(path, valueTpl.References())
+	}
+	return nil
+}
+
+// lintLocation is a position in a file. Line and Column are 0 when the position is not known.
+type lintLocation struct {
+	File   string `json:"file"`
+	Line   int    `json:"line,omitempty"`
+	Column int    `json:"column,omitempty"`
+}
+
+// String returns the location formatted as file:line:column.
+func (l lintLocation) String() string {
+	if l.Line <= 0 {
+		return l.File
+	}
+	return fmt.Sprintf("%s:%d:%d", l.File, l.Line, l.Column)
+}
+
+// lintSecret is a secret tag found in a template, or a directory of which a template uses all secrets.
+type lintSecret struct {
+	lintLocation
+	// Path is empty when the path contains a variable without a value.
+	Path     string `json:"path,omitempty"`
+	Optional bool   `json:"optional,omitempty"`
+	Dir      bool   `json:"dir,omitempty"`
+}
+
+// lintVariable is a variable tag found in a template.
+type lintVariable struct {
+	lintLocation
+	Name string `json:"name"`
+}
+
+// lintProblem is a problem found in a template.
+type lintProblem struct {
+	lintLocation
+	Message string `json:"message"`
+}
+
+// lintResult contains all secrets, variables and problems found in the linted templates.
+type lintResult struct {
+	Secrets   []lintSecret   `json:"secrets"`
+	Variables []lintVariable `json:"variables"`
+	Problems  []lintProblem  `json:"problems"`
+}
+
+// write writes the result in a human readable format to the given writer.
+func (r lintResult) write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 2, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "%s\t%s\n", "SECRET", "LOCATION")
+	for _, secret := range r.Secrets {
+		path := orDash(secret.Path)
+		if secret.Dir && secret.Path != "" {
+			path += "/"
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", path, secret.lintLocation)
+	}
+	fmt.Fprintln(tw)
+
+	fmt.Fprintf(tw, "%s\t%s\n", "VARIABLE", "LOCATION")
+	for _, variable := range r.Variables {
+		fmt.Fprintf(tw, "%s\t%s\n", variable.Name, variable.lintLocation)
+	}
+
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	if len(r.Problems) > 0 {
+		fmt.Fprintln(w)
+	}
+	for _, problem := range r.Problems {
+		fmt.Fprintf(w, "%s: %s\n", problem.lintLocation, problem.Message)
+	}
+	return nil
+}
+
+// templateLinter collects the secrets and variables used in templates and checks them.
+type templateLinter struct {
+	varReader tpl.VariableReader
+	newClient newClientFunc
+	// checked contains the result of checking every secret path, so that every secret is checked once.
+	checked map[string]error
+	result  lintResult
+}
+
+func (l *templateLinter) addProblem(location lintLocation, format string, args ...interface{}) {
+	l.result.Problems = append(l.result.Problems, lintProblem{
+		lintLocation: location,
+		Message:      strings.TrimSpace(fmt.Sprintf(format, args...)),
+	})
+}
+
+// addSyntaxError adds a problem for an error returned when parsing a template,
+// at the position of the syntax error if it is known. Errors in included templates
+// are located in the file of the included template.
+func (l *templateLinter) addSyntaxError(file string, err error) {
+	positioned, ok := err.(tpl.PositionedError)
+	if !ok {
+		l.addProblem(lintLocation{File: file}, "%s", err)
+		return
+	}
+
+	location := lintLocation{File: file}
+	location.Line, location.Column = positioned.Position()
+	if positioned.File() != "" {
+		location.File = positioned.File()
+	}
+	l.addProblem(location, "%s", positioned.Message())
+}
+
+// addReferences adds the secret tags and the variable tags found in the given file
+// and the templates included by it.
+// Variables without a value are reported as problems.
+func (l *templateLinter) addReferences(file string, refs []tpl.Reference) {
+	for _, ref := range refs {
+		location := lintLocation{
+			File:   file,
+			Line:   ref.LineNo,
+			Column: ref.ColNo,
+		}
+		if ref.File != "" {
+			location.File = ref.File
+		}
+
+		if !ref.IsSecret() {
+			l.result.Variables = append(l.result.Variables, lintVariable{
+				lintLocation: location,
+				Name:         ref.Variable,
+			})
+
+			_, err := l.varReader.ReadVariable(ref.Variable)
+			if err != nil {
+				l.addProblem(location, "no value for template variable '%s': set it with --var %s=VALUE or the %s%s environment variable", ref.Variable, ref.Variable, templateVarEnvVarPrefix, strings.ToUpper(ref.Variable))
+			}
+			continue
+		}
+
+		// A path with variables without a value cannot be checked, which is reported for the variables themselves.
+		path, _ := ref.SecretPath(l.varReader)
+		l.result.Secrets = append(l.result.Secrets, lintSecret{
+			lintLocation: location,
+			Path:         path,
+			Optional:     ref.Optional,
+			Dir:          ref.Dir,
+		})
+	}
+}
+
+// check checks for every secret that it exists and can be read by the current account,
+// and for every directory that it exists. Only the metadata of the secrets is retrieved.
+func (l *templateLinter) check() error {
+	var client secrethub.ClientInterface
+	for _, secret := range l.result.Secrets {
+		if secret.Path == "" {
+			continue
+		}
+
+		// Directories are checked separately from secrets on the same path.
+		key := secret.Path
+		if secret.Dir {
+			key += "/"
+		}
+
+		err, ok := l.checked[key]
+		if !ok {
+			if client == nil {
+				var clientErr error
+				client, clientErr = l.newClient()
+				if clientErr != nil {
+					return clientErr
+				}
+			}
+
+			if secret.Dir {
+				// Only the directory itself is fetched, as a depth of 0 would fetch the whole tree below it.
+				_, err = client.Dirs().GetTree(secret.Path, 1, false)
+			} else {
+				_, err = client.Secrets().Versions().GetWithoutData(secret.Path)
+			}
+			l.checked[key] = err
+		}
+
+		if err == nil || (secret.Optional && api.IsErrNotFound(err)) {
+			continue
+		}
+		if secret.Dir {
+			l.addProblem(secret.lintLocation, "directory %s cannot be read: %s", secret.Path, err)
+			continue
+		}
+		l.addProblem(secret.lintLocation, "secret %s cannot be read: %s", secret.Path, err)
+	}
+	return nil
+}
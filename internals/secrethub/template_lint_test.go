@@ -0,0 +1,209 @@
+package secrethub
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/secrethub/secrethub-cli/internals/cli"
+	"github.com/secrethub/secrethub-cli/internals/cli/ui/fakeui"
+
+	"github.com/secrethub/secrethub-go/internals/api"
+	"github.com/secrethub/secrethub-go/internals/assert"
+	"github.com/secrethub/secrethub-go/pkg/secrethub"
+	"github.com/secrethub/secrethub-go/pkg/secrethub/fakeclient"
+)
+
+func TestTemplateLintCommand_Run(t *testing.T) {
+	files := map[string]string{
+		"config.tpl":                          "user: {{ company/${app}/db/user }}\npass: {{ company/${app}/db/pass }}\n",
+		"secrethub.env":                       "DB_USER={{ company/app/db/user }}\nDB_PASS = {{ company/${env}/db/pass }}\nPORT={{ company/app/db/port | default 5432 }}\n",
+		"broken.tpl":                          "user: {{ company/app/db/user }\n",
+		"v1.env":                              "DB_PASS=${company/app/db/missing}\n",
+		"main.tpl":                            "{{ company/app/key }}\n{{ include \"partials/db.tpl\" }}\n",
+		filepath.FromSlash("partials/db.tpl"): "user: {{ company/app/db/user }}\n",
+		"include-error.tpl":                   "{{ include \"partials/broken.tpl\" }}\n",
+		filepath.FromSlash("partials/broken.tpl"): "{{ company/app/db/user \n",
+		"tenants.tpl": "{{ range dir \"company/app/tenants\" }}{{ .Name }}\n{{ end }}{{ if exists \"company/app/beta\" }}beta{{ end }}\n{{ range dir \"company/app/missing\" }}{{ end }}\n",
+	}
+
+	newClient := func() (secrethub.ClientInterface, error) {
+		return fakeclient.Client{
+			SecretService: &fakeclient.SecretService{
+				VersionService: &fakeclient.SecretVersionService{
+					GetWithDataFunc: func(path string) (*api.SecretVersion, error) {
+						t.Fatalf("secret %s is read", path)
+						return nil, nil
+					},
+					GetWithoutDataFunc: func(path string) (*api.SecretVersion, error) {
+						switch path {
+						case "company/app/db/user", "company/app/db/pass":
+							return &api.SecretVersion{}, nil
+						}
+						return nil, api.ErrSecretNotFound
+					},
+				},
+			},
+			DirService: &fakeclient.DirService{
+				GetTreeFunc: func(path string, depth int, ancestors bool) (*api.Tree, error) {
+					if depth != 1 {
+						t.Errorf("tree of %s is fetched with depth %d, expected 1", path, depth)
+					}
+					if path == "company/app/tenants" {
+						return &api.Tree{}, nil
+					}
+					return nil, api.ErrDirNotFound
+				},
+			},
+		}, nil
+	}
+
+	cases := map[string]struct {
+		templates cli.StringListValue
+		envFiles  []string
+		vars      map[string]string
+		format    string
+		out       string
+		err       error
+	}{
+		"template": {
+			templates: cli.StringListValue{"config.tpl"},
+			vars:      map[string]string{"app": "app"},
+			format:    "text",
+			out: "SECRET               LOCATION\n" +
+				"company/app/db/user  config.tpl:1:7\n" +
+				"company/app/db/pass  config.tpl:2:7\n" +
+				"\n" +
+				"VARIABLE  LOCATION\n" +
+				"app       config.tpl:1:18\n" +
+				"app       config.tpl:2:18\n",
+		},
+		"env file with problems": {
+			envFiles: []string{"secrethub.env"},
+			format:   "text",
+			out: "SECRET               LOCATION\n" +
+				"company/app/db/user  secrethub.env:1:9\n" +
+				"-                    secrethub.env:2:11\n" +
+				"company/app/db/port  secrethub.env:3:6\n" +
+				"\n" +
+				"VARIABLE  LOCATION\n" +
+				"env       secrethub.env:2:22\n" +
+				"\n" +
+				"secrethub.env:2:22: no value for template variable 'env': set it with --var env=VALUE or the SECRETHUB_VAR_ENV environment variable\n",
+			err: ErrTemplateLintProblems(1),
+		},
+		"syntax error and missing secret": {
+			templates: cli.StringListValue{"broken.tpl", "not-found.tpl"},
+			envFiles:  []string{"v1.env"},
+			format:    "text",
+			out: "SECRET                  LOCATION\n" +
+				"company/app/db/missing  v1.env:1:9\n" +
+				"\n" +
+				"VARIABLE  LOCATION\n" +
+				"\n" +
+				"broken.tpl:1:31: unexpected '\\n', expected '}'\n" +
+				"not-found.tpl: could not read the file: file does not exist\n" +
+				"v1.env:1:9: secret company/app/db/missing cannot be read: Secret not found (server.secret_not_found)\n",
+			err: ErrTemplateLintProblems(3),
+		},
+		"blocks": {
+			templates: cli.StringListValue{"tenants.tpl"},
+			format:    "text",
+			out: "SECRET                LOCATION\n" +
+				"company/app/tenants/  tenants.tpl:1:1\n" +
+				"company/app/beta      tenants.tpl:2:10\n" +
+				"company/app/missing/  tenants.tpl:3:1\n" +
+				"\n" +
+				"VARIABLE  LOCATION\n" +
+				"\n" +
+				"tenants.tpl:3:1: directory company/app/missing cannot be read: Directory not found (server.dir_not_found)\n",
+			err: ErrTemplateLintProblems(1),
+		},
+		"include": {
+			templates: cli.StringListValue{"main.tpl", "include-error.tpl"},
+			format:    "text",
+			out: "SECRET               LOCATION\n" +
+				"company/app/key      main.tpl:1:1\n" +
+				"company/app/db/user  " + filepath.FromSlash("partials/db.tpl") + ":1:7\n" +
+				"\n" +
+				"VARIABLE  LOCATION\n" +
+				"\n" +
+				filepath.FromSlash("partials/broken.tpl") + ":1:24: unexpected '\\n', expected '}'\n" +
+				"main.tpl:1:1: secret company/app/key cannot be read: Secret not found (server.secret_not_found)\n",
+			err: ErrTemplateLintProblems(2),
+		},
+		"json": {
+			envFiles: []string{"secrethub.env"},
+			vars:     map[string]string{"env": "app"},
+			format:   "json",
+			out: `{
+    "secrets": [
+        {
+            "file": "secrethub.env",
+            "line": 1,
+            "column": 9,
+            "path": "company/app/db/user"
+        },
+        {
+            "file": "secrethub.env",
+            "line": 2,
+            "column": 11,
+            "path": "company/app/db/pass"
+        },
+        {
+            "file": "secrethub.env",
+            "line": 3,
+            "column": 6,
+            "path": "company/app/db/port",
+            "optional": true
+        }
+    ],
+    "variables": [
+        {
+            "file": "secrethub.env",
+            "line": 2,
+            "column": 22,
+            "name": "env"
+        }
+    ],
+    "problems": []
+}
+`,
+		},
+		"no templates": {
+			format: "text",
+			err:    ErrNoTemplatesToLint,
+		},
+		"invalid format": {
+			templates: cli.StringListValue{"config.tpl"},
+			format:    "yaml",
+			err:       ErrInvalidLintOutputFormat("yaml"),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			io := fakeui.NewIO(t)
+			cmd := TemplateLintCommand{
+				io:        io,
+				newClient: newClient,
+				readFile: func(filename string) ([]byte, error) {
+					content, ok := files[filename]
+					if !ok {
+						return nil, os.ErrNotExist
+					}
+					return []byte(content), nil
+				},
+				templates:       tc.templates,
+				envFiles:        tc.envFiles,
+				templateVars:    tc.vars,
+				templateVersion: "auto",
+				format:          tc.format,
+			}
+
+			err := cmd.Run()
+			assert.Equal(t, err, tc.err)
+			assert.Equal(t, io.Out.String(), tc.out)
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package secrethub
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/secrethub/secrethub-cli/internals/cli"
+	"github.com/secrethub/secrethub-cli/internals/cli/ui"
+	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl"
+)
+
+// Errors
+var (
+	ErrUpgradeTemplate = errMain.Code("upgrade_template_failed").ErrorPref("could not upgrade template %s: %s")
+)
+
+// diffContext is the number of unchanged lines shown around the changes in a diff.
+const diffContext = 3
+
+// TemplateUpgradeCommand converts templates from the v1 syntax to the v2 syntax.
+type TemplateUpgradeCommand struct {
+	io      ui.IO
+	paths   cli.StringListValue
+	write   bool
+	exclude []string
+}
+
+// NewTemplateUpgradeCommand creates a new TemplateUpgradeCommand.
+func NewTemplateUpgradeCommand(io ui.IO) *TemplateUpgradeCommand {
+	return &TemplateUpgradeCommand{
+		io: io,
+	}
+}
+
+// Register adds a CommandClause and it's args and flags to a Registerer.
+func (cmd *TemplateUpgradeCommand) Register(r cli.Registerer) {
+	clause := r.Command("upgrade", "Convert templates from the v1 to the v2 syntax.")
+	clause.HelpLong("Convert templates that use the v1 syntax, with secret tags like ${ path/to/secret }, to the v2 syntax, with secret tags like {{ path/to/secret }}. " +
+		"Text in the templates that would be read as a v2 tag or variable is escaped with a backslash, so the upgraded templates result in exactly the same output.\n\n" +
+		"Templates can be given as files or as directories, of which all files are upgraded recursively. Hidden directories, like .git, are skipped. " +
+		"Files without v1 secret tags are left untouched, as they are already read as v2 templates.\n\n" +
+		"By default, the changes are shown as a diff without modifying any files. Use --write to rewrite the templates in place.")
+	clause.Flags().BoolVar(&cmd.write, "write", false, "Rewrite the upgraded templates in place instead of showing the changes.")
+	clause.Flags().StringArrayVar(&cmd.exclude, "exclude", nil, "Do not upgrade files or directories in a given directory that match the given glob pattern, e.g. `vendor`. Patterns without a slash are matched against the file name. Can be repeated.")
+
+	clause.BindAction(cmd.Run)
+	clause.BindArgumentsArr(cli.Argument{Value: &cmd.paths, Name: "path", Required: true, Description: "The paths of the templates or directories of templates to upgrade."})
+}
+
+// upgradeFile is a v1 template with its contents in the v2 syntax.
+type upgradeFile struct {
+	path     string
+	mode     os.FileMode
+	raw      string
+	upgraded string
+}
+
+// Run upgrades the templates and shows the changes or writes them.
+// All templates are upgraded before anything is written, so a template that
+// cannot be upgraded never results in a partially upgraded directory.
+func (cmd *TemplateUpgradeCommand) Run() error {
+	var files []*upgradeFile
+	for _, path := range cmd.paths {
+		upgraded, err := cmd.upgradePath(path)
+		if err != nil {
+			return err
+		}
+		files = append(files, upgraded...)
+	}
+
+	if len(files) == 0 {
+		fmt.Fprintln(cmd.io.Output(), "No v1 templates found.")
+		return nil
+	}
+
+	if !cmd.write {
+		for _, file := range files {
+			err := writeDiff(cmd.io.Output(), file.path, file.raw, file.upgraded)
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(cmd.io.Output(), "\n%d template(s) can be upgraded. Run the command again with --write to upgrade them.\n", len(files))
+		return nil
+	}
+
+	for _, file := range files {
+		err := os.WriteFile(file.path, []byte(file.upgraded), file.mode)
+		if err != nil {
+			return ErrCannotWrite(file.path, err)
+		}
+		fmt.Fprintf(cmd.io.Output(), "Upgraded %s\n", file.path)
+	}
+	return nil
+}
+
+// upgradePath upgrades the template at the given path, or all templates in it when it is a directory.
+func (cmd *TemplateUpgradeCommand) upgradePath(root string) ([]*upgradeFile, error) {
+	var files []*upgradeFile
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return ErrReadFile(path, err)
+		}
+		if path == root && !d.IsDir() {
+			file, err := upgradeTemplateFile(path)
+			if file != nil {
+				files = append(files, file)
+			}
+			return err
+		}
+
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return nil
+		}
+		relPath = filepath.ToSlash(relPath)
+
+		excluded, err := matchInjectPatterns(cmd.exclude, relPath)
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if excluded || strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if excluded || !d.Type().IsRegular() {
+			return nil
+		}
+
+		file, err := upgradeTemplateFile(path)
+		if file != nil {
+			files = append(files, file)
+		}
+		return err
+	})
+	return files, err
+}
+
+// upgradeTemplateFile converts the template in the given file to the v2 syntax.
+// It returns nil when the file does not contain v1 secret tags.
+func upgradeTemplateFile(path string) (*upgradeFile, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, ErrReadFile(path, err)
+	}
+	if !tpl.IsV1Template(raw) {
+		return nil, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, ErrReadFile(path, err)
+	}
+
+	upgraded, err := tpl.UpgradeV1(string(raw))
+	if err != nil {
+		return nil, ErrUpgradeTemplate(path, err)
+	}
+
+	return &upgradeFile{
+		path:     path,
+		mode:     info.Mode().Perm(),
+		raw:      string(raw),
+		upgraded: upgraded,
+	}, nil
+}
+
+// diffLine is a line in a diff: an unchanged line (' '), a removed line ('-') or an added line ('+').
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// diffLines returns the lines of a and b in order, with every line marked as unchanged, removed from a or added in b.
+// The unchanged lines are the longest common subsequence of the lines of a and b.
+func diffLines(a, b []string) []diffLine {
+	// common[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	common := make([][]int, len(a)+1)
+	for i := range common {
+		common[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	var res []diffLine
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			res = append(res, diffLine{op: ' ', text: a[i]})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && common[i+1][j] >= common[i][j+1]):
+			res = append(res, diffLine{op: '-', text: a[i]})
+			i++
+		default:
+			res = append(res, diffLine{op: '+', text: b[j]})
+			j++
+		}
+	}
+	return res
+}
+
+// writeDiff writes the changes from the old to the new contents of the given file in the unified diff format.
+func writeDiff(w io.Writer, file string, old, new string) error {
+	lines := diffLines(splitLines(old), splitLines(new))
+
+	_, err := fmt.Fprintf(w, "--- %s\n+++ %s\n", file, file)
+	if err != nil {
+		return err
+	}
+
+	// oldLineNo and newLineNo are the numbers of the lines before lines[i] in the old and new contents.
+	oldLineNo, newLineNo := 0, 0
+	for i := 0; i < len(lines); {
+		if lines[i].op == ' ' {
+			oldLineNo++
+			newLineNo++
+			i++
+			continue
+		}
+
+		// A hunk starts with the unchanged lines before the change and continues until
+		// the change is followed by more unchanged lines than fit in the context of two hunks.
+		start := i
+		for start > 0 && i-start < diffContext && lines[start-1].op == ' ' {
+			start--
+		}
+		end := i
+		for unchanged := 0; end < len(lines) && unchanged <= 2*diffContext; end++ {
+			if lines[end].op == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		for end > i && lines[end-1].op == ' ' && trailingUnchanged(lines[:end]) > diffContext {
+			end--
+		}
+
+		hunkOldStart, hunkNewStart := oldLineNo-(i-start), newLineNo-(i-start)
+		var oldCount, newCount int
+		for _, line := range lines[start:end] {
+			if line.op != '+' {
+				oldCount++
+			}
+			if line.op != '-' {
+				newCount++
+			}
+		}
+		if oldCount > 0 {
+			hunkOldStart++
+		}
+		if newCount > 0 {
+			hunkNewStart++
+		}
+
+		_, err := fmt.Fprintf(w, "@@ -%d,%d +%d,%d @@\n", hunkOldStart, oldCount, hunkNewStart, newCount)
+		if err != nil {
+			return err
+		}
+		for _, line := range lines[start:end] {
+			text := line.text
+			if !strings.HasSuffix(text, "\n") {
+				text += "\n\\ No newline at end of file\n"
+			}
+			_, err := fmt.Fprintf(w, "%c%s", line.op, text)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, line := range lines[i:end] {
+			if line.op != '+' {
+				oldLineNo++
+			}
+			if line.op != '-' {
+				newLineNo++
+			}
+		}
+		i = end
+	}
+	return nil
+}
+
+// trailingUnchanged returns the number of unchanged lines at the end of the given lines.
+func trailingUnchanged(lines []diffLine) int {
+	n := 0
+	for n < len(lines) && lines[len(lines)-1-n].op == ' ' {
+		n++
+	}
+	return n
+}
+
+// splitLines splits the text into lines that include their line endings.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
@@ -0,0 +1,169 @@
+package secrethub
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/secrethub/secrethub-cli/internals/cli/ui/fakeui"
+	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl"
+
+	"github.com/secrethub/secrethub-go/internals/assert"
+)
+
+func TestTemplateUpgradeCommand_Run(t *testing.T) {
+	templates := map[string]string{
+		"app.tpl":           "[db]\nuser=${ company/app/db/user }\nhome=$HOME\n",
+		"config/db.yml":     "pass: ${company/app/db/pass}\n",
+		"config/v2.yml":     "pass: {{ company/app/db/pass }}\n",
+		"vendor/lib.tpl":    "${ company/app/vendor/key }\n",
+		".git/objects/tags": "${ company/app/db/pass }\n",
+	}
+
+	cases := map[string]struct {
+		templates map[string]string
+		paths     []string
+		write     bool
+		exclude   []string
+
+		out      string
+		expected map[string]string
+		err      error
+	}{
+		"diff": {
+			templates: templates,
+			paths:     []string{"app.tpl"},
+			out: "--- <dir>/app.tpl\n+++ <dir>/app.tpl\n" +
+				"@@ -1,3 +1,3 @@\n" +
+				" [db]\n" +
+				"-user=${ company/app/db/user }\n" +
+				"-home=$HOME\n" +
+				"+user={{ company/app/db/user }}\n" +
+				"+home=\\$HOME\n" +
+				"\n1 template(s) can be upgraded. Run the command again with --write to upgrade them.\n",
+			expected: templates,
+		},
+		"write": {
+			templates: templates,
+			paths:     []string{"app.tpl"},
+			write:     true,
+			out:       "Upgraded <dir>/app.tpl\n",
+			expected: map[string]string{
+				"app.tpl":           "[db]\nuser={{ company/app/db/user }}\nhome=\\$HOME\n",
+				"config/db.yml":     "pass: ${company/app/db/pass}\n",
+				"config/v2.yml":     "pass: {{ company/app/db/pass }}\n",
+				"vendor/lib.tpl":    "${ company/app/vendor/key }\n",
+				".git/objects/tags": "${ company/app/db/pass }\n",
+			},
+		},
+		"directory": {
+			templates: templates,
+			paths:     []string{"."},
+			write:     true,
+			exclude:   []string{"vendor"},
+			out:       "Upgraded <dir>/app.tpl\nUpgraded <dir>/config/db.yml\n",
+			expected: map[string]string{
+				"app.tpl":           "[db]\nuser={{ company/app/db/user }}\nhome=\\$HOME\n",
+				"config/db.yml":     "pass: {{ company/app/db/pass }}\n",
+				"config/v2.yml":     "pass: {{ company/app/db/pass }}\n",
+				"vendor/lib.tpl":    "${ company/app/vendor/key }\n",
+				".git/objects/tags": "${ company/app/db/pass }\n",
+			},
+		},
+		"no v1 templates": {
+			templates: templates,
+			paths:     []string{"config/v2.yml"},
+			out:       "No v1 templates found.\n",
+			expected:  templates,
+		},
+		"invalid path": {
+			templates: map[string]string{
+				"app.tpl": "${ company/app/db/user }\n${ company/app/db user }",
+			},
+			paths: []string{"."},
+			write: true,
+			err:   ErrUpgradeTemplate("<dir>/app.tpl", tpl.ErrCannotUpgradeSecretPath(2, 1, "company/app/db user")),
+			expected: map[string]string{
+				"app.tpl": "${ company/app/db/user }\n${ company/app/db user }",
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tc.templates, 0600)
+
+			paths := make([]string, len(tc.paths))
+			for i, path := range tc.paths {
+				paths[i] = filepath.Join(dir, filepath.FromSlash(path))
+			}
+
+			io := fakeui.NewIO(t)
+			cmd := TemplateUpgradeCommand{
+				io:      io,
+				paths:   paths,
+				write:   tc.write,
+				exclude: tc.exclude,
+			}
+
+			err := cmd.Run()
+			if tc.err != nil {
+				assert.Equal(t, err.Error(), strings.ReplaceAll(tc.err.Error(), "<dir>/app.tpl", filepath.Join(dir, "app.tpl")))
+			} else {
+				assert.OK(t, err)
+			}
+
+			out := strings.ReplaceAll(io.Out.String(), dir+string(filepath.Separator), "<dir>/")
+			assert.Equal(t, filepath.ToSlash(out), tc.out)
+			assert.Equal(t, readFiles(t, dir), tc.expected)
+		})
+	}
+}
+
+func TestWriteDiff(t *testing.T) {
+	lines := func(from, to int) string {
+		var res strings.Builder
+		for i := from; i <= to; i++ {
+			res.WriteString(string(rune('a'+i-1)) + "\n")
+		}
+		return res.String()
+	}
+
+	cases := map[string]struct {
+		old      string
+		new      string
+		expected string
+	}{
+		"separate hunks": {
+			old: lines(1, 20),
+			new: "a\nB\n" + lines(3, 18) + "S\nt\n",
+			expected: "@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n d\n e\n" +
+				"@@ -16,5 +16,5 @@\n p\n q\n r\n-s\n+S\n t\n",
+		},
+		"merged hunks": {
+			old:      lines(1, 8),
+			new:      "A\n" + lines(2, 7) + "H\n",
+			expected: "@@ -1,8 +1,8 @@\n-a\n+A\n b\n c\n d\n e\n f\n g\n-h\n+H\n",
+		},
+		"added lines": {
+			old:      "",
+			new:      "a\nb",
+			expected: "@@ -0,0 +1,2 @@\n+a\n+b\n\\ No newline at end of file\n",
+		},
+		"unchanged": {
+			old:      lines(1, 3),
+			new:      lines(1, 3),
+			expected: "",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			var out strings.Builder
+			err := writeDiff(&out, "app.tpl", tc.old, tc.new)
+			assert.OK(t, err)
+			assert.Equal(t, out.String(), "--- app.tpl\n+++ app.tpl\n"+tc.expected)
+		})
+	}
+}
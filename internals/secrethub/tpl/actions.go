@@ -0,0 +1,481 @@
+package tpl
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	gopath "path"
+	"sort"
+	"unicode"
+
+	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl/internal/token"
+	"github.com/secrethub/secrethub-go/internals/api"
+)
+
+// Actions that can be used in a tag instead of a secret path.
+const (
+	actionRange = "range"
+	actionIf    = "if"
+	actionElse  = "else"
+	actionEnd   = "end"
+)
+
+// functions contains the function that is used by each block action.
+var functions = map[string]string{
+	actionRange: "dir",
+	actionIf:    "exists",
+}
+
+// Fields of the secret in the current iteration of a range block.
+const (
+	fieldName  = "Name"
+	fieldPath  = "Path"
+	fieldValue = "Value"
+)
+
+// DirReader lists the secrets in a directory. A SecretReader that implements DirReader
+// can be used to evaluate templates with range blocks.
+type DirReader interface {
+	// ListSecrets returns the paths of the secrets directly in the directory with the given path.
+	ListSecrets(dirPath string) ([]string, error)
+}
+
+// rangeItem is the secret in the current iteration of a range block.
+type rangeItem struct {
+	path string
+}
+
+// rangeDir is a range block, which evaluates its body for every secret in a directory.
+type rangeDir struct {
+	path []node
+	body []node
+	// file is the included template in which the block is used. It is empty for blocks in the template itself.
+	file   string
+	lineNo int
+	colNo  int
+}
+
+// evaluate lists the secrets in the directory and evaluates the body for each of them, in order of their path.
+func (r rangeDir) evaluate(ctx context) (string, error) {
+	dirPath, err := evaluateNodes(ctx, r.path)
+	if err != nil {
+		return "", err
+	}
+
+	dirReader, ok := ctx.secretReader.(DirReader)
+	if !ok {
+		return "", tagError(r.file, r.lineNo, r.colNo, ErrDirsNotSupported)
+	}
+
+	paths, err := dirReader.ListSecrets(dirPath)
+	if err != nil {
+		return "", tagError(r.file, r.lineNo, r.colNo, err)
+	}
+	sort.Strings(paths)
+
+	var buffer bytes.Buffer
+	for _, path := range paths {
+		itemCtx := ctx
+		itemCtx.item = &rangeItem{path: path}
+
+		eval, err := evaluateNodes(itemCtx, r.body)
+		if err != nil {
+			return "", err
+		}
+		buffer.WriteString(eval)
+	}
+	return buffer.String(), nil
+}
+
+// ifExists is an if block, which evaluates its body when a secret exists and its else body otherwise.
+type ifExists struct {
+	path     []node
+	body     []node
+	elseBody []node
+	// file is the included template in which the block is used. It is empty for blocks in the template itself.
+	file   string
+	lineNo int
+	colNo  int
+}
+
+// evaluate reads the secret to check whether it exists and evaluates the matching body.
+func (i ifExists) evaluate(ctx context) (string, error) {
+	path, err := evaluateNodes(ctx, i.path)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = ctx.secret(path)
+	if api.IsErrNotFound(err) {
+		return evaluateNodes(ctx, i.elseBody)
+	}
+	if err != nil {
+		return "", tagError(i.file, i.lineNo, i.colNo, err)
+	}
+	return evaluateNodes(ctx, i.body)
+}
+
+// field is a field of the secret in the current iteration of a range block, e.g. `{{ .Value }}`.
+type field struct {
+	name    string
+	filters []filter
+	// file is the included template in which the field is used. It is empty for fields in the template itself.
+	file   string
+	lineNo int
+	colNo  int
+}
+
+// evaluate returns the value of the field, passed through the filters.
+// Errors are annotated with the position of the field.
+func (f field) evaluate(ctx context) (string, error) {
+	var value string
+	var err error
+	switch f.name {
+	case fieldName:
+		value, err = evaluatePipeline(ctx, ctx.item.path, gopath.Base(ctx.item.path), nil, f.filters)
+	case fieldPath:
+		value, err = evaluatePipeline(ctx, ctx.item.path, ctx.item.path, nil, f.filters)
+	default:
+		value, err = ctx.secret(ctx.item.path)
+		value, err = evaluatePipeline(ctx, ctx.item.path, value, err, f.filters)
+	}
+	if err != nil {
+		return "", tagError(f.file, f.lineNo, f.colNo, err)
+	}
+	return value, nil
+}
+
+// blockTag is a tag that opens or closes a block. Block tags only exist while parsing:
+// nestBlocks replaces them with the blocks they form.
+type blockTag struct {
+	action string
+	path   []node
+	file   string
+	lineNo int
+	colNo  int
+}
+
+func (t blockTag) evaluate(ctx context) (string, error) {
+	return "", errors.New("unexpected block tag " + t.action)
+}
+
+// peekAction returns the action of the tag that is being opened, "." for a field tag or the
+// empty string for a secret tag. The next character should be the last character of the
+// opening delimiter ('{') when peekAction is called. No characters are read.
+func (p *v2Parser) peekAction() string {
+	rest := bytes.TrimLeft(p.buf.Bytes(), " \t")
+	if bytes.HasPrefix(rest, []byte(".")) {
+		return "."
+	}
+
+	for _, action := range []string{actionRange, actionIf, actionElse, actionEnd, actionInclude} {
+		if !bytes.HasPrefix(rest, []byte(action)) {
+			continue
+		}
+		after := rest[len(action):]
+		if len(after) == 0 || p.isAllowedWhiteSpace(rune(after[0])) || after[0] == '}' {
+			return action
+		}
+	}
+	return ""
+}
+
+// parseAction parses a tag with an action or a field up to the closing delimiter. The tag
+// starts at the given position. The next character should be the last character of the
+// opening delimiter ('{') when parseAction is called.
+//
+// When parseAction returns, the next character in the buffer is the last character
+// of the closing delimiter of the tag ('}').
+func (p *v2Parser) parseAction(lineNo, colNo int) (node, error) {
+	checkError := func(err error) error {
+		if err == io.EOF {
+			return ErrActionTagNotClosed(p.lineNo, p.columnNo+1)
+		}
+		return err
+	}
+
+	err := p.readRune()
+	if err != nil {
+		return nil, checkError(err)
+	}
+
+	err = p.skipWhiteSpace()
+	if err != nil {
+		return nil, checkError(err)
+	}
+
+	if p.next == '.' {
+		err = p.readRune()
+		if err != nil {
+			return nil, checkError(err)
+		}
+
+		nameLineNo, nameColNo := p.lineNo, p.columnNo+1
+		name, err := p.readWord()
+		if err != nil {
+			return nil, checkError(err)
+		}
+		if name != fieldName && name != fieldPath && name != fieldValue {
+			return nil, ErrUnknownField(nameLineNo, nameColNo, name)
+		}
+
+		err = p.skipWhiteSpace()
+		if err != nil {
+			return nil, checkError(err)
+		}
+
+		var filters []filter
+		if p.next == token.Pipe {
+			filters, err = p.parseFilters()
+		} else {
+			err = p.parseClosingDelimiter()
+		}
+		if err != nil {
+			return nil, checkError(err)
+		}
+
+		return field{
+			name:    name,
+			filters: filters,
+			file:    p.file,
+			lineNo:  lineNo,
+			colNo:   colNo,
+		}, nil
+	}
+
+	action, err := p.readWord()
+	if err != nil {
+		return nil, checkError(err)
+	}
+
+	if action == actionInclude {
+		n, err := p.parseInclude(lineNo, colNo)
+		if err != nil {
+			return nil, checkError(err)
+		}
+		return n, nil
+	}
+
+	tag := blockTag{
+		action: action,
+		file:   p.file,
+		lineNo: lineNo,
+		colNo:  colNo,
+	}
+
+	if action == actionRange || action == actionIf {
+		err = p.skipWhiteSpace()
+		if err != nil {
+			return nil, checkError(err)
+		}
+
+		functionLineNo, functionColNo := p.lineNo, p.columnNo+1
+		function, err := p.readWord()
+		if err != nil {
+			return nil, checkError(err)
+		}
+		if function != functions[action] {
+			return nil, ErrUnknownFunction(functionLineNo, functionColNo, action, function, functions[action])
+		}
+
+		err = p.skipWhiteSpace()
+		if err != nil {
+			return nil, checkError(err)
+		}
+
+		tag.path, err = p.parsePathArgument()
+		if err != nil {
+			return nil, checkError(err)
+		}
+
+		p.references = append(p.references, Reference{
+			LineNo:   lineNo,
+			ColNo:    colNo,
+			Dir:      action == actionRange,
+			Optional: action == actionIf,
+			path:     tag.path,
+		})
+	}
+
+	err = p.skipWhiteSpace()
+	if err != nil {
+		return nil, checkError(err)
+	}
+
+	err = p.parseClosingDelimiter()
+	if err != nil {
+		return nil, checkError(err)
+	}
+	return tag, nil
+}
+
+// readWord reads the letters up to the next character that is not a letter and returns them.
+func (p *v2Parser) readWord() (string, error) {
+	var buffer bytes.Buffer
+	for unicode.IsLetter(p.next) {
+		buffer.WriteRune(p.next)
+		err := p.readRune()
+		if err != nil {
+			return "", err
+		}
+	}
+	return buffer.String(), nil
+}
+
+// parseClosingDelimiter parses the closing delimiter of a tag.
+// When parseClosingDelimiter returns, the next character in the buffer is the last character
+// of the closing delimiter ('}').
+func (p *v2Parser) parseClosingDelimiter() error {
+	if p.next != token.RBracket {
+		return ErrUnexpectedCharacter(p.lineNo, p.columnNo+1, p.next, token.RBracket)
+	}
+
+	err := p.readRune()
+	if err != nil {
+		return err
+	}
+
+	if p.next != token.RBracket {
+		return ErrUnexpectedCharacter(p.lineNo, p.columnNo+1, p.next, token.RBracket)
+	}
+	return nil
+}
+
+// parsePathArgument parses a path between double quotes, which can contain variable tags.
+// The next character should be the opening quote when parsePathArgument is called.
+//
+// When parsePathArgument returns, the current character is the closing quote.
+func (p *v2Parser) parsePathArgument() ([]node, error) {
+	if p.next != token.Quote {
+		return nil, ErrUnexpectedCharacter(p.lineNo, p.columnNo+1, p.next, token.Quote)
+	}
+
+	lineNo, colNo := p.lineNo, p.columnNo+1
+	err := p.readRune()
+	if err != nil {
+		return nil, err
+	}
+
+	path := []node{}
+	for {
+		err := p.readRune()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case p.current == token.Quote:
+			return path, nil
+		case p.current == '\n':
+			return nil, ErrPathArgumentNotClosed(lineNo, colNo)
+		case p.current == token.Dollar && p.next == token.LBracket:
+			variable, err := p.parseVar()
+			if err != nil {
+				return nil, err
+			}
+			path = append(path, variable)
+
+			err = p.readRune()
+			if err != nil {
+				return nil, err
+			}
+		case p.current == token.Dollar && p.isVariableStartRune(p.next):
+			variable, err := p.parseVarWithoutBrackets()
+			if err != nil {
+				return nil, err
+			}
+			path = append(path, variable)
+		case p.isSecretPathRune(p.current):
+			path = append(path, character(p.current))
+		default:
+			return nil, ErrIllegalSecretCharacter(p.lineNo, p.columnNo, p.current)
+		}
+	}
+}
+
+// openBlock is a block of which the end tag has not been parsed yet.
+type openBlock struct {
+	tag      blockTag
+	body     []node
+	elseBody []node
+	inElse   bool
+}
+
+// nestBlocks replaces the block tags in the parsed nodes with the blocks they form, so that every
+// block contains the nodes between its opening tag and its end tag. Field tags are only allowed
+// within range blocks.
+func nestBlocks(nodes []node) ([]node, error) {
+	root := &openBlock{body: []node{}}
+	stack := []*openBlock{root}
+	ranges := 0
+
+	for _, n := range nodes {
+		top := stack[len(stack)-1]
+
+		if f, ok := n.(field); ok && ranges == 0 {
+			return nil, ErrFieldOutsideRange(f.lineNo, f.colNo, f.name)
+		}
+
+		tag, ok := n.(blockTag)
+		if !ok {
+			if top.inElse {
+				top.elseBody = append(top.elseBody, n)
+			} else {
+				top.body = append(top.body, n)
+			}
+			continue
+		}
+
+		switch tag.action {
+		case actionRange, actionIf:
+			if tag.action == actionRange {
+				ranges++
+			}
+			stack = append(stack, &openBlock{tag: tag, body: []node{}})
+		case actionElse:
+			if top == root || top.tag.action != actionIf || top.inElse {
+				return nil, ErrUnexpectedAction(tag.lineNo, tag.colNo, tag.action)
+			}
+			top.inElse = true
+		case actionEnd:
+			if top == root {
+				return nil, ErrUnexpectedAction(tag.lineNo, tag.colNo, tag.action)
+			}
+			stack = stack[:len(stack)-1]
+
+			var block node
+			if top.tag.action == actionRange {
+				ranges--
+				block = rangeDir{
+					path:   top.tag.path,
+					body:   top.body,
+					file:   top.tag.file,
+					lineNo: top.tag.lineNo,
+					colNo:  top.tag.colNo,
+				}
+			} else {
+				block = ifExists{
+					path:     top.tag.path,
+					body:     top.body,
+					elseBody: top.elseBody,
+					file:     top.tag.file,
+					lineNo:   top.tag.lineNo,
+					colNo:    top.tag.colNo,
+				}
+			}
+
+			parent := stack[len(stack)-1]
+			if parent.inElse {
+				parent.elseBody = append(parent.elseBody, block)
+			} else {
+				parent.body = append(parent.body, block)
+			}
+		}
+	}
+
+	if len(stack) > 1 {
+		open := stack[len(stack)-1].tag
+		return nil, ErrBlockNotClosed(open.lineNo, open.colNo, open.action)
+	}
+	return root.body, nil
+}
@@ -0,0 +1,158 @@
+package tpl
+
+import (
+	"testing"
+
+	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl/fakes"
+
+	"github.com/secrethub/secrethub-go/internals/api"
+	"github.com/secrethub/secrethub-go/internals/assert"
+)
+
+func TestV2_Blocks(t *testing.T) {
+	secrets := map[string]string{
+		"company/app/tenants/acme":    `{"key": "a1"}`,
+		"company/app/tenants/initech": `{"key": "i2"}`,
+		"company/app/flags/beta":      "on",
+		"company/app/db/pass":         "secret",
+	}
+
+	cases := map[string]struct {
+		raw      string
+		vars     map[string]string
+		escaping Escaping
+
+		expected string
+		parseErr error
+		evalErr  error
+	}{
+		"range": {
+			raw:      `{{ range dir "company/app/tenants" }}{{ .Name }} = {{ .Value }}` + "\n" + `{{ end }}`,
+			expected: "acme = {\"key\": \"a1\"}\ninitech = {\"key\": \"i2\"}\n",
+		},
+		"range with filters and path": {
+			raw:      `{{range dir "company/${app}/tenants"}}{{.Path}}: {{ .Value | json ".key" }};{{end}}`,
+			vars:     map[string]string{"app": "app"},
+			expected: "company/app/tenants/acme: a1;company/app/tenants/initech: i2;",
+		},
+		"nested range": {
+			raw:      `{{ range dir "company/app/flags" }}{{ .Name }}:{{ range dir "company/app/tenants" }} {{ .Name }}{{ end }}{{ end }}`,
+			expected: "beta: acme initech",
+		},
+		"range escaped": {
+			raw:      `{{ range dir "company/app/tenants" }}"{{ .Value }}" {{ end }}`,
+			escaping: EscapingJSON,
+			expected: `"{\"key\": \"a1\"}" "{\"key\": \"i2\"}" `,
+		},
+		"range not found": {
+			raw:     `{{ range dir "company/app/missing" }}{{ .Name }}{{ end }}`,
+			evalErr: evaluationError{lineNo: 1, colNo: 1, err: api.ErrDirNotFound},
+		},
+		"if exists": {
+			raw:      `{{ if exists "company/app/flags/beta" }}beta={{ company/app/flags/beta }}{{ end }}`,
+			expected: "beta=on",
+		},
+		"if not exists": {
+			raw:      `{{ if exists "company/app/flags/alpha" }}alpha={{ company/app/flags/alpha }}{{ end }}.`,
+			expected: ".",
+		},
+		"else": {
+			raw:      `{{ if exists "company/app/flags/alpha" }}alpha{{ else }}no alpha{{ end }}`,
+			expected: "no alpha",
+		},
+		"if in range": {
+			raw:      `{{ range dir "company/app/tenants" }}{{ if exists "company/app/flags/${flag}" }}{{ .Name }} {{ end }}{{ end }}`,
+			vars:     map[string]string{"flag": "beta"},
+			expected: "acme initech ",
+		},
+		"secret path starting with keyword": {
+			raw:      `{{ ifs/repo/secret | default "x" }}`,
+			expected: "x",
+		},
+		"field outside range": {
+			raw:      `{{ .Value }}`,
+			parseErr: ErrFieldOutsideRange(1, 1, "Value"),
+		},
+		"unknown field": {
+			raw:      `{{ range dir "company/app/tenants" }}{{ .Secret }}{{ end }}`,
+			parseErr: ErrUnknownField(1, 42, "Secret"),
+		},
+		"unknown function": {
+			raw:      `{{ range secrets "company/app/tenants" }}{{ end }}`,
+			parseErr: ErrUnknownFunction(1, 10, "range", "secrets", "dir"),
+		},
+		"unquoted path": {
+			raw:      `{{ if exists company/app/db/pass }}{{ end }}`,
+			parseErr: ErrUnexpectedCharacter(1, 14, 'c', '"'),
+		},
+		"path not closed": {
+			raw:      "{{ if exists \"company/app/db\n/pass\" }}{{ end }}",
+			parseErr: ErrPathArgumentNotClosed(1, 14),
+		},
+		"tag not closed": {
+			raw:      `{{ end`,
+			parseErr: ErrActionTagNotClosed(1, 7),
+		},
+		"block not closed": {
+			raw:      "{{ if exists \"company/app/db/pass\" }}\n{{ range dir \"company/app/tenants\" }}{{ end }}",
+			parseErr: ErrBlockNotClosed(1, 1, "if"),
+		},
+		"end without block": {
+			raw:      `{{ company/app/db/pass }}{{ end }}`,
+			parseErr: ErrUnexpectedAction(1, 26, "end"),
+		},
+		"else in range": {
+			raw:      `{{ range dir "company/app/tenants" }}{{ else }}{{ end }}`,
+			parseErr: ErrUnexpectedAction(1, 38, "else"),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			escaping := tc.escaping
+			if escaping == "" {
+				escaping = EscapingNone
+			}
+
+			parsed, err := NewEscapingV2Parser(escaping).Parse(tc.raw, 1, 1)
+			assert.Equal(t, err, tc.parseErr)
+			if err != nil {
+				return
+			}
+
+			actual, err := parsed.Evaluate(fakes.FakeVariableReader{Variables: tc.vars}, fakes.FakeSecretReader{Secrets: secrets})
+			assert.Equal(t, err, tc.evalErr)
+			assert.Equal(t, actual, tc.expected)
+		})
+	}
+}
+
+func TestV2_Blocks_SecretPaths(t *testing.T) {
+	raw := `{{ if exists "company/app/flags/beta" }}{{ company/app/beta/pass }}{{ else }}{{ company/app/pass }}{{ end }}` +
+		`{{ range dir "company/app/tenants" }}{{ .Value }}{{ company/app/tenant/key }}{{ end }}`
+
+	parsed, err := NewV2Parser().Parse(raw, 1, 1)
+	assert.OK(t, err)
+
+	paths, err := parsed.SecretPaths(fakes.FakeVariableReader{})
+	assert.OK(t, err)
+	assert.Equal(t, paths, []string{"company/app/flags/beta", "company/app/beta/pass", "company/app/pass", "company/app/tenant/key"})
+	assert.Equal(t, parsed.ContainsSecrets(), true)
+}
+
+func TestV2_Blocks_DirsNotSupported(t *testing.T) {
+	parsed, err := NewV2Parser().Parse(`{{ range dir "company/app/tenants" }}{{ .Name }}{{ end }}`, 1, 1)
+	assert.OK(t, err)
+
+	_, err = parsed.Evaluate(fakes.FakeVariableReader{}, secretReaderFunc(func(path string) (string, error) {
+		return "", nil
+	}))
+	assert.Equal(t, err, evaluationError{lineNo: 1, colNo: 1, err: ErrDirsNotSupported})
+}
+
+// secretReaderFunc is a SecretReader that does not implement DirReader.
+type secretReaderFunc func(path string) (string, error)
+
+func (f secretReaderFunc) ReadSecret(path string) (string, error) {
+	return f(path)
+}
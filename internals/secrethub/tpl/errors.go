@@ -2,15 +2,122 @@ package tpl
 
 import (
 	"fmt"
+	"strings"
 )
 
 // Evaluate errors
 var (
 	ErrTemplateVarNotFound = tplError.Code("template_var_not_found").ErrorPref("no value was supplied for template variable '%s'")
+	ErrDirsNotSupported    = tplError.Code("dirs_not_supported").Error("range blocks cannot be used here, because directories cannot be read")
 )
 
+// PositionedError is an error that occurred at a known position in a template, such as a syntax error
+// or a secret tag of which the secret cannot be read. Errors that occurred in an included template
+// are positioned in that template.
+type PositionedError interface {
+	error
+	// Position returns the line and column at which the error occurred.
+	Position() (int, int)
+	// File returns the included template in which the error occurred,
+	// or the empty string when it occurred in the template itself.
+	File() string
+	// Message returns the description of the error without its position.
+	Message() string
+}
+
+// evaluationError is returned when a tag cannot be evaluated, for example because
+// its secret does not exist or because a variable in it has no value.
+type evaluationError struct {
+	// file is the included template in which the tag is used. It is empty for tags in the template itself.
+	file   string
+	lineNo int
+	colNo  int
+	err    error
+}
+
+func (err evaluationError) Error() string {
+	if err.file != "" {
+		return tplError.Code("evaluation_failed").Errorf("template error in %s at %d:%d: %s", err.file, err.lineNo, err.colNo, err.Message()).Error()
+	}
+	return tplError.Code("evaluation_failed").Errorf("template error at %d:%d: %s", err.lineNo, err.colNo, err.Message()).Error()
+}
+
+func (err evaluationError) Unwrap() error {
+	return err.err
+}
+
+// Position returns the line and column of the tag that could not be evaluated.
+func (err evaluationError) Position() (int, int) {
+	return err.lineNo, err.colNo
+}
+
+// File returns the included template in which the tag is used,
+// or the empty string when it is used in the template itself.
+func (err evaluationError) File() string {
+	return err.file
+}
+
+// Message returns the description of the error without its position.
+func (err evaluationError) Message() string {
+	return strings.TrimSpace(err.err.Error())
+}
+
+// tagError annotates an error returned when evaluating the tag at the given position with that
+// position, unless the error already has a position, like the errors of filters and nested tags.
+// Templates parsed at a line smaller than 1 have no known position, so their errors are not annotated.
+func tagError(file string, lineNo, colNo int, err error) error {
+	if _, ok := err.(PositionedError); ok || lineNo <= 0 {
+		return err
+	}
+	return evaluationError{
+		file:   file,
+		lineNo: lineNo,
+		colNo:  colNo,
+		err:    err,
+	}
+}
+
+// filterError is returned when a filter fails to process its input.
+type filterError struct {
+	// file is the included template in which the filter is used. It is empty for filters in the template itself.
+	file   string
+	lineNo int
+	colNo  int
+	filter string
+	err    error
+}
+
+func (err filterError) Error() string {
+	if err.file != "" {
+		return tplError.Code("filter_failed").Errorf("filter %s in %s at %d:%d failed: %s", err.filter, err.file, err.lineNo, err.colNo, err.err).Error()
+	}
+	return tplError.Code("filter_failed").Errorf("filter %s at %d:%d failed: %s", err.filter, err.lineNo, err.colNo, err.err).Error()
+}
+
+func (err filterError) Unwrap() error {
+	return err.err
+}
+
+// Position returns the line and column of the filter that failed.
+func (err filterError) Position() (int, int) {
+	return err.lineNo, err.colNo
+}
+
+// File returns the included template in which the filter is used,
+// or the empty string when it is used in the template itself.
+func (err filterError) File() string {
+	return err.file
+}
+
+// Message returns the description of the error without its position.
+func (err filterError) Message() string {
+	return fmt.Sprintf("filter %s failed: %s", err.filter, strings.TrimSpace(err.err.Error()))
+}
+
 // Parse errors
 type templateSyntaxError struct {
+	// file is the included template in which the syntax error occurred. It is empty for errors in the template itself.
+	file   string
 	lineNo int
 	colNo  int
 	code   string
@@ -18,9 +125,28 @@ type templateSyntaxError struct {
 }
 
 func (err templateSyntaxError) Error() string {
+	if err.file != "" {
+		return tplError.Code(err.code).Errorf("template syntax error in %s at %d:%d: %s", err.file, err.lineNo, err.colNo, err.msg).Error()
+	}
 	return tplError.Code(err.code).Errorf("template syntax error at %d:%d: %s", err.lineNo, err.colNo, err.msg).Error()
 }
 
+// Position returns the line and column at which the syntax error occurred.
+func (err templateSyntaxError) Position() (int, int) {
+	return err.lineNo, err.colNo
+}
+
+// File returns the included template in which the syntax error occurred,
+// or the empty string when it occurred in the template itself.
+func (err templateSyntaxError) File() string {
+	return err.file
+}
+
+// Message returns the description of the syntax error without its position.
+func (err templateSyntaxError) Message() string {
+	return err.msg
+}
+
 // ErrUnexpectedCharacter is returned when expecting a specific character, for example
 // the first character of a closing delimiter after a space occurred in a tag, or
 // the second character of a closing delimiter after the first character of the closing
@@ -30,7 +156,7 @@ func ErrUnexpectedCharacter(lineNo, colNo int, actual, expected rune) error {
 		lineNo: lineNo,
 		colNo:  colNo,
 		code:   "unexpected character",
-		msg:    fmt.Sprintf("unexpected '%c', expected '%c'", actual, expected),
+		msg:    fmt.Sprintf("unexpected %q, expected %q", actual, expected),
 	}
 }
 
@@ -73,3 +199,164 @@ func ErrVariableTagNotClosed(lineNo, colNo int) error {
 		msg:    "expected the closing of a variable tag `}`, but reached the end of the template.",
 	}
 }
+
+// ErrUnknownFilter is returned when a filter is used in a secret tag that does not exist.
+func ErrUnknownFilter(lineNo, colNo int, name string) error {
+	return templateSyntaxError{
+		lineNo: lineNo,
+		colNo:  colNo,
+		code:   "unknown_filter",
+		msg:    fmt.Sprintf("unknown filter '%s'. Available filters are base64decode, base64encode, default, escape, generate, indent, json, jsonescape, trim and yamlquote.", name),
+	}
+}
+
+// ErrInvalidFilterArguments is returned when a filter is given the wrong number of arguments or invalid arguments.
+func ErrInvalidFilterArguments(lineNo, colNo int, name string, msg string) error {
+	return templateSyntaxError{
+		lineNo: lineNo,
+		colNo:  colNo,
+		code:   "invalid_filter_arguments",
+		msg:    fmt.Sprintf("invalid arguments for filter '%s': %s", name, msg),
+	}
+}
+
+// ErrIllegalFilterCharacter is returned when a filter in a secret tag contains a character that is not allowed.
+func ErrIllegalFilterCharacter(lineNo, colNo int, char rune) error {
+	return templateSyntaxError{
+		lineNo: lineNo,
+		colNo:  colNo,
+		code:   "illegal_filter_character",
+		msg:    fmt.Sprintf("illegal character '%c'. Filter names can only contain letters and digits and arguments have to be separated by spaces.", char),
+	}
+}
+
+// ErrFilterArgumentNotClosed is returned when a quoted filter argument is opened, but not closed on the same line.
+func ErrFilterArgumentNotClosed(lineNo, colNo int) error {
+	return templateSyntaxError{
+		lineNo: lineNo,
+		colNo:  colNo,
+		code:   "filter_argument_not_closed",
+		msg:    "expected the closing quote of a filter argument `\"`, but reached the end of the line.",
+	}
+}
+
+// ErrActionTagNotClosed is returned when a tag with an action or a field is opened, but never closed.
+func ErrActionTagNotClosed(lineNo, colNo int) error {
+	return templateSyntaxError{
+		lineNo: lineNo,
+		colNo:  colNo,
+		code:   "action_tag_not_closed",
+		msg:    "expected the closing of a tag `}}`, but reached the end of the template.",
+	}
+}
+
+// ErrPathArgumentNotClosed is returned when the quoted path of a range or if tag is opened, but not closed on the same line.
+func ErrPathArgumentNotClosed(lineNo, colNo int) error {
+	return templateSyntaxError{
+		lineNo: lineNo,
+		colNo:  colNo,
+		code:   "path_argument_not_closed",
+		msg:    "expected the closing quote of a path `\"`, but reached the end of the line.",
+	}
+}
+
+// ErrUnknownFunction is returned when a range or if tag uses a function that is not supported by it.
+func ErrUnknownFunction(lineNo, colNo int, action, name, expected string) error {
+	return templateSyntaxError{
+		lineNo: lineNo,
+		colNo:  colNo,
+		code:   "unknown_function",
+		msg:    fmt.Sprintf("unknown function '%s' for %s. Use `{{ %s %s \"path\" }}`.", name, action, action, expected),
+	}
+}
+
+// ErrUnknownField is returned when a field tag refers to a field that does not exist.
+func ErrUnknownField(lineNo, colNo int, name string) error {
+	return templateSyntaxError{
+		lineNo: lineNo,
+		colNo:  colNo,
+		code:   "unknown_field",
+		msg:    fmt.Sprintf("unknown field '.%s'. Available fields are .Name, .Path and .Value.", name),
+	}
+}
+
+// ErrFieldOutsideRange is returned when a field tag is used outside of a range block.
+func ErrFieldOutsideRange(lineNo, colNo int, name string) error {
+	return templateSyntaxError{
+		lineNo: lineNo,
+		colNo:  colNo,
+		code:   "field_outside_range",
+		msg:    fmt.Sprintf("field '.%s' can only be used within a range block.", name),
+	}
+}
+
+// ErrUnexpectedAction is returned when an else or end tag does not belong to a block.
+func ErrUnexpectedAction(lineNo, colNo int, action string) error {
+	return templateSyntaxError{
+		lineNo: lineNo,
+		colNo:  colNo,
+		code:   "unexpected_action",
+		msg:    fmt.Sprintf("unexpected `{{ %s }}`. It can only be used once to close an if block or a range block.", action),
+	}
+}
+
+// ErrBlockNotClosed is returned when a range or if block is opened, but never closed with an end tag.
+func ErrBlockNotClosed(lineNo, colNo int, action string) error {
+	return templateSyntaxError{
+		lineNo: lineNo,
+		colNo:  colNo,
+		code:   "block_not_closed",
+		msg:    fmt.Sprintf("expected the %s block to be closed with `{{ end }}`, but reached the end of the template.", action),
+	}
+}
+
+// ErrIncludesNotSupported is returned when an include tag is used in a template that is not read from a file.
+func ErrIncludesNotSupported(lineNo, colNo int) error {
+	return templateSyntaxError{
+		lineNo: lineNo,
+		colNo:  colNo,
+		code:   "includes_not_supported",
+		msg:    "templates cannot be included here, because the template is not read from a file.",
+	}
+}
+
+// ErrCannotReadInclude is returned when the file of an included template cannot be read.
+func ErrCannotReadInclude(lineNo, colNo int, file string, err error) error {
+	return templateSyntaxError{
+		lineNo: lineNo,
+		colNo:  colNo,
+		code:   "cannot_read_include",
+		msg:    fmt.Sprintf("could not read included template %s: %s", file, err),
+	}
+}
+
+// ErrIncludeCycle is returned when a template includes itself, directly or through other included templates.
+func ErrIncludeCycle(lineNo, colNo int, files []string) error {
+	return templateSyntaxError{
+		lineNo: lineNo,
+		colNo:  colNo,
+		code:   "include_cycle",
+		msg:    fmt.Sprintf("templates cannot include themselves: %s.", strings.Join(files, " includes ")),
+	}
+}
+
+// ErrCannotUpgradeSecretPath is returned when the path of a v1 secret tag contains characters
+// that are not allowed in the secret paths of v2 templates.
+func ErrCannotUpgradeSecretPath(lineNo, colNo int, path string) error {
+	return templateSyntaxError{
+		lineNo: lineNo,
+		colNo:  colNo,
+		code:   "cannot_upgrade_secret_path",
+		msg:    fmt.Sprintf("secret path '%s' cannot be used in a v2 template. Secret paths can only contain letters, digits, underscores, hypens, dots, slashes and a colon.", path),
+	}
+}
+
+// ErrV1SecretTagNotClosed is returned when a secret tag of a v1 template is opened, but never closed.
+func ErrV1SecretTagNotClosed(lineNo, colNo int) error {
+	return templateSyntaxError{
+		lineNo: lineNo,
+		colNo:  colNo,
+		code:   "secret_tag_not_closed",
+		msg:    "expected the closing of a secret tag `}`, but reached the end of the template.",
+	}
+}
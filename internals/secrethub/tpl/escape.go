@@ -0,0 +1,171 @@
+package tpl
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Errors
+var (
+	ErrUnknownEscaping = tplError.Code("unknown_escaping").ErrorPref("unknown escaping '%s': the options are json, yaml, shell, xml, toml and none")
+)
+
+// Escaping is a file format for which the values of secrets are escaped when they are injected into a template.
+// Values are escaped so that they can be used between double quotes, or in text content for XML.
+type Escaping string
+
+// Supported escapings.
+const (
+	EscapingJSON  Escaping = "json"
+	EscapingYAML  Escaping = "yaml"
+	EscapingShell Escaping = "shell"
+	EscapingXML   Escaping = "xml"
+	EscapingTOML  Escaping = "toml"
+	EscapingNone  Escaping = "none"
+)
+
+// escapers contains the function that escapes a value for every escaping.
+var escapers = map[Escaping]func(string) string{
+	EscapingJSON:  jsonEscape,
+	EscapingYAML:  yamlEscape,
+	EscapingShell: shellEscape,
+	EscapingXML:   xmlEscape,
+	EscapingTOML:  tomlEscape,
+	EscapingNone: func(input string) string {
+		return input
+	},
+}
+
+// escapingExtensions contains the escaping to use for files with the given extension.
+var escapingExtensions = map[string]Escaping{
+	".json": EscapingJSON,
+	".yaml": EscapingYAML,
+	".yml":  EscapingYAML,
+	".sh":   EscapingShell,
+	".bash": EscapingShell,
+	".zsh":  EscapingShell,
+	".xml":  EscapingXML,
+	".toml": EscapingTOML,
+}
+
+// escapingFilters are the filters that escape the value of a secret tag themselves.
+// Secret tags with any of these filters are not escaped automatically.
+var escapingFilters = map[string]bool{
+	escapeFilter: true,
+	"jsonescape": true,
+	"yamlquote":  true,
+}
+
+// ParseEscaping returns the escaping with the given name.
+func ParseEscaping(name string) (Escaping, error) {
+	escaping := Escaping(strings.ToLower(name))
+	if _, ok := escapers[escaping]; !ok {
+		return "", ErrUnknownEscaping(name)
+	}
+	return escaping, nil
+}
+
+// EscapingForFile returns the escaping for the format of the file with the given name, based on its extension.
+// EscapingNone is returned when the format of the file is not known.
+func EscapingForFile(name string) Escaping {
+	escaping, ok := escapingExtensions[strings.ToLower(filepath.Ext(name))]
+	if !ok {
+		return EscapingNone
+	}
+	return escaping
+}
+
+// yamlEscape escapes the input so that it can be used between double quotes in a YAML document.
+func yamlEscape(input string) string {
+	var buf strings.Builder
+	for i := 0; i < len(input); {
+		r, size := utf8.DecodeRuneInString(input[i:])
+		if r == utf8.RuneError && size == 1 {
+			fmt.Fprintf(&buf, `\x%02x`, input[i])
+			i += size
+			continue
+		}
+		i += size
+
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case 0:
+			buf.WriteString(`\0`)
+		default:
+			if unicode.IsControl(r) {
+				if r <= 0xff {
+					fmt.Fprintf(&buf, `\x%02x`, r)
+				} else {
+					fmt.Fprintf(&buf, `\u%04x`, r)
+				}
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	return buf.String()
+}
+
+// shellEscape escapes the input so that it can be used between double quotes in a POSIX shell script.
+func shellEscape(input string) string {
+	var buf strings.Builder
+	for _, r := range input {
+		switch r {
+		case '"', '\\', '$', '`':
+			buf.WriteByte('\\')
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+// xmlEscape escapes the input so that it can be used in the text content or in a quoted attribute of an XML document.
+func xmlEscape(input string) string {
+	var buf strings.Builder
+	// Writing to a strings.Builder never fails.
+	_ = xml.EscapeText(&buf, []byte(input))
+	return buf.String()
+}
+
+// tomlEscape escapes the input so that it can be used in a basic string, between double quotes, in a TOML document.
+func tomlEscape(input string) string {
+	var buf strings.Builder
+	for _, r := range input {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\r':
+			buf.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&buf, `\u%04X`, r)
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,165 @@
+package tpl
+
+import (
+	"testing"
+
+	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl/fakes"
+
+	"github.com/secrethub/secrethub-go/internals/assert"
+)
+
+func TestEscapers(t *testing.T) {
+	cases := map[string]struct {
+		escaping Escaping
+		input    string
+		expected string
+	}{
+		"json": {
+			escaping: EscapingJSON,
+			input:    "p\"a\\ss\nword<>",
+			expected: `p\"a\\ss\nword<>`,
+		},
+		"yaml": {
+			escaping: EscapingYAML,
+			input:    "p\"a\\ss\nword\x01",
+			expected: `p\"a\\ss\nword\x01`,
+		},
+		"shell": {
+			escaping: EscapingShell,
+			input:    "p\"a\\ss`$(word)`\n'",
+			expected: "p\\\"a\\\\ss\\`\\$(word)\\`\n'",
+		},
+		"xml": {
+			escaping: EscapingXML,
+			input:    `<p"a&ss'word>`,
+			expected: "&lt;p&#34;a&amp;ss&#39;word&gt;",
+		},
+		"toml": {
+			escaping: EscapingTOML,
+			input:    "p\"a\\ss\nword\x01\x7f",
+			expected: `p\"a\\ss\nword\u0001\u007F`,
+		},
+		"none": {
+			escaping: EscapingNone,
+			input:    "p\"a\\ss\nword",
+			expected: "p\"a\\ss\nword",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			actual := escapers[tc.escaping](tc.input)
+			assert.Equal(t, actual, tc.expected)
+		})
+	}
+}
+
+func TestParseEscaping(t *testing.T) {
+	cases := map[string]struct {
+		name     string
+		expected Escaping
+		err      error
+	}{
+		"json": {
+			name:     "json",
+			expected: EscapingJSON,
+		},
+		"uppercase": {
+			name:     "YAML",
+			expected: EscapingYAML,
+		},
+		"unknown": {
+			name: "csv",
+			err:  ErrUnknownEscaping("csv"),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			actual, err := ParseEscaping(tc.name)
+			assert.Equal(t, err, tc.err)
+			assert.Equal(t, actual, tc.expected)
+		})
+	}
+}
+
+func TestEscapingForFile(t *testing.T) {
+	cases := map[string]Escaping{
+		"config.json":         EscapingJSON,
+		"config/app.yml":      EscapingYAML,
+		"docker-compose.YAML": EscapingYAML,
+		"start.sh":            EscapingShell,
+		"web.xml":             EscapingXML,
+		"Cargo.toml":          EscapingTOML,
+		"config.ini":          EscapingNone,
+		"Makefile":            EscapingNone,
+	}
+
+	for name, expected := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, EscapingForFile(name), expected)
+		})
+	}
+}
+
+func TestEscapingV2Parser(t *testing.T) {
+	secretReader := fakes.FakeSecretReader{
+		Secrets: map[string]string{
+			"company/app/pass": `pa"ss`,
+			"company/app/json": `{"password": "pa\"ss"}`,
+		},
+	}
+
+	cases := map[string]struct {
+		escaping Escaping
+		raw      string
+		expected string
+	}{
+		"escaped secret": {
+			escaping: EscapingJSON,
+			raw:      `{"password": "{{ company/app/pass }}"}`,
+			expected: `{"password": "pa\"ss"}`,
+		},
+		"filtered value": {
+			escaping: EscapingJSON,
+			raw:      `{"password": "{{ company/app/json | json ".password" }}"}`,
+			expected: `{"password": "pa\"ss"}`,
+		},
+		"default value": {
+			escaping: EscapingYAML,
+			raw:      `user: "{{ company/app/user | default "ro\ot" }}"`,
+			expected: `user: "ro\\ot"`,
+		},
+		"per tag escaping": {
+			escaping: EscapingJSON,
+			raw:      `export PASS="{{ company/app/pass | escape "shell" }}"`,
+			expected: `export PASS="pa\"ss"`,
+		},
+		"per tag no escaping": {
+			escaping: EscapingJSON,
+			raw:      `{{ company/app/json | escape "none" }}`,
+			expected: `{"password": "pa\"ss"}`,
+		},
+		"escaping filter": {
+			escaping: EscapingYAML,
+			raw:      `password: {{ company/app/pass | yamlquote }}`,
+			expected: `password: "pa\"ss"`,
+		},
+		"no escaping": {
+			escaping: EscapingNone,
+			raw:      `password: {{ company/app/pass }}`,
+			expected: `password: pa"ss`,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			template, err := NewEscapingV2Parser(tc.escaping).Parse(tc.raw, 1, 1)
+			assert.OK(t, err)
+
+			actual, err := template.Evaluate(fakes.FakeVariableReader{}, secretReader)
+			assert.OK(t, err)
+			assert.Equal(t, actual, tc.expected)
+		})
+	}
+}
@@ -1,8 +1,12 @@
 package fakes
 
-import "errors"
+import (
+	gopath "path"
 
-// FakeSecretReader implements tpl.SecretReader.
+	"github.com/secrethub/secrethub-go/internals/api"
+)
+
+// FakeSecretReader implements tpl.SecretReader and tpl.DirReader.
 type FakeSecretReader struct {
 	Secrets map[string]string
 }
@@ -13,5 +17,20 @@ func (fsr FakeSecretReader) ReadSecret(path string) (string, error) {
 	if ok {
 		return secret, nil
 	}
-	return "", errors.New("secret not found")
+	return "", api.ErrSecretNotFound
+}
+
+// ListSecrets implements tpl.DirReader.ListSecrets.
+// A directory exists when it directly contains at least one of the secrets.
+func (fsr FakeSecretReader) ListSecrets(dirPath string) ([]string, error) {
+	var paths []string
+	for path := range fsr.Secrets {
+		if gopath.Dir(path) == dirPath {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return nil, api.ErrDirNotFound
+	}
+	return paths, nil
 }
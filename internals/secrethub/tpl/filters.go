@@ -0,0 +1,365 @@
+package tpl
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Filter errors
+var (
+	errJSONFieldNotFound = errors.New("field not found")
+	errNotJSON           = errors.New("value is not valid JSON")
+	errNotBase64         = errors.New("value is not valid base64")
+)
+
+// defaultFilter is the name of the filter that replaces empty and absent values.
+const defaultFilter = "default"
+
+// escapeFilter is the name of the filter that escapes a value for a given escaping.
+const escapeFilter = "escape"
+
+// filter is a single step in the pipeline of a secret tag, e.g. `| indent 4`.
+type filter struct {
+	name   string
+	args   []string
+	file   string
+	lineNo int
+	colNo  int
+}
+
+// apply applies the filter on the given input.
+// Errors returned by the filter are annotated with the position of the filter in the template.
+func (f filter) apply(input string) (string, error) {
+	output, err := filters[f.name].apply(input, f.args)
+	if err != nil {
+		return "", filterError{
+			file:   f.file,
+			lineNo: f.lineNo,
+			colNo:  f.colNo,
+			filter: f.name,
+			err:    err,
+		}
+	}
+	return output, nil
+}
+
+// filterDefinition defines the arguments and behavior of a filter.
+type filterDefinition struct {
+	// args are the names of the arguments the filter takes.
+	args []string
+	// optionalArgs are the names of the arguments that can be given after args.
+	optionalArgs []string
+	// variadic is set when the last optional argument can be repeated.
+	variadic bool
+	// validate checks the arguments when the template is parsed. It can be nil.
+	validate func(args []string) error
+	apply    func(input string, args []string) (string, error)
+}
+
+// filters contains all filters that can be used in the pipeline of a secret tag, by name.
+var filters = map[string]filterDefinition{
+	"json": {
+		args: []string{"path"},
+		validate: func(args []string) error {
+			_, err := parseJSONPath(args[0])
+			return err
+		},
+		apply: func(input string, args []string) (string, error) {
+			path, err := parseJSONPath(args[0])
+			if err != nil {
+				return "", err
+			}
+			return extractJSON(input, path)
+		},
+	},
+	"base64decode": {
+		apply: func(input string, _ []string) (string, error) {
+			input = strings.TrimSpace(input)
+			decoded, err := base64.StdEncoding.DecodeString(input)
+			if err != nil {
+				decoded, err = base64.RawStdEncoding.DecodeString(input)
+				if err != nil {
+					return "", errNotBase64
+				}
+			}
+			return string(decoded), nil
+		},
+	},
+	"base64encode": {
+		apply: func(input string, _ []string) (string, error) {
+			return base64.StdEncoding.EncodeToString([]byte(input)), nil
+		},
+	},
+	"trim": {
+		apply: func(input string, _ []string) (string, error) {
+			return strings.TrimSpace(input), nil
+		},
+	},
+	"jsonescape": {
+		apply: func(input string, _ []string) (string, error) {
+			return jsonEscape(input), nil
+		},
+	},
+	"yamlquote": {
+		apply: func(input string, _ []string) (string, error) {
+			return yamlQuote(input), nil
+		},
+	},
+	escapeFilter: {
+		args: []string{"escaping"},
+		validate: func(args []string) error {
+			_, err := ParseEscaping(args[0])
+			return err
+		},
+		apply: func(input string, args []string) (string, error) {
+			escaping, err := ParseEscaping(args[0])
+			if err != nil {
+				return "", err
+			}
+			return escapers[escaping](input), nil
+		},
+	},
+	"indent": {
+		args: []string{"width"},
+		validate: func(args []string) error {
+			_, err := parseIndentWidth(args[0])
+			return err
+		},
+		apply: func(input string, args []string) (string, error) {
+			width, err := parseIndentWidth(args[0])
+			if err != nil {
+				return "", err
+			}
+			return indent(input, width), nil
+		},
+	},
+	generateFilter: {
+		args:         []string{"length"},
+		optionalArgs: []string{"charset", "min"},
+		variadic:     true,
+		validate: func(args []string) error {
+			_, err := parseGenerateOptions(args)
+			return err
+		},
+		// Existing secrets are passed through. Missing secrets are generated when the pipeline is evaluated.
+		apply: func(input string, _ []string) (string, error) {
+			return input, nil
+		},
+	},
+	defaultFilter: {
+		args: []string{"value"},
+		apply: func(input string, args []string) (string, error) {
+			if input == "" {
+				return args[0], nil
+			}
+			return input, nil
+		},
+	},
+}
+
+// newFilter returns the filter with the given name and arguments, positioned at
+// the given line and column. An error is returned when the filter does not exist
+// or when the arguments are invalid.
+func newFilter(name string, args []string, lineNo, colNo int) (filter, error) {
+	def, ok := filters[name]
+	if !ok {
+		return filter{}, ErrUnknownFilter(lineNo, colNo, name)
+	}
+
+	if !def.acceptsArgs(len(args)) {
+		return filter{}, ErrInvalidFilterArguments(lineNo, colNo, name, def.expectedArgs(len(args)))
+	}
+
+	if def.validate != nil {
+		err := def.validate(args)
+		if err != nil {
+			return filter{}, ErrInvalidFilterArguments(lineNo, colNo, name, err.Error())
+		}
+	}
+
+	return filter{
+		name:   name,
+		args:   args,
+		lineNo: lineNo,
+		colNo:  colNo,
+	}, nil
+}
+
+// acceptsArgs returns whether the filter can be given the given number of arguments.
+func (def filterDefinition) acceptsArgs(n int) bool {
+	if n < len(def.args) {
+		return false
+	}
+	return def.variadic || n <= len(def.args)+len(def.optionalArgs)
+}
+
+// expectedArgs describes the arguments the filter expects, for when it is given the wrong number of arguments.
+func (def filterDefinition) expectedArgs(n int) string {
+	if len(def.optionalArgs) == 0 {
+		return fmt.Sprintf("expected %d argument(s) (%s), got %d", len(def.args), strings.Join(def.args, ", "), n)
+	}
+
+	names := append([]string{}, def.args...)
+	for i, arg := range def.optionalArgs {
+		if def.variadic && i == len(def.optionalArgs)-1 {
+			arg += "..."
+		}
+		names = append(names, "["+arg+"]")
+	}
+
+	if def.variadic {
+		return fmt.Sprintf("expected at least %d argument(s) (%s), got %d", len(def.args), strings.Join(names, ", "), n)
+	}
+	return fmt.Sprintf("expected %d to %d argument(s) (%s), got %d", len(def.args), len(def.args)+len(def.optionalArgs), strings.Join(names, ", "), n)
+}
+
+// isNoValue returns whether the error indicates that a value is absent, rather than
+// that something went wrong. Absent values can be replaced with the default filter.
+func isNoValue(err error) bool {
+	return errors.Is(err, errJSONFieldNotFound)
+}
+
+// jsonPathElement is a single element of a JSON path: either an object key or an array index.
+type jsonPathElement struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// parseJSONPath parses a path into a JSON document, like `.db.hosts[0]` or `.["key.with.dots"]`.
+// The path `.` refers to the whole document.
+func parseJSONPath(path string) ([]jsonPathElement, error) {
+	if !strings.HasPrefix(path, ".") {
+		return nil, fmt.Errorf("JSON path %s should start with a '.'", path)
+	}
+
+	var res []jsonPathElement
+	rest := path
+	for rest != "" && rest != "." {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			if strings.HasPrefix(rest, "[") {
+				continue
+			}
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("JSON path %s contains an empty key", path)
+			}
+			res = append(res, jsonPathElement{key: rest[:end]})
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("JSON path %s contains an unclosed '['", path)
+			}
+			inner := rest[1:end]
+			if strings.HasPrefix(inner, `"`) {
+				key, err := strconv.Unquote(inner)
+				if err != nil {
+					return nil, fmt.Errorf("JSON path %s contains an invalid quoted key %s", path, inner)
+				}
+				res = append(res, jsonPathElement{key: key})
+			} else {
+				index, err := strconv.Atoi(inner)
+				if err != nil || index < 0 {
+					return nil, fmt.Errorf("JSON path %s contains an invalid array index %s", path, inner)
+				}
+				res = append(res, jsonPathElement{index: index, isIndex: true})
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("JSON path %s contains an unexpected '%c'", path, rest[0])
+		}
+	}
+	return res, nil
+}
+
+// extractJSON returns the value on the given path in the JSON document.
+// Strings are returned without quotes, all other values are returned as compact JSON.
+func extractJSON(input string, path []jsonPathElement) (string, error) {
+	decoder := json.NewDecoder(strings.NewReader(input))
+	decoder.UseNumber()
+
+	var doc interface{}
+	err := decoder.Decode(&doc)
+	if err != nil {
+		return "", errNotJSON
+	}
+
+	for _, elem := range path {
+		if elem.isIndex {
+			arr, ok := doc.([]interface{})
+			if !ok || elem.index >= len(arr) {
+				return "", errJSONFieldNotFound
+			}
+			doc = arr[elem.index]
+		} else {
+			obj, ok := doc.(map[string]interface{})
+			if !ok {
+				return "", errJSONFieldNotFound
+			}
+			doc, ok = obj[elem.key]
+			if !ok {
+				return "", errJSONFieldNotFound
+			}
+		}
+	}
+
+	if s, ok := doc.(string); ok {
+		return s, nil
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	err = encoder.Encode(doc)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// jsonEscape escapes the input so that it can be used between double quotes in a JSON document.
+func jsonEscape(input string) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	// Encoding a string never fails.
+	_ = encoder.Encode(input)
+	quoted := strings.TrimSuffix(buf.String(), "\n")
+	return quoted[1 : len(quoted)-1]
+}
+
+// yamlQuote returns the input as a double quoted YAML scalar.
+func yamlQuote(input string) string {
+	return `"` + yamlEscape(input) + `"`
+}
+
+func parseIndentWidth(arg string) (int, error) {
+	width, err := strconv.Atoi(arg)
+	if err != nil || width < 0 {
+		return 0, fmt.Errorf("indent width %s is not a positive number", arg)
+	}
+	return width, nil
+}
+
+// indent prefixes every non-empty line of the input with the given number of spaces.
+func indent(input string, width int) string {
+	prefix := strings.Repeat(" ", width)
+	lines := strings.Split(input, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,192 @@
+package tpl
+
+import (
+	"testing"
+
+	"github.com/secrethub/secrethub-go/internals/assert"
+)
+
+func TestFilters(t *testing.T) {
+	cases := map[string]struct {
+		filter   string
+		args     []string
+		input    string
+		expected string
+		err      error
+	}{
+		"json string field": {
+			filter:   "json",
+			args:     []string{".password"},
+			input:    `{"password": "secret"}`,
+			expected: "secret",
+		},
+		"json nested field": {
+			filter:   "json",
+			args:     []string{".db.hosts[1].name"},
+			input:    `{"db": {"hosts": [{"name": "primary"}, {"name": "replica"}]}}`,
+			expected: "replica",
+		},
+		"json quoted key": {
+			filter:   "json",
+			args:     []string{`.["key.with.dots"]`},
+			input:    `{"key.with.dots": "value"}`,
+			expected: "value",
+		},
+		"json number": {
+			filter:   "json",
+			args:     []string{".port"},
+			input:    `{"port": 12345678901234567890}`,
+			expected: "12345678901234567890",
+		},
+		"json object": {
+			filter:   "json",
+			args:     []string{".db"},
+			input:    `{"db": {"url": "https://example.com/?a=1&b=2"}}`,
+			expected: `{"url":"https://example.com/?a=1&b=2"}`,
+		},
+		"json whole document": {
+			filter:   "json",
+			args:     []string{"."},
+			input:    `[1, 2]`,
+			expected: `[1,2]`,
+		},
+		"json field not found": {
+			filter: "json",
+			args:   []string{".db.user"},
+			input:  `{"db": "postgres"}`,
+			err:    errJSONFieldNotFound,
+		},
+		"json index out of range": {
+			filter: "json",
+			args:   []string{".[2]"},
+			input:  `[1, 2]`,
+			err:    errJSONFieldNotFound,
+		},
+		"json invalid input": {
+			filter: "json",
+			args:   []string{".password"},
+			input:  "password",
+			err:    errNotJSON,
+		},
+		"base64decode": {
+			filter:   "base64decode",
+			input:    "c2VjcmV0\n",
+			expected: "secret",
+		},
+		"base64decode without padding": {
+			filter:   "base64decode",
+			input:    "c2VjcmV0MQ",
+			expected: "secret1",
+		},
+		"base64decode invalid input": {
+			filter: "base64decode",
+			input:  "secret!",
+			err:    errNotBase64,
+		},
+		"base64encode": {
+			filter:   "base64encode",
+			input:    "secret",
+			expected: "c2VjcmV0",
+		},
+		"trim": {
+			filter:   "trim",
+			input:    " \tsecret\r\n",
+			expected: "secret",
+		},
+		"jsonescape": {
+			filter:   "jsonescape",
+			input:    "line \"1\"\n<line 2>\\",
+			expected: `line \"1\"\n<line 2>\\`,
+		},
+		"yamlquote": {
+			filter:   "yamlquote",
+			input:    "it's \"quoted\"\n\ttab\x01",
+			expected: `"it's \"quoted\"\n\ttab\x01"`,
+		},
+		"yamlquote plain": {
+			filter:   "yamlquote",
+			input:    "yes",
+			expected: `"yes"`,
+		},
+		"escape": {
+			filter:   "escape",
+			args:     []string{"shell"},
+			input:    "$HOME \"quoted\"",
+			expected: `\$HOME \"quoted\"`,
+		},
+		"indent": {
+			filter:   "indent",
+			args:     []string{"4"},
+			input:    "-----BEGIN KEY-----\n\nabc\n-----END KEY-----\n",
+			expected: "    -----BEGIN KEY-----\n\n    abc\n    -----END KEY-----\n",
+		},
+		"default on empty": {
+			filter:   "default",
+			args:     []string{"fallback"},
+			input:    "",
+			expected: "fallback",
+		},
+		"default on value": {
+			filter:   "default",
+			args:     []string{"fallback"},
+			input:    "value",
+			expected: "value",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			actual, err := filters[tc.filter].apply(tc.input, tc.args)
+			assert.Equal(t, err, tc.err)
+			assert.Equal(t, actual, tc.expected)
+		})
+	}
+}
+
+func TestParseJSONPath(t *testing.T) {
+	cases := map[string]struct {
+		path     string
+		expected []jsonPathElement
+		err      bool
+	}{
+		"root": {
+			path: ".",
+		},
+		"keys": {
+			path:     ".db.password",
+			expected: []jsonPathElement{{key: "db"}, {key: "password"}},
+		},
+		"indices": {
+			path:     ".hosts[0][1]",
+			expected: []jsonPathElement{{key: "hosts"}, {index: 0, isIndex: true}, {index: 1, isIndex: true}},
+		},
+		"quoted key": {
+			path:     `.["a.b"].c`,
+			expected: []jsonPathElement{{key: "a.b"}, {key: "c"}},
+		},
+		"no leading dot": {
+			path: "db",
+			err:  true,
+		},
+		"empty key": {
+			path: ".db..password",
+			err:  true,
+		},
+		"unclosed bracket": {
+			path: ".hosts[0",
+			err:  true,
+		},
+		"negative index": {
+			path: ".hosts[-1]",
+			err:  true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			actual, err := parseJSONPath(tc.path)
+			assert.Equal(t, err != nil, tc.err)
+			assert.Equal(t, actual, tc.expected)
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package tpl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/secrethub/secrethub-go/pkg/randchar"
+)
+
+// generateFilter is the name of the filter that generates the value of a secret that does not exist.
+const generateFilter = "generate"
+
+// SecretGenerator generates secrets that do not exist yet. A SecretReader that implements
+// SecretGenerator can be used to create the missing secrets of secret tags with a generate filter.
+type SecretGenerator interface {
+	// GenerateSecret generates a random value for the secret on the given path, writes it and returns the value.
+	GenerateSecret(path string, options GenerateOptions) (string, error)
+}
+
+// GenerateOptions are the arguments of a generate filter, e.g. `| generate 32 "alphanumeric" "numeric:2"`.
+type GenerateOptions struct {
+	Length int
+	// Charset is a comma separated list of the names of the character sets to generate the secret from.
+	Charset string
+	// Min contains rules of the form <charset>:<n>, which require the secret to contain
+	// at least n characters from the given character set.
+	Min []string
+}
+
+// parseGenerateOptions parses the arguments of a generate filter. The length is required and the character
+// sets default to alphanumeric. All arguments after the character sets are min rules.
+func parseGenerateOptions(args []string) (GenerateOptions, error) {
+	length, err := strconv.Atoi(args[0])
+	if err != nil || length <= 0 {
+		return GenerateOptions{}, fmt.Errorf("secret length %s is not a number larger than 0", args[0])
+	}
+
+	options := GenerateOptions{
+		Length:  length,
+		Charset: "alphanumeric",
+	}
+	if len(args) > 1 {
+		options.Charset = args[1]
+		options.Min = args[2:]
+	}
+
+	for _, name := range strings.Split(options.Charset, ",") {
+		_, ok := randchar.CharsetByName(name)
+		if !ok {
+			return GenerateOptions{}, fmt.Errorf("unknown charset %s", name)
+		}
+	}
+
+	for _, rule := range options.Min {
+		elements := strings.Split(rule, ":")
+		if len(elements) != 2 {
+			return GenerateOptions{}, fmt.Errorf("min rule %s should be of the form <charset>:<n>", rule)
+		}
+		if _, ok := randchar.CharsetByName(elements[0]); !ok {
+			return GenerateOptions{}, fmt.Errorf("unknown charset %s in min rule %s", elements[0], rule)
+		}
+		if _, err := strconv.Atoi(elements[1]); err != nil {
+			return GenerateOptions{}, fmt.Errorf("count %s in min rule %s is not a number", elements[1], rule)
+		}
+	}
+
+	return options, nil
+}
+
+// canGenerate returns whether missing secrets can be generated in the context.
+func (ctx context) canGenerate() bool {
+	_, ok := ctx.secretReader.(SecretGenerator)
+	return ok
+}
+
+// generate generates the value of the missing secret on the given path with the given generate filter.
+// It should only be called when canGenerate returns true.
+func (ctx context) generate(path string, f filter) (string, error) {
+	// The arguments have been validated when the template was parsed.
+	options, err := parseGenerateOptions(f.args)
+	if err != nil {
+		return "", err
+	}
+	return ctx.secretReader.(SecretGenerator).GenerateSecret(path, options)
+}
+
+// hasGenerate returns whether the filters contain a generate filter.
+func hasGenerate(filters []filter) bool {
+	for _, f := range filters {
+		if f.name == generateFilter {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package tpl
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl/fakes"
+
+	"github.com/secrethub/secrethub-go/internals/api"
+	"github.com/secrethub/secrethub-go/internals/assert"
+)
+
+func TestParseGenerateOptions(t *testing.T) {
+	cases := map[string]struct {
+		args     []string
+		expected GenerateOptions
+		err      error
+	}{
+		"length": {
+			args: []string{"32"},
+			expected: GenerateOptions{
+				Length:  32,
+				Charset: "alphanumeric",
+			},
+		},
+		"charsets and min rules": {
+			args: []string{"16", "lowercase,numeric", "numeric:2", "lowercase:4"},
+			expected: GenerateOptions{
+				Length:  16,
+				Charset: "lowercase,numeric",
+				Min:     []string{"numeric:2", "lowercase:4"},
+			},
+		},
+		"invalid length": {
+			args: []string{"0"},
+			err:  errors.New("secret length 0 is not a number larger than 0"),
+		},
+		"unknown charset": {
+			args: []string{"16", "emoji"},
+			err:  errors.New("unknown charset emoji"),
+		},
+		"invalid min rule": {
+			args: []string{"16", "alphanumeric", "numeric"},
+			err:  errors.New("min rule numeric should be of the form <charset>:<n>"),
+		},
+		"invalid min count": {
+			args: []string{"16", "alphanumeric", "numeric:two"},
+			err:  errors.New("count two in min rule numeric:two is not a number"),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			actual, err := parseGenerateOptions(tc.args)
+			assert.Equal(t, err, tc.err)
+			assert.Equal(t, actual, tc.expected)
+		})
+	}
+}
+
+// generatingSecretReader is a SecretReader that generates missing secrets.
+type generatingSecretReader struct {
+	fakes.FakeSecretReader
+	generated map[string]GenerateOptions
+}
+
+func (sr generatingSecretReader) GenerateSecret(path string, options GenerateOptions) (string, error) {
+	sr.generated[path] = options
+	return "generated-" + path, nil
+}
+
+func TestV2_Generate(t *testing.T) {
+	cases := map[string]struct {
+		raw       string
+		secrets   map[string]string
+		generator bool
+
+		expected  string
+		generated map[string]GenerateOptions
+		err       error
+	}{
+		"existing secret": {
+			raw:       `{{ app/key | generate 32 }}`,
+			secrets:   map[string]string{"app/key": "existing"},
+			generator: true,
+			expected:  "existing",
+			generated: map[string]GenerateOptions{},
+		},
+		"missing secret": {
+			raw:       `{{ app/${env}/key | generate 16 "numeric" "numeric:2" | base64encode }}`,
+			generator: true,
+			expected:  "Z2VuZXJhdGVkLWFwcC9wcm9kL2tleQ==",
+			generated: map[string]GenerateOptions{
+				"app/prod/key": {Length: 16, Charset: "numeric", Min: []string{"numeric:2"}},
+			},
+		},
+		"generate before default": {
+			raw:       `{{ app/key | generate 32 | default "x" }}`,
+			generator: true,
+			expected:  "generated-app/key",
+			generated: map[string]GenerateOptions{
+				"app/key": {Length: 32, Charset: "alphanumeric"},
+			},
+		},
+		"missing secret without generator": {
+			raw: `{{ app/key | generate 32 }}`,
+			err: evaluationError{lineNo: 1, colNo: 1, err: api.ErrSecretNotFound},
+		},
+		"default without generator": {
+			raw:      `{{ app/key | generate 32 | default "x" }}`,
+			expected: "x",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			parsed, err := NewV2Parser().Parse(tc.raw, 1, 1)
+			assert.OK(t, err)
+
+			var sr SecretReader = fakes.FakeSecretReader{Secrets: tc.secrets}
+			generated := map[string]GenerateOptions{}
+			if tc.generator {
+				sr = generatingSecretReader{
+					FakeSecretReader: fakes.FakeSecretReader{Secrets: tc.secrets},
+					generated:        generated,
+				}
+			}
+
+			actual, err := parsed.Evaluate(fakes.FakeVariableReader{Variables: map[string]string{"env": "prod"}}, sr)
+			assert.Equal(t, err, tc.err)
+			assert.Equal(t, actual, tc.expected)
+			if tc.generator {
+				assert.Equal(t, generated, tc.generated)
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package tpl
+
+import (
+	"bytes"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl/internal/token"
+)
+
+// actionInclude includes another template, e.g. `{{ include "partials/db.tpl" }}`.
+const actionInclude = "include"
+
+// includeLine matches a line that contains nothing but an include tag.
+var includeLine = regexp.MustCompile(`^([ \t]*)\{\{[ \t]*include[ \t]+"([^"]*)"[ \t]*\}\}[ \t]*\r?$`)
+
+// included is a template that is included by an include tag. It is evaluated
+// with the same variables and secrets as the template that includes it.
+type included struct {
+	nodes      []node
+	references []Reference
+	lineNo     int
+	colNo      int
+}
+
+func (i included) evaluate(ctx context) (string, error) {
+	return evaluateNodes(ctx, i.nodes)
+}
+
+// parseInclude parses the path of an include tag up to the closing delimiter and parses the
+// included template. The tag starts at the given position. The next character should be the
+// first character after the include action when parseInclude is called. io.EOF is returned
+// when the end of the template is reached before the tag is closed.
+//
+// When parseInclude returns, the next character in the buffer is the last character
+// of the closing delimiter of the tag ('}').
+func (p *v2Parser) parseInclude(lineNo, colNo int) (node, error) {
+	err := p.skipWhiteSpace()
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := p.parseFileArgument()
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.skipWhiteSpace()
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.parseClosingDelimiter()
+	if err != nil {
+		return nil, err
+	}
+
+	settings, raw, err := p.settings.readInclude(path, lineNo, colNo, p.includedBy)
+	if err != nil {
+		return nil, err
+	}
+
+	file := settings.file
+	template, err := settings.parse(string(raw), 1, 1, append(append([]string{}, p.includedBy...), p.settings.file))
+	if err != nil {
+		return nil, includeError(file, err)
+	}
+
+	for i := range template.references {
+		if template.references[i].File == "" {
+			template.references[i].File = file
+		}
+	}
+
+	inc := included{
+		nodes:      template.nodes,
+		references: template.references,
+		lineNo:     lineNo,
+		colNo:      colNo,
+	}
+	p.includes = append(p.includes, inc)
+	return inc, nil
+}
+
+// readInclude reads the template included by an include tag with the given path at the given position of the
+// template in the file of the parser, which is included by the templates in includedBy. It returns the included
+// template and the settings to parse it with, which resolve include tags relative to the included template.
+func (p parserV2) readInclude(path string, lineNo, colNo int, includedBy []string) (parserV2, []byte, error) {
+	if p.readFile == nil {
+		return parserV2{}, nil, ErrIncludesNotSupported(lineNo, colNo)
+	}
+
+	file := filepath.Clean(filepath.FromSlash(path))
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(filepath.Dir(p.file), file)
+	}
+
+	files := append(append([]string{}, includedBy...), p.file)
+	for i, including := range files {
+		if filepath.Clean(including) == file {
+			return parserV2{}, nil, ErrIncludeCycle(lineNo, colNo, append(files[i:], file))
+		}
+	}
+
+	raw, err := p.readFile(file)
+	if err != nil {
+		return parserV2{}, nil, ErrCannotReadInclude(lineNo, colNo, file, err)
+	}
+
+	settings := p
+	settings.file = file
+	return settings, raw, nil
+}
+
+// Line is a line of a template, or of a template included by it.
+type Line struct {
+	Text string
+	// File is the file of the included template that contains the line.
+	// It is empty for lines of the template itself.
+	File string
+	// LineNo is the number of the line, within File if it is set.
+	LineNo int
+	// Parser parses the tags on the line, resolving include tags relative to the file that contains the line.
+	Parser Parser
+}
+
+// IncludeLines splits the template into lines and replaces every include tag that is on a line of its own
+// with the lines of the included template. This allows templates to include multiple lines of formats that
+// are parsed line by line, like env files, before the tags on the lines are parsed. Include tags that are not
+// on a line of their own are left to the parser. When the parser does not support include tags, the lines
+// of the template are returned as they are.
+func IncludeLines(parser Parser, raw string) ([]Line, error) {
+	p, ok := parser.(parserV2)
+	if !ok || p.readFile == nil {
+		lines := strings.Split(raw, "\n")
+		res := make([]Line, len(lines))
+		for i, text := range lines {
+			res[i] = Line{Text: text, LineNo: i + 1, Parser: parser}
+		}
+		return res, nil
+	}
+	return p.includeLines(raw, nil)
+}
+
+// includeLines returns the lines of the template in the file of the parser, which is included by the templates
+// in includedBy, with include tags on a line of their own replaced by the lines of the included templates.
+func (p parserV2) includeLines(raw string, includedBy []string) ([]Line, error) {
+	var file string
+	var parser Parser = p
+	if len(includedBy) > 0 {
+		file = p.file
+		parser = includedParser{parser: p, includedBy: includedBy}
+	}
+
+	var res []Line
+	for i, text := range strings.Split(raw, "\n") {
+		lineNo := i + 1
+		match := includeLine.FindStringSubmatch(text)
+		if match == nil {
+			res = append(res, Line{Text: text, File: file, LineNo: lineNo, Parser: parser})
+			continue
+		}
+
+		colNo := utf8.RuneCountInString(match[1]) + 1
+		settings, included, err := p.readInclude(match[2], lineNo, colNo, includedBy)
+		if err != nil {
+			return nil, err
+		}
+
+		lines, err := settings.includeLines(strings.TrimSuffix(string(included), "\n"), append(append([]string{}, includedBy...), p.file))
+		if err != nil {
+			return nil, includeError(settings.file, err)
+		}
+		res = append(res, lines...)
+	}
+	return res, nil
+}
+
+// includedParser parses the lines of an included template, like the included template is parsed by an include tag.
+type includedParser struct {
+	parser     parserV2
+	includedBy []string
+}
+
+func (p includedParser) Parse(raw string, line, column int) (Template, error) {
+	template, err := p.parser.parse(raw, line, column, p.includedBy)
+	if err != nil {
+		return nil, includeError(p.parser.file, err)
+	}
+
+	for i := range template.references {
+		if template.references[i].File == "" {
+			template.references[i].File = p.parser.file
+		}
+	}
+	return template, nil
+}
+
+// parseFileArgument parses a file path between double quotes.
+// The next character should be the opening quote when parseFileArgument is called.
+//
+// When parseFileArgument returns, the current character is the closing quote.
+func (p *v2Parser) parseFileArgument() (string, error) {
+	if p.next != token.Quote {
+		return "", ErrUnexpectedCharacter(p.lineNo, p.columnNo+1, p.next, token.Quote)
+	}
+
+	lineNo, colNo := p.lineNo, p.columnNo+1
+	err := p.readRune()
+	if err != nil {
+		return "", err
+	}
+
+	var buffer bytes.Buffer
+	for {
+		err := p.readRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch p.current {
+		case token.Quote:
+			return buffer.String(), nil
+		case '\n':
+			return "", ErrPathArgumentNotClosed(lineNo, colNo)
+		default:
+			buffer.WriteRune(p.current)
+		}
+	}
+}
+
+// includeError annotates an error returned when parsing an included template with the file of
+// that template, unless the error occurred in a template included by it and already has a file.
+func includeError(file string, err error) error {
+	syntaxErr, ok := err.(templateSyntaxError)
+	if !ok || syntaxErr.file != "" {
+		return err
+	}
+	syntaxErr.file = file
+	return syntaxErr
+}
+
+// mergeIncludedReferences returns the references of a template with the references of the included
+// templates inserted at the positions of their include tags. Both the references and the included
+// templates should be given in order of appearance.
+func mergeIncludedReferences(references []Reference, includes []included) []Reference {
+	if len(includes) == 0 {
+		return references
+	}
+
+	res := make([]Reference, 0, len(references))
+	i := 0
+	for _, inc := range includes {
+		for i < len(references) && (references[i].LineNo < inc.lineNo || (references[i].LineNo == inc.lineNo && references[i].ColNo < inc.colNo)) {
+			res = append(res, references[i])
+			i++
+		}
+		res = append(res, inc.references...)
+	}
+	return append(res, references[i:]...)
+}
@@ -0,0 +1,296 @@
+package tpl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl/fakes"
+
+	"github.com/secrethub/secrethub-go/internals/api"
+	"github.com/secrethub/secrethub-go/internals/assert"
+)
+
+func TestV2_Include(t *testing.T) {
+	files := map[string]string{
+		filepath.FromSlash("templates/partials/db.tpl"):     "host={{ company/${env}/db/host }}\npass={{ company/${env}/db/pass }}",
+		filepath.FromSlash("templates/partials/nested.tpl"): `[{{ include "db.tpl" }}]`,
+		filepath.FromSlash("templates/partials/tenant.tpl"): `{{ company/tenants/${tenant} }}`,
+		filepath.FromSlash("templates/partials/broken.tpl"): "ok\n{{ company/db/pass }",
+		filepath.FromSlash("templates/partials/filter.tpl"): `{{ company/prod/db/host | base64decode }}`,
+		filepath.FromSlash("templates/partials/self.tpl"):   `{{ include "self.tpl" }}`,
+		filepath.FromSlash("templates/partials/a.tpl"):      `{{ include "b.tpl" }}`,
+		filepath.FromSlash("templates/partials/b.tpl"):      `{{ include "a.tpl" }}`,
+		filepath.FromSlash("templates/partials/range.tpl"):  `{{ range dir "company/tenants" }}{{ .Name }} {{ end }}`,
+		filepath.FromSlash("templates/partials/field.tpl"):  `{{ .Name }}`,
+		filepath.FromSlash("templates/partials/absent.tpl"): "user=root\npass={{ company/absent }}",
+	}
+	readFile := func(filename string) ([]byte, error) {
+		content, ok := files[filename]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return []byte(content), nil
+	}
+
+	secrets := map[string]string{
+		"company/prod/db/host":    "db.example.com",
+		"company/prod/db/pass":    `p"ss`,
+		"company/tenants/acme":    "a1",
+		"company/tenants/initech": "i2",
+	}
+
+	cases := map[string]struct {
+		raw        string
+		escaping   Escaping
+		noIncludes bool
+
+		expected string
+		parseErr error
+		evalErr  error
+	}{
+		"include": {
+			raw:      "[db]\n{{ include \"partials/db.tpl\" }}\n",
+			expected: "[db]\nhost=db.example.com\npass=p\"ss\n",
+		},
+		"nested include relative to included template": {
+			raw:      `{{include "partials/nested.tpl"}}`,
+			expected: "[host=db.example.com\npass=p\"ss]",
+		},
+		"include escaped": {
+			raw:      `{{ include "partials/db.tpl" }}`,
+			escaping: EscapingJSON,
+			expected: "host=db.example.com\npass=p\\\"ss",
+		},
+		"include in blocks": {
+			raw:      `{{ range dir "company/tenants" }}{{ if exists "company/prod/db/host" }}{{ include "partials/tenant.tpl" }};{{ end }}{{ end }}`,
+			expected: "a1;a1;",
+		},
+		"include with range": {
+			raw:      `tenants: {{ include "partials/range.tpl" }}`,
+			expected: "tenants: acme initech ",
+		},
+		"include not found": {
+			raw:      "\n  {{ include \"partials/missing.tpl\" }}",
+			parseErr: ErrCannotReadInclude(2, 3, filepath.FromSlash("templates/partials/missing.tpl"), os.ErrNotExist),
+		},
+		"syntax error in included template": {
+			raw:      `{{ include "partials/broken.tpl" }}`,
+			parseErr: templateSyntaxError{file: filepath.FromSlash("templates/partials/broken.tpl"), lineNo: 2, colNo: 21, code: "secret_tag_not_closed", msg: "expected the closing of a secret tag `}}`, but reached the end of the template."},
+		},
+		"field in included template": {
+			raw:      `{{ range dir "company/tenants" }}{{ include "partials/field.tpl" }}{{ end }}`,
+			parseErr: templateSyntaxError{file: filepath.FromSlash("templates/partials/field.tpl"), lineNo: 1, colNo: 1, code: "field_outside_range", msg: "field '.Name' can only be used within a range block."},
+		},
+		"filter error in included template": {
+			raw:     `{{ include "partials/filter.tpl" }}`,
+			evalErr: filterError{file: filepath.FromSlash("templates/partials/filter.tpl"), lineNo: 1, colNo: 27, filter: "base64decode", err: errNotBase64},
+		},
+		"missing secret in included template": {
+			raw:     `{{ include "partials/absent.tpl" }}`,
+			evalErr: evaluationError{file: filepath.FromSlash("templates/partials/absent.tpl"), lineNo: 2, colNo: 6, err: api.ErrSecretNotFound},
+		},
+		"include itself": {
+			raw: `{{ include "partials/self.tpl" }}`,
+			parseErr: templateSyntaxError{
+				file:   filepath.FromSlash("templates/partials/self.tpl"),
+				lineNo: 1,
+				colNo:  1,
+				code:   "include_cycle",
+				msg:    "templates cannot include themselves: " + filepath.FromSlash("templates/partials/self.tpl") + " includes " + filepath.FromSlash("templates/partials/self.tpl") + ".",
+			},
+		},
+		"include cycle": {
+			raw: `{{ include "partials/a.tpl" }}`,
+			parseErr: templateSyntaxError{
+				file:   filepath.FromSlash("templates/partials/b.tpl"),
+				lineNo: 1,
+				colNo:  1,
+				code:   "include_cycle",
+				msg:    "templates cannot include themselves: " + filepath.FromSlash("templates/partials/a.tpl") + " includes " + filepath.FromSlash("templates/partials/b.tpl") + " includes " + filepath.FromSlash("templates/partials/a.tpl") + ".",
+			},
+		},
+		"include not supported": {
+			raw:        `x{{ include "partials/db.tpl" }}`,
+			noIncludes: true,
+			parseErr:   ErrIncludesNotSupported(1, 2),
+		},
+		"unquoted path": {
+			raw:      `{{ include partials/db.tpl }}`,
+			parseErr: ErrUnexpectedCharacter(1, 12, 'p', '"'),
+		},
+		"include not closed": {
+			raw:      `{{ include "partials/db.tpl"`,
+			parseErr: ErrActionTagNotClosed(1, 29),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			escaping := tc.escaping
+			if escaping == "" {
+				escaping = EscapingNone
+			}
+
+			parser := NewIncludingV2Parser(escaping, filepath.FromSlash("templates/app.tpl"), readFile)
+			if tc.noIncludes {
+				parser = NewEscapingV2Parser(escaping)
+			}
+			parsed, err := parser.Parse(tc.raw, 1, 1)
+			assert.Equal(t, err, tc.parseErr)
+			if err != nil {
+				return
+			}
+
+			vars := fakes.FakeVariableReader{Variables: map[string]string{"env": "prod", "tenant": "acme"}}
+			actual, err := parsed.Evaluate(vars, fakes.FakeSecretReader{Secrets: secrets})
+			assert.Equal(t, err, tc.evalErr)
+			assert.Equal(t, actual, tc.expected)
+		})
+	}
+}
+
+func TestV2_Include_SecretPaths(t *testing.T) {
+	readFile := func(filename string) ([]byte, error) {
+		return []byte("{{ company/${env}/db/pass }}"), nil
+	}
+
+	parsed, err := NewIncludingV2Parser(EscapingNone, "app.tpl", readFile).Parse(`{{ include "db.tpl" }}{{ company/prod/db/host }}`, 1, 1)
+	assert.OK(t, err)
+
+	paths, err := parsed.SecretPaths(fakes.FakeVariableReader{Variables: map[string]string{"env": "prod"}})
+	assert.OK(t, err)
+	assert.Equal(t, paths, []string{"company/prod/db/pass", "company/prod/db/host"})
+	assert.Equal(t, parsed.ContainsSecrets(), true)
+}
+
+func TestIncludeLines(t *testing.T) {
+	files := map[string]string{
+		filepath.FromSlash("config/partials/db.env"):     "DB_HOST=db.example.com\nDB_PASS={{ company/db/pass }}\n",
+		filepath.FromSlash("config/partials/nested.env"): "APP=app\n\t{{ include \"db.env\" }}",
+		filepath.FromSlash("config/partials/self.env"):   "X=1\n{{ include \"self.env\" }}",
+	}
+	readFile := func(filename string) ([]byte, error) {
+		content, ok := files[filename]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return []byte(content), nil
+	}
+
+	type line struct {
+		text   string
+		file   string
+		lineNo int
+	}
+
+	cases := map[string]struct {
+		raw        string
+		noIncludes bool
+		expected   []line
+		err        error
+	}{
+		"include on its own line": {
+			raw: "FOO=foo\n{{ include \"partials/db.env\" }}\nBAR=bar",
+			expected: []line{
+				{text: "FOO=foo", lineNo: 1},
+				{text: "DB_HOST=db.example.com", file: filepath.FromSlash("config/partials/db.env"), lineNo: 1},
+				{text: "DB_PASS={{ company/db/pass }}", file: filepath.FromSlash("config/partials/db.env"), lineNo: 2},
+				{text: "BAR=bar", lineNo: 3},
+			},
+		},
+		"nested include": {
+			raw: "  {{include \"partials/nested.env\"}}\r",
+			expected: []line{
+				{text: "APP=app", file: filepath.FromSlash("config/partials/nested.env"), lineNo: 1},
+				{text: "DB_HOST=db.example.com", file: filepath.FromSlash("config/partials/db.env"), lineNo: 1},
+				{text: "DB_PASS={{ company/db/pass }}", file: filepath.FromSlash("config/partials/db.env"), lineNo: 2},
+			},
+		},
+		"include within a line": {
+			raw: "DB={{ include \"partials/db.env\" }}",
+			expected: []line{
+				{text: "DB={{ include \"partials/db.env\" }}", lineNo: 1},
+			},
+		},
+		"includes not supported": {
+			raw:        "FOO=foo\n{{ include \"partials/db.env\" }}",
+			noIncludes: true,
+			expected: []line{
+				{text: "FOO=foo", lineNo: 1},
+				{text: "{{ include \"partials/db.env\" }}", lineNo: 2},
+			},
+		},
+		"include itself": {
+			raw: "{{ include \"partials/self.env\" }}",
+			err: templateSyntaxError{
+				file:   filepath.FromSlash("config/partials/self.env"),
+				lineNo: 2,
+				colNo:  1,
+				code:   "include_cycle",
+				msg:    "templates cannot include themselves: " + filepath.FromSlash("config/partials/self.env") + " includes " + filepath.FromSlash("config/partials/self.env") + ".",
+			},
+		},
+		"include not found": {
+			raw: "FOO=foo\n  {{ include \"partials/absent.env\" }}",
+			err: ErrCannotReadInclude(2, 3, filepath.FromSlash("config/partials/absent.env"), os.ErrNotExist),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			parser := NewIncludingV2Parser(EscapingNone, filepath.FromSlash("config/secrethub.env"), readFile)
+			if tc.noIncludes {
+				parser = NewV2Parser()
+			}
+
+			lines, err := IncludeLines(parser, tc.raw)
+			assert.Equal(t, err, tc.err)
+			if err != nil {
+				return
+			}
+
+			actual := make([]line, len(lines))
+			for i, l := range lines {
+				actual[i] = line{text: strings.TrimSuffix(l.Text, "\r"), file: l.File, lineNo: l.LineNo}
+			}
+			assert.Equal(t, actual, tc.expected)
+		})
+	}
+}
+
+func TestIncludeLines_Parser(t *testing.T) {
+	readFile := func(filename string) ([]byte, error) {
+		switch filename {
+		case filepath.FromSlash("config/partials/db.env"):
+			return []byte("DB_URL=postgres://{{ include \"host.tpl\" }}/{{ company/db/name }}\nDB_PASS={{ company/db/pass }"), nil
+		case filepath.FromSlash("config/partials/host.tpl"):
+			return []byte("db.example.com"), nil
+		}
+		return nil, os.ErrNotExist
+	}
+
+	parser := NewIncludingV2Parser(EscapingNone, filepath.FromSlash("config/secrethub.env"), readFile)
+	lines, err := IncludeLines(parser, "{{ include \"partials/db.env\" }}")
+	assert.OK(t, err)
+	assert.Equal(t, len(lines), 2)
+
+	// Include tags on the lines of an included template are resolved relative to that template.
+	parsed, err := lines[0].Parser.Parse(lines[0].Text, lines[0].LineNo, 1)
+	assert.OK(t, err)
+	actual, err := parsed.Evaluate(fakes.FakeVariableReader{}, fakes.FakeSecretReader{Secrets: map[string]string{"company/db/name": "app"}})
+	assert.OK(t, err)
+	assert.Equal(t, actual, "DB_URL=postgres://db.example.com/app")
+	assert.Equal(t, parsed.References()[0].File, filepath.FromSlash("config/partials/db.env"))
+
+	// Errors on the lines of an included template are located in that template.
+	_, err = lines[1].Parser.Parse(lines[1].Text, lines[1].LineNo, 1)
+	assert.Equal(t, err, templateSyntaxError{
+		file:   filepath.FromSlash("config/partials/db.env"),
+		lineNo: 2,
+		colNo:  29,
+		code:   "secret_tag_not_closed",
+		msg:    "expected the closing of a secret tag `}}`, but reached the end of the template.",
+	})
+}
@@ -6,6 +6,8 @@ var (
 	LBracket  = '{'
 	RBracket  = '}'
 	Backslash = '\\'
+	Pipe      = '|'
+	Quote     = '"'
 
 	tokens = []rune{Dollar, LBracket, RBracket, Backslash}
 )
@@ -22,7 +22,49 @@ type Template interface {
 	// The supplied variables should have lowercase keys.
 	Evaluate(varReader VariableReader, sr SecretReader) (string, error)
 
+	// SecretPaths returns the paths of all secrets that can be read when the template is evaluated
+	// with the given variables. Every path is returned once, in the order of first appearance.
+	// Secrets that are only known when the template is evaluated, like those in directories of
+	// range blocks, are not included.
+	SecretPaths(varReader VariableReader) ([]string, error)
+
 	ContainsSecrets() bool
+
+	// References returns all secret tags and variable tags in the template, in order of appearance.
+	// Variable tags within the path of a secret tag are returned after the secret tag. The tags of
+	// included templates are returned at the position of their include tag.
+	References() []Reference
+}
+
+// Reference is a secret tag or a variable tag in a template.
+type Reference struct {
+	// File is the file of the included template that contains the tag.
+	// It is empty for tags in the template itself.
+	File string
+	// LineNo and ColNo are the position of the opening delimiter of the tag, within File if it is set.
+	LineNo int
+	ColNo  int
+	// Variable is the name of the variable of a variable tag. It is empty for secret tags.
+	Variable string
+	// Optional is true for secret tags that do not require the secret to exist,
+	// because they contain a default value or because they only check whether the secret exists.
+	Optional bool
+	// Dir is true for the tags of range blocks. The path of such a tag is the path of
+	// a directory, of which all secrets can be read.
+	Dir bool
+	// path contains the path of the secret of a secret tag.
+	path []node
+}
+
+// IsSecret returns whether the reference is a secret tag, which includes the tags of range and if blocks.
+func (r Reference) IsSecret() bool {
+	return r.Variable == ""
+}
+
+// SecretPath returns the path of the secret of a secret tag with all variables in it replaced.
+// For the tag of a range block, this is the path of the directory.
+func (r Reference) SecretPath(varReader VariableReader) (string, error) {
+	return secret{path: r.path}.evaluatePath(context{varReader: varReader})
 }
 
 // NewParser returns a parser for the latest template syntax.
@@ -36,3 +78,17 @@ var v1SecretTag = regexp.MustCompile(`\${[\t ]*[_\-\.a-zA-Z0-9]+/[_\-\.a-zA-Z0-9
 func IsV1Template(raw []byte) bool {
 	return v1SecretTag.Match(raw)
 }
+
+// uniquePaths returns the given paths with all duplicates removed, preserving their order.
+func uniquePaths(paths []string) []string {
+	res := make([]string, 0, len(paths))
+	seen := make(map[string]struct{}, len(paths))
+	for _, path := range paths {
+		if _, ok := seen[path]; ok {
+			continue
+		}
+		seen[path] = struct{}{}
+		res = append(res, path)
+	}
+	return res
+}
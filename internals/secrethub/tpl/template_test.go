@@ -3,6 +3,9 @@ package tpl
 import (
 	"testing"
 
+	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl/fakes"
+
+	"github.com/secrethub/secrethub-go/internals/api"
 	"github.com/secrethub/secrethub-go/internals/assert"
 )
 
@@ -57,3 +60,132 @@ func TestIsV1Template(t *testing.T) {
 		})
 	}
 }
+
+func TestReferences(t *testing.T) {
+	type reference struct {
+		file     string
+		lineNo   int
+		colNo    int
+		variable string
+		path     string
+		optional bool
+	}
+
+	cases := map[string]struct {
+		parser   Parser
+		raw      string
+		line     int
+		column   int
+		expected []reference
+	}{
+		"v2": {
+			parser: NewV2Parser(),
+			raw:    "a={{ ${app}/db/user }}\nb=${env} {{ app/$env/pass | default \"x\" }}",
+			line:   1,
+			column: 1,
+			expected: []reference{
+				{lineNo: 1, colNo: 3, path: "myapp/db/user"},
+				{lineNo: 1, colNo: 6, variable: "app"},
+				{lineNo: 2, colNo: 3, variable: "env"},
+				{lineNo: 2, colNo: 10, path: "app/prod/pass", optional: true},
+				{lineNo: 2, colNo: 17, variable: "env"},
+			},
+		},
+		"v2 with offset": {
+			parser: NewV2Parser(),
+			raw:    "{{ app/pass }}",
+			line:   3,
+			column: 5,
+			expected: []reference{
+				{lineNo: 3, colNo: 5, path: "app/pass"},
+			},
+		},
+		"v2 with include": {
+			parser: NewIncludingV2Parser(EscapingNone, "app.tpl", func(filename string) ([]byte, error) {
+				return []byte("user={{ app/db/user }}\npass={{ app/${env}/pass }}"), nil
+			}),
+			raw:    "{{ app/key }}\n{{ include \"db.tpl\" }} ${env}",
+			line:   1,
+			column: 1,
+			expected: []reference{
+				{lineNo: 1, colNo: 1, path: "app/key"},
+				{file: "db.tpl", lineNo: 1, colNo: 6, path: "app/db/user"},
+				{file: "db.tpl", lineNo: 2, colNo: 6, path: "app/prod/pass"},
+				{file: "db.tpl", lineNo: 2, colNo: 13, variable: "env"},
+				{lineNo: 2, colNo: 24, variable: "env"},
+			},
+		},
+		"v1": {
+			parser: NewV1Parser(),
+			raw:    "a=${ app/db/user }\nb=x${app/db/pass:1}",
+			line:   1,
+			column: 1,
+			expected: []reference{
+				{lineNo: 1, colNo: 3, path: "app/db/user"},
+				{lineNo: 2, colNo: 4, path: "app/db/pass:1"},
+			},
+		},
+	}
+
+	varReader := fakes.FakeVariableReader{
+		Variables: map[string]string{
+			"app": "myapp",
+			"env": "prod",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			template, err := tc.parser.Parse(tc.raw, tc.line, tc.column)
+			assert.OK(t, err)
+
+			var actual []reference
+			for _, ref := range template.References() {
+				r := reference{
+					file:     ref.File,
+					lineNo:   ref.LineNo,
+					colNo:    ref.ColNo,
+					variable: ref.Variable,
+					optional: ref.Optional,
+				}
+				if ref.IsSecret() {
+					r.path, err = ref.SecretPath(varReader)
+					assert.OK(t, err)
+				}
+				actual = append(actual, r)
+			}
+			assert.Equal(t, actual, tc.expected)
+		})
+	}
+}
+
+func TestV1_Errors(t *testing.T) {
+	cases := map[string]struct {
+		raw      string
+		parseErr error
+		evalErr  error
+	}{
+		"secret not found": {
+			raw:     "a=${ app/db/user }\nb=x${app/db/pass}",
+			evalErr: evaluationError{lineNo: 2, colNo: 4, err: api.ErrSecretNotFound},
+		},
+		"tag not closed": {
+			raw:      "a=${ app/db/user }\nb=x${app/db/pass",
+			parseErr: ErrV1SecretTagNotClosed(2, 4),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			template, err := NewV1Parser().Parse(tc.raw, 1, 1)
+			assert.Equal(t, err, tc.parseErr)
+			if err != nil {
+				return
+			}
+
+			secrets := map[string]string{"app/db/user": "root"}
+			_, err = template.Evaluate(nil, fakes.FakeSecretReader{Secrets: secrets})
+			assert.Equal(t, err, tc.evalErr)
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package tpl
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl/internal/token"
+)
+
+// UpgradeV1 converts a template in the v1 syntax to an equivalent template in the v2 syntax.
+//
+// Every v1 secret tag `${ path/to/secret }` is replaced with a v2 secret tag `{{ path/to/secret }}`.
+// Characters in the rest of the template that would otherwise be read as the start of a v2 tag or
+// variable, like `${env}` or `{{`, are escaped with a backslash, so that the upgraded template
+// evaluates to the same output as the original template.
+func UpgradeV1(raw string) (string, error) {
+	// Parsing the template ensures that every secret tag is closed.
+	_, err := NewV1Parser().Parse(raw, 1, 1)
+	if err != nil {
+		return "", err
+	}
+
+	var res strings.Builder
+	lineNo, colNo := 1, 1
+	rest := raw
+	for {
+		start := strings.Index(rest, "${")
+		if start == -1 {
+			res.WriteString(escapeV2Text(rest, utf8.RuneError))
+			return res.String(), nil
+		}
+		res.WriteString(escapeV2Text(rest[:start], token.LBracket))
+		lineNo, colNo = advancePosition(lineNo, colNo, rest[:start])
+
+		end := start + strings.Index(rest[start:], "}") + 1
+		path := strings.Trim(rest[start+2:end-1], " ")
+		if !isV2SecretPath(path) {
+			return "", ErrCannotUpgradeSecretPath(lineNo, colNo, path)
+		}
+		res.WriteString("{{ " + path + " }}")
+
+		lineNo, colNo = advancePosition(lineNo, colNo, rest[start:end])
+		rest = rest[end:]
+	}
+}
+
+// escapeV2Text escapes the characters of text outside of tags that would be read as part of
+// a tag or an escape sequence by the v2 parser. next is the character directly following the
+// text, or utf8.RuneError when the text is at the end of the template.
+func escapeV2Text(text string, next rune) string {
+	var p v2Parser
+	runes := []rune(text)
+	var res strings.Builder
+	for i, r := range runes {
+		following := next
+		if i+1 < len(runes) {
+			following = runes[i+1]
+		}
+
+		if (r == token.Backslash && token.IsToken(following)) ||
+			(r == token.Dollar && (following == token.LBracket || p.isVariableStartRune(following))) ||
+			(r == token.LBracket && following == token.LBracket) {
+			res.WriteRune(token.Backslash)
+		}
+		res.WriteRune(r)
+	}
+	return res.String()
+}
+
+// isV2SecretPath returns whether the path can be used in a v2 secret tag.
+func isV2SecretPath(path string) bool {
+	if path == "" {
+		return false
+	}
+	var p v2Parser
+	for _, r := range path {
+		if !p.isSecretPathRune(r) {
+			return false
+		}
+	}
+	return true
+}
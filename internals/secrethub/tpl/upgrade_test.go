@@ -0,0 +1,83 @@
+package tpl
+
+import (
+	"testing"
+
+	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl/fakes"
+
+	"github.com/secrethub/secrethub-go/internals/assert"
+)
+
+func TestUpgradeV1(t *testing.T) {
+	cases := map[string]struct {
+		raw      string
+		expected string
+		err      error
+	}{
+		"secret tags": {
+			raw:      "user=${ company/app/db/user }\npass=${company/app/db/pass:latest}",
+			expected: "user={{ company/app/db/user }}\npass={{ company/app/db/pass:latest }}",
+		},
+		"no secret tags": {
+			raw:      "foo=bar",
+			expected: "foo=bar",
+		},
+		"variables in text": {
+			raw:      "home=$HOME price=$5 $ ${ company/app/db/pass }",
+			expected: `home=\$HOME price=$5 $ {{ company/app/db/pass }}`,
+		},
+		"brackets in text": {
+			raw:      `{"a": {{}}} {{{${ company/app/db/pass }`,
+			expected: `{"a": \{{}}} \{\{\{{{ company/app/db/pass }}`,
+		},
+		"backslashes in text": {
+			raw:      `C:\dir \${ company/app/db/pass } \\ \}`,
+			expected: `C:\dir \\{{ company/app/db/pass }} \\\ \\}`,
+		},
+		"dollar before tag": {
+			raw:      `$${ company/app/db/pass }$`,
+			expected: `\${{ company/app/db/pass }}$`,
+		},
+		"unclosed tag": {
+			raw: "${ company/app/db/pass",
+			err: ErrV1SecretTagNotClosed(1, 1),
+		},
+		"invalid path": {
+			raw: "foo\nbar=${ company/app/db pass }",
+			err: ErrCannotUpgradeSecretPath(2, 5, "company/app/db pass"),
+		},
+		"empty path": {
+			raw: "${}",
+			err: ErrCannotUpgradeSecretPath(1, 1, ""),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			actual, err := UpgradeV1(tc.raw)
+			assert.Equal(t, err, tc.err)
+			assert.Equal(t, actual, tc.expected)
+			if err != nil {
+				return
+			}
+
+			secrets := fakes.FakeSecretReader{Secrets: map[string]string{
+				"company/app/db/user":        "root",
+				"company/app/db/pass":        "secret",
+				"company/app/db/pass:latest": "secret",
+			}}
+
+			v1, err := NewV1Parser().Parse(tc.raw, 1, 1)
+			assert.OK(t, err)
+			expected, err := v1.Evaluate(fakes.FakeVariableReader{}, secrets)
+			assert.OK(t, err)
+
+			v2, err := NewV2Parser().Parse(actual, 1, 1)
+			assert.OK(t, err)
+			upgraded, err := v2.Evaluate(fakes.FakeVariableReader{}, secrets)
+			assert.OK(t, err)
+
+			assert.Equal(t, upgraded, expected)
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package tpl
 
 import (
+	"strings"
+
 	"github.com/secrethub/secrethub-cli/internals/tpl"
 )
 
@@ -16,31 +18,48 @@ func NewV1Parser() Parser {
 
 type templateV1 struct {
 	template tpl.Template
+	raw      string
+	lineNo   int
+	colNo    int
 }
 
 type parserV1 struct{}
 
 // Parse parses a secret template from a raw string.
 // See tpl.Template for the format of the template.
-func (p parserV1) Parse(raw string, _, _ int) (Template, error) {
+func (p parserV1) Parse(raw string, line, column int) (Template, error) {
 	t, err := tpl.NewParser("${", "}").Parse(raw)
 	if err != nil {
+		lineNo, colNo, ok := unclosedV1Tag(raw, line, column)
+		if ok && line > 0 {
+			return nil, ErrV1SecretTagNotClosed(lineNo, colNo)
+		}
 		return nil, err
 	}
 
 	return templateV1{
 		template: t,
+		raw:      raw,
+		lineNo:   line,
+		colNo:    column,
 	}, nil
 }
 
 // InjectVars takes a map of template variables with their corresponding values. It replaces
 // the template variables with their values in the template.
+// Errors are annotated with the position of the first secret tag of the secret that could not be read.
 func (t templateV1) Evaluate(_ VariableReader, sr SecretReader) (string, error) {
 	keys := t.template.Keys()
 	secrets := make(map[string]string, len(keys))
 	for _, path := range keys {
 		secret, err := sr.ReadSecret(path)
 		if err != nil {
+			for _, ref := range t.References() {
+				// V1 secret tags do not contain variables, so no variable reader is needed.
+				if refPath, _ := ref.SecretPath(nil); refPath == path {
+					return "", tagError("", ref.LineNo, ref.ColNo, err)
+				}
+			}
 			return "", err
 		}
 		secrets[path] = secret
@@ -49,6 +68,81 @@ func (t templateV1) Evaluate(_ VariableReader, sr SecretReader) (string, error)
 	return t.template.Inject(secrets)
 }
 
+// SecretPaths returns the paths of the secrets in the template.
+// V1 templates do not support variables, so the variable reader is not used.
+func (t templateV1) SecretPaths(_ VariableReader) ([]string, error) {
+	return t.template.Keys(), nil
+}
+
 func (t templateV1) ContainsSecrets() bool {
 	return len(t.template.Keys()) > 0
 }
+
+// References returns the secret tags in the template.
+// V1 templates do not support variables, so only secret tags are returned.
+func (t templateV1) References() []Reference {
+	var res []Reference
+
+	lineNo, colNo := t.lineNo, t.colNo
+	rest := t.raw
+	for {
+		start := strings.Index(rest, "${")
+		if start == -1 {
+			return res
+		}
+		lineNo, colNo = advancePosition(lineNo, colNo, rest[:start])
+
+		// The template has been parsed successfully, so every tag is closed.
+		end := start + strings.Index(rest[start:], "}") + 1
+		path := strings.Trim(rest[start+2:end-1], " ")
+
+		nodes := make([]node, 0, len(path))
+		for _, r := range path {
+			nodes = append(nodes, character(r))
+		}
+		res = append(res, Reference{
+			LineNo: lineNo,
+			ColNo:  colNo,
+			path:   nodes,
+		})
+
+		lineNo, colNo = advancePosition(lineNo, colNo, rest[start:end])
+		rest = rest[end:]
+	}
+}
+
+// advancePosition returns the position in a template directly after the given text,
+// when the text starts at the given position.
+func advancePosition(lineNo, colNo int, text string) (int, int) {
+	for _, r := range text {
+		if r == '\n' {
+			lineNo++
+			colNo = 1
+		} else {
+			colNo++
+		}
+	}
+	return lineNo, colNo
+}
+
+// unclosedV1Tag returns the position of the first secret tag in the raw v1 template that is not closed,
+// when the template starts at the given position.
+func unclosedV1Tag(raw string, lineNo, colNo int) (int, int, bool) {
+	rest := raw
+	for {
+		start := strings.Index(rest, "${")
+		if start == -1 {
+			return 0, 0, false
+		}
+		lineNo, colNo = advancePosition(lineNo, colNo, rest[:start])
+
+		end := strings.Index(rest[start:], "}")
+		if end == -1 {
+			return lineNo, colNo, true
+		}
+		end += start + 1
+
+		lineNo, colNo = advancePosition(lineNo, colNo, rest[start:end])
+		rest = rest[end:]
+	}
+}
@@ -3,10 +3,12 @@ package tpl
 import (
 	"bytes"
 	"io"
+	"sort"
 	"strings"
 	"unicode"
 
 	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl/internal/token"
+	"github.com/secrethub/secrethub-go/internals/api"
 )
 
 // NewV2Parser returns a parser for the v2 template syntax.
@@ -20,6 +22,20 @@ import (
 // {{ ${app}/db/secret }}
 // Variables cannot be used outside of secret paths.
 //
+// The value of a secret can be passed through a pipeline of filters:
+// {{ path/to/secret | json ".password" | default "secret" }}
+//
+// The values of secrets can be escaped for the format of the output with
+// the escape filter, e.g. {{ path/to/secret | escape "json" }}, or for all
+// secret tags with NewEscapingV2Parser.
+//
+// Secrets that do not exist can be generated with the generate filter, e.g.
+// {{ path/to/secret | generate 32 "alphanumeric" }}, when the template is
+// evaluated with a SecretReader that implements SecretGenerator.
+//
+// Other templates can be included with {{ include "path/to/template" }} when
+// the templates are parsed with NewIncludingV2Parser.
+//
 // Spaces directly after opening delimiters (`{{` and `${`) and directly
 // before closing delimiters (`}}`, `}`) are ignored. They are not
 // included in the secret pahts and variable names.
@@ -30,6 +46,10 @@ func NewV2Parser() Parser {
 type context struct {
 	varReader    VariableReader
 	secretReader SecretReader
+	// escape escapes the values of secret tags. It is nil when values are not escaped.
+	escape func(string) string
+	// item is the secret in the current iteration of a range block. It is nil outside of range blocks.
+	item *rangeItem
 }
 
 func (ctx context) secret(path string) (string, error) {
@@ -40,31 +60,136 @@ type node interface {
 	evaluate(ctx context) (string, error)
 }
 
+// evaluateNodes evaluates the nodes and returns their concatenated results.
+func evaluateNodes(ctx context, nodes []node) (string, error) {
+	var buffer bytes.Buffer
+	for _, n := range nodes {
+		eval, err := n.evaluate(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		buffer.WriteString(eval)
+	}
+	return buffer.String(), nil
+}
+
 type secret struct {
-	path []node
+	path    []node
+	filters []filter
+	// file is the included template in which the secret tag is used. It is empty for tags in the template itself.
+	file   string
+	lineNo int
+	colNo  int
 }
 
+// evaluate reads the secret and passes its value through the filters.
+// Errors are annotated with the position of the secret tag.
 func (s secret) evaluate(ctx context) (string, error) {
-	var buffer bytes.Buffer
-	for _, p := range s.path {
-		eval, err := p.evaluate(ctx)
+	path, err := s.evaluatePath(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	value, err := ctx.secret(path)
+	value, err = evaluatePipeline(ctx, path, value, err, s.filters)
+	if err != nil {
+		return "", tagError(s.file, s.lineNo, s.colNo, err)
+	}
+	return value, nil
+}
+
+// evaluatePipeline passes the value of the secret on the given path, or the error returned when reading it,
+// through the filters and escapes the result.
+//
+// When the secret does not exist or a filter finds no value (e.g. a missing JSON field),
+// the filters up to the next default filter are skipped and the default value is used instead.
+// When secrets can be generated, a secret that does not exist is generated by the first generate
+// filter instead, after which the remaining filters are applied to the generated value.
+func evaluatePipeline(ctx context, path string, value string, err error, filters []filter) (string, error) {
+	generate := ctx.canGenerate() && hasGenerate(filters)
+	if err != nil && !(api.IsErrNotFound(err) && (hasDefault(filters) || generate)) {
+		return "", err
+	}
+
+	noValueErr := err
+	for _, f := range filters {
+		if noValueErr != nil {
+			switch {
+			case f.name == defaultFilter:
+				value, noValueErr = f.args[0], nil
+			case f.name == generateFilter && generate && api.IsErrNotFound(noValueErr):
+				value, err = ctx.generate(path, f)
+				if err != nil {
+					return "", err
+				}
+				noValueErr = nil
+			}
+			continue
+		}
+
+		value, err = f.apply(value)
+		if isNoValue(err) {
+			noValueErr = err
+			continue
+		}
 		if err != nil {
 			return "", err
 		}
+	}
+	if noValueErr != nil {
+		return "", noValueErr
+	}
 
-		buffer.WriteString(eval)
+	if ctx.escape != nil && !escapesValue(filters) {
+		value = ctx.escape(value)
 	}
-	return ctx.secret(buffer.String())
+	return value, nil
+}
+
+// hasDefault returns whether the secret tag contains a default filter.
+func (s secret) hasDefault() bool {
+	return hasDefault(s.filters)
+}
+
+// hasDefault returns whether the filters contain a default filter.
+func hasDefault(filters []filter) bool {
+	for _, f := range filters {
+		if f.name == defaultFilter {
+			return true
+		}
+	}
+	return false
+}
+
+// escapesValue returns whether the filters contain a filter that escapes the value,
+// in which case the value is not escaped automatically.
+func escapesValue(filters []filter) bool {
+	for _, f := range filters {
+		if escapingFilters[f.name] {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluatePath returns the path of the secret with all variables in it replaced.
+func (s secret) evaluatePath(ctx context) (string, error) {
+	return evaluateNodes(ctx, s.path)
 }
 
 type variable struct {
 	key string
+	// file is the included template in which the variable tag is used. It is empty for tags in the template itself.
+	file   string
+	lineNo int
+	colNo  int
 }
 
 func (v variable) evaluate(ctx context) (string, error) {
 	res, err := ctx.varReader.ReadVariable(v.key)
 	if err != nil {
-		return "", err
+		return "", tagError(v.file, v.lineNo, v.colNo, err)
 	}
 	return res, nil
 }
@@ -76,10 +201,39 @@ func (c character) evaluate(ctx context) (string, error) {
 }
 
 type templateV2 struct {
-	nodes []node
+	nodes      []node
+	references []Reference
+	escape     func(string) string
+}
+
+type parserV2 struct {
+	escape func(string) string
+	// file is the path of the template file, relative to which included templates are resolved.
+	file string
+	// readFile reads the files of included templates. Include tags are not supported when it is nil.
+	readFile func(filename string) ([]byte, error)
 }
 
-type parserV2 struct{}
+// NewEscapingV2Parser returns a parser for the v2 template syntax of which the templates escape the values
+// of secret tags for the given escaping. Secret tags that escape their value themselves, with the escape,
+// jsonescape or yamlquote filter, are not escaped automatically. Text outside of secret tags is never escaped.
+func NewEscapingV2Parser(escaping Escaping) Parser {
+	return NewIncludingV2Parser(escaping, "", nil)
+}
+
+// NewIncludingV2Parser returns a parser for the v2 template syntax of which the templates escape the values
+// of secret tags for the given escaping, like NewEscapingV2Parser does, and can include other templates.
+// Included templates are read with readFile, relative to the directory of the given template file.
+func NewIncludingV2Parser(escaping Escaping, file string, readFile func(filename string) ([]byte, error)) Parser {
+	parser := parserV2{
+		file:     file,
+		readFile: readFile,
+	}
+	if escaping != EscapingNone {
+		parser.escape = escapers[escaping]
+	}
+	return parser
+}
 
 // Parse parses a secret template from a raw string.
 //
@@ -95,16 +249,50 @@ type parserV2 struct{}
 //   - Variable tags cannot contain secret tags.
 //   - Secret tags cannot contain secret tags (they cannot be nested).
 //   - Variable tags cannot contain variable tags (they cannot be nested).
+//   - The path of a secret tag can be followed by filters, each preceded by a pipe:
+//     `{{ path/to/secret | base64decode | indent 4 }}`. Arguments of filters are
+//     separated by spaces and can be quoted with double quotes: `| default "some value"`.
+//   - Other templates can be included with an include tag: `{{ include "partials/db.tpl" }}`.
+//     The path is relative to the directory of the including template. Included templates
+//     are parsed as separate templates, so blocks cannot be opened in one template and
+//     closed in another.
 func (p parserV2) Parse(raw string, line, column int) (Template, error) {
+	template, err := p.parse(raw, line, column, nil)
+	if err != nil {
+		return nil, err
+	}
+	return template, nil
+}
+
+// parse parses a template from a raw string. includedBy contains the files of the templates that include
+// the template, starting with the outermost template. It is empty when the template is not included.
+func (p parserV2) parse(raw string, line, column int, includedBy []string) (templateV2, error) {
 	parser := newV2Parser(bytes.NewBufferString(raw), line, column)
+	parser.settings = p
+	parser.includedBy = includedBy
+	if len(includedBy) > 0 {
+		parser.file = p.file
+	}
 
 	nodes, err := parser.parse()
 	if err != nil {
-		return nil, err
+		return templateV2{}, err
+	}
+
+	nodes, err = nestBlocks(nodes)
+	if err != nil {
+		return templateV2{}, err
 	}
 
+	sort.SliceStable(parser.references, func(i, j int) bool {
+		a, b := parser.references[i], parser.references[j]
+		return a.LineNo < b.LineNo || (a.LineNo == b.LineNo && a.ColNo < b.ColNo)
+	})
+
 	return templateV2{
-		nodes: nodes,
+		nodes:      nodes,
+		references: mergeIncludedReferences(parser.references, parser.includes),
+		escape:     p.escape,
 	}, nil
 }
 
@@ -126,6 +314,19 @@ type v2Parser struct {
 
 	current rune
 	next    rune
+
+	// references contains the secret tags and variable tags that have been parsed.
+	references []Reference
+	// includes contains the templates that have been included.
+	includes []included
+
+	// settings is the parser of which the settings are used to parse included templates.
+	settings parserV2
+	// includedBy contains the files of the templates that include the template being parsed.
+	includedBy []string
+	// file is the file of the template being parsed when it is included by another template.
+	// Errors in included templates are annotated with their file.
+	file string
 }
 
 // readRune reads the next rune from the raw template.
@@ -193,11 +394,29 @@ func (p *v2Parser) parseRoot() (node, error) {
 	}
 
 	if p.current == token.LBracket && p.next == token.LBracket {
-		secret, err := p.parseSecret()
+		lineNo, colNo := p.lineNo, p.columnNo
+		if p.peekAction() != "" {
+			n, err := p.parseAction(lineNo, colNo)
+			if err != nil {
+				return nil, err
+			}
+			return n, p.readRune()
+		}
+
+		n, err := p.parseSecret()
 		if err != nil {
 			return nil, err
 		}
-		return secret, p.readRune()
+
+		s := n.(secret)
+		s.file, s.lineNo, s.colNo = p.file, lineNo, colNo
+		p.references = append(p.references, Reference{
+			LineNo:   lineNo,
+			ColNo:    colNo,
+			Optional: s.hasDefault(),
+			path:     s.path,
+		})
+		return s, p.readRune()
 	}
 
 	if p.current == token.Backslash && token.IsToken(p.next) {
@@ -210,24 +429,38 @@ func (p *v2Parser) parseRoot() (node, error) {
 
 func (p *v2Parser) parseVarWithoutBrackets() (node, error) {
 	var buffer bytes.Buffer
+	lineNo, colNo := p.lineNo, p.columnNo
 
 	for p.isVariableRune(p.next) {
 		buffer.WriteRune(p.next)
 
 		err := p.readRune()
 		if err == io.EOF {
-			return variable{
-				key: strings.ToLower(buffer.String()),
-			}, err
+			return p.variable(lineNo, colNo, buffer.String()), err
 		}
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	return p.variable(lineNo, colNo, buffer.String()), nil
+}
+
+// variable returns the variable with the given name and adds it to the references
+// of the template at the given position.
+func (p *v2Parser) variable(lineNo, colNo int, name string) variable {
+	key := strings.ToLower(name)
+	p.references = append(p.references, Reference{
+		LineNo:   lineNo,
+		ColNo:    colNo,
+		Variable: key,
+	})
 	return variable{
-		key: strings.ToLower(buffer.String()),
-	}, nil
+		key:    key,
+		file:   p.file,
+		lineNo: lineNo,
+		colNo:  colNo,
+	}
 }
 
 // parseVar parses the contents of a template variable up to the closing delimiter.
@@ -238,6 +471,7 @@ func (p *v2Parser) parseVarWithoutBrackets() (node, error) {
 // of the template variable ('}').
 func (p *v2Parser) parseVar() (node, error) {
 	var buffer bytes.Buffer
+	lineNo, colNo := p.lineNo, p.columnNo
 
 	checkError := func(err error) error {
 		if err == io.EOF {
@@ -258,9 +492,7 @@ func (p *v2Parser) parseVar() (node, error) {
 
 	for {
 		if p.next == token.RBracket {
-			return variable{
-				key: strings.ToLower(buffer.String()),
-			}, nil
+			return p.variable(lineNo, colNo, buffer.String()), nil
 		}
 
 		if p.isAllowedWhiteSpace(p.next) {
@@ -270,9 +502,7 @@ func (p *v2Parser) parseVar() (node, error) {
 			}
 
 			if p.next == token.RBracket {
-				return variable{
-					key: strings.ToLower(buffer.String()),
-				}, nil
+				return p.variable(lineNo, colNo, buffer.String()), nil
 			}
 
 			return nil, ErrUnexpectedCharacter(p.lineNo, p.columnNo+1, p.next, token.RBracket)
@@ -360,6 +590,17 @@ func (p *v2Parser) parseSecret() (node, error) {
 				return nil, checkError(err)
 			}
 
+			if p.next == token.Pipe {
+				filters, err := p.parseFilters()
+				if err != nil {
+					return nil, err
+				}
+				return secret{
+					path:    path,
+					filters: filters,
+				}, nil
+			}
+
 			if p.next != token.RBracket {
 				return nil, ErrUnexpectedCharacter(p.lineNo, p.columnNo+1, p.next, token.RBracket)
 			}
@@ -389,6 +630,16 @@ func (p *v2Parser) parseSecret() (node, error) {
 
 		if p.isSecretPathRune(p.current) {
 			path = append(path, character(p.current))
+			if p.next == token.Pipe {
+				filters, err := p.parseFilters()
+				if err != nil {
+					return nil, err
+				}
+				return secret{
+					path:    path,
+					filters: filters,
+				}, nil
+			}
 			continue
 		}
 
@@ -396,6 +647,143 @@ func (p *v2Parser) parseSecret() (node, error) {
 	}
 }
 
+// parseFilters parses the filters of a secret tag up to the closing delimiter.
+// The next character should be the pipe ('|') of the first filter when parseFilters is called.
+//
+// When parseFilters returns, the next character in the buffer is the last character
+// of the closing delimiter of the secret tag ('}').
+func (p *v2Parser) parseFilters() ([]filter, error) {
+	var filters []filter
+
+	checkError := func(err error) error {
+		if err == io.EOF {
+			return ErrSecretTagNotClosed(p.lineNo, p.columnNo+1)
+		}
+		return err
+	}
+
+	for p.next == token.Pipe {
+		err := p.readRune()
+		if err != nil {
+			return nil, checkError(err)
+		}
+
+		err = p.skipWhiteSpace()
+		if err != nil {
+			return nil, checkError(err)
+		}
+
+		lineNo, colNo := p.lineNo, p.columnNo+1
+		var name bytes.Buffer
+		for p.isFilterNameRune(p.next) {
+			name.WriteRune(p.next)
+			err = p.readRune()
+			if err != nil {
+				return nil, checkError(err)
+			}
+		}
+		if name.Len() == 0 {
+			return nil, ErrIllegalFilterCharacter(p.lineNo, p.columnNo+1, p.next)
+		}
+
+		var args []string
+		for p.isAllowedWhiteSpace(p.next) {
+			err = p.skipWhiteSpace()
+			if err != nil {
+				return nil, checkError(err)
+			}
+
+			if p.next == token.Pipe || p.next == token.RBracket {
+				break
+			}
+
+			arg, err := p.parseFilterArg()
+			if err != nil {
+				return nil, checkError(err)
+			}
+			args = append(args, arg)
+		}
+
+		if p.next != token.Pipe && p.next != token.RBracket {
+			return nil, ErrIllegalFilterCharacter(p.lineNo, p.columnNo+1, p.next)
+		}
+
+		f, err := newFilter(name.String(), args, lineNo, colNo)
+		if err != nil {
+			return nil, err
+		}
+		f.file = p.file
+		filters = append(filters, f)
+	}
+
+	err := p.readRune()
+	if err != nil {
+		return nil, checkError(err)
+	}
+
+	if p.next != token.RBracket {
+		return nil, ErrUnexpectedCharacter(p.lineNo, p.columnNo+1, p.next, token.RBracket)
+	}
+
+	return filters, nil
+}
+
+// parseFilterArg parses a single argument of a filter. Arguments are either quoted
+// between double quotes, in which \" and \\ can be used to escape a quote and a backslash,
+// or run up to the next whitespace, pipe or closing bracket.
+// The next character should be the first character of the argument when parseFilterArg is called.
+func (p *v2Parser) parseFilterArg() (string, error) {
+	var buffer bytes.Buffer
+
+	if p.next != token.Quote {
+		for !p.isAllowedWhiteSpace(p.next) && p.next != token.Pipe && p.next != token.RBracket {
+			if p.next == token.Quote || p.next == '\n' {
+				return "", ErrIllegalFilterCharacter(p.lineNo, p.columnNo+1, p.next)
+			}
+			buffer.WriteRune(p.next)
+			err := p.readRune()
+			if err != nil {
+				return "", err
+			}
+		}
+		return buffer.String(), nil
+	}
+
+	lineNo, colNo := p.lineNo, p.columnNo+1
+	err := p.readRune()
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		err := p.readRune()
+		if err == io.EOF || p.current == '\n' {
+			return "", ErrFilterArgumentNotClosed(lineNo, colNo)
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if p.current == token.Quote {
+			return buffer.String(), nil
+		}
+
+		if p.current == token.Backslash && (p.next == token.Quote || p.next == token.Backslash) {
+			err := p.readRune()
+			if err != nil {
+				return "", err
+			}
+		}
+
+		buffer.WriteRune(p.current)
+	}
+}
+
+// isFilterNameRune returns whether the given rune is allowed to be used in the name of a filter.
+func (p v2Parser) isFilterNameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
 // isSecretPathRune returns whether the given rune is allowed to be used in
 // a secret path.
 func (p v2Parser) isSecretPathRune(r rune) bool {
@@ -445,26 +833,88 @@ func (t templateV2) Evaluate(varReader VariableReader, sr SecretReader) (string,
 	ctx := context{
 		varReader:    varReader,
 		secretReader: sr,
+		escape:       t.escape,
 	}
 
-	var buffer bytes.Buffer
-	for _, n := range t.nodes {
-		eval, err := n.evaluate(ctx)
-		if err != nil {
-			return "", err
-		}
+	return evaluateNodes(ctx, t.nodes)
+}
 
-		buffer.WriteString(eval)
+// SecretPaths returns the paths of the secrets in the template with all variables in them replaced.
+// This includes the secrets in both branches of if blocks and the secrets checked by them.
+// The secrets in directories of range blocks are not included.
+func (t templateV2) SecretPaths(varReader VariableReader) ([]string, error) {
+	ctx := context{
+		varReader: varReader,
 	}
 
-	return buffer.String(), nil
+	paths, err := secretPaths(ctx, t.nodes)
+	if err != nil {
+		return nil, err
+	}
+	return uniquePaths(paths), nil
+}
+
+// secretPaths returns the paths of the secrets in the nodes and the blocks within them.
+func secretPaths(ctx context, nodes []node) ([]string, error) {
+	var paths []string
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case secret:
+			path, err := n.evaluatePath(ctx)
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, path)
+		case ifExists:
+			path, err := evaluateNodes(ctx, n.path)
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, path)
+
+			for _, body := range [][]node{n.body, n.elseBody} {
+				bodyPaths, err := secretPaths(ctx, body)
+				if err != nil {
+					return nil, err
+				}
+				paths = append(paths, bodyPaths...)
+			}
+		case rangeDir:
+			bodyPaths, err := secretPaths(ctx, n.body)
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, bodyPaths...)
+		case included:
+			includedPaths, err := secretPaths(ctx, n.nodes)
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, includedPaths...)
+		}
+	}
+	return paths, nil
+}
+
+// References returns the secret tags and variable tags in the template.
+func (t templateV2) References() []Reference {
+	return t.references
 }
 
 func (t templateV2) ContainsSecrets() bool {
-	for _, node := range t.nodes {
-		_, ok := node.(secret)
-		if ok {
+	return containsSecrets(t.nodes)
+}
+
+// containsSecrets returns whether the nodes or the templates included by them contain secret tags or blocks.
+func containsSecrets(nodes []node) bool {
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case secret, ifExists, rangeDir:
 			return true
+		case included:
+			if containsSecrets(n.nodes) {
+				return true
+			}
 		}
 	}
 
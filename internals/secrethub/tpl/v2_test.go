@@ -7,6 +7,7 @@ import (
 
 	"github.com/secrethub/secrethub-cli/internals/secrethub/tpl/fakes"
 
+	"github.com/secrethub/secrethub-go/internals/api"
 	"github.com/secrethub/secrethub-go/internals/assert"
 )
 
@@ -40,7 +41,9 @@ func TestParserV2_parse(t *testing.T) {
 			input: "${var} world",
 			expected: []node{
 				variable{
-					key: "var",
+					key:    "var",
+					lineNo: 1,
+					colNo:  1,
 				},
 				character(' '),
 				character('w'),
@@ -60,7 +63,9 @@ func TestParserV2_parse(t *testing.T) {
 				character('o'),
 				character(' '),
 				variable{
-					key: "var",
+					key:    "var",
+					lineNo: 1,
+					colNo:  7,
 				},
 			},
 		},
@@ -74,7 +79,9 @@ func TestParserV2_parse(t *testing.T) {
 				character('o'),
 				character(' '),
 				variable{
-					key: "var",
+					key:    "var",
+					lineNo: 1,
+					colNo:  7,
 				},
 				character(' '),
 				character('w'),
@@ -88,7 +95,9 @@ func TestParserV2_parse(t *testing.T) {
 			input: "${ VAR }",
 			expected: []node{
 				variable{
-					key: "var",
+					key:    "var",
+					lineNo: 1,
+					colNo:  1,
 				},
 			},
 		},
@@ -96,7 +105,9 @@ func TestParserV2_parse(t *testing.T) {
 			input: "$VAR",
 			expected: []node{
 				variable{
-					key: "var",
+					key:    "var",
+					lineNo: 1,
+					colNo:  1,
 				},
 			},
 		},
@@ -104,7 +115,9 @@ func TestParserV2_parse(t *testing.T) {
 			input: "$var",
 			expected: []node{
 				variable{
-					key: "var",
+					key:    "var",
+					lineNo: 1,
+					colNo:  1,
 				},
 			},
 		},
@@ -112,7 +125,9 @@ func TestParserV2_parse(t *testing.T) {
 			input: "$_var",
 			expected: []node{
 				variable{
-					key: "_var",
+					key:    "_var",
+					lineNo: 1,
+					colNo:  1,
 				},
 			},
 		},
@@ -130,7 +145,9 @@ func TestParserV2_parse(t *testing.T) {
 			input: "$var-foo",
 			expected: []node{
 				variable{
-					key: "var",
+					key:    "var",
+					lineNo: 1,
+					colNo:  1,
 				},
 				character('-'),
 				character('f'),
@@ -154,7 +171,9 @@ func TestParserV2_parse(t *testing.T) {
 						character('h'),
 						character('/'),
 						variable{
-							key: "var",
+							key:    "var",
+							lineNo: 1,
+							colNo:  14,
 						},
 						character('/'),
 						character('t'),
@@ -167,6 +186,8 @@ func TestParserV2_parse(t *testing.T) {
 						character('e'),
 						character('t'),
 					},
+					lineNo: 1,
+					colNo:  1,
 				},
 			},
 		},
@@ -178,9 +199,13 @@ func TestParserV2_parse(t *testing.T) {
 						character('a'),
 						character('/'),
 						variable{
-							key: "b",
+							key:    "b",
+							lineNo: 1,
+							colNo:  5,
 						},
 					},
+					lineNo: 1,
+					colNo:  1,
 				},
 			},
 		},
@@ -192,9 +217,13 @@ func TestParserV2_parse(t *testing.T) {
 						character('a'),
 						character('/'),
 						variable{
-							key: "b",
+							key:    "b",
+							lineNo: 1,
+							colNo:  6,
 						},
 					},
+					lineNo: 1,
+					colNo:  1,
 				},
 			},
 		},
@@ -218,6 +247,8 @@ func TestParserV2_parse(t *testing.T) {
 						character('e'),
 						character('t'),
 					},
+					lineNo: 1,
+					colNo:  1,
 				},
 			},
 		},
@@ -247,6 +278,8 @@ func TestParserV2_parse(t *testing.T) {
 						character('e'),
 						character('t'),
 					},
+					lineNo: 1,
+					colNo:  7,
 				},
 				character(' '),
 				character('s'),
@@ -264,11 +297,15 @@ func TestParserV2_parse(t *testing.T) {
 					path: []node{
 						character('a'),
 					},
+					lineNo: 1,
+					colNo:  1,
 				},
 				secret{
 					path: []node{
 						character('b'),
 					},
+					lineNo: 1,
+					colNo:  8,
 				},
 			},
 		},
@@ -278,7 +315,9 @@ func TestParserV2_parse(t *testing.T) {
 				secret{
 					path: []node{
 						variable{
-							key: "var",
+							key:    "var",
+							lineNo: 1,
+							colNo:  3,
 						},
 						character('/'),
 						character('s'),
@@ -288,6 +327,8 @@ func TestParserV2_parse(t *testing.T) {
 						character('e'),
 						character('t'),
 					},
+					lineNo: 1,
+					colNo:  1,
 				},
 			},
 		},
@@ -303,9 +344,13 @@ func TestParserV2_parse(t *testing.T) {
 						character('e'),
 						character('t'),
 						variable{
-							key: "var",
+							key:    "var",
+							lineNo: 1,
+							colNo:  9,
 						},
 					},
+					lineNo: 1,
+					colNo:  1,
 				},
 			},
 		},
@@ -321,9 +366,13 @@ func TestParserV2_parse(t *testing.T) {
 						character('e'),
 						character('t'),
 						variable{
-							key: "var",
+							key:    "var",
+							lineNo: 1,
+							colNo:  10,
 						},
 					},
+					lineNo: 1,
+					colNo:  1,
 				},
 			},
 		},
@@ -341,7 +390,9 @@ func TestParserV2_parse(t *testing.T) {
 						character('o'),
 						character('/'),
 						variable{
-							key: "var",
+							key:    "var",
+							lineNo: 1,
+							colNo:  11,
 						},
 						character('/'),
 						character('s'),
@@ -351,6 +402,8 @@ func TestParserV2_parse(t *testing.T) {
 						character('e'),
 						character('t'),
 					},
+					lineNo: 1,
+					colNo:  1,
 				},
 			},
 		},
@@ -358,7 +411,9 @@ func TestParserV2_parse(t *testing.T) {
 			input: "${ var }",
 			expected: []node{
 				variable{
-					key: "var",
+					key:    "var",
+					lineNo: 1,
+					colNo:  1,
 				},
 			},
 		},
@@ -382,6 +437,8 @@ func TestParserV2_parse(t *testing.T) {
 						character('e'),
 						character('t'),
 					},
+					lineNo: 1,
+					colNo:  1,
 				},
 			},
 		},
@@ -496,6 +553,149 @@ func TestParserV2_parse(t *testing.T) {
 				character('a'),
 			},
 		},
+		"secret with filter": {
+			input: "{{ path/to/secret | trim }}",
+			expected: []node{
+				secret{
+					path: []node{
+						character('p'),
+						character('a'),
+						character('t'),
+						character('h'),
+						character('/'),
+						character('t'),
+						character('o'),
+						character('/'),
+						character('s'),
+						character('e'),
+						character('c'),
+						character('r'),
+						character('e'),
+						character('t'),
+					},
+					filters: []filter{
+						{name: "trim", lineNo: 1, colNo: 21},
+					},
+					lineNo: 1,
+					colNo:  1,
+				},
+			},
+		},
+		"secret with filter without spaces": {
+			input: "{{a|trim}}",
+			expected: []node{
+				secret{
+					path: []node{
+						character('a'),
+					},
+					filters: []filter{
+						{name: "trim", lineNo: 1, colNo: 5},
+					},
+					lineNo: 1,
+					colNo:  1,
+				},
+			},
+		},
+		"secret with filter pipeline and arguments": {
+			input: `{{ a | json ".db.password" | default "x \"y\" \\" | indent 4 }}`,
+			expected: []node{
+				secret{
+					path: []node{
+						character('a'),
+					},
+					filters: []filter{
+						{name: "json", args: []string{".db.password"}, lineNo: 1, colNo: 8},
+						{name: "default", args: []string{`x "y" \`}, lineNo: 1, colNo: 30},
+						{name: "indent", args: []string{"4"}, lineNo: 1, colNo: 53},
+					},
+					lineNo: 1,
+					colNo:  1,
+				},
+			},
+		},
+		"variable in secret with filter": {
+			input: "{{ ${app}/a | base64decode }}",
+			expected: []node{
+				secret{
+					path: []node{
+						variable{
+							key:    "app",
+							lineNo: 1,
+							colNo:  4,
+						},
+						character('/'),
+						character('a'),
+					},
+					filters: []filter{
+						{name: "base64decode", lineNo: 1, colNo: 15},
+					},
+					lineNo: 1,
+					colNo:  1,
+				},
+			},
+		},
+		"pipe outside secret tag": {
+			input: "a | b",
+			expected: []node{
+				character('a'),
+				character(' '),
+				character('|'),
+				character(' '),
+				character('b'),
+			},
+		},
+		"unknown filter": {
+			input: "{{ a | trim | upper }}",
+			err:   ErrUnknownFilter(1, 15, "upper"),
+		},
+		"missing filter argument": {
+			input: "{{ a | indent }}",
+			err:   ErrInvalidFilterArguments(1, 8, "indent", "expected 1 argument(s) (width), got 0"),
+		},
+		"too many filter arguments": {
+			input: "{{ a | trim 4 }}",
+			err:   ErrInvalidFilterArguments(1, 8, "trim", "expected 0 argument(s) (), got 1"),
+		},
+		"invalid filter argument": {
+			input: "{{ a | indent four }}",
+			err:   ErrInvalidFilterArguments(1, 8, "indent", "indent width four is not a positive number"),
+		},
+		"missing generate length": {
+			input: "{{ a | generate }}",
+			err:   ErrInvalidFilterArguments(1, 8, "generate", "expected at least 1 argument(s) (length, [charset], [min...]), got 0"),
+		},
+		"invalid generate charset": {
+			input: `{{ a | generate 32 "alphanumeric,emoji" }}`,
+			err:   ErrInvalidFilterArguments(1, 8, "generate", "unknown charset emoji"),
+		},
+		"invalid JSON path": {
+			input: `{{ a | json "db" }}`,
+			err:   ErrInvalidFilterArguments(1, 8, "json", "JSON path db should start with a '.'"),
+		},
+		"empty filter": {
+			input: "{{ a | }}",
+			err:   ErrIllegalFilterCharacter(1, 8, '}'),
+		},
+		"illegal filter character": {
+			input: "{{ a | tr@m }}",
+			err:   ErrIllegalFilterCharacter(1, 10, '@'),
+		},
+		"filter argument not closed": {
+			input: `{{ a | default "x }}`,
+			err:   ErrFilterArgumentNotClosed(1, 16),
+		},
+		"filter argument not closed on line": {
+			input: "{{ a | default \"x\n\" }}",
+			err:   ErrFilterArgumentNotClosed(1, 16),
+		},
+		"secret tag with filter not closed": {
+			input: "{{ a | trim",
+			err:   ErrSecretTagNotClosed(1, 12),
+		},
+		"secret tag with filter not closed after first bracket": {
+			input: "{{ a | trim }x",
+			err:   ErrUnexpectedCharacter(1, 14, 'x', '}'),
+		},
 		"illegal variable space": {
 			input: "${ va r }",
 			err:   ErrUnexpectedCharacter(1, 7, 'r', '}'),
@@ -662,13 +862,80 @@ func TestV2(t *testing.T) {
 			},
 			expected: "hello world",
 		},
+		"json filter": {
+			raw: `password={{ app/db | json ".password" }}`,
+			secrets: map[string]string{
+				"app/db": `{"user": "admin", "password": "p@ss"}`,
+			},
+			expected: "password=p@ss",
+		},
+		"filter pipeline": {
+			raw: "key:\n{{ app/key | base64decode | trim | indent 2 }}",
+			secrets: map[string]string{
+				"app/key": "IGxpbmUxCmxpbmUyCg==",
+			},
+			expected: "key:\n  line1\n  line2",
+		},
+		"default for missing secret": {
+			raw:      `{{ app/missing | trim | default "fallback" }}`,
+			secrets:  map[string]string{},
+			expected: "fallback",
+		},
+		"default for missing JSON field": {
+			raw: `{{ app/db | json ".port" | default "5432" }}`,
+			secrets: map[string]string{
+				"app/db": `{"host": "localhost"}`,
+			},
+			expected: "5432",
+		},
+		"default for empty secret": {
+			raw: `{{ app/db | default "empty" }}`,
+			secrets: map[string]string{
+				"app/db": "",
+			},
+			expected: "empty",
+		},
+		"filters after default are applied": {
+			raw:      `{{ app/missing | default "a\"b" | jsonescape }}`,
+			secrets:  map[string]string{},
+			expected: `a\"b`,
+		},
+		"missing secret without default": {
+			raw:     `{{ app/missing | trim }}`,
+			secrets: map[string]string{},
+			evalErr: evaluationError{lineNo: 1, colNo: 1, err: api.ErrSecretNotFound},
+		},
+		"missing JSON field without default": {
+			raw: `{{ app/db | json ".port" }}`,
+			secrets: map[string]string{
+				"app/db": `{"host": "localhost"}`,
+			},
+			evalErr: filterError{
+				lineNo: 1,
+				colNo:  13,
+				filter: "json",
+				err:    errJSONFieldNotFound,
+			},
+		},
+		"filter error on second line": {
+			raw: "foo\n{{ app/db | base64decode }}",
+			secrets: map[string]string{
+				"app/db": "not base64!",
+			},
+			evalErr: filterError{
+				lineNo: 2,
+				colNo:  13,
+				filter: "base64decode",
+				err:    errNotBase64,
+			},
+		},
 		"missing var": {
 			raw:  "hello {{ ${app}/greeting }}",
 			vars: map[string]string{},
 			secrets: map[string]string{
 				"company/helloworld/greeting": "world",
 			},
-			evalErr: errors.New("variable not found: app"),
+			evalErr: evaluationError{lineNo: 1, colNo: 10, err: errors.New("variable not found: app")},
 		},
 		"missing var with spaces": {
 			raw:  "hello {{ ${ app }/greeting }}",
@@ -676,7 +943,7 @@ func TestV2(t *testing.T) {
 			secrets: map[string]string{
 				"company/helloworld/greeting": "world",
 			},
-			evalErr: errors.New("variable not found: app"),
+			evalErr: evaluationError{lineNo: 1, colNo: 10, err: errors.New("variable not found: app")},
 		},
 	}
 
@@ -695,3 +962,48 @@ func TestV2(t *testing.T) {
 		})
 	}
 }
+
+func TestV2_SecretPaths(t *testing.T) {
+	cases := map[string]struct {
+		raw      string
+		vars     map[string]string
+		expected []string
+		err      error
+	}{
+		"no secrets": {
+			raw:      "hello world",
+			expected: []string{},
+		},
+		"secrets": {
+			raw:      "{{ path/to/foo }} and {{ path/to/bar }}",
+			expected: []string{"path/to/foo", "path/to/bar"},
+		},
+		"duplicate secrets": {
+			raw:      "{{ path/to/foo }} and {{ path/to/bar }} and {{ path/to/foo }}",
+			expected: []string{"path/to/foo", "path/to/bar"},
+		},
+		"template var in secret": {
+			raw: "{{ ${app}/greeting }}",
+			vars: map[string]string{
+				"app": "company/helloworld",
+			},
+			expected: []string{"company/helloworld/greeting"},
+		},
+		"missing var": {
+			raw:  "{{ ${app}/greeting }}",
+			vars: map[string]string{},
+			err:  evaluationError{lineNo: 1, colNo: 4, err: errors.New("variable not found: app")},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			parsed, err := NewV2Parser().Parse(tc.raw, 1, 1)
+			assert.OK(t, err)
+
+			actual, err := parsed.SecretPaths(fakes.FakeVariableReader{Variables: tc.vars})
+			assert.Equal(t, err, tc.err)
+			assert.Equal(t, actual, tc.expected)
+		})
+	}
+}
@@ -0,0 +1,284 @@
+//go:build !windows
+
+package secrethub
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+// tty is a pseudo-terminal in which the command runs, so that it behaves as when it is run interactively,
+// while its input and output are passed through the current process.
+type tty struct {
+	// pty is the side of the pseudo-terminal that is read and written by the current process.
+	pty *os.File
+	// tty is the terminal of the command.
+	tty *os.File
+	// input is the input of the current process. When it is a terminal, it is put in raw mode
+	// for as long as the command runs, so that every key press is passed on to the command as is.
+	input      *os.File
+	inputState *term.State
+	resizes    chan os.Signal
+	done       chan struct{}
+	// closed is closed when the terminal is closed, so that the input is no longer passed on to it.
+	closed chan struct{}
+	// drainTimeout is how long the output is read after the command exited, while other processes
+	// that it started keep the terminal open.
+	drainTimeout time.Duration
+}
+
+// ttyDrainTimeout is the default time that the output of the terminal is read after the command exited.
+const ttyDrainTimeout = time.Second * 2
+
+// newTTY opens a pseudo-terminal with the same size as the given input, if it is a terminal.
+func newTTY(input *os.File) (*tty, error) {
+	pty, tt, err := openPTY()
+	if err == ErrTTYNotSupported {
+		return nil, err
+	} else if err != nil {
+		return nil, ErrTTYFailed(err)
+	}
+
+	t := &tty{
+		pty:     pty,
+		tty:     tt,
+		input:   input,
+		resizes: make(chan os.Signal, 1),
+		done:    make(chan struct{}),
+		closed:  make(chan struct{}),
+
+		drainTimeout: ttyDrainTimeout,
+	}
+
+	fd := int(input.Fd())
+	if term.IsTerminal(fd) {
+		t.resize()
+
+		t.inputState, err = term.MakeRaw(fd)
+		if err != nil {
+			t.close()
+			return nil, ErrTTYFailed(err)
+		}
+	}
+	return t, nil
+}
+
+// attach makes the terminal the controlling terminal and the standard input and output of the command.
+func (t *tty) attach(command *exec.Cmd) {
+	command.Stdin = t.tty
+	command.Stdout = t.tty
+	command.Stderr = t.tty
+
+	if command.SysProcAttr == nil {
+		command.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	command.SysProcAttr.Setsid = true
+	command.SysProcAttr.Setctty = true
+	// Ctty is the file descriptor of the terminal in the command, which is its stdin.
+	command.SysProcAttr.Ctty = 0
+}
+
+// start passes the input on to the terminal and writes everything the command writes to
+// the terminal to the given output. Changes of the size of the input terminal are passed on as well.
+// It must be called after the command has been started.
+func (t *tty) start(output io.Writer) {
+	// The command has its own copy of the terminal, so that reading the output ends when the command closes it.
+	_ = t.tty.Close()
+
+	if t.inputState != nil {
+		signal.Notify(t.resizes, syscall.SIGWINCH)
+		go func() {
+			for range t.resizes {
+				t.resize()
+			}
+		}()
+	}
+
+	go func() {
+		err := inputOf(t.input).copyTo(t.pty, t.closed)
+		if err == nil && t.inputState == nil {
+			// Pass on the end of piped input by typing Ctrl-D, as the command reads from a terminal instead of a pipe.
+			_, _ = t.pty.Write([]byte{0x04})
+		}
+	}()
+
+	go func() {
+		// Reading fails when the command and all processes it started have closed the terminal.
+		_, _ = io.Copy(output, t.pty)
+		close(t.done)
+	}()
+}
+
+// resize sets the size of the terminal to the size of the input terminal.
+func (t *tty) resize() {
+	size, err := unix.IoctlGetWinsize(int(t.input.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return
+	}
+	_ = controlFd(t.pty, func(fd int) error {
+		return unix.IoctlSetWinsize(fd, unix.TIOCSWINSZ, size)
+	})
+}
+
+// wait waits until all output of the command has been read from the terminal and closes it.
+// When processes started by the command keep the terminal open, their output is read for at most
+// the drain timeout, after which the terminal is closed anyway.
+func (t *tty) wait() {
+	timer := time.NewTimer(t.drainTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-t.done:
+		t.close()
+	case <-timer.C:
+		// Closing the terminal ends reading its output.
+		t.close()
+		<-t.done
+	}
+}
+
+// close closes the terminal and restores the state of the input terminal.
+// It must be called only once.
+func (t *tty) close() {
+	close(t.closed)
+	signal.Stop(t.resizes)
+	close(t.resizes)
+	if t.inputState != nil {
+		_ = term.Restore(int(t.input.Fd()), t.inputState)
+	}
+	_ = t.tty.Close()
+	_ = t.pty.Close()
+}
+
+// controlFd calls fn with the file descriptor of the file. Unlike with Fd, the file is kept in non-blocking mode,
+// so that closing it interrupts reading from it.
+func controlFd(f *os.File, fn func(fd int) error) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var fnErr error
+	err = conn.Control(func(fd uintptr) {
+		fnErr = fn(int(fd))
+	})
+	if err != nil {
+		return err
+	}
+	return fnErr
+}
+
+// ttyInput reads the input of the current process for the terminals in which the command runs.
+// Reading a file cannot be interrupted, so the input is read by a single goroutine that hands what it
+// reads to the terminal that currently runs the command. This way, a terminal of a restarted command
+// takes over the input without another terminal reading from it.
+type ttyInput struct {
+	// chunks receives what is read from the input. It is closed when the input ends.
+	chunks chan []byte
+
+	mutex sync.Mutex
+	// pending is a chunk that could not be written to a closed terminal, to be passed on to the next terminal.
+	pending []byte
+	// err is the error that ended reading the input. It is nil when the input ended normally.
+	err error
+}
+
+// errTTYInputStopped is returned when passing on the input to a terminal is stopped because the terminal is closed.
+var errTTYInputStopped = errors.New("the terminal is closed")
+
+var (
+	ttyInputsMutex sync.Mutex
+	ttyInputs      = map[*os.File]*ttyInput{}
+)
+
+// inputOf returns the reader of the given input, which starts reading the input when it is first requested.
+func inputOf(input *os.File) *ttyInput {
+	ttyInputsMutex.Lock()
+	defer ttyInputsMutex.Unlock()
+
+	in, ok := ttyInputs[input]
+	if !ok {
+		in = newTTYInput(input)
+		ttyInputs[input] = in
+	}
+	return in
+}
+
+// newTTYInput starts reading the given reader.
+func newTTYInput(r io.Reader) *ttyInput {
+	in := &ttyInput{
+		chunks: make(chan []byte),
+	}
+	go in.read(r)
+	return in
+}
+
+// read passes everything that is read from r on to the chunks channel.
+func (in *ttyInput) read(r io.Reader) {
+	defer close(in.chunks)
+	for {
+		buf := make([]byte, 1024)
+		n, err := r.Read(buf)
+		if n > 0 {
+			in.chunks <- buf[:n]
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			in.mutex.Lock()
+			in.err = err
+			in.mutex.Unlock()
+			return
+		}
+	}
+}
+
+// copyTo writes the input to w until the input ends or the stop channel is closed.
+// It returns nil when the input ended normally. A chunk that cannot be written is kept for the next call,
+// so that no input is lost when the terminal is closed while it is written to.
+func (in *ttyInput) copyTo(w io.Writer, stop <-chan struct{}) error {
+	for {
+		select {
+		case <-stop:
+			return errTTYInputStopped
+		default:
+		}
+
+		in.mutex.Lock()
+		chunk := in.pending
+		in.pending = nil
+		in.mutex.Unlock()
+
+		if chunk == nil {
+			var ok bool
+			select {
+			case <-stop:
+				return errTTYInputStopped
+			case chunk, ok = <-in.chunks:
+			}
+			if !ok {
+				in.mutex.Lock()
+				defer in.mutex.Unlock()
+				return in.err
+			}
+		}
+
+		_, err := w.Write(chunk)
+		if err != nil {
+			in.mutex.Lock()
+			in.pending = chunk
+			in.mutex.Unlock()
+			return err
+		}
+	}
+}
@@ -0,0 +1,90 @@
+//go:build !windows
+
+package secrethub
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/secrethub/secrethub-go/internals/assert"
+)
+
+// failingWriter is a writer of which every write fails, like a closed terminal.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, os.ErrClosed
+}
+
+func TestTTYInput_copyTo(t *testing.T) {
+	r, w := io.Pipe()
+	in := newTTYInput(r)
+
+	// The first terminal is closed while the input is written to it, so the input goes to the next terminal.
+	errs := make(chan error, 1)
+	go func() {
+		errs <- in.copyTo(failingWriter{}, make(chan struct{}))
+	}()
+	_, err := w.Write([]byte("first"))
+	assert.OK(t, err)
+	assert.Equal(t, <-errs, os.ErrClosed)
+
+	// A terminal that is closed before any input is read no longer reads the input.
+	stop := make(chan struct{})
+	go func() {
+		errs <- in.copyTo(failingWriter{}, stop)
+	}()
+	close(stop)
+	assert.Equal(t, <-errs, errTTYInputStopped)
+
+	var buf bytes.Buffer
+	go func() {
+		errs <- in.copyTo(&buf, make(chan struct{}))
+	}()
+	_, err = w.Write([]byte(" second"))
+	assert.OK(t, err)
+	assert.OK(t, w.Close())
+
+	assert.OK(t, <-errs)
+	assert.Equal(t, buf.String(), "first second")
+}
+
+func TestTTY_wait_DrainTimeout(t *testing.T) {
+	input, err := os.Open(os.DevNull)
+	assert.OK(t, err)
+	defer input.Close()
+
+	tt, err := newTTY(input)
+	if errors.Is(err, ErrTTYNotSupported) {
+		t.Skip(err)
+	}
+	assert.OK(t, err)
+	tt.drainTimeout = time.Millisecond * 50
+
+	// A process started by the command keeps the terminal open after the command exited.
+	held, err := os.OpenFile(tt.tty.Name(), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		tt.close()
+		t.Skip(err)
+	}
+	defer held.Close()
+
+	tt.start(io.Discard)
+
+	waited := make(chan struct{})
+	go func() {
+		tt.wait()
+		close(waited)
+	}()
+
+	select {
+	case <-waited:
+	case <-time.After(time.Second * 5):
+		t.Fatal("waiting for the terminal did not end after the drain timeout")
+	}
+}
@@ -0,0 +1,23 @@
+package secrethub
+
+import (
+	"io"
+	"os"
+	"os/exec"
+)
+
+// tty is not supported on Windows, which does not have pseudo-terminals that can be used like on Unix.
+type tty struct{}
+
+// newTTY always returns an error, as pseudo-terminals are not supported on Windows.
+func newTTY(input *os.File) (*tty, error) {
+	return nil, ErrTTYNotSupported
+}
+
+func (t *tty) attach(command *exec.Cmd) {}
+
+func (t *tty) start(output io.Writer) {}
+
+func (t *tty) wait() {}
+
+func (t *tty) close() {}